@@ -0,0 +1,72 @@
+// Command backup makes, lists and restores online backups of the SQLite
+// song database, the same ones the server makes, and prints them as JSON.
+//
+//	backup [-db data/songs.db] [-dir data/backups] [-keep 7] create | list | restore <name>
+//
+// Backing up is safe while a server is using the database. So is
+// restoring, but the server keeps matching against the songs it had loaded
+// until its index is rebuilt; restore through the server's
+// /api/admin/backups/{name}/restore to have that done too.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"Shazam/config"
+	"Shazam/internal/backup"
+	"Shazam/internal/database"
+)
+
+func main() {
+	cfg := config.Load()
+
+	dbPath := flag.String("db", cfg.DatabasePath, "path to the song database")
+	dir := flag.String("dir", cfg.BackupDir, "directory holding the backups")
+	keep := flag.Int("keep", cfg.BackupKeep, "number of backups kept when making a new one, 0 for all")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] create | list | restore <name>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := database.Initialize(*dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	backups := backup.NewService(db, *dir, *keep)
+
+	var result interface{}
+	switch command := flag.Arg(0); {
+	case command == "create" && flag.NArg() == 1:
+		result, err = backups.Create(backup.Manual)
+	case command == "list" && flag.NArg() == 1:
+		result, err = backups.List()
+	case command == "restore" && flag.NArg() == 2:
+		var previous *backup.Backup
+		if previous, err = backups.Restore(flag.Arg(1)); err == nil {
+			result = map[string]interface{}{"restored": flag.Arg(1), "pre_restore": previous}
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("Failed to %s: %v", flag.Arg(0), err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		log.Fatalf("Failed to write output: %v", err)
+	}
+}
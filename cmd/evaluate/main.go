@@ -0,0 +1,191 @@
+// Command evaluate measures identification accuracy on degraded excerpts
+// of the library's source audio and prints the report as JSON.
+//
+//	evaluate [-queries 100] [-seconds 10] [-seed 1] [-condition pink:snr=5 ...]
+//
+// Source audio is looked up in -audio-dir, where ingestion keeps it, or in
+// a -manifest JSON object mapping song IDs to file paths.
+//
+// With -calibrate the match decision model is fitted to the run and
+// written to the given file, ready to be loaded with MATCH_CALIBRATION.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	"Shazam/config"
+	"Shazam/internal/audio"
+	"Shazam/internal/database"
+	"Shazam/internal/evaluation"
+	"Shazam/internal/matching"
+)
+
+func main() {
+	cfg := config.Load()
+	defaults := evaluation.DefaultOptions()
+
+	var specs []string
+	dbPath := flag.String("db", cfg.DatabasePath, "path to the song database")
+	dbURL := flag.String("db-url", cfg.DatabaseURL, "URL of a PostgreSQL song database, used instead of -db")
+	audioDir := flag.String("audio-dir", cfg.AudioDir, "directory holding source audio by song ID")
+	manifest := flag.String("manifest", "", "JSON file mapping song IDs to source audio paths")
+	queries := flag.Int("queries", defaults.Queries, "number of excerpts")
+	seconds := flag.Float64("seconds", defaults.Seconds, "excerpt length in seconds")
+	holdout := flag.Float64("holdout", defaults.Holdout, "fraction of songs left out of the index as negatives")
+	topN := flag.Int("top", defaults.TopN, "candidates checked for top-N accuracy")
+	seed := flag.Int64("seed", defaults.Seed, "random seed")
+	details := flag.Bool("details", false, "include every query in the report")
+	out := flag.String("out", "", "write the report to this file instead of stdout")
+	decisionPath := flag.String("decision", cfg.MatchCalibration, "calibration file used to decide matches")
+	calibrate := flag.String("calibrate", "", "fit the match decision to this run and write it to this file")
+	precision := flag.Float64("target-precision", 0.98, "precision the calibrated threshold must reach")
+	shift := flag.Float64("shift", 0, "identify with shift-tolerant matching up to this tempo and pitch deviation")
+	flag.Func("condition", "degradation chain to evaluate, repeatable (default: a standard set)", func(v string) error {
+		specs = append(specs, v)
+		return nil
+	})
+	flag.Parse()
+
+	if len(specs) == 0 {
+		specs = evaluation.DefaultConditions
+	}
+	conditions := make([]evaluation.Condition, 0, len(specs))
+	for _, spec := range specs {
+		c, err := evaluation.ParseCondition(spec)
+		if err != nil {
+			log.Fatalf("Invalid condition %q: %v", spec, err)
+		}
+		conditions = append(conditions, c)
+	}
+
+	db, err := database.Open(*dbURL, *dbPath, database.DefaultOptions())
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	lshConfig := matching.DefaultLSHConfig()
+	lshConfig.Tables = cfg.LSHTables
+	lshConfig.NibblesPerKey = cfg.LSHNibbles
+	lshConfig.Candidates = cfg.LSHCandidates
+	index, err := matching.NewIndex(db, lshConfig)
+	if err != nil {
+		log.Fatalf("Failed to build index: %v", err)
+	}
+
+	if *decisionPath != "" {
+		decision, err := matching.LoadDecisionConfig(*decisionPath)
+		if err != nil {
+			log.Fatalf("Failed to load match calibration: %v", err)
+		}
+		index.SetDecision(decision)
+	}
+
+	paths, err := loadManifest(*manifest)
+	if err != nil {
+		log.Fatalf("Failed to read manifest: %v", err)
+	}
+
+	var sources []evaluation.Source
+	for _, song := range index.Songs() {
+		path, ok := paths[song.ID]
+		if !ok {
+			path = audio.SourceAudioPath(*audioDir, song.ID)
+		}
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		sources = append(sources, evaluation.Source{Song: song, Path: path})
+	}
+	log.Printf("Evaluating %d of %d songs with source audio, %d conditions",
+		len(sources), len(index.Songs()), len(conditions))
+
+	opts := evaluation.Options{
+		Queries: *queries,
+		Seconds: *seconds,
+		Holdout: *holdout,
+		TopN:    *topN,
+		Seed:    *seed,
+		Details: *details || *calibrate != "",
+	}
+	if *shift > 0 {
+		shiftOpts := matching.DefaultShiftOptions()
+		shiftOpts.MaxTempo, shiftOpts.MaxPitch = *shift, *shift
+		opts.Shift = &shiftOpts
+	}
+
+	report, err := evaluation.Run(index, sources, conditions, opts, audio.LoadSamples)
+	if err != nil {
+		log.Fatalf("Evaluation failed: %v", err)
+	}
+
+	if *calibrate != "" {
+		decision, err := evaluation.Calibrate(report, index.Decision(), *precision)
+		if err != nil {
+			log.Fatalf("Calibration failed: %v", err)
+		}
+		if err := writeJSON(*calibrate, decision); err != nil {
+			log.Fatalf("Failed to write calibration: %v", err)
+		}
+		log.Printf("Wrote match calibration to %s (threshold %.3f)", *calibrate, decision.Threshold)
+
+		if !*details {
+			for _, r := range report.Conditions {
+				r.Details = nil
+			}
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func loadManifest(path string) (map[int]string, error) {
+	paths := make(map[int]string)
+	if path == "" {
+		return paths, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	for key, p := range raw {
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, err
+		}
+		paths[id] = p
+	}
+	return paths, nil
+}
@@ -4,22 +4,80 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"Shazam/config"
+	"Shazam/internal/backup"
 	"Shazam/internal/database"
 	"Shazam/internal/handlers"
+	"Shazam/internal/matching"
+	"Shazam/internal/monitor"
 )
 
 func main() {
 	cfg := config.Load()
 
-	db, err := database.Initialize(cfg.DatabasePath)
+	db, err := database.Open(cfg.DatabaseURL, cfg.DatabasePath, databaseOptions(cfg))
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer db.Close()
 
-	h := handlers.New(db, cfg)
+	lshConfig := matching.DefaultLSHConfig()
+	lshConfig.Tables = cfg.LSHTables
+	lshConfig.NibblesPerKey = cfg.LSHNibbles
+	lshConfig.Candidates = cfg.LSHCandidates
+
+	libraries, err := db.GetLibraries()
+	if err != nil {
+		log.Fatalf("Failed to list libraries: %v", err)
+	}
+	var ids []int
+	for _, library := range libraries {
+		ids = append(ids, library.ID)
+	}
+	indexes, err := matching.NewLibraries(db, lshConfig, ids)
+	if err != nil {
+		log.Fatalf("Failed to build fingerprint index: %v", err)
+	}
+	for _, library := range libraries {
+		index, _ := indexes.Index(library.ID)
+		log.Printf("📚 Fingerprint index of library %q loaded: %s", library.Name, index.Stats().Summary())
+	}
+	if cfg.IndexRefresh > 0 {
+		go refreshIndex(indexes, time.Duration(cfg.IndexRefresh)*time.Second)
+	}
+
+	decision := matching.DefaultDecisionConfig()
+	if cfg.MatchCalibration != "" {
+		if decision, err = matching.LoadDecisionConfig(cfg.MatchCalibration); err != nil {
+			log.Fatalf("Failed to load match calibration: %v", err)
+		}
+	}
+	if cfg.MatchThreshold > 0 {
+		decision.Threshold = cfg.MatchThreshold
+	}
+	if cfg.MatchMinScore > 0 {
+		decision.MinScore = cfg.MatchMinScore
+	}
+	indexes.SetDecision(decision)
+
+	monitors := monitor.NewService(db, indexes, monitor.DefaultOptions())
+	if err := monitors.StartAll(); err != nil {
+		log.Fatalf("Failed to start stream monitors: %v", err)
+	}
+	defer monitors.StopAll()
+
+	var backups *backup.Service
+	if store, ok := db.(database.BackupStore); ok {
+		backups = backup.NewService(store, cfg.BackupDir, cfg.BackupKeep)
+		if cfg.BackupInterval > 0 {
+			go scheduleBackups(backups, time.Duration(cfg.BackupInterval)*time.Second)
+		}
+	}
+
+	h := handlers.New(db, indexes, monitors, backups, cfg)
 
 	setupRoutes(h)
 
@@ -29,16 +87,111 @@ func main() {
 	log.Fatal(http.ListenAndServe(":"+cfg.Port, nil))
 }
 
+// databaseOptions overrides the default connection options with those set
+// in the configuration.
+func databaseOptions(cfg *config.Config) database.Options {
+	opts := database.DefaultOptions()
+	if cfg.SQLiteJournalMode != "" {
+		opts.JournalMode = cfg.SQLiteJournalMode
+	}
+	if cfg.SQLiteBusyTimeout > 0 {
+		opts.BusyTimeout = time.Duration(cfg.SQLiteBusyTimeout) * time.Millisecond
+	}
+	if cfg.SQLiteSynchronous != "" {
+		opts.Synchronous = cfg.SQLiteSynchronous
+	}
+	if enabled, err := strconv.ParseBool(cfg.SQLiteForeignKeys); err == nil {
+		opts.ForeignKeys = enabled
+	}
+	if cfg.SQLiteIntegrityCheck != "" {
+		opts.IntegrityCheck = cfg.SQLiteIntegrityCheck
+	}
+	if cfg.DBMaxOpenConns > 0 {
+		opts.MaxOpenConns = cfg.DBMaxOpenConns
+	}
+	if cfg.DBMaxIdleConns > 0 {
+		opts.MaxIdleConns = cfg.DBMaxIdleConns
+	}
+	if cfg.DBConnMaxLifetime > 0 {
+		opts.ConnMaxLifetime = time.Duration(cfg.DBConnMaxLifetime) * time.Second
+	}
+	return opts
+}
+
+// refreshIndex periodically reloads the indexes so that a server sharing
+// its database with others picks up the songs they add and remove.
+func refreshIndex(indexes *matching.Libraries, every time.Duration) {
+	for range time.Tick(every) {
+		if err := indexes.Rebuild(); err != nil {
+			log.Printf("Failed to refresh fingerprint index: %v", err)
+		}
+	}
+}
+
+// scheduleBackups backs the database up periodically, keeping as many
+// backups as configured.
+func scheduleBackups(backups *backup.Service, every time.Duration) {
+	for range time.Tick(every) {
+		if b, err := backups.Create(backup.Scheduled); err != nil {
+			log.Printf("Failed to back up database: %v", err)
+		} else {
+			log.Printf("💾 Database backed up to %s", b.Name)
+		}
+	}
+}
+
 func setupRoutes(h *handlers.Handler) {
 	http.HandleFunc("/", h.HomePage)
 	http.HandleFunc("/database", h.DatabasePage)
 	http.HandleFunc("/record", h.RecordPage)
+	http.HandleFunc("/history", h.HistoryPage)
+	http.HandleFunc("/artists", h.ArtistsPage)
+	http.HandleFunc("/artists/{id}", h.ArtistPage)
+	http.HandleFunc("/albums/{id}", h.AlbumPage)
 
 	http.HandleFunc("/api/record", h.RecordAudio)
 	http.HandleFunc("/api/identify", h.IdentifySong)
+	http.HandleFunc("/api/tracklist", h.Tracklist)
 	http.HandleFunc("/api/songs", h.GetSongs)
 	http.HandleFunc("/api/songs/add", h.AddSong)
 	http.HandleFunc("/api/songs/search", h.SearchSongs)
+	http.HandleFunc("/api/songs/{id}", h.SongByID)
+	http.HandleFunc("/api/songs/{id}/spectrogram.png", h.SongSpectrogram)
+	http.HandleFunc("/api/songs/{id}/tags", h.SongTags)
+	http.HandleFunc("/api/songs/{id}/undo", h.UndoSong)
+	http.HandleFunc("/api/tags", h.Tags)
+	http.HandleFunc("/api/playlists", h.Playlists)
+	http.HandleFunc("/api/playlists/{id}", h.PlaylistByID)
+	http.HandleFunc("/api/playlists/{id}/songs", h.PlaylistSongs)
+
+	http.HandleFunc("/api/libraries", h.Libraries)
+	http.HandleFunc("/api/libraries/{id}", h.LibraryByID)
+
+	http.HandleFunc("/api/artists", h.Artists)
+	http.HandleFunc("/api/artists/{id}", h.ArtistByID)
+	http.HandleFunc("/api/artists/{id}/songs", h.ArtistSongs)
+	http.HandleFunc("/api/artists/{id}/merge", h.MergeArtist)
+	http.HandleFunc("/api/albums/{id}", h.AlbumByID)
+
+	http.HandleFunc("/api/history", h.History)
+	http.HandleFunc("/api/history/accuracy", h.Accuracy)
+	http.HandleFunc("/api/history/{id}", h.HistoryByID)
+	http.HandleFunc("/api/history/{id}/confirm", h.ConfirmHistory)
+	http.HandleFunc("/api/history/{id}/alignment.svg", h.HistoryAlignment)
+	http.HandleFunc("/api/history/{id}/explain", h.HistoryExplain)
+
+	http.HandleFunc("/api/monitors", h.Monitors)
+	http.HandleFunc("/api/monitors/{id}", h.MonitorByID)
+	http.HandleFunc("/api/monitors/{id}/plays", h.MonitorPlays)
+	http.HandleFunc("/api/monitors/{id}/report", h.MonitorReport)
+
+	http.HandleFunc("/api/admin/index", h.IndexStats)
+	http.HandleFunc("/api/admin/index/rebuild", h.RebuildIndex)
+	http.HandleFunc("/api/admin/analysis/backfill", h.BackfillAnalysis)
+	http.HandleFunc("/api/admin/backups", h.Backups)
+	http.HandleFunc("/api/admin/backups/{name}/restore", h.RestoreBackup)
+
+	http.HandleFunc("/api/audit", h.Audit)
 
 	http.Handle("/static/", http.StripPrefix("/static/",
 		http.FileServer(http.Dir("web/static/"))))
@@ -0,0 +1,79 @@
+// Command tracklist identifies every song in a DJ mix or long recording
+// and prints the resulting tracklist as JSON or as a CUE sheet.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"Shazam/config"
+	"Shazam/internal/audio"
+	"Shazam/internal/database"
+	"Shazam/internal/matching"
+)
+
+func main() {
+	cfg := config.Load()
+	opts := matching.DefaultSegmentOptions()
+
+	dbPath := flag.String("db", cfg.DatabasePath, "path to the song database")
+	dbURL := flag.String("db-url", cfg.DatabaseURL, "URL of a PostgreSQL song database, used instead of -db")
+	format := flag.String("format", "json", "output format: json or cue")
+	flag.Float64Var(&opts.WindowSeconds, "window", opts.WindowSeconds, "seconds identified per window")
+	flag.Float64Var(&opts.HopSeconds, "hop", opts.HopSeconds, "seconds between windows")
+	flag.Float64Var(&opts.MinSeconds, "min", opts.MinSeconds, "drop segments shorter than this many seconds")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] <audio file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	input := flag.Arg(0)
+
+	db, err := database.Open(*dbURL, *dbPath, database.DefaultOptions())
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	lshConfig := matching.DefaultLSHConfig()
+	lshConfig.Tables = cfg.LSHTables
+	lshConfig.NibblesPerKey = cfg.LSHNibbles
+	lshConfig.Candidates = cfg.LSHCandidates
+
+	index, err := matching.NewIndex(db, lshConfig)
+	if err != nil {
+		log.Fatalf("Failed to build fingerprint index: %v", err)
+	}
+
+	fp, err := audio.ProcessLongAudioFile(input)
+	if err != nil {
+		log.Fatalf("Failed to process %s: %v", input, err)
+	}
+
+	segments := matching.FindTracklist(index, fp, opts)
+
+	switch *format {
+	case "cue":
+		name := filepath.Base(input)
+		if err := matching.WriteCUE(os.Stdout, name, name, segments); err != nil {
+			log.Fatal(err)
+		}
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(segments); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("Unknown format %q", *format)
+	}
+}
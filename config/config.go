@@ -2,25 +2,69 @@ package config
 
 import (
 	"os"
+	"strconv"
 )
 
 type Config struct {
 	Port                string
 	DatabasePath        string
+	DatabaseURL         string
 	TempDir             string
+	AudioDir            string
 	SpotifyClientID     string
 	SpotifyClientSecret string
 	RecordingDuration   int
+	LSHTables           int
+	LSHNibbles          int
+	LSHCandidates       int
+	MatchCalibration    string
+	MatchThreshold      float64
+	MatchMinScore       float64
+	IndexRefresh        int
+	BackupDir           string
+	BackupInterval      int
+	BackupKeep          int
+	// SQLite connection settings and pool limits. Unset ones keep the
+	// defaults of database.DefaultOptions.
+	SQLiteJournalMode    string
+	SQLiteBusyTimeout    int
+	SQLiteSynchronous    string
+	SQLiteForeignKeys    string
+	SQLiteIntegrityCheck string
+	DBMaxOpenConns       int
+	DBMaxIdleConns       int
+	DBConnMaxLifetime    int
 }
 
 func Load() *Config {
 	return &Config{
 		Port:                getEnv("PORT", "8080"),
 		DatabasePath:        getEnv("DATABASE_PATH", "data/songs.db"),
+		DatabaseURL:         getEnv("DATABASE_URL", ""),
 		TempDir:             getEnv("TEMP_DIR", "data/temp"),
+		AudioDir:            getEnv("AUDIO_DIR", "data/audio"),
 		SpotifyClientID:     getEnv("SPOTIFY_CLIENT_ID", "eec03041bad34931a01c2d8106bef880"),
 		SpotifyClientSecret: getEnv("SPOTIFY_CLIENT_SECRET", "66ea4b4480034839ae27ab41a9a20d1b"),
 		RecordingDuration:   10,
+		LSHTables:           getEnvInt("LSH_TABLES", 8),
+		LSHNibbles:          getEnvInt("LSH_NIBBLES", 7),
+		LSHCandidates:       getEnvInt("LSH_CANDIDATES", 32),
+		MatchCalibration:    getEnv("MATCH_CALIBRATION", ""),
+		MatchThreshold:      getEnvFloat("MATCH_THRESHOLD", 0),
+		MatchMinScore:       getEnvFloat("MATCH_MIN_SCORE", 0),
+		IndexRefresh:        getEnvInt("INDEX_REFRESH_SECONDS", 0),
+		BackupDir:           getEnv("BACKUP_DIR", "data/backups"),
+		BackupInterval:      getEnvInt("BACKUP_INTERVAL_SECONDS", 0),
+		BackupKeep:          getEnvInt("BACKUP_KEEP", 7),
+
+		SQLiteJournalMode:    getEnv("SQLITE_JOURNAL_MODE", ""),
+		SQLiteBusyTimeout:    getEnvInt("SQLITE_BUSY_TIMEOUT_MS", 0),
+		SQLiteSynchronous:    getEnv("SQLITE_SYNCHRONOUS", ""),
+		SQLiteForeignKeys:    getEnv("SQLITE_FOREIGN_KEYS", ""),
+		SQLiteIntegrityCheck: getEnv("SQLITE_INTEGRITY_CHECK", ""),
+		DBMaxOpenConns:       getEnvInt("DB_MAX_OPEN_CONNS", 0),
+		DBMaxIdleConns:       getEnvInt("DB_MAX_IDLE_CONNS", 0),
+		DBConnMaxLifetime:    getEnvInt("DB_CONN_MAX_LIFETIME_SECONDS", 0),
 	}
 }
 
@@ -30,3 +74,21 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
@@ -4,7 +4,9 @@ go 1.24.6
 
 require (
 	github.com/gorilla/websocket v1.5.3
+	github.com/lib/pq v1.12.3
 	github.com/mattn/go-sqlite3 v1.14.31
 	github.com/zmb3/spotify/v2 v2.4.3
 	golang.org/x/oauth2 v0.30.0
+	golang.org/x/text v0.29.0
 )
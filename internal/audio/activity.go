@@ -0,0 +1,104 @@
+package audio
+
+import (
+	"math"
+
+	"Shazam/internal/database"
+)
+
+// Activity detection thresholds, in dB. A frame is inactive when it is
+// far below the recent peak level, or when its spectrum is as flat as noise
+// and it is quiet either relative to that peak or absolutely. Noise-like
+// frames that are loud, such as drum hits, stay active.
+const (
+	// activityFloorDB is the level below which a frame is always inactive.
+	activityFloorDB = -80.0
+	// activityRangeDB is how far below the recent peak a frame may fall.
+	activityRangeDB = 40.0
+	// noiseRangeDB is how far below the recent peak a noise-like frame may
+	// fall.
+	noiseRangeDB = 20.0
+	// noiseFloorDB is the level below which a noise-like frame is inactive
+	// regardless of the peak, so that hiss before the first loud frame is
+	// caught too.
+	noiseFloorDB = -45.0
+	// peakDecayDB is how much the recent peak falls per frame, about 3 dB
+	// a second, so the detector adapts when a song gets quieter.
+	peakDecayDB = 3.0 * HopSize / SampleRate
+	// noiseFlatness is the spectral flatness above which a frame counts as
+	// noise-like. White noise measures about 0.56, tonal music below 0.05.
+	noiseFlatness = 0.4
+)
+
+// activityDetector marks the frames that carry too little information to
+// hash: silence, quiet noise floors and fades. It is causal, judging each
+// frame only by those before it, so a clip gets the same marks whether it
+// is fingerprinted whole or streamed in pieces.
+type activityDetector struct {
+	peak float64
+	seen bool
+}
+
+// spectrum returns the magnitude spectrum of window, or nil when the frame
+// is inactive.
+func (d *activityDetector) spectrum(window []float64) []float64 {
+	mags, ok := frameSpectrum(window)
+	if !ok {
+		return nil
+	}
+
+	level := 20 * math.Log10(rms(window))
+	if !d.seen {
+		d.peak, d.seen = level, true
+	}
+	d.peak = math.Max(level, d.peak-peakDecayDB)
+
+	if level < activityFloorDB || level < d.peak-activityRangeDB {
+		return nil
+	}
+	if flatness(mags) > noiseFlatness && (level < noiseFloorDB || level < d.peak-noiseRangeDB) {
+		return nil
+	}
+	return mags
+}
+
+// flatness is the ratio of the geometric to the arithmetic mean of the
+// power spectrum, ignoring the DC bin.
+func flatness(mags []float64) float64 {
+	if len(mags) < 2 {
+		return 0
+	}
+	logSum, sum := 0.0, 0.0
+	for _, m := range mags[1:] {
+		p := m * m
+		logSum += math.Log(p)
+		sum += p
+	}
+	n := float64(len(mags) - 1)
+	if sum == 0 {
+		return 0
+	}
+	return math.Exp(logSum/n) / (sum / n)
+}
+
+// spectrumHash hashes a spectrum from activityDetector, marking inactive
+// frames with database.SilentHash.
+func spectrumHash(mags []float64, pitch float64) uint64 {
+	if mags == nil {
+		return database.SilentHash
+	}
+	return bandHash(mags, pitch)
+}
+
+// trimSilence drops the inactive frames at either end of a clip and
+// returns the rest with the number dropped from the start.
+func trimSilence(hs database.HashSegments) (database.HashSegments, int) {
+	start, end := 0, len(hs)
+	for start < end && hs[start] == database.SilentHash {
+		start++
+	}
+	for end > start && hs[end-1] == database.SilentHash {
+		end--
+	}
+	return hs[start:end], start
+}
@@ -0,0 +1,184 @@
+package audio
+
+import (
+	"math"
+
+	"Shazam/internal/database"
+)
+
+// Tempo estimation searches this range of beats per minute. Tempos outside
+// it are usually heard at half or double speed anyway.
+const (
+	minBPM = 60.0
+	maxBPM = 200.0
+	// preferredBPM centres the prior that settles octave errors between
+	// candidates a factor of two apart.
+	preferredBPM = 120.0
+)
+
+// minTempoSeconds is the shortest audio worth estimating a tempo for.
+const minTempoSeconds = 8.0
+
+// EstimateBPM estimates the tempo of samples in beats per minute from the
+// autocorrelation of their onset strength. It returns 0 when the audio is
+// too short or shows no periodicity.
+func EstimateBPM(samples []float64) float64 {
+	if float64(len(samples)) < minTempoSeconds*SampleRate {
+		return 0
+	}
+	return tempoFromOnsets(onsetStrength(samples))
+}
+
+// onsetStrength is the half-wave rectified spectral flux of the log
+// magnitude spectrum, one value per hop, with its running mean removed so
+// that only sudden rises in energy remain.
+func onsetStrength(samples []float64) []float64 {
+	var onsets []float64
+	var prev []float64
+	for i := 0; i+FrameSize <= len(samples); i += HopSize {
+		mags, ok := frameSpectrum(samples[i : i+FrameSize])
+		if !ok {
+			// Silence still takes time, so it counts as no onset.
+			onsets = append(onsets, 0)
+			prev = nil
+			continue
+		}
+
+		flux := 0.0
+		for k, m := range mags {
+			m = math.Log1p(1000 * m)
+			if prev != nil && m > prev[k] {
+				flux += m - prev[k]
+			}
+			mags[k] = m
+		}
+		onsets = append(onsets, flux)
+		prev = mags
+	}
+
+	// Subtract a one second moving average.
+	const window = SampleRate / HopSize
+	out := make([]float64, len(onsets))
+	sum := 0.0
+	for i, v := range onsets {
+		sum += v
+		if i >= window {
+			sum -= onsets[i-window]
+		}
+		n := min(i+1, window)
+		out[i] = math.Max(0, v-sum/float64(n))
+	}
+	return out
+}
+
+// tempoFromOnsets picks the beat period whose autocorrelation, weighted by
+// a log-normal prior around preferredBPM, is strongest.
+func tempoFromOnsets(onsets []float64) float64 {
+	framesPerMinute := 60.0 * SampleRate / HopSize
+	minLag := int(framesPerMinute / maxBPM)
+	maxLag := int(math.Ceil(framesPerMinute / minBPM))
+	if len(onsets) < 2*maxLag {
+		return 0
+	}
+
+	energy := 0.0
+	for _, v := range onsets {
+		energy += v * v
+	}
+	if energy == 0 {
+		return 0
+	}
+
+	acf := make([]float64, maxLag+2)
+	for lag := minLag - 1; lag <= maxLag+1; lag++ {
+		sum := 0.0
+		for i := lag; i < len(onsets); i++ {
+			sum += onsets[i] * onsets[i-lag]
+		}
+		acf[lag] = sum / energy
+	}
+
+	best, bestScore := 0, 0.0
+	for lag := minLag; lag <= maxLag; lag++ {
+		if acf[lag] < acf[lag-1] || acf[lag] < acf[lag+1] {
+			continue
+		}
+		octaves := math.Log2(framesPerMinute / float64(lag) / preferredBPM)
+		score := acf[lag] * math.Exp(-0.5*octaves*octaves)
+		if score > bestScore {
+			best, bestScore = lag, score
+		}
+	}
+	if best == 0 {
+		return 0
+	}
+
+	// Interpolate the peak between lags for sub-frame resolution.
+	lag := float64(best)
+	if d := acf[best-1] - 2*acf[best] + acf[best+1]; d != 0 {
+		lag += 0.5 * (acf[best-1] - acf[best+1]) / d
+	}
+	return math.Round(framesPerMinute/lag*10) / 10
+}
+
+// keyNames names the pitch classes in the order chroma stores them.
+var keyNames = [12]string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+// Krumhansl-Kessler key profiles for C major and C minor.
+var (
+	majorProfile = [12]float64{6.35, 2.23, 3.48, 2.33, 4.38, 4.09, 2.52, 5.19, 2.39, 3.66, 2.29, 2.88}
+	minorProfile = [12]float64{6.33, 2.68, 3.52, 5.38, 2.60, 3.53, 2.54, 4.75, 3.98, 2.69, 3.34, 3.17}
+)
+
+// EstimateKey names the key, such as "A minor", whose profile correlates
+// best with the song's overall pitch-class distribution. It returns "" for
+// an empty or flat sequence.
+func EstimateKey(seq database.ChromaSequence) string {
+	var profile [12]float64
+	for _, c := range seq {
+		for k, v := range c {
+			profile[k] += float64(v)
+		}
+	}
+
+	best, bestScore := "", 0.0
+	for tonic := 0; tonic < 12; tonic++ {
+		for _, mode := range []struct {
+			name    string
+			profile [12]float64
+		}{{"major", majorProfile}, {"minor", minorProfile}} {
+			var rotated [12]float64
+			for k := range rotated {
+				rotated[k] = mode.profile[(k-tonic+12)%12]
+			}
+			if r := correlation(profile[:], rotated[:]); r > bestScore {
+				best, bestScore = keyNames[tonic]+" "+mode.name, r
+			}
+		}
+	}
+	return best
+}
+
+// correlation is the Pearson correlation of two equally long vectors, or 0
+// if either is constant.
+func correlation(a, b []float64) float64 {
+	var meanA, meanB float64
+	for i := range a {
+		meanA += a[i]
+		meanB += b[i]
+	}
+	meanA /= float64(len(a))
+	meanB /= float64(len(b))
+
+	var cov, varA, varB float64
+	for i := range a {
+		da, db := a[i]-meanA, b[i]-meanB
+		cov += da * db
+		varA += da * da
+		varB += db * db
+	}
+	if varA == 0 || varB == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(varA*varB)
+}
@@ -0,0 +1,91 @@
+package audio
+
+import (
+	"math"
+	"sync"
+
+	"Shazam/internal/database"
+)
+
+// ChromaBlock is the number of frames averaged into one chroma profile,
+// about half a second of audio.
+const ChromaBlock = 20
+
+// chromaMinHz and chromaMaxHz bound the bins folded into pitch classes.
+// Lower bins are too coarse to resolve semitones and higher ones carry
+// mostly overtones and noise.
+const (
+	chromaMinHz = 80.0
+	chromaMaxHz = 5000.0
+)
+
+var (
+	chromaOnce sync.Once
+	chromaBins []int
+)
+
+// pitchClasses maps each bin of a FrameSize spectrum to its pitch class,
+// with C as 0, or -1 for bins outside the chroma range.
+func pitchClasses() []int {
+	chromaOnce.Do(func() {
+		chromaBins = make([]int, FrameSize/2)
+		for k := range chromaBins {
+			hz := float64(k) * SampleRate / FrameSize
+			if hz < chromaMinHz || hz > chromaMaxHz {
+				chromaBins[k] = -1
+				continue
+			}
+			semitone := int(math.Round(12 * math.Log2(hz/440)))
+			chromaBins[k] = ((semitone+9)%12 + 12) % 12
+		}
+	})
+	return chromaBins
+}
+
+// chromaAccumulator folds frame spectra into a chroma profile every
+// ChromaBlock frames. Inactive frames count towards their block without
+// adding to it, so profiles keep their place in time and a block of only
+// inactive frames is all zeros.
+type chromaAccumulator struct {
+	sum    [12]float64
+	frames int
+	seq    database.ChromaSequence
+}
+
+// add folds in the spectrum of the next frame, nil for an inactive one.
+func (a *chromaAccumulator) add(mags []float64) {
+	for k, pc := range pitchClasses() {
+		if pc >= 0 && k < len(mags) {
+			a.sum[pc] += mags[k] * mags[k]
+		}
+	}
+	a.frames++
+	if a.frames == ChromaBlock {
+		a.flush()
+	}
+}
+
+func (a *chromaAccumulator) flush() {
+	if a.frames == 0 {
+		return
+	}
+	peak := 0.0
+	for _, v := range a.sum {
+		peak = math.Max(peak, v)
+	}
+
+	var c database.Chroma
+	if peak > 0 {
+		for i, v := range a.sum {
+			c[i] = uint8(math.Round(v / peak * 255))
+		}
+	}
+	a.seq = append(a.seq, c)
+	a.sum = [12]float64{}
+	a.frames = 0
+}
+
+// finish returns the sequence, dropping a trailing partial block.
+func (a *chromaAccumulator) finish() database.ChromaSequence {
+	return a.seq
+}
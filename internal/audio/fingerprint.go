@@ -7,68 +7,125 @@ import (
 	"math"
 	"math/cmplx"
 	"sort"
-	"strings"
+	"sync"
 
 	"Shazam/internal/database"
 )
 
 type AudioFingerprint struct {
-	TrackID      string   `json:"track_id"`
-	TrackName    string   `json:"track_name"`
-	Artist       string   `json:"artist"`
-	Fingerprint  string   `json:"fingerprint"`
-	HashSegments []string `json:"hash_segments"`
+	TrackID      string                `json:"track_id"`
+	TrackName    string                `json:"track_name"`
+	Artist       string                `json:"artist"`
+	Fingerprint  string                `json:"fingerprint"`
+	HashSegments database.HashSegments `json:"hash_segments"`
+	// Offset is the number of inactive frames trimmed from the start, so
+	// that segment i hashes frame Offset+i of the audio.
+	Offset int `json:"offset"`
+	// Chroma is only computed by GenerateFingerprint. It covers every
+	// frame, trimmed or not.
+	Chroma database.ChromaSequence `json:"-"`
 }
 
+// FingerprintVersion numbers how references are fingerprinted and is
+// stored with every song. Songs of an older version were hashed before
+// inactive frames were trimmed with the offset recorded, so their times
+// may be off; the analysis backfill fingerprints them again.
+const FingerprintVersion = 1
+
+const (
+	// SampleRate is the rate audio is resampled to before fingerprinting.
+	SampleRate = 22050
+	// FrameSize is the FFT window length in samples.
+	FrameSize = 2048
+	// HopSize is the distance between consecutive frames in samples; each
+	// hash segment therefore covers HopSize/SampleRate seconds.
+	HopSize = 512
+)
+
+// GenerateFingerprint hashes every frame of samples. Frames the activity
+// detector finds inactive are marked with database.SilentHash, and those at
+// either end are trimmed; Offset records how many were trimmed from the
+// start.
 func GenerateFingerprint(samples []float64) (*AudioFingerprint, error) {
 	if len(samples) < 1024 {
 		return nil, fmt.Errorf("insufficient audio samples")
 	}
 
-	windowSize := 2048
-	hopSize := 512
-	hashSegments := []string{}
-
-	for i := 0; i < len(samples)-windowSize; i += hopSize {
-		window := samples[i : i+windowSize]
-
-		if rms(window) < 0.00001 {
-			continue
-		}
+	hashSegments := database.HashSegments{}
+	var chroma chromaAccumulator
+	var activity activityDetector
 
-		windowed := make([]complex128, windowSize)
-		for j, sample := range window {
-			w := 0.54 - 0.46*math.Cos(2*math.Pi*float64(j)/float64(windowSize-1))
-			windowed[j] = complex(sample*w, 0)
-		}
-
-		spectrum := fft(windowed)
-		hash := createRobustHash(spectrum)
-		if hash != "" {
-			hashSegments = append(hashSegments, hash)
-		}
+	for i := 0; i < len(samples)-FrameSize; i += HopSize {
+		mags := activity.spectrum(samples[i : i+FrameSize])
+		hashSegments = append(hashSegments, spectrumHash(mags, 1))
+		chroma.add(mags)
 	}
 
+	hashSegments, offset := trimSilence(hashSegments)
 	if len(hashSegments) < 1 {
 		return nil, fmt.Errorf("too few hash segments generated: %d", len(hashSegments))
 	}
 
-	combinedHash := strings.Join(hashSegments, "")
-	finalHash := sha256.Sum256([]byte(combinedHash))
-
 	return &AudioFingerprint{
-		Fingerprint:  hex.EncodeToString(finalHash[:]),
+		Fingerprint:  digest(hashSegments),
 		HashSegments: hashSegments,
+		Offset:       offset,
+		Chroma:       chroma.finish(),
 	}, nil
 }
 
-func createRobustHash(spectrum []complex128) string {
+// frameSpectrum returns the magnitude spectrum of one FrameSize window, or
+// false for frames too quiet to carry a usable spectrum.
+func frameSpectrum(window []float64) ([]float64, bool) {
+	if rms(window) < 0.00001 {
+		return nil, false
+	}
+	return magnitudes(windowedFFT(window)), true
+}
+
+// windowedFFT applies a Hamming window and transforms the frame.
+func windowedFFT(window []float64) []complex128 {
+	windowed := make([]complex128, len(window))
+	for j, sample := range window {
+		w := 0.54 - 0.46*math.Cos(2*math.Pi*float64(j)/float64(len(window)-1))
+		windowed[j] = complex(sample*w, 0)
+	}
+	return fft(windowed)
+}
+
+// digest summarises the segments into the song-level fingerprint. It
+// covers the hex form so it matches fingerprints stored before hashes were
+// packed into integers.
+func digest(hashSegments database.HashSegments) string {
+	sum := sha256.New()
+	for _, h := range hashSegments {
+		sum.Write([]byte(database.FormatHash(h)))
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+func createRobustHash(spectrum []complex128) uint64 {
+	return bandHash(magnitudes(spectrum), 1)
+}
+
+// magnitudes returns the magnitudes of the positive-frequency half of a
+// spectrum.
+func magnitudes(spectrum []complex128) []float64 {
 	n := len(spectrum) / 2
 	mags := make([]float64, n)
 	const eps = 1e-12
 	for i := 0; i < n; i++ {
 		mags[i] = cmplx.Abs(spectrum[i]) + eps
 	}
+	return mags
+}
+
+// bandHash packs the level of 16 equal-width bands, relative to their
+// median, into one nibble each. A pitch other than 1 reads each band from
+// where it lands in audio transposed by that factor.
+func bandHash(mags []float64, pitch float64) uint64 {
+	n := len(mags)
+	const eps = 1e-12
 
 	numBands := 16
 	bandSize := n / numBands
@@ -80,11 +137,22 @@ func createRobustHash(spectrum []complex128) string {
 		if b == numBands-1 || end > n {
 			end = n
 		}
-		var sum float64
-		for i := start; i < end; i++ {
-			sum += mags[i]
+		if pitch != 1 {
+			start = int(math.Round(float64(start) * pitch))
+			end = max(int(math.Round(float64(end)*pitch)), start+1)
+		}
+		if end > n {
+			end = n
+		}
+
+		avg := eps
+		if start < end {
+			var sum float64
+			for i := start; i < end; i++ {
+				sum += mags[i]
+			}
+			avg = sum / float64(end-start)
 		}
-		avg := sum / float64(end-start)
 
 		bands[b] = 20.0 * math.Log10(avg)
 	}
@@ -95,8 +163,7 @@ func createRobustHash(spectrum []complex128) string {
 	}
 
 	const lo, hi = -24.0, 24.0
-	hash := make([]byte, numBands)
-	hexDigits := []byte("0123456789abcdef")
+	var hash uint64
 	for b := 0; b < numBands; b++ {
 		v := bands[b]
 		if v < lo {
@@ -105,10 +172,10 @@ func createRobustHash(spectrum []complex128) string {
 		if v > hi {
 			v = hi
 		}
-		q := int(math.Round((v - lo) / (hi - lo) * 15.0))
-		hash[b] = hexDigits[q]
+		q := uint64(math.Round((v - lo) / (hi - lo) * 15.0))
+		hash = hash<<4 | q
 	}
-	return string(hash)
+	return hash
 }
 
 func median(xs []float64) float64 {
@@ -124,28 +191,64 @@ func median(xs []float64) float64 {
 	return 0.5 * (tmp[n/2-1] + tmp[n/2])
 }
 
+// fft is an iterative radix-2 transform. It performs the same butterflies
+// with the same twiddle factors as the textbook recursive form, so results
+// are bit-for-bit identical to fingerprints computed with it.
 func fft(x []complex128) []complex128 {
 	n := len(x)
+	result := make([]complex128, n)
 	if n <= 1 {
-		return x
+		copy(result, x)
+		return result
 	}
-	even := make([]complex128, n/2)
-	odd := make([]complex128, n/2)
-	for i := 0; i < n/2; i++ {
-		even[i] = x[2*i]
-		odd[i] = x[2*i+1]
+
+	bits := 0
+	for 1<<bits < n {
+		bits++
 	}
-	evenFFT := fft(even)
-	oddFFT := fft(odd)
-	result := make([]complex128, n)
-	for i := 0; i < n/2; i++ {
-		t := cmplx.Exp(complex(0, -2*math.Pi*float64(i)/float64(n))) * oddFFT[i]
-		result[i] = evenFFT[i] + t
-		result[i+n/2] = evenFFT[i] - t
+	for i, v := range x {
+		result[reverseBits(i, bits)] = v
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		half := size / 2
+		w := twiddles(size)
+		for start := 0; start < n; start += size {
+			for i := 0; i < half; i++ {
+				t := w[i] * result[start+i+half]
+				result[start+i+half] = result[start+i] - t
+				result[start+i] += t
+			}
+		}
 	}
 	return result
 }
 
+func reverseBits(i, bits int) int {
+	r := 0
+	for b := 0; b < bits; b++ {
+		r = r<<1 | i&1
+		i >>= 1
+	}
+	return r
+}
+
+// twiddleCache holds the twiddle factors of each FFT size, which are
+// otherwise recomputed for every butterfly of every frame.
+var twiddleCache sync.Map
+
+func twiddles(n int) []complex128 {
+	if w, ok := twiddleCache.Load(n); ok {
+		return w.([]complex128)
+	}
+	w := make([]complex128, n/2)
+	for i := range w {
+		w[i] = cmplx.Exp(complex(0, -2*math.Pi*float64(i)/float64(n)))
+	}
+	twiddleCache.Store(n, w)
+	return w
+}
+
 func rms(samples []float64) float64 {
 	var sum float64
 	for _, sample := range samples {
@@ -161,5 +264,6 @@ func ConvertSongToFingerprint(song *database.Song) *AudioFingerprint {
 		Artist:       song.Artist,
 		Fingerprint:  song.Fingerprint,
 		HashSegments: song.HashSegments,
+		Offset:       song.HashOffset,
 	}
 }
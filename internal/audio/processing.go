@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
@@ -24,6 +25,16 @@ const (
 )
 
 func ProcessAudioFile(filePath string) (*AudioFingerprint, error) {
+	samples, err := LoadSamples(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return GenerateFingerprint(samples)
+}
+
+// LoadSamples decodes an audio file to mono samples at SampleRate.
+func LoadSamples(filePath string) ([]float64, error) {
 	cmd := exec.Command("ffmpeg", "-i", filePath, "-f", "f64le", "-acodec", "pcm_f64le", "-ac", "1", "-ar", "22050", "-")
 	output, err := cmd.Output()
 	if err != nil {
@@ -39,7 +50,7 @@ func ProcessAudioFile(filePath string) (*AudioFingerprint, error) {
 		samples[i] = math.Float64frombits(bits)
 	}
 
-	return GenerateFingerprint(samples)
+	return samples, nil
 }
 
 func DownloadAudioPreview(searchQuery string, outputPath string) error {
@@ -68,7 +79,11 @@ func DownloadAudioPreview(searchQuery string, outputPath string) error {
 	return nil
 }
 
-func AddSongToDatabase(db *database.DB, artistName, songName, albumName string) error {
+// AddSongToDatabase downloads, fingerprints and stores a song. When
+// audioDir is not empty the downloaded audio is kept there as the song's
+// source audio, named by SourceAudioPath. The song goes into library, the
+// default library when 0.
+func AddSongToDatabase(db database.SongStore, audioDir string, library int, artistName, songName, albumName string) (*database.Song, error) {
 	fmt.Printf("🎵 Adding song to database: %s - %s\n", artistName, songName)
 
 	searchQuery := fmt.Sprintf("%s %s", artistName, songName)
@@ -76,25 +91,51 @@ func AddSongToDatabase(db *database.DB, artistName, songName, albumName string)
 
 	err := DownloadAudioPreview(searchQuery, tempFile)
 	if err != nil {
-		return fmt.Errorf("failed to download %s - %s: %v", artistName, songName, err)
+		return nil, fmt.Errorf("failed to download %s - %s: %v", artistName, songName, err)
 	}
 	defer os.Remove(tempFile)
 
-	fingerprint, err := ProcessAudioFile(tempFile)
+	samples, err := LoadSamples(tempFile)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := GenerateFingerprint(samples)
 	if err != nil {
-		return fmt.Errorf("failed to generate fingerprint: %v", err)
+		return nil, fmt.Errorf("failed to generate fingerprint: %v", err)
 	}
 
 	song := &database.Song{
-		Title:        songName,
-		Artist:       artistName,
-		Album:        albumName,
-		Duration:     len(fingerprint.HashSegments) * 512 / 22050,
-		Fingerprint:  fingerprint.Fingerprint,
-		HashSegments: fingerprint.HashSegments,
+		Title:              songName,
+		Artist:             artistName,
+		Album:              albumName,
+		Duration:           (fingerprint.Offset + len(fingerprint.HashSegments)) * 512 / 22050,
+		Fingerprint:        fingerprint.Fingerprint,
+		HashSegments:       fingerprint.HashSegments,
+		HashOffset:         fingerprint.Offset,
+		FingerprintVersion: FingerprintVersion,
+		Chroma:             fingerprint.Chroma,
+		BPM:                EstimateBPM(samples),
+		Key:                EstimateKey(fingerprint.Chroma),
+		LibraryID:          library,
+	}
+
+	if err := db.AddSong(song); err != nil {
+		return nil, err
+	}
+
+	if audioDir != "" {
+		if err := os.MkdirAll(audioDir, 0755); err == nil {
+			if err := os.Rename(tempFile, SourceAudioPath(audioDir, song.ID)); err != nil {
+				fmt.Printf("⚠️ Could not keep source audio for song %d: %v\n", song.ID, err)
+			}
+		}
 	}
+	return song, nil
+}
 
-	return db.AddSong(song)
+// SourceAudioPath is where the source audio of a song is kept.
+func SourceAudioPath(audioDir string, songID int) string {
+	return filepath.Join(audioDir, fmt.Sprintf("%d.wav", songID))
 }
 
 func ExtractSpotifyID(input string) (string, error) {
@@ -0,0 +1,75 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+
+	"Shazam/internal/database"
+)
+
+// Shift describes how a clip differs from the original recording: Tempo is
+// how many times faster it plays and Pitch how many times higher it
+// sounds. Speeding a recording up scales both by the same factor; time
+// stretching changes only Tempo and pitch shifting only Pitch.
+type Shift struct {
+	Tempo float64 `json:"tempo"`
+	Pitch float64 `json:"pitch"`
+}
+
+// GenerateShiftedFingerprints fingerprints samples once per shift, each
+// time undoing that shift so the hashes line up with the original
+// recording: frames are taken every HopSize/Tempo samples and bands are
+// read from frequencies scaled by Pitch. Inactive frames are marked and
+// trimmed as in GenerateFingerprint. Shifts with the same tempo share
+// their FFTs. The result is ordered like shifts.
+func GenerateShiftedFingerprints(samples []float64, shifts []Shift) ([]*AudioFingerprint, error) {
+	if len(samples) < 1024 {
+		return nil, fmt.Errorf("insufficient audio samples")
+	}
+
+	spectra := make(map[float64][][]float64)
+	fingerprints := make([]*AudioFingerprint, len(shifts))
+
+	for i, shift := range shifts {
+		frames, ok := spectra[shift.Tempo]
+		if !ok {
+			frames = stretchedSpectra(samples, shift.Tempo)
+			spectra[shift.Tempo] = frames
+		}
+		if len(frames) == 0 {
+			return nil, fmt.Errorf("too few hash segments generated at tempo %.2f", shift.Tempo)
+		}
+
+		hashSegments := make(database.HashSegments, len(frames))
+		for j, mags := range frames {
+			hashSegments[j] = spectrumHash(mags, shift.Pitch)
+		}
+		hashSegments, offset := trimSilence(hashSegments)
+		if len(hashSegments) == 0 {
+			return nil, fmt.Errorf("too few hash segments generated at tempo %.2f", shift.Tempo)
+		}
+		fingerprints[i] = &AudioFingerprint{
+			Fingerprint:  digest(hashSegments),
+			HashSegments: hashSegments,
+			Offset:       offset,
+		}
+	}
+
+	return fingerprints, nil
+}
+
+// stretchedSpectra returns the magnitude spectra of the frames taken every
+// HopSize/tempo samples, nil for inactive ones.
+func stretchedSpectra(samples []float64, tempo float64) [][]float64 {
+	hop := HopSize / tempo
+	var frames [][]float64
+	var activity activityDetector
+	for k := 0; ; k++ {
+		i := int(math.Round(float64(k) * hop))
+		if i >= len(samples)-FrameSize {
+			break
+		}
+		frames = append(frames, activity.spectrum(samples[i:i+FrameSize]))
+	}
+	return frames
+}
@@ -0,0 +1,16 @@
+package audio
+
+// Spectrogram returns the magnitude spectrum of every frame the
+// fingerprint is computed from, one per HopSize samples. Frames too quiet
+// to hash are kept as all zeros so that columns stay evenly spaced in time.
+func Spectrogram(samples []float64) [][]float64 {
+	var frames [][]float64
+	for i := 0; i+FrameSize <= len(samples); i += HopSize {
+		mags, ok := frameSpectrum(samples[i : i+FrameSize])
+		if !ok {
+			mags = make([]float64, FrameSize/2)
+		}
+		frames = append(frames, mags)
+	}
+	return frames
+}
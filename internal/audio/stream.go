@@ -0,0 +1,139 @@
+package audio
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+
+	"Shazam/internal/database"
+)
+
+// StreamFingerprinter hashes audio incrementally as samples arrive. Feeding
+// it a clip in any number of pieces yields the same segments that
+// GenerateFingerprint produces for the whole clip, except that inactive
+// frames at the end are not trimmed as more audio may follow.
+type StreamFingerprinter struct {
+	buf      []float64
+	activity activityDetector
+	started  bool
+	skipped  int
+}
+
+// Offset is the number of inactive frames dropped before the first
+// segment, which the times of segments count on from.
+func (s *StreamFingerprinter) Offset() int {
+	return s.skipped
+}
+
+// Push appends mono SampleRate samples and returns the hashes of every
+// frame they complete.
+func (s *StreamFingerprinter) Push(samples []float64) database.HashSegments {
+	s.buf = append(s.buf, samples...)
+
+	var segments database.HashSegments
+	start := 0
+	for len(s.buf)-start > FrameSize {
+		hash := spectrumHash(s.activity.spectrum(s.buf[start:start+FrameSize]), 1)
+		if hash != database.SilentHash {
+			s.started = true
+		}
+		if s.started {
+			segments = append(segments, hash)
+		} else {
+			s.skipped++
+		}
+		start += HopSize
+	}
+	s.buf = append(s.buf[:0], s.buf[start:]...)
+
+	return segments
+}
+
+// DecodeStream runs ffmpeg on input, which may be a file path or a stream
+// URL, and passes the decoded mono SampleRate samples to fn in chunks of
+// chunkSamples. It returns when the input ends, ctx is cancelled or fn
+// returns an error.
+func DecodeStream(ctx context.Context, input string, chunkSamples int, fn func([]float64) error) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-i", input, "-vn", "-f", "f64le",
+		"-acodec", "pcm_f64le", "-ac", "1", "-ar", fmt.Sprint(SampleRate), "-")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %v", err)
+	}
+
+	readErr := readSamples(bufio.NewReader(stdout), chunkSamples, fn)
+	if readErr != nil {
+		_ = cmd.Process.Kill()
+	}
+	waitErr := cmd.Wait()
+
+	if readErr != nil {
+		return readErr
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if waitErr != nil {
+		return fmt.Errorf("failed to decode audio: %v", waitErr)
+	}
+	return nil
+}
+
+func readSamples(r io.Reader, chunkSamples int, fn func([]float64) error) error {
+	raw := make([]byte, chunkSamples*8)
+	samples := make([]float64, chunkSamples)
+
+	for {
+		n, err := io.ReadFull(r, raw)
+		count := n / 8
+		for i := 0; i < count; i++ {
+			samples[i] = math.Float64frombits(binary.LittleEndian.Uint64(raw[i*8:]))
+		}
+		if count > 0 {
+			if err := fn(samples[:count]); err != nil {
+				return err
+			}
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ProcessLongAudioFile fingerprints a recording of any length without
+// holding its decoded samples in memory.
+func ProcessLongAudioFile(filePath string) (*AudioFingerprint, error) {
+	var fp StreamFingerprinter
+	var segments database.HashSegments
+
+	err := DecodeStream(context.Background(), filePath, SampleRate, func(samples []float64) error {
+		segments = append(segments, fp.Push(samples)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	segments, _ = trimSilence(segments)
+	if len(segments) < 1 {
+		return nil, fmt.Errorf("too few hash segments generated: %d", len(segments))
+	}
+
+	return &AudioFingerprint{
+		Fingerprint:  digest(segments),
+		HashSegments: segments,
+		Offset:       fp.Offset(),
+	}, nil
+}
@@ -0,0 +1,171 @@
+// Package backup keeps timestamped online backups of the song database in
+// a directory, prunes them to a retention count and restores from them.
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"Shazam/internal/database"
+)
+
+// Kinds of backup, by what made them.
+const (
+	Manual     = "manual"
+	Scheduled  = "scheduled"
+	PreRestore = "pre-restore"
+)
+
+// ErrNotFound is returned when restoring a backup that is not in the
+// directory.
+var ErrNotFound = errors.New("backup not found")
+
+// Backup is one backup file.
+type Backup struct {
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Backups are named songs-<time>-<kind>.db, the time in UTC down to the
+// millisecond so that names sort by age.
+const stampLayout = "20060102-150405.000"
+
+var namePattern = regexp.MustCompile(`^songs-(\d{8}-\d{6}\.\d{3})-([a-z-]+)\.db$`)
+
+// Service makes, lists and restores the backups in one directory, one at a
+// time.
+type Service struct {
+	db   database.BackupStore
+	dir  string
+	keep int
+
+	mu sync.Mutex
+}
+
+// NewService keeps the backups of db in dir, pruning all but the newest
+// keep of them, or none when keep is 0.
+func NewService(db database.BackupStore, dir string, keep int) *Service {
+	return &Service{db: db, dir: dir, keep: keep}
+}
+
+// Create backs the database up now and prunes old backups.
+func (s *Service) Create(kind string) (*Backup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := s.create(kind)
+	if err != nil {
+		return nil, err
+	}
+	s.prune()
+	return b, nil
+}
+
+func (s *Service) create(kind string) (*Backup, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	name := fmt.Sprintf("songs-%s-%s.db", now.Format(stampLayout), kind)
+	path := filepath.Join(s.dir, name)
+	if err := s.db.Backup(path); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Backup{Name: name, Kind: kind, Size: info.Size(), CreatedAt: now.Truncate(time.Millisecond)}, nil
+}
+
+// List returns the backups in the directory, newest first.
+func (s *Service) List() ([]*Backup, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []*Backup{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []*Backup{}
+	for _, entry := range entries {
+		m := namePattern.FindStringSubmatch(entry.Name())
+		if m == nil || !entry.Type().IsRegular() {
+			continue
+		}
+		createdAt, err := time.Parse(stampLayout, m[1])
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, &Backup{Name: entry.Name(), Kind: m[2], Size: info.Size(), CreatedAt: createdAt})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
+
+// Restore replaces the database with the named backup. The current
+// database is backed up first, so a restore can itself be undone; that
+// backup is returned.
+func (s *Service) Restore(name string) (*Backup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	backups, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, b := range backups {
+		found = found || b.Name == name
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	previous, err := s.create(PreRestore)
+	if err != nil {
+		return nil, fmt.Errorf("backing up the current database: %w", err)
+	}
+	defer s.prune()
+	if err := s.db.Restore(filepath.Join(s.dir, name)); err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+// prune deletes all but the newest backups. Failures are only logged, as
+// the backup that triggered the pruning was made.
+func (s *Service) prune() {
+	if s.keep <= 0 {
+		return
+	}
+	backups, err := s.List()
+	if err != nil {
+		log.Printf("Failed to list backups for pruning: %v", err)
+		return
+	}
+	for _, b := range backups[min(s.keep, len(backups)):] {
+		if err := os.Remove(filepath.Join(s.dir, b.Name)); err != nil {
+			log.Printf("Failed to prune backup %s: %v", b.Name, err)
+		}
+	}
+}
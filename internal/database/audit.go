@@ -0,0 +1,228 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The audit log records who created, updated or deleted what in the
+// library, keeping the entity as JSON before and after each change so that
+// a change can be shown and undone.
+
+// AuditStore keeps the audit log. Each entry belongs to the library the
+// change was made in, the default library when stored without one. Adding
+// an entry that undoes another marks that one undone, reporting
+// sql.ErrNoRows if it is missing or was undone already.
+//
+// RevertSong undoes a change to a song and records the undo entry in one
+// step: undoing action create deletes the song, delete restores it and
+// update puts previous, with its library and tags, back. If any part
+// fails, such as the entry having been undone meanwhile, nothing changes.
+type AuditStore interface {
+	AddAuditEntry(entry *AuditEntry) error
+	GetAuditEntries(filter AuditFilter) ([]*AuditEntry, int, error)
+	RevertSong(undo *AuditEntry, action string, previous *Song, tags []string) error
+}
+
+// createAuditTables creates the audit log.
+func (db *DB) createAuditTables() error {
+	id, timestamp := "INTEGER PRIMARY KEY AUTOINCREMENT", "DATETIME"
+	if db.dialect == postgresDialect {
+		id, timestamp = "BIGSERIAL PRIMARY KEY", "TIMESTAMPTZ"
+	}
+	_, err := db.exec(`
+    CREATE TABLE IF NOT EXISTS audit_log (
+        id ` + id + `,
+        created_at ` + timestamp + ` NOT NULL,
+        actor TEXT NOT NULL,
+        action TEXT NOT NULL,
+        entity TEXT NOT NULL,
+        entity_id BIGINT NOT NULL,
+        library_id BIGINT,
+        before_json TEXT,
+        after_json TEXT,
+        undo_of BIGINT,
+        undone_at ` + timestamp + `
+    );
+
+    CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log(entity, entity_id);
+    CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log(created_at);
+    `)
+	if err != nil {
+		return err
+	}
+
+	// Logs from before entries had a library get the column here, as the
+	// table is created after addColumns runs, and go to the default one.
+	if db.dialect == postgresDialect {
+		_, err = db.exec(`ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS library_id BIGINT`)
+	} else if exists, hasErr := db.hasColumn("audit_log", "library_id"); hasErr != nil {
+		err = hasErr
+	} else if !exists {
+		_, err = db.exec(`ALTER TABLE audit_log ADD COLUMN library_id INTEGER`)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = db.exec(`UPDATE audit_log SET library_id = ? WHERE library_id IS NULL`, db.defaultLibrary)
+	return err
+}
+
+func (db *DB) AddAuditEntry(entry *AuditEntry) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC()
+	}
+	if entry.UndoOf != 0 {
+		result, err := db.exec(`UPDATE audit_log SET undone_at = ? WHERE id = ? AND undone_at IS NULL`,
+			entry.CreatedAt.UTC(), entry.UndoOf)
+		if err != nil {
+			return err
+		}
+		if err := requireRow(result); err != nil {
+			return err
+		}
+	}
+
+	entry.LibraryID = db.libraryOf(entry.LibraryID)
+	id, err := db.insert(`
+    INSERT INTO audit_log (created_at, actor, action, entity, entity_id, library_id, before_json, after_json, undo_of)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.CreatedAt.UTC(), entry.Actor, entry.Action, entry.Entity, entry.EntityID, entry.LibraryID,
+		nullJSON(entry.Before), nullJSON(entry.After), nullID(entry.UndoOf))
+	if err != nil {
+		return err
+	}
+	entry.ID = id
+	entry.UndoneAt = nil
+	return nil
+}
+
+func (db *DB) RevertSong(undo *AuditEntry, action string, previous *Song, tags []string) error {
+	return db.inTx(func(tx *DB) error {
+		var err error
+		switch action {
+		case AuditCreate:
+			err = tx.DeleteSong(previous.ID)
+		case AuditDelete:
+			err = tx.RestoreSong(previous.ID)
+		case AuditUpdate:
+			err = tx.revertSongUpdate(previous, tags)
+		default:
+			err = fmt.Errorf("cannot undo %s", action)
+		}
+		if err != nil {
+			return err
+		}
+		return tx.AddAuditEntry(undo)
+	})
+}
+
+// revertSongUpdate puts the names, library and tags of a song back.
+func (db *DB) revertSongUpdate(previous *Song, tags []string) error {
+	if err := db.UpdateSong(previous); err != nil {
+		return err
+	}
+	if previous.LibraryID != 0 {
+		if _, err := db.exec(`UPDATE songs SET library_id = ? WHERE id = ?`, previous.LibraryID, previous.ID); err != nil {
+			return err
+		}
+	}
+	return db.SetSongTags(previous.ID, tags)
+}
+
+// nullJSON stores a missing before or after state as NULL.
+func nullJSON(data []byte) sql.NullString {
+	return sql.NullString{String: string(data), Valid: len(data) > 0}
+}
+
+// GetAuditEntries lists the audit log entries matching filter, newest
+// first, together with the total number of matching entries.
+func (db *DB) GetAuditEntries(filter AuditFilter) ([]*AuditEntry, int, error) {
+	var where []string
+	var args []interface{}
+
+	if filter.Actor != "" {
+		where = append(where, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Entity != "" {
+		where = append(where, "entity = ?")
+		args = append(args, filter.Entity)
+	}
+	if filter.EntityID != 0 {
+		where = append(where, "entity_id = ?")
+		args = append(args, filter.EntityID)
+	}
+	if filter.LibraryID != 0 {
+		where = append(where, "library_id = ?")
+		args = append(args, filter.LibraryID)
+	}
+	if filter.Undone != nil {
+		if *filter.Undone {
+			where = append(where, "undone_at IS NOT NULL")
+		} else {
+			where = append(where, "undone_at IS NULL")
+		}
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, filter.To.UTC())
+	}
+
+	clause := ""
+	if len(where) > 0 {
+		clause = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := db.queryRow(`SELECT COUNT(*) FROM audit_log`+clause, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := db.query(`
+    SELECT id, created_at, actor, action, entity, entity_id, library_id, COALESCE(before_json, ''),
+        COALESCE(after_json, ''), COALESCE(undo_of, 0), undone_at
+    FROM audit_log`+clause+` ORDER BY id DESC LIMIT ? OFFSET ?`,
+		append(args, db.dialect.limit(limit), filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		e := &AuditEntry{}
+		var before, after string
+		var undoneAt sql.NullTime
+		err := rows.Scan(&e.ID, &e.CreatedAt, &e.Actor, &e.Action, &e.Entity, &e.EntityID, &e.LibraryID,
+			&before, &after, &e.UndoOf, &undoneAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		if before != "" {
+			e.Before = []byte(before)
+		}
+		if after != "" {
+			e.After = []byte(after)
+		}
+		if undoneAt.Valid {
+			e.UndoneAt = &undoneAt.Time
+		}
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
@@ -0,0 +1,200 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Backups copy the SQLite database page by page through SQLite's online
+// backup API, so they are consistent even while the server writes to it.
+
+var (
+	// ErrBackupUnsupported is returned when backing up or restoring a
+	// database other than SQLite, which has its own tools for it.
+	ErrBackupUnsupported = errors.New("backups are only supported for SQLite databases")
+	// ErrInvalidBackup is returned when restoring from a file that is not
+	// a sound song database.
+	ErrInvalidBackup = errors.New("not a valid song database backup")
+)
+
+// BackupStore is a store that can be copied to a file and restored from
+// one while in use.
+type BackupStore interface {
+	Backup(path string) error
+	Restore(path string) error
+}
+
+var _ BackupStore = (*DB)(nil)
+
+// backupTimeout bounds how long a backup waits for other connections to
+// release their locks.
+const backupTimeout = 30 * time.Second
+
+// Backup writes a consistent copy of the database to path, which must not
+// exist yet.
+func (db *DB) Backup(path string) error {
+	if db.dialect != sqliteDialect {
+		return ErrBackupUnsupported
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	if err := copyDatabase(dest, db.conn); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// Restore replaces the contents of the database with the backup at path
+// and brings its schema up to date. The backup is checked first and the
+// database is left as it was if the backup is not a sound song database.
+//
+// IDs handed out since the backup are not handed out again: files kept
+// under a song's ID, such as its source audio, would otherwise be taken
+// for those of the next song added. Writes wait until the restore is done,
+// so none is lost between reading the IDs and replacing the database.
+func (db *DB) Restore(path string) error {
+	if db.dialect != sqliteDialect {
+		return ErrBackupUnsupported
+	}
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	src, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := checkBackup(src); err != nil {
+		return err
+	}
+
+	db.writes.Lock()
+	defer db.writes.Unlock()
+	// The restore writes through a copy that does not wait for the lock
+	// it holds.
+	restorer := &DB{conn: db.conn, dialect: db.dialect, parent: db}
+	issued, err := restorer.sequences()
+	if err != nil {
+		return err
+	}
+	if err := copyDatabase(db.conn, src); err != nil {
+		return err
+	}
+	if err := restorer.migrate(); err != nil {
+		return err
+	}
+	db.fullText, db.defaultLibrary = restorer.fullText, restorer.defaultLibrary
+	return restorer.keepSequences(issued)
+}
+
+// sequences reads the last ID each AUTOINCREMENT table handed out.
+func (db *DB) sequences() (map[string]int64, error) {
+	rows, err := db.conn.Query(`SELECT name, seq FROM sqlite_sequence`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	issued := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var seq int64
+		if err := rows.Scan(&name, &seq); err != nil {
+			return nil, err
+		}
+		issued[name] = seq
+	}
+	return issued, rows.Err()
+}
+
+// keepSequences moves the AUTOINCREMENT sequences back up to the IDs in
+// issued, so that no ID is handed out twice.
+func (db *DB) keepSequences(issued map[string]int64) error {
+	for name, seq := range issued {
+		_, err := db.conn.Exec(`UPDATE sqlite_sequence SET seq = ? WHERE name = ? AND seq < ?`, seq, name, seq)
+		if err != nil {
+			return err
+		}
+		_, err = db.conn.Exec(`INSERT INTO sqlite_sequence (name, seq)
+            SELECT ?, ? WHERE NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = ?)`, name, seq, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBackup verifies that conn holds an intact database with songs.
+func checkBackup(conn *sql.DB) error {
+	if err := integrityCheck(conn, "quick"); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+
+	var tables int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'songs'`).Scan(&tables); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if tables == 0 {
+		return fmt.Errorf("%w: no songs table", ErrInvalidBackup)
+	}
+	return nil
+}
+
+// copyDatabase copies the whole of src over dest in one step, retrying
+// while either is locked by another connection.
+func copyDatabase(dest, src *sql.DB) error {
+	ctx := context.Background()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(d interface{}) error {
+		return srcConn.Raw(func(s interface{}) error {
+			backup, err := d.(*sqlite3.SQLiteConn).Backup("main", s.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+
+			deadline := time.Now().Add(backupTimeout)
+			for {
+				done, err := backup.Step(-1)
+				if err != nil {
+					backup.Close()
+					return err
+				}
+				if done {
+					return backup.Finish()
+				}
+				if time.Now().After(deadline) {
+					backup.Close()
+					return errors.New("database stayed locked, backup timed out")
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		})
+	})
+}
@@ -0,0 +1,410 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// The catalog gives each artist and album a row of its own that songs link
+// to by artist_id and album_id. Names are matched by nameKey, so spellings
+// differing in case, accents or punctuation share an entry, and an artist
+// merged into another leaves its names behind in artist_aliases. The songs
+// table keeps a copy of the catalog names it links to, which is what song
+// listings, search and history read.
+
+// createCatalogTables creates the artists, their aliases and albums.
+func (db *DB) createCatalogTables() error {
+	id := "INTEGER PRIMARY KEY AUTOINCREMENT"
+	if db.dialect == postgresDialect {
+		id = "BIGSERIAL PRIMARY KEY"
+	}
+	_, err := db.exec(`
+    CREATE TABLE IF NOT EXISTS artists (
+        id ` + id + `,
+        name TEXT NOT NULL,
+        name_key TEXT NOT NULL UNIQUE
+    );
+
+    CREATE TABLE IF NOT EXISTS artist_aliases (
+        name_key TEXT PRIMARY KEY,
+        name TEXT NOT NULL,
+        artist_id BIGINT NOT NULL REFERENCES artists(id)
+    );
+
+    CREATE INDEX IF NOT EXISTS idx_artist_aliases_artist ON artist_aliases(artist_id);
+
+    CREATE TABLE IF NOT EXISTS albums (
+        id ` + id + `,
+        artist_id BIGINT NOT NULL REFERENCES artists(id),
+        title TEXT NOT NULL,
+        title_key TEXT NOT NULL,
+        UNIQUE (artist_id, title_key)
+    );
+    `)
+	return err
+}
+
+// linkSongs indexes the catalog links of songs and backfills them for
+// songs added before the catalog existed, settling each on the first
+// spelling of its artist and album in the library. The backfill is made in
+// one transaction, so that an upgrade that fails leaves no song half
+// linked.
+func (db *DB) linkSongs() error {
+	_, err := db.exec(`
+    CREATE INDEX IF NOT EXISTS idx_songs_artist ON songs(artist_id);
+    CREATE INDEX IF NOT EXISTS idx_songs_album ON songs(album_id);
+    `)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.query(`SELECT id, artist, COALESCE(album, '') FROM songs WHERE artist_id IS NULL ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	var unlinked []*Song
+	for rows.Next() {
+		song := &Song{}
+		if err := rows.Scan(&song.ID, &song.Artist, &song.Album); err != nil {
+			rows.Close()
+			return err
+		}
+		unlinked = append(unlinked, song)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return db.inTx(func(tx *DB) error {
+		for _, song := range unlinked {
+			if err := tx.linkCatalog(song); err != nil {
+				return err
+			}
+			_, err := tx.exec(`UPDATE songs SET artist = ?, album = ?, artist_id = ?, album_id = ? WHERE id = ?`,
+				song.Artist, song.Album, song.ArtistID, nullID(song.AlbumID), song.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// nameKey is the form artist names and album titles are compared in: their
+// words, lower-cased and without accents, so "Beyoncé", "beyonce" and
+// "BEYONCE!" are one artist. A name without any word compares as written.
+func nameKey(name string) string {
+	if words := searchTokens(name); len(words) > 0 {
+		return strings.Join(words, " ")
+	}
+	return strings.ToLower(cleanName(name))
+}
+
+// cleanName trims a name as entered and collapses the white space in it.
+func cleanName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// nullID stores a missing link as NULL.
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// linkCatalog finds or creates the artist and album of song, setting its
+// IDs and replacing its names with the catalog's spelling of them.
+func (db *DB) linkCatalog(song *Song) error {
+	key := nameKey(song.Artist)
+	find := func() error {
+		return db.queryRow(`
+        SELECT id, name FROM artists
+        WHERE name_key = ? OR id = (SELECT artist_id FROM artist_aliases WHERE name_key = ?)`,
+			key, key).Scan(&song.ArtistID, &song.Artist)
+	}
+	err := find()
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = db.exec(`INSERT INTO artists (name, name_key) VALUES (?, ?) ON CONFLICT (name_key) DO NOTHING`,
+			cleanName(song.Artist), key)
+		if err == nil {
+			err = find()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	song.AlbumID = 0
+	if song.Album = cleanName(song.Album); song.Album == "" {
+		return nil
+	}
+	key = nameKey(song.Album)
+	_, err = db.exec(`INSERT INTO albums (artist_id, title, title_key) VALUES (?, ?, ?) ON CONFLICT (artist_id, title_key) DO NOTHING`,
+		song.ArtistID, song.Album, key)
+	if err != nil {
+		return err
+	}
+	return db.queryRow(`SELECT id, title FROM albums WHERE artist_id = ? AND title_key = ?`,
+		song.ArtistID, key).Scan(&song.AlbumID, &song.Album)
+}
+
+// pruneCatalog drops the albums and artists no live song links to any
+// more. Deleted songs keep their stale links until restored.
+func (db *DB) pruneCatalog() error {
+	for _, query := range []string{
+		`DELETE FROM albums WHERE NOT EXISTS (SELECT 1 FROM songs WHERE songs.album_id = albums.id AND ` + liveSongs + `)`,
+		`DELETE FROM artist_aliases WHERE NOT EXISTS (SELECT 1 FROM songs WHERE songs.artist_id = artist_aliases.artist_id AND ` + liveSongs + `)`,
+		`DELETE FROM artists WHERE NOT EXISTS (SELECT 1 FROM songs WHERE songs.artist_id = artists.id AND ` + liveSongs + `)`,
+	} {
+		if _, err := db.exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// artistColumns selects what scanArtists reads, counting the songs and
+// albums of the library given four times as its first arguments.
+const artistColumns = `
+    SELECT id, name,
+        (SELECT COUNT(*) FROM songs WHERE songs.artist_id = artists.id AND ` + liveSongs + ` AND ` + inLibrary + `),
+        (SELECT COUNT(*) FROM albums WHERE albums.artist_id = artists.id AND ` + albumInLibrary + `)
+    FROM artists`
+
+// artistInLibrary and albumInLibrary keep the artists and albums with a
+// live song in the library given twice as their arguments, or all of them
+// for library 0. The catalog is shared by every library, and an artist or
+// album is seen in those its songs are in.
+const (
+	artistInLibrary = `EXISTS (SELECT 1 FROM songs WHERE songs.artist_id = artists.id AND ` + liveSongs + ` AND ` + inLibrary + `)`
+	albumInLibrary  = `EXISTS (SELECT 1 FROM songs WHERE songs.album_id = albums.id AND ` + liveSongs + ` AND ` + inLibrary + `)`
+)
+
+// libraryArgs repeats library for n uses of inLibrary and the conditions
+// built on it.
+func libraryArgs(library, n int) []interface{} {
+	args := make([]interface{}, 2*n)
+	for i := range args {
+		args[i] = library
+	}
+	return args
+}
+
+func scanArtists(rows *sql.Rows) ([]*Artist, error) {
+	artists := []*Artist{}
+	for rows.Next() {
+		a := &Artist{}
+		if err := rows.Scan(&a.ID, &a.Name, &a.SongCount, &a.AlbumCount); err != nil {
+			return nil, err
+		}
+		artists = append(artists, a)
+	}
+	return artists, rows.Err()
+}
+
+// GetArtists returns a page of the artists of library whose name contains
+// name, as nameKey compares them, in name order, with the number found.
+func (db *DB) GetArtists(name string, library, limit, offset int) ([]*Artist, int, error) {
+	match := "%" + escapeLike(nameKey(name)) + "%"
+
+	var total int
+	err := db.queryRow(`SELECT COUNT(*) FROM artists WHERE name_key LIKE ? ESCAPE '\' AND `+artistInLibrary,
+		append([]interface{}{match}, libraryArgs(library, 1)...)...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if limit <= 0 {
+		limit = -1
+	}
+	args := append(libraryArgs(library, 2), match)
+	args = append(args, libraryArgs(library, 1)...)
+	rows, err := db.query(artistColumns+` WHERE name_key LIKE ? ESCAPE '\' AND `+artistInLibrary+` ORDER BY name_key, id LIMIT ? OFFSET ?`,
+		append(args, db.dialect.limit(limit), offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	artists, err := scanArtists(rows)
+	return artists, total, err
+}
+
+// GetArtist returns an artist of library with the aliases merged into it.
+func (db *DB) GetArtist(id, library int) (*Artist, error) {
+	args := append(libraryArgs(library, 2), id)
+	rows, err := db.query(artistColumns+` WHERE id = ? AND `+artistInLibrary, append(args, libraryArgs(library, 1)...)...)
+	if err != nil {
+		return nil, err
+	}
+	artists, err := scanArtists(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(artists) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	artist := artists[0]
+
+	rows, err = db.query(`SELECT name FROM artist_aliases WHERE artist_id = ? ORDER BY name_key`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var alias string
+		if err := rows.Scan(&alias); err != nil {
+			return nil, err
+		}
+		artist.Aliases = append(artist.Aliases, alias)
+	}
+	return artist, rows.Err()
+}
+
+// albumColumns selects what scanAlbums reads, counting the songs of the
+// library given twice as its first arguments.
+const albumColumns = `
+    SELECT albums.id, albums.artist_id, artists.name, albums.title,
+        (SELECT COUNT(*) FROM songs WHERE songs.album_id = albums.id AND ` + liveSongs + ` AND ` + inLibrary + `)
+    FROM albums
+    JOIN artists ON artists.id = albums.artist_id`
+
+func scanAlbums(rows *sql.Rows) ([]*Album, error) {
+	albums := []*Album{}
+	for rows.Next() {
+		a := &Album{}
+		if err := rows.Scan(&a.ID, &a.ArtistID, &a.Artist, &a.Title, &a.SongCount); err != nil {
+			return nil, err
+		}
+		albums = append(albums, a)
+	}
+	return albums, rows.Err()
+}
+
+// GetArtistAlbums returns the albums of an artist in library in title
+// order.
+func (db *DB) GetArtistAlbums(id, library int) ([]*Album, error) {
+	args := append(libraryArgs(library, 1), id)
+	rows, err := db.query(albumColumns+` WHERE albums.artist_id = ? AND `+albumInLibrary+` ORDER BY albums.title_key, albums.id`,
+		append(args, libraryArgs(library, 1)...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAlbums(rows)
+}
+
+func (db *DB) GetAlbum(id, library int) (*Album, error) {
+	args := append(libraryArgs(library, 1), id)
+	rows, err := db.query(albumColumns+` WHERE albums.id = ? AND `+albumInLibrary, append(args, libraryArgs(library, 1)...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	albums, err := scanAlbums(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(albums) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return albums[0], nil
+}
+
+// MergeArtists credits the songs of artist id in library to artist into,
+// both of which must have songs there, and makes the names of id aliases
+// of into, so songs added under them later go to into as well. Albums the
+// two share by title become one. Songs of other libraries are left alone:
+// an album of id that also has songs elsewhere is copied to into rather
+// than moved, and while id has songs elsewhere its names stay its own.
+// Library 0 merges the songs of every library. The merge is made in one
+// transaction.
+func (db *DB) MergeArtists(id, into, library int) error {
+	if id == into {
+		return nil
+	}
+	return db.inTx(func(tx *DB) error { return tx.mergeArtists(id, into, library) })
+}
+
+func (db *DB) mergeArtists(id, into, library int) error {
+	target, err := db.GetArtist(into, library)
+	if err != nil {
+		return err
+	}
+	if _, err := db.GetArtist(id, library); err != nil {
+		return err
+	}
+
+	albums, err := db.GetArtistAlbums(id, library)
+	if err != nil {
+		return err
+	}
+	for _, album := range albums {
+		var sharedID int
+		var sharedTitle string
+		err := db.queryRow(`SELECT id, title FROM albums WHERE artist_id = ? AND title_key = ?`,
+			into, nameKey(album.Title)).Scan(&sharedID, &sharedTitle)
+		if errors.Is(err, sql.ErrNoRows) {
+			var elsewhere bool
+			if elsewhere, err = db.songsElsewhere(`album_id`, album.ID, library); err != nil {
+				return err
+			}
+			if !elsewhere {
+				if _, err := db.exec(`UPDATE albums SET artist_id = ? WHERE id = ?`, into, album.ID); err != nil {
+					return err
+				}
+				continue
+			}
+			sharedTitle = album.Title
+			sharedID, err = db.insert(`INSERT INTO albums (artist_id, title, title_key) VALUES (?, ?, ?)`,
+				into, album.Title, nameKey(album.Title))
+		}
+		if err != nil {
+			return err
+		}
+		_, err = db.exec(`UPDATE songs SET album_id = ?, album = ? WHERE album_id = ? AND `+inLibrary,
+			sharedID, sharedTitle, album.ID, library, library)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.exec(`UPDATE songs SET artist_id = ?, artist = ? WHERE artist_id = ? AND `+inLibrary,
+		into, target.Name, id, library, library)
+	if err != nil {
+		return err
+	}
+	// The names of an artist still heard in other libraries stay its own.
+	elsewhere, err := db.songsElsewhere(`artist_id`, id, library)
+	if err != nil {
+		return err
+	}
+	if !elsewhere {
+		if _, err := db.exec(`UPDATE artist_aliases SET artist_id = ? WHERE artist_id = ?`, into, id); err != nil {
+			return err
+		}
+		_, err = db.exec(`INSERT INTO artist_aliases (name_key, name, artist_id) SELECT name_key, name, ? FROM artists WHERE id = ?`,
+			into, id)
+		if err != nil {
+			return err
+		}
+	}
+	// With its songs gone, pruning deletes the artist itself.
+	return db.pruneCatalog()
+}
+
+// songsElsewhere reports whether live songs outside library link to the
+// artist or album id by column.
+func (db *DB) songsElsewhere(column string, id, library int) (bool, error) {
+	if library == 0 {
+		return false, nil
+	}
+	var n int
+	err := db.queryRow(`SELECT COUNT(*) FROM songs WHERE `+column+` = ? AND library_id <> ? AND `+liveSongs,
+		id, library).Scan(&n)
+	return n > 0, err
+}
@@ -0,0 +1,53 @@
+package database
+
+import "fmt"
+
+// Chroma is a pitch-class profile: the energy of C, C#, D ... B over a
+// short stretch of audio, scaled so the strongest class is 255.
+type Chroma [12]uint8
+
+// ChromaSequence is a song's chroma over time, used to recognise covers and
+// live versions that the exact fingerprint cannot.
+type ChromaSequence []Chroma
+
+// encodeChroma stores a sequence as 12 bytes per profile.
+func encodeChroma(seq ChromaSequence) []byte {
+	buf := make([]byte, 0, len(seq)*12)
+	for _, c := range seq {
+		buf = append(buf, c[:]...)
+	}
+	return buf
+}
+
+// decodeChroma reverses encodeChroma. Songs stored before chroma existed
+// decode to an empty sequence.
+func decodeChroma(raw []byte) (ChromaSequence, error) {
+	if len(raw)%12 != 0 {
+		return nil, fmt.Errorf("corrupt chroma: %d bytes", len(raw))
+	}
+	seq := make(ChromaSequence, len(raw)/12)
+	for i := range seq {
+		copy(seq[i][:], raw[i*12:])
+	}
+	return seq, nil
+}
+
+// SetSongChroma stores the chroma of an existing song.
+func (db *DB) SetSongChroma(id int, seq ChromaSequence) error {
+	result, err := db.exec(`UPDATE songs SET chroma = ? WHERE id = ?`, encodeChroma(seq), id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// SetSongFingerprint replaces the fingerprint of an existing song with one
+// computed by a newer version of the fingerprinter.
+func (db *DB) SetSongFingerprint(id int, fingerprint string, segments HashSegments, offset, version int) error {
+	result, err := db.exec(`UPDATE songs SET fingerprint = ?, hash_segments = ?, hash_offset = ?, fingerprint_version = ? WHERE id = ?`,
+		fingerprint, encodeHashSegments(segments), offset, version, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
@@ -0,0 +1,296 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// normalizeTags trims and lower-cases tags, dropping empty and repeated
+// ones, and sorts them.
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(cleanName(tag))
+}
+
+// GetTags returns every tag in use in library with the number of its
+// songs carrying it, in tag order.
+func (db *DB) GetTags(library int) ([]*TagCount, error) {
+	rows, err := db.query(`
+    SELECT tag, COUNT(*) FROM song_tags JOIN songs ON songs.id = song_tags.song_id
+    WHERE `+liveSongs+` AND `+inLibrary+`
+    GROUP BY tag ORDER BY tag`, library, library)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*TagCount{}
+	for rows.Next() {
+		t := &TagCount{}
+		if err := rows.Scan(&t.Tag, &t.Songs); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// GetSongTags returns the tags of a song in tag order.
+func (db *DB) GetSongTags(songID int) ([]string, error) {
+	rows, err := db.query(`SELECT tag FROM song_tags WHERE song_id = ? ORDER BY tag`, songID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// SetSongTags replaces the tags of a song.
+func (db *DB) SetSongTags(songID int, tags []string) error {
+	if err := db.requireSong(songID); err != nil {
+		return err
+	}
+	if _, err := db.exec(`DELETE FROM song_tags WHERE song_id = ?`, songID); err != nil {
+		return err
+	}
+	for _, tag := range normalizeTags(tags) {
+		if _, err := db.exec(`INSERT INTO song_tags (song_id, tag) VALUES (?, ?)`, songID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requireSong reports sql.ErrNoRows, naming the song, when it does not
+// exist or is deleted.
+func (db *DB) requireSong(id int) error {
+	var found int
+	err := db.queryRow(`SELECT COUNT(*) FROM songs WHERE id = ? AND `+liveSongs, id).Scan(&found)
+	if err == nil && found == 0 {
+		err = fmt.Errorf("song %d: %w", id, sql.ErrNoRows)
+	}
+	return err
+}
+
+// requireLibrarySong is requireSong also reporting ErrOtherLibrary, naming
+// the song, when it is not in library.
+func (db *DB) requireLibrarySong(id, library int) error {
+	var songLibrary int
+	err := db.queryRow(`SELECT library_id FROM songs WHERE id = ? AND `+liveSongs, id).Scan(&songLibrary)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("song %d: %w", id, sql.ErrNoRows)
+	case err == nil && songLibrary != library:
+		return fmt.Errorf("song %d: %w", id, ErrOtherLibrary)
+	}
+	return err
+}
+
+// tagSummaries fills in the tags of listed songs.
+func (db *DB) tagSummaries(songs []*SongSummary) error {
+	byID := make(map[int]*SongSummary, len(songs))
+	for _, song := range songs {
+		byID[song.ID] = song
+	}
+
+	// Batches keep the statement well under the placeholder limits.
+	const batch = 500
+	for start := 0; start < len(songs); start += batch {
+		ids := make([]interface{}, 0, batch)
+		for _, song := range songs[start:min(start+batch, len(songs))] {
+			ids = append(ids, song.ID)
+		}
+		rows, err := db.query(`SELECT song_id, tag FROM song_tags WHERE song_id IN (?`+
+			strings.Repeat(", ?", len(ids)-1)+`) ORDER BY tag`, ids...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int
+			var tag string
+			if err := rows.Scan(&id, &tag); err != nil {
+				rows.Close()
+				return err
+			}
+			byID[id].Tags = append(byID[id].Tags, tag)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// playlistEntry keeps the entries of live songs in the library of their
+// playlist; entries made before playlists had a library may name songs of
+// another.
+const playlistEntry = liveSongs + ` AND songs.library_id =
+    (SELECT library_id FROM playlists WHERE playlists.id = playlist_songs.playlist_id)`
+
+// playlistColumns selects what scanPlaylists reads.
+const playlistColumns = `
+    SELECT id, name, library_id, created_at,
+        (SELECT COUNT(*) FROM playlist_songs JOIN songs ON songs.id = playlist_songs.song_id
+         WHERE playlist_songs.playlist_id = playlists.id AND ` + playlistEntry + `)
+    FROM playlists`
+
+func scanPlaylists(rows *sql.Rows) ([]*Playlist, error) {
+	playlists := []*Playlist{}
+	for rows.Next() {
+		p := &Playlist{}
+		var createdAt sql.NullTime
+		if err := rows.Scan(&p.ID, &p.Name, &p.LibraryID, &createdAt, &p.SongCount); err != nil {
+			return nil, err
+		}
+		p.CreatedAt = createdAt.Time
+		playlists = append(playlists, p)
+	}
+	return playlists, rows.Err()
+}
+
+func (db *DB) AddPlaylist(playlist *Playlist) error {
+	playlist.LibraryID = db.libraryOf(playlist.LibraryID)
+	id, err := db.insert(`INSERT INTO playlists (name, library_id) VALUES (?, ?)`, playlist.Name, playlist.LibraryID)
+	if err != nil {
+		return err
+	}
+	playlist.ID = id
+	playlist.SongCount = 0
+	playlist.CreatedAt = time.Now().UTC()
+	return nil
+}
+
+// GetPlaylists returns the playlists of library in the order they were
+// created.
+func (db *DB) GetPlaylists(library int) ([]*Playlist, error) {
+	rows, err := db.query(playlistColumns+` WHERE (? = 0 OR library_id = ?) ORDER BY id`, library, library)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPlaylists(rows)
+}
+
+func (db *DB) GetPlaylist(id int) (*Playlist, error) {
+	rows, err := db.query(playlistColumns+` WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists, err := scanPlaylists(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(playlists) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return playlists[0], nil
+}
+
+func (db *DB) RenamePlaylist(id int, name string) error {
+	result, err := db.exec(`UPDATE playlists SET name = ? WHERE id = ?`, name, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func (db *DB) DeletePlaylist(id int) error {
+	if _, err := db.exec(`DELETE FROM playlist_songs WHERE playlist_id = ?`, id); err != nil {
+		return err
+	}
+	result, err := db.exec(`DELETE FROM playlists WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// GetPlaylistSongs returns the entries of a playlist in order, skipping
+// deleted songs and those of another library.
+func (db *DB) GetPlaylistSongs(id int) ([]*SongSummary, error) {
+	rows, err := db.query(songSummaryColumns+`
+    JOIN playlist_songs ON playlist_songs.song_id = songs.id
+    WHERE playlist_songs.playlist_id = ? AND `+playlistEntry+`
+    ORDER BY playlist_songs.position`, id)
+	if err != nil {
+		return nil, err
+	}
+	songs, err := scanSongSummaries(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return songs, db.tagSummaries(songs)
+}
+
+// SetPlaylistSongs replaces the entries of a playlist with songIDs, in
+// that order. Entries of deleted songs are replaced too.
+func (db *DB) SetPlaylistSongs(id int, songIDs []int) error {
+	playlist, err := db.GetPlaylist(id)
+	if err != nil {
+		return err
+	}
+	for _, songID := range songIDs {
+		if err := db.requireLibrarySong(songID, playlist.LibraryID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := db.exec(`DELETE FROM playlist_songs WHERE playlist_id = ?`, id); err != nil {
+		return err
+	}
+	for position, songID := range songIDs {
+		_, err := db.exec(`INSERT INTO playlist_songs (playlist_id, position, song_id) VALUES (?, ?, ?)`,
+			id, position, songID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddPlaylistSong appends a song to a playlist.
+func (db *DB) AddPlaylistSong(id, songID int) error {
+	playlist, err := db.GetPlaylist(id)
+	if err != nil {
+		return err
+	}
+	if err := db.requireLibrarySong(songID, playlist.LibraryID); err != nil {
+		return err
+	}
+	_, err = db.exec(`
+    INSERT INTO playlist_songs (playlist_id, position, song_id)
+    SELECT ?, COALESCE(MAX(position) + 1, 0), ? FROM playlist_songs WHERE playlist_id = ?`,
+		id, songID, id)
+	return err
+}
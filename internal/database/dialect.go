@@ -0,0 +1,176 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialect is the SQL database a DB talks to. Queries are written once with
+// ? placeholders and in SQL both databases accept; the dialect covers what
+// cannot be written portably.
+type dialect int
+
+const (
+	sqliteDialect dialect = iota
+	postgresDialect
+)
+
+// rebind rewrites ? placeholders as $1, $2, ... for PostgreSQL. No query
+// here has a ? inside a string literal.
+func (d dialect) rebind(query string) string {
+	if d != postgresDialect || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// limit is the LIMIT argument for n rows, where a negative n means all of
+// them: SQLite takes -1 for that and PostgreSQL NULL.
+func (d dialect) limit(n int) interface{} {
+	if n >= 0 {
+		return n
+	}
+	if d == postgresDialect {
+		return nil
+	}
+	return -1
+}
+
+// timestamp is the query argument comparing t with a column set from
+// CURRENT_TIMESTAMP, which SQLite stores as UTC text to the second.
+func (d dialect) timestamp(t time.Time) interface{} {
+	if d == postgresDialect {
+		return t.UTC()
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// writing keeps Restore waiting until the write it is called for is done
+// and returns the function that lets it go. The statements of a
+// transaction are covered by the hold inTx takes for all of it.
+func (db *DB) writing() func() {
+	if db.tx != nil || db.writes == nil {
+		return func() {}
+	}
+	db.writes.RLock()
+	return db.writes.RUnlock
+}
+
+func (db *DB) exec(query string, args ...interface{}) (sql.Result, error) {
+	defer db.writing()()
+	if db.tx != nil {
+		return db.tx.Exec(db.dialect.rebind(query), args...)
+	}
+	return db.conn.Exec(db.dialect.rebind(query), args...)
+}
+
+func (db *DB) query(query string, args ...interface{}) (*sql.Rows, error) {
+	if db.tx != nil {
+		return db.tx.Query(db.dialect.rebind(query), args...)
+	}
+	return db.conn.Query(db.dialect.rebind(query), args...)
+}
+
+func (db *DB) queryRow(query string, args ...interface{}) *sql.Row {
+	if db.tx != nil {
+		return db.tx.QueryRow(db.dialect.rebind(query), args...)
+	}
+	return db.conn.QueryRow(db.dialect.rebind(query), args...)
+}
+
+// inTx runs fn with a copy of db whose statements all run in one
+// transaction, committed if fn succeeds and rolled back otherwise. Within
+// a transaction already, fn joins it.
+func (db *DB) inTx(fn func(tx *DB) error) error {
+	if db.tx != nil {
+		return fn(db)
+	}
+	defer db.writing()()
+	sqlTx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	tx := &DB{
+		conn:           db.conn,
+		dialect:        db.dialect,
+		fullText:       db.fullText,
+		defaultLibrary: db.defaultLibrary,
+		tx:             sqlTx,
+		parent:         db,
+	}
+	if err := fn(tx); err != nil {
+		sqlTx.Rollback()
+		return err
+	}
+	return sqlTx.Commit()
+}
+
+// prepared returns query as a prepared statement, preparing it on first
+// use and keeping it until Close. Only queries whose text never varies go
+// through it: the inserts and the lookups made on every request or match.
+// Copies share the statements of their parent, bound to their transaction
+// if they have one.
+func (db *DB) prepared(query string) (*sql.Stmt, error) {
+	if db.parent != nil {
+		stmt, err := db.parent.prepared(query)
+		if err != nil || db.tx == nil {
+			return stmt, err
+		}
+		return db.tx.Stmt(stmt), nil
+	}
+
+	db.stmtMu.Lock()
+	defer db.stmtMu.Unlock()
+
+	if stmt, ok := db.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := db.conn.Prepare(db.dialect.rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	if db.stmts == nil {
+		db.stmts = make(map[string]*sql.Stmt)
+	}
+	db.stmts[query] = stmt
+	return stmt, nil
+}
+
+// insert runs an INSERT and returns the id of the new row. PostgreSQL has
+// no LastInsertId, so the id is read back with RETURNING instead.
+func (db *DB) insert(query string, args ...interface{}) (int, error) {
+	defer db.writing()()
+	if db.dialect == postgresDialect {
+		stmt, err := db.prepared(query + " RETURNING id")
+		if err != nil {
+			return 0, err
+		}
+		var id int
+		err = stmt.QueryRow(args...).Scan(&id)
+		return id, err
+	}
+
+	stmt, err := db.prepared(query)
+	if err != nil {
+		return 0, err
+	}
+	result, err := stmt.Exec(args...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
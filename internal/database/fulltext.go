@@ -0,0 +1,182 @@
+package database
+
+import (
+	"strings"
+)
+
+// createSearchIndex sets up songs_fts, an FTS5 index over the title, artist
+// and album of every song that triggers keep in step with the songs table.
+// Without FTS5 in the driver the triggers are dropped, as they could not
+// run, and search falls back to scanning the library.
+func (db *DB) createSearchIndex() error {
+	var available bool
+	if err := db.queryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&available); err != nil {
+		return err
+	}
+	if !available {
+		_, err := db.exec(`
+        DROP TRIGGER IF EXISTS songs_fts_insert;
+        DROP TRIGGER IF EXISTS songs_fts_delete;
+        DROP TRIGGER IF EXISTS songs_fts_update;
+        `)
+		return err
+	}
+
+	_, err := db.exec(`
+    CREATE VIRTUAL TABLE IF NOT EXISTS songs_fts USING fts5(
+        title, artist, album,
+        content='songs', content_rowid='id',
+        tokenize='unicode61 remove_diacritics 2'
+    )`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.exec(`
+    CREATE TRIGGER IF NOT EXISTS songs_fts_insert AFTER INSERT ON songs BEGIN
+        INSERT INTO songs_fts (rowid, title, artist, album)
+        VALUES (new.id, new.title, new.artist, new.album);
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS songs_fts_delete AFTER DELETE ON songs BEGIN
+        INSERT INTO songs_fts (songs_fts, rowid, title, artist, album)
+        VALUES ('delete', old.id, old.title, old.artist, old.album);
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS songs_fts_update AFTER UPDATE OF title, artist, album ON songs BEGIN
+        INSERT INTO songs_fts (songs_fts, rowid, title, artist, album)
+        VALUES ('delete', old.id, old.title, old.artist, old.album);
+        INSERT INTO songs_fts (rowid, title, artist, album)
+        VALUES (new.id, new.title, new.artist, new.album);
+    END;
+    `)
+	if err != nil {
+		return err
+	}
+
+	// A build without FTS5 may have changed songs since the last start.
+	if _, err := db.exec(`INSERT INTO songs_fts (songs_fts) VALUES ('rebuild')`); err != nil {
+		return err
+	}
+
+	db.fullText = true
+	return nil
+}
+
+// SearchSongs returns a page of the songs of library matching query, best
+// first, together with the number found. A limit that is not positive
+// returns them all.
+func (db *DB) SearchSongs(query string, library, limit, offset int) ([]*Song, int, error) {
+	tokens := searchTokens(query)
+	if len(tokens) == 0 {
+		return []*Song{}, 0, nil
+	}
+
+	if db.fullText {
+		songs, total, err := db.searchFullText(tokens, library, limit, offset)
+		if err != nil || total > 0 {
+			return songs, total, err
+		}
+	}
+
+	entries, err := db.searchEntries(library)
+	if err != nil {
+		return nil, 0, err
+	}
+	ids := rankSearch(tokens, entries)
+	songs, err := db.songsByID(page(ids, limit, offset))
+	return songs, len(ids), err
+}
+
+// searchFullText answers the exact pass of a search from songs_fts, ranked
+// by BM25 with the field weights rankSearch uses.
+func (db *DB) searchFullText(tokens []string, library, limit, offset int) ([]*Song, int, error) {
+	// Tokens hold only letters and digits, so quoting them is enough to keep
+	// them from being read as FTS5 syntax; the star makes each a prefix.
+	terms := make([]string, len(tokens))
+	for i, token := range tokens {
+		terms[i] = `"` + token + `"*`
+	}
+	match := strings.Join(terms, " ")
+
+	var total int
+	err := db.queryRow(`
+    SELECT COUNT(*) FROM songs_fts JOIN songs ON songs.id = songs_fts.rowid
+    WHERE songs_fts MATCH ? AND `+liveSongs+` AND `+inLibrary, match, library, library).Scan(&total)
+	if err != nil || total == 0 {
+		return []*Song{}, 0, err
+	}
+
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := db.query(`
+    WITH hits AS (
+        SELECT rowid AS song_id, bm25(songs_fts, ?, ?, ?) AS score
+        FROM songs_fts WHERE songs_fts MATCH ?
+    )`+songColumns+`
+    JOIN hits ON hits.song_id = songs.id
+    WHERE `+liveSongs+` AND `+inLibrary+`
+    ORDER BY hits.score, artist, title, id
+    LIMIT ? OFFSET ?`,
+		titleWeight, artistWeight, albumWeight, match, library, library, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	return scanSongs(rows), total, nil
+}
+
+// searchEntries loads the searchable text of every song in library.
+func (db *DB) searchEntries(library int) ([]searchEntry, error) {
+	rows, err := db.query(`SELECT id, title, artist, COALESCE(album, '') FROM songs WHERE `+liveSongs+` AND `+inLibrary,
+		library, library)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []searchEntry
+	for rows.Next() {
+		var e searchEntry
+		if err := rows.Scan(&e.id, &e.title, &e.artist, &e.album); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// songsByID loads the songs with the given IDs in the order given.
+func (db *DB) songsByID(ids []int) ([]*Song, error) {
+	if len(ids) == 0 {
+		return []*Song{}, nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := db.query(songColumns+` WHERE id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[int]*Song, len(ids))
+	for _, song := range scanSongs(rows) {
+		byID[song.ID] = song
+	}
+	songs := make([]*Song, 0, len(ids))
+	for _, id := range ids {
+		if song, ok := byID[id]; ok {
+			songs = append(songs, song)
+		}
+	}
+	return songs, nil
+}
+
+// inLibrary keeps the songs of the library given twice as its arguments,
+// or every song for library 0.
+const inLibrary = `(? = 0 OR songs.library_id = ?)`
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// HashSegments holds one packed band hash per analysis frame. Each hash
+// stores 16 bands as 4-bit nibbles with band 0 in the most significant
+// nibble, so formatting it as 16 hex digits yields the legacy string form.
+type HashSegments []uint64
+
+// SilentHash marks a frame the activity detector found to carry no
+// information. Band hashes never take this value, as at least half the
+// bands sit at or above their median and so have a nibble of 8 or more.
+// Silent segments never match anything.
+const SilentHash uint64 = 0
+
+// Active counts the segments that are not marked silent.
+func (hs HashSegments) Active() int {
+	n := 0
+	for _, h := range hs {
+		if h != SilentHash {
+			n++
+		}
+	}
+	return n
+}
+
+// FormatHash renders a packed hash as a 16-character hex string.
+func FormatHash(h uint64) string {
+	return fmt.Sprintf("%016x", h)
+}
+
+// ParseHash parses a 16-character hex string into a packed hash.
+func ParseHash(s string) (uint64, error) {
+	if len(s) != 16 {
+		return 0, fmt.Errorf("invalid hash segment %q", s)
+	}
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// MarshalJSON renders the segments as hex strings for API compatibility.
+func (hs HashSegments) MarshalJSON() ([]byte, error) {
+	out := make([]string, len(hs))
+	for i, h := range hs {
+		out[i] = FormatHash(h)
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON accepts the hex string form produced by MarshalJSON.
+func (hs *HashSegments) UnmarshalJSON(data []byte) error {
+	var strs []string
+	if err := json.Unmarshal(data, &strs); err != nil {
+		return err
+	}
+	out := make(HashSegments, len(strs))
+	for i, s := range strs {
+		h, err := ParseHash(s)
+		if err != nil {
+			return err
+		}
+		out[i] = h
+	}
+	*hs = out
+	return nil
+}
+
+// encodeHashSegments packs segments into 8 big-endian bytes each.
+func encodeHashSegments(hs HashSegments) []byte {
+	buf := make([]byte, 8*len(hs))
+	for i, h := range hs {
+		binary.BigEndian.PutUint64(buf[i*8:], h)
+	}
+	return buf
+}
+
+// decodeHashSegments reverses encodeHashSegments.
+func decodeHashSegments(raw []byte) (HashSegments, error) {
+	if len(raw)%8 != 0 {
+		return nil, fmt.Errorf("corrupt hash segments: %d bytes", len(raw))
+	}
+	hs := make(HashSegments, len(raw)/8)
+	for i := range hs {
+		hs[i] = binary.BigEndian.Uint64(raw[i*8:])
+	}
+	return hs, nil
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestHashSegmentsRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		hs   HashSegments
+	}{
+		{"empty", HashSegments{}},
+		{"silent", HashSegments{SilentHash}},
+		{"extremes", HashSegments{1, 0xffffffffffffffff, 0x8000000000000000}},
+		{"mixed", HashSegments{0x0123456789abcdef, SilentHash, 0xfedcba9876543210}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			raw := encodeHashSegments(test.hs)
+			if len(raw) != 8*len(test.hs) {
+				t.Errorf("encoded to %d bytes, want %d", len(raw), 8*len(test.hs))
+			}
+			got, err := decodeHashSegments(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.hs) {
+				t.Errorf("decoded %x, want %x", got, test.hs)
+			}
+
+			data, err := json.Marshal(test.hs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var back HashSegments
+			if err := json.Unmarshal(data, &back); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(back, test.hs) {
+				t.Errorf("JSON %s came back as %x", data, back)
+			}
+		})
+	}
+}
+
+func TestHashSegmentsRejectCorruptData(t *testing.T) {
+	for _, n := range []int{1, 7, 9, 15} {
+		if _, err := decodeHashSegments(make([]byte, n)); err == nil {
+			t.Errorf("decoding %d bytes succeeded", n)
+		}
+	}
+	var hs HashSegments
+	for _, data := range []string{`["0123"]`, `["0123456789abcdeg"]`, `[1]`} {
+		if err := json.Unmarshal([]byte(data), &hs); err == nil {
+			t.Errorf("unmarshalling %s succeeded", data)
+		}
+	}
+}
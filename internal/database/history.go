@@ -0,0 +1,206 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+func (db *DB) AddIdentification(ident *Identification) error {
+	candidatesJSON, err := json.Marshal(ident.Candidates)
+	if err != nil {
+		return err
+	}
+	var coversJSON sql.NullString
+	if len(ident.Covers) > 0 {
+		data, err := json.Marshal(ident.Covers)
+		if err != nil {
+			return err
+		}
+		coversJSON = sql.NullString{String: string(data), Valid: true}
+	}
+
+	var songID sql.NullInt64
+	if ident.SongID != 0 {
+		songID = sql.NullInt64{Int64: int64(ident.SongID), Valid: true}
+	}
+	if ident.CreatedAt.IsZero() {
+		ident.CreatedAt = time.Now().UTC()
+	}
+	ident.LibraryID = db.libraryOf(ident.LibraryID)
+
+	id, err := db.insert(`
+    INSERT INTO identifications (created_at, library_id, source, duration, song_id, is_match,
+        confidence, time_in_song, latency_ms, candidates, covers, query_hashes)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `, ident.CreatedAt.UTC(), ident.LibraryID, ident.Source, ident.Duration, songID, ident.IsMatch,
+		ident.Confidence, ident.TimeInSong, ident.LatencyMillis, string(candidatesJSON), coversJSON,
+		encodeHashSegments(ident.Query))
+	if err != nil {
+		return err
+	}
+
+	ident.ID = id
+	return nil
+}
+
+const identificationColumns = `
+    i.id, i.created_at, i.library_id, i.source, i.duration, COALESCE(i.song_id, 0),
+    COALESCE(s.title, ''), COALESCE(s.artist, ''), i.is_match, i.confidence,
+    i.time_in_song, i.latency_ms, i.candidates, COALESCE(i.covers, ''),
+    COALESCE(i.confirmed_song_id, 0), i.confirmed_at
+    FROM identifications i LEFT JOIN songs s ON s.id = i.song_id AND s.deleted_at IS NULL`
+
+func (db *DB) GetIdentification(id int) (*Identification, error) {
+	rows, err := db.query(`SELECT `+identificationColumns+` WHERE i.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	idents := scanIdentifications(rows)
+	if len(idents) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return idents[0], nil
+}
+
+// GetIdentificationQuery returns the hashes an identification attempt was
+// made with. Attempts recorded before they were kept have none.
+func (db *DB) GetIdentificationQuery(id int) (HashSegments, error) {
+	var raw []byte
+	err := db.queryRow(`SELECT query_hashes FROM identifications WHERE id = ?`, id).Scan(&raw)
+	if err != nil {
+		return nil, err
+	}
+	return decodeHashSegments(raw)
+}
+
+// GetIdentifications lists identification attempts matching filter, newest
+// first, together with the total number of matching attempts.
+func (db *DB) GetIdentifications(filter HistoryFilter) ([]*Identification, int, error) {
+	var where []string
+	var args []interface{}
+
+	if filter.LibraryID != 0 {
+		where = append(where, "i.library_id = ?")
+		args = append(args, filter.LibraryID)
+	}
+	if filter.Source != "" {
+		where = append(where, "i.source = ?")
+		args = append(args, filter.Source)
+	}
+	if filter.SongID != 0 {
+		where = append(where, "i.song_id = ?")
+		args = append(args, filter.SongID)
+	}
+	if filter.Matched != nil {
+		where = append(where, "i.is_match = ?")
+		args = append(args, *filter.Matched)
+	}
+	if filter.MinConfidence > 0 {
+		where = append(where, "i.confidence >= ?")
+		args = append(args, filter.MinConfidence)
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "i.created_at >= ?")
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "i.created_at < ?")
+		args = append(args, filter.To.UTC())
+	}
+
+	clause := ""
+	if len(where) > 0 {
+		clause = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	err := db.queryRow(`SELECT COUNT(*) FROM identifications i`+clause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := db.query(`SELECT `+identificationColumns+clause+
+		` ORDER BY i.created_at DESC, i.id DESC LIMIT ? OFFSET ?`,
+		append(args, db.dialect.limit(limit), filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	return scanIdentifications(rows), total, nil
+}
+
+func scanIdentifications(rows *sql.Rows) []*Identification {
+	idents := []*Identification{}
+	for rows.Next() {
+		ident := &Identification{}
+		var candidatesJSON, coversJSON string
+		var confirmedAt sql.NullTime
+
+		err := rows.Scan(&ident.ID, &ident.CreatedAt, &ident.LibraryID, &ident.Source, &ident.Duration,
+			&ident.SongID, &ident.Title, &ident.Artist, &ident.IsMatch, &ident.Confidence,
+			&ident.TimeInSong, &ident.LatencyMillis, &candidatesJSON, &coversJSON,
+			&ident.ConfirmedSongID, &confirmedAt)
+		if err != nil {
+			continue
+		}
+		if confirmedAt.Valid {
+			ident.ConfirmedAt = &confirmedAt.Time
+		}
+
+		json.Unmarshal([]byte(candidatesJSON), &ident.Candidates)
+		if coversJSON != "" {
+			json.Unmarshal([]byte(coversJSON), &ident.Covers)
+		}
+		idents = append(idents, ident)
+	}
+
+	return idents
+}
+
+// ConfirmIdentification records the song a user says was actually playing.
+// A songID of 0 means none of the candidates was right.
+func (db *DB) ConfirmIdentification(id, songID int) error {
+	result, err := db.exec(`
+    UPDATE identifications SET confirmed_song_id = ?, confirmed_at = ? WHERE id = ?
+    `, songID, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// GetAccuracyStats compares confirmed songs with what the matcher answered
+// in library. An attempt without a match counts as answering "none".
+func (db *DB) GetAccuracyStats(library int) (*AccuracyStats, error) {
+	stats := &AccuracyStats{}
+	err := db.queryRow(`
+    SELECT
+        COUNT(*),
+        COALESCE(SUM(CASE WHEN confirmed_song_id = answer THEN 1 ELSE 0 END), 0),
+        COALESCE(SUM(CASE WHEN confirmed_song_id != 0 AND confirmed_song_id != answer THEN 1 ELSE 0 END), 0),
+        COALESCE(SUM(CASE WHEN confirmed_song_id = 0 AND answer != 0 THEN 1 ELSE 0 END), 0)
+    FROM (
+        SELECT confirmed_song_id,
+               CASE WHEN is_match THEN COALESCE(song_id, 0) ELSE 0 END AS answer
+        FROM identifications
+        WHERE confirmed_at IS NOT NULL AND (? = 0 OR library_id = ?)
+    ) reviewed
+    `, library, library).Scan(&stats.Reviewed, &stats.Correct, &stats.Corrected, &stats.Rejected)
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.Reviewed > 0 {
+		stats.Accuracy = float64(stats.Correct) / float64(stats.Reviewed)
+	}
+	return stats, nil
+}
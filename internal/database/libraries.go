@@ -0,0 +1,202 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Libraries keep separate collections of songs apart on one server. Songs,
+// identifications, monitors and playlists each belong to one library by
+// library_id, and rows from before libraries existed are moved into the
+// default one.
+
+// DefaultLibrary names the library that always exists and that songs,
+// identifications and monitors stored without a library belong to.
+const DefaultLibrary = "default"
+
+var (
+	// ErrLibraryExists is returned when adding a library under a name
+	// already taken.
+	ErrLibraryExists = errors.New("library already exists")
+	// ErrLibraryInUse is returned when deleting the default library or one
+	// that still has songs or monitors.
+	ErrLibraryInUse = errors.New("library is in use")
+	// ErrOtherLibrary is returned when adding a song to a playlist of
+	// another library.
+	ErrOtherLibrary = errors.New("song is in another library")
+)
+
+// createLibraryTables creates the libraries with the default one and moves
+// every row without a library into it.
+func (db *DB) createLibraryTables() error {
+	id, timestamp := "INTEGER PRIMARY KEY AUTOINCREMENT", "DATETIME"
+	if db.dialect == postgresDialect {
+		id, timestamp = "BIGSERIAL PRIMARY KEY", "TIMESTAMPTZ"
+	}
+	_, err := db.exec(`
+    CREATE TABLE IF NOT EXISTS libraries (
+        id ` + id + `,
+        name TEXT NOT NULL UNIQUE,
+        created_at ` + timestamp + ` DEFAULT CURRENT_TIMESTAMP
+    );
+
+    CREATE INDEX IF NOT EXISTS idx_songs_library ON songs(library_id);
+    CREATE INDEX IF NOT EXISTS idx_identifications_library ON identifications(library_id);
+    `)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.exec(`INSERT INTO libraries (name) VALUES (?) ON CONFLICT (name) DO NOTHING`, DefaultLibrary); err != nil {
+		return err
+	}
+	if err := db.queryRow(`SELECT id FROM libraries WHERE name = ?`, DefaultLibrary).Scan(&db.defaultLibrary); err != nil {
+		return err
+	}
+
+	for _, table := range []string{"songs", "identifications", "monitors", "playlists"} {
+		if _, err := db.exec(`UPDATE `+table+` SET library_id = ? WHERE library_id IS NULL`, db.defaultLibrary); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// libraryOf is the library a row stored with library is put in.
+func (db *DB) libraryOf(library int) int {
+	if library == 0 {
+		return db.defaultLibrary
+	}
+	return library
+}
+
+// AddLibrary stores a new library under its trimmed name, reporting
+// ErrLibraryExists if the name is taken.
+func (db *DB) AddLibrary(library *Library) error {
+	library.Name = strings.TrimSpace(library.Name)
+	if _, err := db.GetLibraryByName(library.Name); err == nil {
+		return ErrLibraryExists
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	id, err := db.insert(`INSERT INTO libraries (name) VALUES (?)`, library.Name)
+	if err != nil {
+		return err
+	}
+
+	library.ID = id
+	library.SongCount, library.Identifications = 0, 0
+	library.CreatedAt = time.Now().UTC()
+	return nil
+}
+
+// libraryColumns selects what scanLibraries reads.
+const libraryColumns = `
+    SELECT l.id, l.name, l.created_at,
+        (SELECT COUNT(*) FROM songs WHERE songs.library_id = l.id AND ` + liveSongs + `),
+        (SELECT COUNT(*) FROM identifications WHERE identifications.library_id = l.id)
+    FROM libraries l`
+
+func scanLibraries(rows *sql.Rows) ([]*Library, error) {
+	libraries := []*Library{}
+	for rows.Next() {
+		l := &Library{}
+		var createdAt sql.NullTime
+		if err := rows.Scan(&l.ID, &l.Name, &createdAt, &l.SongCount, &l.Identifications); err != nil {
+			return nil, err
+		}
+		l.CreatedAt = createdAt.Time
+		libraries = append(libraries, l)
+	}
+	return libraries, rows.Err()
+}
+
+// GetLibraries lists the libraries in the order they were added.
+func (db *DB) GetLibraries() ([]*Library, error) {
+	rows, err := db.query(libraryColumns + ` ORDER BY l.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanLibraries(rows)
+}
+
+func (db *DB) GetLibrary(id int) (*Library, error) {
+	return db.getLibrary(`l.id = ?`, id)
+}
+
+func (db *DB) GetLibraryByName(name string) (*Library, error) {
+	return db.getLibrary(`l.name = ?`, strings.TrimSpace(name))
+}
+
+// getLibrary runs prepared, as every request looks its library up by name.
+func (db *DB) getLibrary(condition string, arg interface{}) (*Library, error) {
+	stmt, err := db.prepared(libraryColumns + ` WHERE ` + condition)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.Query(arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	libraries, err := scanLibraries(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(libraries) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return libraries[0], nil
+}
+
+// DeleteLibrary deletes an empty library along with its identification
+// history, playlists, audit log and deleted songs, which can no longer be
+// restored. The default library and libraries that still have songs or
+// monitors are kept, reporting ErrLibraryInUse.
+func (db *DB) DeleteLibrary(id int) error {
+	return db.inTx(func(tx *DB) error {
+		return tx.deleteLibrary(id)
+	})
+}
+
+// deleteLibrary checks and deletes in one transaction, so that a song added
+// meanwhile keeps the library.
+func (db *DB) deleteLibrary(id int) error {
+	library, err := db.GetLibrary(id)
+	if err != nil {
+		return err
+	}
+	var monitors int
+	if err := db.queryRow(`SELECT COUNT(*) FROM monitors WHERE library_id = ?`, id).Scan(&monitors); err != nil {
+		return err
+	}
+	if id == db.defaultLibrary || library.SongCount > 0 || monitors > 0 {
+		return ErrLibraryInUse
+	}
+
+	deleted := `(SELECT id FROM songs WHERE library_id = ? AND NOT ` + liveSongs + `)`
+	for _, query := range []string{
+		`DELETE FROM song_tags WHERE song_id IN ` + deleted,
+		`DELETE FROM playlist_songs WHERE song_id IN ` + deleted,
+		`DELETE FROM songs WHERE library_id = ? AND NOT ` + liveSongs,
+		`DELETE FROM identifications WHERE library_id = ?`,
+		`DELETE FROM playlist_songs WHERE playlist_id IN (SELECT id FROM playlists WHERE library_id = ?)`,
+		`DELETE FROM playlists WHERE library_id = ?`,
+		`DELETE FROM audit_log WHERE library_id = ?`,
+	} {
+		if _, err := db.exec(query, id); err != nil {
+			return err
+		}
+	}
+	result, err := db.exec(`DELETE FROM libraries WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
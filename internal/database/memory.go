@@ -0,0 +1,1317 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store held entirely in memory, for tests that should not
+// need a database file. It orders, filters and searches exactly as DB does
+// and, like an AUTOINCREMENT column, never reuses the ID of a deleted row.
+// Everything it returns is a copy, so callers may modify results freely.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	songs   map[int]*Song
+	artists map[int]*memoryArtist
+	albums  map[int]*memoryAlbum
+	// deleted holds the deleted songs, apart so that only RestoreSong and
+	// listings of deleted songs see them.
+	deleted map[int]*Song
+	// tags holds the sorted tags of each song and playlists the
+	// entries of each playlist.
+	tags      map[int][]string
+	playlists map[int]*memoryPlaylist
+	libraries map[int]*Library
+	idents    map[int]*Identification
+	monitors  map[int]*Monitor
+	airplay   map[int]*Airplay
+	audit     map[int]*AuditEntry
+
+	lastSong, lastArtist, lastAlbum, lastPlaylist, lastLibrary, lastIdent, lastMonitor, lastAirplay, lastAudit int
+}
+
+// memoryArtist is an artist with the name keys it is found by: its own and
+// those of its aliases, which map to the alias as entered.
+type memoryArtist struct {
+	id        int
+	name, key string
+	aliases   map[string]string
+}
+
+type memoryAlbum struct {
+	id, artistID int
+	title, key   string
+}
+
+type memoryPlaylist struct {
+	Playlist
+	songIDs []int
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	m := &MemoryStore{
+		songs:     make(map[int]*Song),
+		deleted:   make(map[int]*Song),
+		artists:   make(map[int]*memoryArtist),
+		albums:    make(map[int]*memoryAlbum),
+		tags:      make(map[int][]string),
+		playlists: make(map[int]*memoryPlaylist),
+		libraries: make(map[int]*Library),
+		idents:    make(map[int]*Identification),
+		monitors:  make(map[int]*Monitor),
+		airplay:   make(map[int]*Airplay),
+		audit:     make(map[int]*AuditEntry),
+	}
+	m.AddLibrary(&Library{Name: DefaultLibrary})
+	return m
+}
+
+// currentTimestamp is the time a row is stamped with, at the second
+// resolution of CURRENT_TIMESTAMP.
+func currentTimestamp() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// copySong returns song as it reads back from the database, with hashes
+// and chroma never nil.
+func copySong(song *Song) *Song {
+	c := *song
+	c.HashSegments = append(HashSegments{}, song.HashSegments...)
+	c.Chroma = append(ChromaSequence{}, song.Chroma...)
+	if song.DeletedAt != nil {
+		at := *song.DeletedAt
+		c.DeletedAt = &at
+	}
+	return &c
+}
+
+func (m *MemoryStore) AddSong(song *Song) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.linkCatalog(song)
+	song.LibraryID = m.libraryOf(song.LibraryID)
+	m.lastSong++
+	song.ID = m.lastSong
+	stored := copySong(song)
+	// A failed tempo estimate is stored as missing, as analysisValues does.
+	stored.BPM = max(stored.BPM, 0)
+	stored.DateAdded = currentTimestamp()
+	m.songs[song.ID] = stored
+	return nil
+}
+
+func (m *MemoryStore) GetAllSongs() ([]*Song, error) {
+	return m.ListSongs(SongFilter{})
+}
+
+func (m *MemoryStore) ListSongs(filter SongFilter) ([]*Song, error) {
+	var compare func(a, b *Song) int
+	var missing func(s *Song) bool
+	switch filter.Sort {
+	case "":
+	case "title":
+		compare = func(a, b *Song) int { return strings.Compare(a.Title, b.Title) }
+	case "artist":
+		compare = func(a, b *Song) int { return strings.Compare(a.Artist, b.Artist) }
+	case "album":
+		compare = func(a, b *Song) int { return strings.Compare(a.Album, b.Album) }
+	case "duration":
+		compare = func(a, b *Song) int { return a.Duration - b.Duration }
+	case "bpm":
+		compare = func(a, b *Song) int { return compareFloats(a.BPM, b.BPM) }
+		missing = func(s *Song) bool { return s.BPM <= 0 }
+	case "key":
+		compare = func(a, b *Song) int { return strings.Compare(a.Key, b.Key) }
+		missing = func(s *Song) bool { return s.Key == "" }
+	case "date_added":
+		compare = func(a, b *Song) int { return a.ID - b.ID }
+	default:
+		return nil, fmt.Errorf("unknown sort %q", filter.Sort)
+	}
+
+	less := func(a, b *Song) bool {
+		if compare != nil {
+			if missing != nil && missing(a) != missing(b) {
+				return missing(b)
+			}
+			if c := compare(a, b); c != 0 {
+				return (c < 0) != filter.Desc
+			}
+		}
+		if a.Artist != b.Artist {
+			return a.Artist < b.Artist
+		}
+		if a.Title != b.Title {
+			return a.Title < b.Title
+		}
+		return a.ID < b.ID
+	}
+
+	cursor, err := decodeSongCursor(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	source := m.songs
+	if filter.Deleted {
+		source = m.deleted
+	}
+	var songs []*Song
+	for _, song := range source {
+		// As in SQL, a song missing its tempo or key fails any filter on it.
+		if filter.MinBPM > 0 && (song.BPM <= 0 || song.BPM < filter.MinBPM) {
+			continue
+		}
+		if filter.MaxBPM > 0 && (song.BPM <= 0 || song.BPM > filter.MaxBPM) {
+			continue
+		}
+		if filter.Key != "" && strings.ToLower(song.Key) != strings.ToLower(filter.Key) {
+			continue
+		}
+		if filter.Artist != "" && !strings.Contains(strings.ToLower(song.Artist), strings.ToLower(filter.Artist)) {
+			continue
+		}
+		if filter.Album != "" && !strings.Contains(strings.ToLower(song.Album), strings.ToLower(filter.Album)) {
+			continue
+		}
+		if filter.ArtistID != 0 && song.ArtistID != filter.ArtistID {
+			continue
+		}
+		if filter.AlbumID != 0 && song.AlbumID != filter.AlbumID {
+			continue
+		}
+		if filter.LibraryID != 0 && song.LibraryID != filter.LibraryID {
+			continue
+		}
+		if filter.Tag != "" && !slices.Contains(m.tags[song.ID], normalizeTag(filter.Tag)) {
+			continue
+		}
+		if !filter.AddedFrom.IsZero() && song.DateAdded.Before(filter.AddedFrom) {
+			continue
+		}
+		if !filter.AddedTo.IsZero() && !song.DateAdded.Before(filter.AddedTo) {
+			continue
+		}
+		if cursor != nil && !less(cursor.song(), song) {
+			continue
+		}
+		songs = append(songs, copySong(song))
+	}
+
+	sort.Slice(songs, func(i, j int) bool { return less(songs[i], songs[j]) })
+	return page(songs, filter.Limit, 0), nil
+}
+
+func (m *MemoryStore) ListSongSummaries(filter SongFilter) ([]*SongSummary, error) {
+	songs, err := m.ListSongs(filter)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	summaries := make([]*SongSummary, len(songs))
+	for i, song := range songs {
+		summaries[i] = m.summarizeSong(song)
+	}
+	return summaries, nil
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (m *MemoryStore) GetSong(id int) (*Song, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	song, ok := m.songs[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copySong(song), nil
+}
+
+func (m *MemoryStore) UpdateSong(song *Song) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.updateSong(song)
+}
+
+// updateSong is UpdateSong for a caller holding the write lock.
+func (m *MemoryStore) updateSong(song *Song) error {
+	stored, ok := m.songs[song.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	m.linkCatalog(song)
+	stored.Title, stored.Artist, stored.Album = song.Title, song.Artist, song.Album
+	stored.ArtistID, stored.AlbumID = song.ArtistID, song.AlbumID
+	m.pruneCatalog()
+	return nil
+}
+
+func (m *MemoryStore) SetSongChroma(id int, seq ChromaSequence) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.songs[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	stored.Chroma = append(ChromaSequence{}, seq...)
+	return nil
+}
+
+func (m *MemoryStore) SetSongFingerprint(id int, fingerprint string, segments HashSegments, offset, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.songs[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	stored.Fingerprint = fingerprint
+	stored.HashSegments = append(HashSegments{}, segments...)
+	stored.HashOffset, stored.FingerprintVersion = offset, version
+	return nil
+}
+
+func (m *MemoryStore) SetSongAnalysis(id int, bpm float64, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.songs[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	stored.BPM, stored.Key = max(bpm, 0), key
+	return nil
+}
+
+func (m *MemoryStore) DeleteSong(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.deleteSong(id)
+}
+
+// deleteSong is DeleteSong for a caller holding the write lock.
+func (m *MemoryStore) deleteSong(id int) error {
+	stored, ok := m.songs[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	deletedAt := time.Now().UTC()
+	stored.DeletedAt = &deletedAt
+	delete(m.songs, id)
+	m.deleted[id] = stored
+	m.pruneCatalog()
+	return nil
+}
+
+func (m *MemoryStore) RestoreSong(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.restoreSong(id)
+}
+
+// restoreSong is RestoreSong for a caller holding the write lock.
+func (m *MemoryStore) restoreSong(id int) error {
+	stored, ok := m.deleted[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	stored.DeletedAt = nil
+	delete(m.deleted, id)
+	m.songs[id] = stored
+	m.linkCatalog(stored)
+	m.pruneCatalog()
+	return nil
+}
+
+func (m *MemoryStore) SearchSongs(query string, library, limit, offset int) ([]*Song, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := make([]searchEntry, 0, len(m.songs))
+	for _, song := range m.songs {
+		if library == 0 || song.LibraryID == library {
+			entries = append(entries, searchEntry{song.ID, song.Title, song.Artist, song.Album})
+		}
+	}
+	ids := rankSearch(searchTokens(query), entries)
+
+	songs := []*Song{}
+	for _, id := range page(ids, limit, offset) {
+		songs = append(songs, copySong(m.songs[id]))
+	}
+	return songs, len(ids), nil
+}
+
+func (m *MemoryStore) GetSongCount() (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.songs), nil
+}
+
+// linkCatalog finds or creates the artist and album of song as
+// DB.linkCatalog does. The caller holds the write lock.
+func (m *MemoryStore) linkCatalog(song *Song) {
+	key := nameKey(song.Artist)
+	var artist *memoryArtist
+	for _, a := range m.artists {
+		if _, alias := a.aliases[key]; a.key == key || alias {
+			artist = a
+			break
+		}
+	}
+	if artist == nil {
+		m.lastArtist++
+		artist = &memoryArtist{id: m.lastArtist, name: cleanName(song.Artist), key: key, aliases: map[string]string{}}
+		m.artists[artist.id] = artist
+	}
+	song.ArtistID, song.Artist = artist.id, artist.name
+
+	song.AlbumID = 0
+	if song.Album = cleanName(song.Album); song.Album == "" {
+		return
+	}
+	key = nameKey(song.Album)
+	for _, album := range m.albums {
+		if album.artistID == artist.id && album.key == key {
+			song.AlbumID, song.Album = album.id, album.title
+			return
+		}
+	}
+	m.lastAlbum++
+	m.albums[m.lastAlbum] = &memoryAlbum{id: m.lastAlbum, artistID: artist.id, title: song.Album, key: key}
+	song.AlbumID = m.lastAlbum
+}
+
+// catalogCounts counts the songs in library of each artist and album and
+// the albums of each artist with songs there, every library's for 0.
+func (m *MemoryStore) catalogCounts(library int) (artistSongs, albumSongs, artistAlbums map[int]int) {
+	artistSongs, albumSongs, artistAlbums = make(map[int]int), make(map[int]int), make(map[int]int)
+	for _, song := range m.songs {
+		if library == 0 || song.LibraryID == library {
+			artistSongs[song.ArtistID]++
+			albumSongs[song.AlbumID]++
+		}
+	}
+	for _, album := range m.albums {
+		if albumSongs[album.id] > 0 {
+			artistAlbums[album.artistID]++
+		}
+	}
+	return artistSongs, albumSongs, artistAlbums
+}
+
+// pruneCatalog drops the albums and artists no song links to any more.
+// The caller holds the write lock.
+func (m *MemoryStore) pruneCatalog() {
+	artistSongs, albumSongs, _ := m.catalogCounts(0)
+	for id := range m.albums {
+		if albumSongs[id] == 0 {
+			delete(m.albums, id)
+		}
+	}
+	for id := range m.artists {
+		if artistSongs[id] == 0 {
+			delete(m.artists, id)
+		}
+	}
+}
+
+func (m *MemoryStore) GetArtists(name string, library, limit, offset int) ([]*Artist, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key := nameKey(name)
+	artistSongs, _, artistAlbums := m.catalogCounts(library)
+	var found []*memoryArtist
+	for _, a := range m.artists {
+		if strings.Contains(a.key, key) && artistSongs[a.id] > 0 {
+			found = append(found, a)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].key != found[j].key {
+			return found[i].key < found[j].key
+		}
+		return found[i].id < found[j].id
+	})
+
+	artists := []*Artist{}
+	for _, a := range page(found, limit, offset) {
+		artists = append(artists, &Artist{ID: a.id, Name: a.name, SongCount: artistSongs[a.id], AlbumCount: artistAlbums[a.id]})
+	}
+	return artists, len(found), nil
+}
+
+func (m *MemoryStore) GetArtist(id, library int) (*Artist, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.artist(id, library)
+}
+
+// artist reads a stored artist of library the way DB does. The caller
+// holds the lock.
+func (m *MemoryStore) artist(id, library int) (*Artist, error) {
+	artistSongs, _, artistAlbums := m.catalogCounts(library)
+	a, ok := m.artists[id]
+	if !ok || artistSongs[id] == 0 {
+		return nil, sql.ErrNoRows
+	}
+	artist := &Artist{ID: a.id, Name: a.name, SongCount: artistSongs[id], AlbumCount: artistAlbums[id]}
+
+	keys := make([]string, 0, len(a.aliases))
+	for key := range a.aliases {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		artist.Aliases = append(artist.Aliases, a.aliases[key])
+	}
+	return artist, nil
+}
+
+// album reads a stored album the way DB lists it. The caller holds the
+// lock.
+func (m *MemoryStore) album(stored *memoryAlbum, albumSongs map[int]int) *Album {
+	return &Album{
+		ID:        stored.id,
+		ArtistID:  stored.artistID,
+		Artist:    m.artists[stored.artistID].name,
+		Title:     stored.title,
+		SongCount: albumSongs[stored.id],
+	}
+}
+
+func (m *MemoryStore) GetArtistAlbums(id, library int) ([]*Album, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.artistAlbums(id, library), nil
+}
+
+// artistAlbums lists the albums of an artist in library. The caller holds
+// the lock.
+func (m *MemoryStore) artistAlbums(id, library int) []*Album {
+	_, albumSongs, _ := m.catalogCounts(library)
+	var found []*memoryAlbum
+	for _, album := range m.albums {
+		if album.artistID == id && albumSongs[album.id] > 0 {
+			found = append(found, album)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].key != found[j].key {
+			return found[i].key < found[j].key
+		}
+		return found[i].id < found[j].id
+	})
+
+	albums := []*Album{}
+	for _, album := range found {
+		albums = append(albums, m.album(album, albumSongs))
+	}
+	return albums
+}
+
+func (m *MemoryStore) GetAlbum(id, library int) (*Album, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, albumSongs, _ := m.catalogCounts(library)
+	stored, ok := m.albums[id]
+	if !ok || albumSongs[id] == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return m.album(stored, albumSongs), nil
+}
+
+func (m *MemoryStore) MergeArtists(id, into, library int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id == into {
+		return nil
+	}
+	target, err := m.artist(into, library)
+	if err != nil {
+		return err
+	}
+	if _, err := m.artist(id, library); err != nil {
+		return err
+	}
+	inLibrary := func(song *Song) bool { return library == 0 || song.LibraryID == library }
+	// elsewhere reports live songs outside library that stay linked, and
+	// songs, deleted ones included, are those DB updates.
+	elsewhere := func(linked func(*Song) bool) bool {
+		for _, song := range m.songs {
+			if !inLibrary(song) && linked(song) {
+				return true
+			}
+		}
+		return false
+	}
+	songs := slices.Collect(maps.Values(m.songs))
+	songs = append(songs, slices.Collect(maps.Values(m.deleted))...)
+
+	for _, listed := range m.artistAlbums(id, library) {
+		album := m.albums[listed.ID]
+		var shared *memoryAlbum
+		for _, other := range m.albums {
+			if other.artistID == into && other.key == album.key {
+				shared = other
+			}
+		}
+		if shared == nil {
+			if !elsewhere(func(s *Song) bool { return s.AlbumID == album.id }) {
+				album.artistID = into
+				continue
+			}
+			m.lastAlbum++
+			shared = &memoryAlbum{id: m.lastAlbum, artistID: into, title: album.title, key: album.key}
+			m.albums[shared.id] = shared
+		}
+		for _, song := range songs {
+			if song.AlbumID == album.id && inLibrary(song) {
+				song.AlbumID, song.Album = shared.id, shared.title
+			}
+		}
+	}
+	for _, song := range songs {
+		if song.ArtistID == id && inLibrary(song) {
+			song.ArtistID, song.Artist = into, target.Name
+		}
+	}
+	if !elsewhere(func(s *Song) bool { return s.ArtistID == id }) {
+		source, dest := m.artists[id], m.artists[into]
+		for key, alias := range source.aliases {
+			dest.aliases[key] = alias
+		}
+		dest.aliases[source.key] = source.name
+	}
+	m.pruneCatalog()
+	return nil
+}
+
+// summarizeSong lists a song with its tags. The caller holds the lock.
+func (m *MemoryStore) summarizeSong(song *Song) *SongSummary {
+	summary := summarizeSong(song)
+	summary.Tags = slices.Clone(m.tags[song.ID])
+	return summary
+}
+
+func (m *MemoryStore) GetTags(library int) ([]*TagCount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for songID, tags := range m.tags {
+		song, ok := m.songs[songID]
+		if !ok || library != 0 && song.LibraryID != library {
+			continue
+		}
+		for _, tag := range tags {
+			counts[tag]++
+		}
+	}
+	tags := []*TagCount{}
+	for tag, n := range counts {
+		tags = append(tags, &TagCount{Tag: tag, Songs: n})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Tag < tags[j].Tag })
+	return tags, nil
+}
+
+func (m *MemoryStore) GetSongTags(songID int) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]string{}, m.tags[songID]...), nil
+}
+
+func (m *MemoryStore) SetSongTags(songID int, tags []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.setSongTags(songID, tags)
+}
+
+// setSongTags is SetSongTags for a caller holding the write lock.
+func (m *MemoryStore) setSongTags(songID int, tags []string) error {
+	if _, ok := m.songs[songID]; !ok {
+		return fmt.Errorf("song %d: %w", songID, sql.ErrNoRows)
+	}
+	if tags = normalizeTags(tags); len(tags) > 0 {
+		m.tags[songID] = tags
+	} else {
+		delete(m.tags, songID)
+	}
+	return nil
+}
+
+func (m *MemoryStore) AddPlaylist(playlist *Playlist) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastPlaylist++
+	playlist.ID = m.lastPlaylist
+	playlist.LibraryID = m.libraryOf(playlist.LibraryID)
+	playlist.SongCount = 0
+	playlist.CreatedAt = time.Now().UTC()
+
+	stored := &memoryPlaylist{Playlist: *playlist}
+	stored.CreatedAt = currentTimestamp()
+	m.playlists[playlist.ID] = stored
+	return nil
+}
+
+// playlist reads a stored playlist the way DB lists it. The caller holds
+// the lock.
+func (m *MemoryStore) playlist(stored *memoryPlaylist) *Playlist {
+	playlist := stored.Playlist
+	playlist.SongCount = len(m.playlistSongs(stored))
+	return &playlist
+}
+
+// playlistSongs returns the live songs of a playlist's entries that are in
+// its library, as DB skips the others. The caller holds the lock.
+func (m *MemoryStore) playlistSongs(stored *memoryPlaylist) []*Song {
+	var songs []*Song
+	for _, songID := range stored.songIDs {
+		if song, ok := m.songs[songID]; ok && song.LibraryID == stored.LibraryID {
+			songs = append(songs, song)
+		}
+	}
+	return songs
+}
+
+// requireLibrarySong reports a missing song or one outside library as DB
+// does. The caller holds the lock.
+func (m *MemoryStore) requireLibrarySong(id, library int) error {
+	song, ok := m.songs[id]
+	if !ok {
+		return fmt.Errorf("song %d: %w", id, sql.ErrNoRows)
+	}
+	if song.LibraryID != library {
+		return fmt.Errorf("song %d: %w", id, ErrOtherLibrary)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetPlaylists(library int) ([]*Playlist, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	playlists := []*Playlist{}
+	for _, stored := range m.playlists {
+		if library == 0 || stored.LibraryID == library {
+			playlists = append(playlists, m.playlist(stored))
+		}
+	}
+	sort.Slice(playlists, func(i, j int) bool { return playlists[i].ID < playlists[j].ID })
+	return playlists, nil
+}
+
+func (m *MemoryStore) GetPlaylist(id int) (*Playlist, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, ok := m.playlists[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return m.playlist(stored), nil
+}
+
+func (m *MemoryStore) RenamePlaylist(id int, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.playlists[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	stored.Name = name
+	return nil
+}
+
+func (m *MemoryStore) DeletePlaylist(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.playlists[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.playlists, id)
+	return nil
+}
+
+func (m *MemoryStore) GetPlaylistSongs(id int) ([]*SongSummary, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	songs := []*SongSummary{}
+	if stored, ok := m.playlists[id]; ok {
+		for _, song := range m.playlistSongs(stored) {
+			songs = append(songs, m.summarizeSong(song))
+		}
+	}
+	return songs, nil
+}
+
+func (m *MemoryStore) SetPlaylistSongs(id int, songIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.playlists[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	for _, songID := range songIDs {
+		if err := m.requireLibrarySong(songID, stored.LibraryID); err != nil {
+			return err
+		}
+	}
+	stored.songIDs = slices.Clone(songIDs)
+	return nil
+}
+
+func (m *MemoryStore) AddPlaylistSong(id, songID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.playlists[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if err := m.requireLibrarySong(songID, stored.LibraryID); err != nil {
+		return err
+	}
+	stored.songIDs = append(stored.songIDs, songID)
+	return nil
+}
+
+// libraryOf is the library a row stored with library is put in, the
+// default library being the first one added.
+func (m *MemoryStore) libraryOf(library int) int {
+	if library == 0 {
+		return 1
+	}
+	return library
+}
+
+func (m *MemoryStore) AddLibrary(library *Library) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	library.Name = strings.TrimSpace(library.Name)
+	for _, stored := range m.libraries {
+		if stored.Name == library.Name {
+			return ErrLibraryExists
+		}
+	}
+	m.lastLibrary++
+	library.ID = m.lastLibrary
+	library.SongCount, library.Identifications = 0, 0
+	library.CreatedAt = time.Now().UTC()
+
+	stored := *library
+	stored.CreatedAt = currentTimestamp()
+	m.libraries[library.ID] = &stored
+	return nil
+}
+
+// readLibrary copies a stored library with its current counts. The caller
+// holds the lock.
+func (m *MemoryStore) readLibrary(stored *Library) *Library {
+	library := *stored
+	for _, song := range m.songs {
+		if song.LibraryID == library.ID {
+			library.SongCount++
+		}
+	}
+	for _, ident := range m.idents {
+		if ident.LibraryID == library.ID {
+			library.Identifications++
+		}
+	}
+	return &library
+}
+
+func (m *MemoryStore) GetLibraries() ([]*Library, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	libraries := []*Library{}
+	for _, stored := range m.libraries {
+		libraries = append(libraries, m.readLibrary(stored))
+	}
+	sort.Slice(libraries, func(i, j int) bool { return libraries[i].ID < libraries[j].ID })
+	return libraries, nil
+}
+
+func (m *MemoryStore) GetLibrary(id int) (*Library, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, ok := m.libraries[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return m.readLibrary(stored), nil
+}
+
+func (m *MemoryStore) GetLibraryByName(name string) (*Library, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, stored := range m.libraries {
+		if stored.Name == strings.TrimSpace(name) {
+			return m.readLibrary(stored), nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) DeleteLibrary(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.libraries[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	inUse := id == m.libraryOf(0) || m.readLibrary(stored).SongCount > 0
+	for _, monitor := range m.monitors {
+		inUse = inUse || monitor.LibraryID == id
+	}
+	if inUse {
+		return ErrLibraryInUse
+	}
+
+	for songID, song := range m.deleted {
+		if song.LibraryID != id {
+			continue
+		}
+		delete(m.deleted, songID)
+		delete(m.tags, songID)
+		for _, playlist := range m.playlists {
+			playlist.songIDs = slices.DeleteFunc(playlist.songIDs, func(entry int) bool { return entry == songID })
+		}
+	}
+	for identID, ident := range m.idents {
+		if ident.LibraryID == id {
+			delete(m.idents, identID)
+		}
+	}
+	for playlistID, playlist := range m.playlists {
+		if playlist.LibraryID == id {
+			delete(m.playlists, playlistID)
+		}
+	}
+	for entryID, entry := range m.audit {
+		if entry.LibraryID == id {
+			delete(m.audit, entryID)
+		}
+	}
+	delete(m.libraries, id)
+	return nil
+}
+
+func (m *MemoryStore) AddIdentification(ident *Identification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if ident.CreatedAt.IsZero() {
+		ident.CreatedAt = time.Now().UTC()
+	}
+	ident.LibraryID = m.libraryOf(ident.LibraryID)
+	m.lastIdent++
+	ident.ID = m.lastIdent
+
+	stored := *ident
+	stored.CreatedAt = ident.CreatedAt.UTC()
+	stored.Candidates = slices.Clone(ident.Candidates)
+	stored.Covers = nil
+	if len(ident.Covers) > 0 {
+		stored.Covers = slices.Clone(ident.Covers)
+	}
+	stored.Query = append(HashSegments{}, ident.Query...)
+	stored.ConfirmedSongID, stored.ConfirmedAt = 0, nil
+	m.idents[ident.ID] = &stored
+	return nil
+}
+
+// readIdentification copies a stored attempt the way it lists: with the
+// current title and artist of its song and without its query.
+func (m *MemoryStore) readIdentification(stored *Identification) *Identification {
+	ident := *stored
+	ident.Title, ident.Artist = "", ""
+	if song, ok := m.songs[ident.SongID]; ok {
+		ident.Title, ident.Artist = song.Title, song.Artist
+	}
+	ident.Candidates = slices.Clone(stored.Candidates)
+	ident.Covers = slices.Clone(stored.Covers)
+	ident.Query = nil
+	if stored.ConfirmedAt != nil {
+		at := *stored.ConfirmedAt
+		ident.ConfirmedAt = &at
+	}
+	return &ident
+}
+
+func (m *MemoryStore) GetIdentification(id int) (*Identification, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, ok := m.idents[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return m.readIdentification(stored), nil
+}
+
+func (m *MemoryStore) GetIdentificationQuery(id int) (HashSegments, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, ok := m.idents[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return append(HashSegments{}, stored.Query...), nil
+}
+
+func (m *MemoryStore) GetIdentifications(filter HistoryFilter) ([]*Identification, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	idents := []*Identification{}
+	for _, stored := range m.idents {
+		switch {
+		case filter.LibraryID != 0 && stored.LibraryID != filter.LibraryID,
+			filter.Source != "" && stored.Source != filter.Source,
+			filter.SongID != 0 && stored.SongID != filter.SongID,
+			filter.Matched != nil && stored.IsMatch != *filter.Matched,
+			filter.MinConfidence > 0 && stored.Confidence < filter.MinConfidence,
+			!filter.From.IsZero() && stored.CreatedAt.Before(filter.From),
+			!filter.To.IsZero() && !stored.CreatedAt.Before(filter.To):
+			continue
+		}
+		idents = append(idents, stored)
+	}
+
+	sort.Slice(idents, func(i, j int) bool {
+		if !idents[i].CreatedAt.Equal(idents[j].CreatedAt) {
+			return idents[i].CreatedAt.After(idents[j].CreatedAt)
+		}
+		return idents[i].ID > idents[j].ID
+	})
+
+	total := len(idents)
+	idents = page(idents, filter.Limit, filter.Offset)
+	for i, stored := range idents {
+		idents[i] = m.readIdentification(stored)
+	}
+	return idents, total, nil
+}
+
+// page applies LIMIT and OFFSET, where a limit that is not positive means
+// no limit.
+func page[T any](items []T, limit, offset int) []T {
+	items = items[min(max(offset, 0), len(items)):]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+func (m *MemoryStore) ConfirmIdentification(id, songID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.idents[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	at := time.Now().UTC()
+	stored.ConfirmedSongID, stored.ConfirmedAt = songID, &at
+	return nil
+}
+
+func (m *MemoryStore) GetAccuracyStats(library int) (*AccuracyStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := &AccuracyStats{}
+	for _, ident := range m.idents {
+		if ident.ConfirmedAt == nil || library != 0 && ident.LibraryID != library {
+			continue
+		}
+		answer := 0
+		if ident.IsMatch {
+			answer = ident.SongID
+		}
+
+		stats.Reviewed++
+		switch {
+		case ident.ConfirmedSongID == answer:
+			stats.Correct++
+		case ident.ConfirmedSongID != 0:
+			stats.Corrected++
+		default:
+			stats.Rejected++
+		}
+	}
+
+	if stats.Reviewed > 0 {
+		stats.Accuracy = float64(stats.Correct) / float64(stats.Reviewed)
+	}
+	return stats, nil
+}
+
+func (m *MemoryStore) AddMonitor(monitor *Monitor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	monitor.LibraryID = m.libraryOf(monitor.LibraryID)
+	m.lastMonitor++
+	monitor.ID = m.lastMonitor
+	monitor.CreatedAt = time.Now().UTC()
+
+	stored := *monitor
+	stored.CreatedAt = currentTimestamp()
+	m.monitors[monitor.ID] = &stored
+	return nil
+}
+
+func (m *MemoryStore) GetMonitors() ([]*Monitor, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var monitors []*Monitor
+	for _, stored := range m.monitors {
+		monitor := *stored
+		monitors = append(monitors, &monitor)
+	}
+	sort.Slice(monitors, func(i, j int) bool { return monitors[i].ID < monitors[j].ID })
+	return monitors, nil
+}
+
+func (m *MemoryStore) GetMonitor(id int) (*Monitor, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, ok := m.monitors[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	monitor := *stored
+	return &monitor, nil
+}
+
+func (m *MemoryStore) SetMonitorEnabled(id int, enabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.monitors[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	stored.Enabled = enabled
+	return nil
+}
+
+func (m *MemoryStore) DeleteMonitor(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for playID, play := range m.airplay {
+		if play.MonitorID == id {
+			delete(m.airplay, playID)
+		}
+	}
+	if _, ok := m.monitors[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.monitors, id)
+	return nil
+}
+
+func (m *MemoryStore) AddAirplay(play *Airplay) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastAirplay++
+	play.ID = m.lastAirplay
+
+	stored := *play
+	stored.StartedAt, stored.EndedAt = play.StartedAt.UTC(), play.EndedAt.UTC()
+	stored.Title, stored.Artist = "", ""
+	m.airplay[play.ID] = &stored
+	return nil
+}
+
+func (m *MemoryStore) GetAirplays(monitorID int, from, to time.Time, limit, offset int) ([]*Airplay, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	plays := []*Airplay{}
+	for _, stored := range m.airplay {
+		if stored.MonitorID != monitorID || stored.StartedAt.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !stored.StartedAt.Before(to) {
+			continue
+		}
+		play := *stored
+		if song, ok := m.songs[play.SongID]; ok {
+			play.Title, play.Artist = song.Title, song.Artist
+		}
+		plays = append(plays, &play)
+	}
+
+	sort.Slice(plays, func(i, j int) bool {
+		if !plays[i].StartedAt.Equal(plays[j].StartedAt) {
+			return plays[i].StartedAt.After(plays[j].StartedAt)
+		}
+		return plays[i].ID > plays[j].ID
+	})
+	if limit == 0 {
+		return []*Airplay{}, nil
+	}
+	return page(plays, limit, offset), nil
+}
+
+func (m *MemoryStore) GetAirplayReport(monitorID int, day time.Time) ([]*AirplaySummary, error) {
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	plays, err := m.GetAirplays(monitorID, from, from.AddDate(0, 0, 1), -1, 0)
+	if err != nil {
+		return nil, err
+	}
+	return summariseAirplay(plays), nil
+}
+
+func (m *MemoryStore) AddAuditEntry(entry *AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.addAuditEntry(entry)
+}
+
+// addAuditEntry is AddAuditEntry for a caller holding the write lock.
+func (m *MemoryStore) addAuditEntry(entry *AuditEntry) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC()
+	}
+	if entry.UndoOf != 0 {
+		undone, ok := m.audit[entry.UndoOf]
+		if !ok || undone.UndoneAt != nil {
+			return sql.ErrNoRows
+		}
+		at := entry.CreatedAt.UTC()
+		undone.UndoneAt = &at
+	}
+
+	m.lastAudit++
+	entry.ID = m.lastAudit
+	entry.LibraryID = m.libraryOf(entry.LibraryID)
+	entry.UndoneAt = nil
+	stored := *entry
+	stored.CreatedAt = entry.CreatedAt.UTC()
+	stored.Before = slices.Clone(entry.Before)
+	stored.After = slices.Clone(entry.After)
+	m.audit[entry.ID] = &stored
+	return nil
+}
+
+// RevertSong checks everything that can fail before changing anything, as
+// it has no transaction to roll back.
+func (m *MemoryStore) RevertSong(undo *AuditEntry, action string, previous *Song, tags []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if undone, ok := m.audit[undo.UndoOf]; undo.UndoOf != 0 && (!ok || undone.UndoneAt != nil) {
+		return sql.ErrNoRows
+	}
+	var err error
+	switch action {
+	case AuditCreate:
+		err = m.deleteSong(previous.ID)
+	case AuditDelete:
+		err = m.restoreSong(previous.ID)
+	case AuditUpdate:
+		if err = m.updateSong(previous); err == nil {
+			if previous.LibraryID != 0 {
+				m.songs[previous.ID].LibraryID = previous.LibraryID
+			}
+			err = m.setSongTags(previous.ID, tags)
+		}
+	default:
+		err = fmt.Errorf("cannot undo %s", action)
+	}
+	if err != nil {
+		return err
+	}
+	return m.addAuditEntry(undo)
+}
+
+func (m *MemoryStore) GetAuditEntries(filter AuditFilter) ([]*AuditEntry, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := []*AuditEntry{}
+	for _, stored := range m.audit {
+		switch {
+		case filter.Actor != "" && stored.Actor != filter.Actor,
+			filter.Action != "" && stored.Action != filter.Action,
+			filter.Entity != "" && stored.Entity != filter.Entity,
+			filter.EntityID != 0 && stored.EntityID != filter.EntityID,
+			filter.LibraryID != 0 && stored.LibraryID != filter.LibraryID,
+			filter.Undone != nil && (stored.UndoneAt != nil) != *filter.Undone,
+			!filter.From.IsZero() && stored.CreatedAt.Before(filter.From),
+			!filter.To.IsZero() && !stored.CreatedAt.Before(filter.To):
+			continue
+		}
+		entries = append(entries, stored)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID > entries[j].ID })
+
+	total := len(entries)
+	entries = page(entries, filter.Limit, filter.Offset)
+	for i, stored := range entries {
+		entry := *stored
+		entry.Before = slices.Clone(stored.Before)
+		entry.After = slices.Clone(stored.After)
+		if stored.UndoneAt != nil {
+			at := *stored.UndoneAt
+			entry.UndoneAt = &at
+		}
+		entries[i] = &entry
+	}
+	return entries, total, nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
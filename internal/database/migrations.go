@@ -13,12 +13,42 @@ func (db *DB) RunMigrations() error {
             album TEXT,
             duration INTEGER,
             fingerprint TEXT NOT NULL,
-            hash_segments TEXT NOT NULL,
+            hash_segments BLOB NOT NULL,
             date_added DATETIME DEFAULT CURRENT_TIMESTAMP
         );`,
 		`CREATE INDEX IF NOT EXISTS idx_artist ON songs(artist);`,
 		`CREATE INDEX IF NOT EXISTS idx_title ON songs(title);`,
 		`CREATE INDEX IF NOT EXISTS idx_fingerprint ON songs(fingerprint);`,
+		`CREATE TABLE IF NOT EXISTS monitors (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT NOT NULL,
+            url TEXT NOT NULL,
+            enabled BOOLEAN NOT NULL DEFAULT 1,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+		`CREATE TABLE IF NOT EXISTS airplay (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            monitor_id INTEGER NOT NULL,
+            song_id INTEGER NOT NULL,
+            started_at DATETIME NOT NULL,
+            ended_at DATETIME NOT NULL,
+            confidence REAL NOT NULL
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_airplay_monitor ON airplay(monitor_id, started_at);`,
+		`CREATE TABLE IF NOT EXISTS identifications (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            created_at DATETIME NOT NULL,
+            source TEXT NOT NULL,
+            duration REAL NOT NULL,
+            song_id INTEGER,
+            is_match BOOLEAN NOT NULL,
+            confidence REAL NOT NULL,
+            time_in_song REAL NOT NULL,
+            latency_ms INTEGER NOT NULL,
+            candidates TEXT NOT NULL
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_identifications_created ON identifications(created_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_identifications_song ON identifications(song_id);`,
 		`CREATE TABLE IF NOT EXISTS migration_history (
             id INTEGER PRIMARY KEY AUTOINCREMENT,
             migration_name TEXT NOT NULL,
@@ -57,6 +87,7 @@ func (db *DB) GetMigrationHistory() ([]string, error) {
 }
 
 func (db *DB) RecordMigration(name string) error {
+	defer db.writing()()
 	_, err := db.conn.Exec(`INSERT INTO migration_history (migration_name) VALUES (?)`, name)
 	return err
 }
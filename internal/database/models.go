@@ -1,34 +1,67 @@
 package database
 
 import (
+	"encoding/json"
 	"time"
 )
 
 type Song struct {
-	ID           int       `json:"id" db:"id"`
-	Title        string    `json:"title" db:"title"`
-	Artist       string    `json:"artist" db:"artist"`
-	Album        string    `json:"album" db:"album"`
-	Duration     int       `json:"duration" db:"duration"`
-	Fingerprint  string    `json:"fingerprint" db:"fingerprint"`
-	HashSegments []string  `json:"hash_segments" db:"-"`
-	DateAdded    time.Time `json:"date_added" db:"date_added"`
+	ID           int            `json:"id" db:"id"`
+	Title        string         `json:"title" db:"title"`
+	Artist       string         `json:"artist" db:"artist"`
+	Album        string         `json:"album" db:"album"`
+	Duration     int            `json:"duration" db:"duration"`
+	Fingerprint  string         `json:"fingerprint" db:"fingerprint"`
+	HashSegments HashSegments   `json:"hash_segments" db:"-"`
+	Chroma       ChromaSequence `json:"-" db:"-"`
+	// HashOffset is the number of inactive frames trimmed from the start of
+	// the audio before hashing, so that segment i hashes frame
+	// HashOffset+i. FingerprintVersion is the audio.FingerprintVersion the
+	// segments were computed with, 0 for songs fingerprinted before it.
+	HashOffset         int `json:"hash_offset" db:"hash_offset"`
+	FingerprintVersion int `json:"fingerprint_version" db:"fingerprint_version"`
+	// BPM and Key are estimated from the audio on ingestion; they are zero
+	// for songs that have not been analysed.
+	BPM       float64   `json:"bpm,omitempty" db:"bpm"`
+	Key       string    `json:"key,omitempty" db:"musical_key"`
+	DateAdded time.Time `json:"date_added" db:"date_added"`
+	// ArtistID and AlbumID link the song to its catalog entries, whose
+	// names Artist and Album copy. AlbumID is 0 for a song without one.
+	ArtistID int `json:"artist_id,omitempty" db:"artist_id"`
+	AlbumID  int `json:"album_id,omitempty" db:"album_id"`
+	// LibraryID is the library the song belongs to; songs added without
+	// one go to the default library.
+	LibraryID int `json:"library_id" db:"library_id"`
+	// DeletedAt is set while the song is deleted, which keeps it out of
+	// matching and every listing until it is restored.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
 }
 
+// MatchResult is one song's answer to a query. Score is the raw fraction of
+// matching segments; Confidence is the calibrated probability that the song
+// is really playing, derived from Score, its Margin over the best rival and
+// its ZScore against the query's background scores.
 type MatchResult struct {
 	IsMatch     bool    `json:"is_match"`
 	Confidence  float64 `json:"confidence"`
+	Score       float64 `json:"score"`
+	Margin      float64 `json:"margin"`
+	ZScore      float64 `json:"z_score"`
 	MatchOffset int     `json:"match_offset"`
 	Song        *Song   `json:"song,omitempty"`
 	TimeInSong  float64 `json:"time_in_song"`
+	// TempoFactor and PitchFactor are set by shift-tolerant matching to
+	// how much faster and higher the query is than the song.
+	TempoFactor float64 `json:"tempo_factor,omitempty"`
+	PitchFactor float64 `json:"pitch_factor,omitempty"`
 }
 
 type AudioFingerprint struct {
-	TrackID      string   `json:"track_id"`
-	TrackName    string   `json:"track_name"`
-	Artist       string   `json:"artist"`
-	Fingerprint  string   `json:"fingerprint"`
-	HashSegments []string `json:"hash_segments"`
+	TrackID      string       `json:"track_id"`
+	TrackName    string       `json:"track_name"`
+	Artist       string       `json:"artist"`
+	Fingerprint  string       `json:"fingerprint"`
+	HashSegments HashSegments `json:"hash_segments"`
 }
 
 type RecordingStatus struct {
@@ -37,3 +70,257 @@ type RecordingStatus struct {
 	Message   string `json:"message"`
 	Countdown int    `json:"countdown,omitempty"`
 }
+
+type Monitor struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Enabled   bool      `json:"enabled"`
+	LibraryID int       `json:"library_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Airplay struct {
+	ID         int       `json:"id"`
+	MonitorID  int       `json:"monitor_id"`
+	SongID     int       `json:"song_id"`
+	Title      string    `json:"title"`
+	Artist     string    `json:"artist"`
+	StartedAt  time.Time `json:"started_at"`
+	EndedAt    time.Time `json:"ended_at"`
+	Confidence float64   `json:"confidence"`
+}
+
+type AirplaySummary struct {
+	SongID  int     `json:"song_id"`
+	Title   string  `json:"title"`
+	Artist  string  `json:"artist"`
+	Plays   int     `json:"plays"`
+	Seconds float64 `json:"seconds"`
+}
+
+// Identification is one persisted identification attempt.
+type Identification struct {
+	ID            int                   `json:"id"`
+	CreatedAt     time.Time             `json:"created_at"`
+	LibraryID     int                   `json:"library_id"`
+	Source        string                `json:"source"`
+	Duration      float64               `json:"duration"`
+	SongID        int                   `json:"song_id,omitempty"`
+	Title         string                `json:"title,omitempty"`
+	Artist        string                `json:"artist,omitempty"`
+	IsMatch       bool                  `json:"is_match"`
+	Confidence    float64               `json:"confidence"`
+	TimeInSong    float64               `json:"time_in_song"`
+	LatencyMillis int64                 `json:"latency_ms"`
+	Candidates    []IdentifiedCandidate `json:"candidates"`
+	// Query holds the hashes that were identified, kept so the alignment
+	// can be inspected later. It is only stored, never listed.
+	Query HashSegments `json:"-"`
+	// Covers lists songs the query may be a cover or live version of. It
+	// is only searched when there is no exact match.
+	Covers []PossibleCover `json:"covers,omitempty"`
+	// ConfirmedAt is set once a user has reviewed the result. ConfirmedSongID
+	// is the song they picked, or 0 if they said none of the candidates was
+	// right.
+	ConfirmedSongID int        `json:"confirmed_song_id,omitempty"`
+	ConfirmedAt     *time.Time `json:"confirmed_at,omitempty"`
+}
+
+// IdentifiedCandidate is one of the ranked songs considered for an
+// identification.
+type IdentifiedCandidate struct {
+	SongID      int     `json:"song_id"`
+	Title       string  `json:"title"`
+	Artist      string  `json:"artist"`
+	Album       string  `json:"album,omitempty"`
+	Confidence  float64 `json:"confidence"`
+	MatchOffset int     `json:"match_offset"`
+	TimeInSong  float64 `json:"time_in_song"`
+	// TempoFactor and PitchFactor are the shift the song was matched at,
+	// as in MatchResult.
+	TempoFactor float64 `json:"tempo_factor,omitempty"`
+	PitchFactor float64 `json:"pitch_factor,omitempty"`
+}
+
+// PossibleCover is a library song whose harmony follows the query's.
+// Transpose is how many semitones the query sits above the song and Tempo
+// how many times faster it plays.
+type PossibleCover struct {
+	SongID     int     `json:"song_id"`
+	Title      string  `json:"title"`
+	Artist     string  `json:"artist"`
+	Album      string  `json:"album,omitempty"`
+	Similarity float64 `json:"similarity"`
+	Transpose  int     `json:"transpose"`
+	Tempo      float64 `json:"tempo"`
+	TimeInSong float64 `json:"time_in_song"`
+}
+
+// AccuracyStats summarises user confirmations of identification results.
+type AccuracyStats struct {
+	Reviewed  int     `json:"reviewed"`
+	Correct   int     `json:"correct"`
+	Corrected int     `json:"corrected"`
+	Rejected  int     `json:"rejected"`
+	Accuracy  float64 `json:"accuracy"`
+}
+
+// SongFilter narrows and orders a song listing. Zero values leave a field
+// unfiltered; Sort is one of SongSorts and defaults to artist then title.
+type SongFilter struct {
+	MinBPM float64
+	MaxBPM float64
+	Key    string
+	// Artist and Album match any part of the name, ignoring case, and
+	// ArtistID and AlbumID the catalog entry.
+	Artist   string
+	Album    string
+	ArtistID int
+	AlbumID  int
+	// Tag keeps the songs carrying the tag.
+	Tag string
+	// LibraryID keeps the songs of one library.
+	LibraryID int
+	// Deleted lists the deleted songs instead of the live ones.
+	Deleted bool
+	// AddedFrom and AddedTo bound the date added to [AddedFrom, AddedTo).
+	AddedFrom time.Time
+	AddedTo   time.Time
+	Sort      string
+	Desc      bool
+	// After is the cursor of the last song of the previous page, as made by
+	// ListSongPage, and Limit the most songs to return, 0 for all.
+	After string
+	Limit int
+}
+
+// SongSummary is the listing form of a song, without its fingerprint data.
+type SongSummary struct {
+	ID        int       `json:"id"`
+	Title     string    `json:"title"`
+	Artist    string    `json:"artist"`
+	Album     string    `json:"album"`
+	Duration  int       `json:"duration"`
+	BPM       float64   `json:"bpm,omitempty"`
+	Key       string    `json:"key,omitempty"`
+	DateAdded time.Time `json:"date_added"`
+	ArtistID  int       `json:"artist_id,omitempty"`
+	AlbumID   int       `json:"album_id,omitempty"`
+	LibraryID int       `json:"library_id"`
+	Tags      []string  `json:"tags,omitempty"`
+	// DeletedAt is set on deleted songs.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Segments is the number of hash segments fingerprinted.
+	Segments int `json:"segments"`
+}
+
+// SongPage is one page of a song listing. Next is the cursor of the page
+// after it, empty on the last page.
+type SongPage struct {
+	Songs []*SongSummary `json:"items"`
+	Next  string         `json:"next,omitempty"`
+}
+
+// Artist is a performer songs are credited to. Names that differ only in
+// case, accents or punctuation are the same artist, and so are the
+// Aliases of artists merged into it.
+type Artist struct {
+	ID         int      `json:"id"`
+	Name       string   `json:"name"`
+	Aliases    []string `json:"aliases,omitempty"`
+	SongCount  int      `json:"song_count"`
+	AlbumCount int      `json:"album_count"`
+}
+
+// Album is a release of one artist, shared by the songs credited to it.
+type Album struct {
+	ID        int    `json:"id"`
+	ArtistID  int    `json:"artist_id"`
+	Artist    string `json:"artist"`
+	Title     string `json:"title"`
+	SongCount int    `json:"song_count"`
+}
+
+// TagCount is a tag and the number of songs carrying it.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Songs int    `json:"songs"`
+}
+
+// Playlist is a named, ordered list of songs, in which a song may appear
+// more than once.
+type Playlist struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	LibraryID int       `json:"library_id"`
+	SongCount int       `json:"song_count"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Library is a collection of songs identified apart from every other, with
+// the number of songs in it and of identifications made against it.
+type Library struct {
+	ID              int       `json:"id"`
+	Name            string    `json:"name"`
+	SongCount       int       `json:"song_count"`
+	Identifications int       `json:"identifications"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// HistoryFilter narrows a history listing. Zero values leave a field
+// unfiltered.
+type HistoryFilter struct {
+	LibraryID     int
+	Source        string
+	SongID        int
+	Matched       *bool
+	MinConfidence float64
+	From          time.Time
+	To            time.Time
+	Limit         int
+	Offset        int
+}
+
+// Actions recorded in the audit log.
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+	AuditUndo   = "undo"
+)
+
+// AuditEntry is one change recorded in the audit log: what Actor did to
+// the Entity with EntityID, and the entity as JSON before and after. Before
+// is empty for a creation and After for a deletion.
+type AuditEntry struct {
+	ID        int             `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Entity    string          `json:"entity"`
+	EntityID  int             `json:"entity_id"`
+	LibraryID int             `json:"library_id"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	// UndoOf is the entry an undo reverted, and UndoneAt is set on an
+	// entry once it has been.
+	UndoOf   int        `json:"undo_of,omitempty"`
+	UndoneAt *time.Time `json:"undone_at,omitempty"`
+}
+
+// AuditFilter narrows an audit log listing. Zero values leave a field
+// unfiltered.
+type AuditFilter struct {
+	Actor    string
+	Action   string
+	Entity   string
+	EntityID int
+	// LibraryID keeps the changes made in one library.
+	LibraryID int
+	Undone    *bool
+	From      time.Time
+	To        time.Time
+	Limit     int
+	Offset    int
+}
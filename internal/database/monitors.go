@@ -0,0 +1,156 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+func (db *DB) AddMonitor(monitor *Monitor) error {
+	monitor.LibraryID = db.libraryOf(monitor.LibraryID)
+	id, err := db.insert(`INSERT INTO monitors (name, url, enabled, library_id) VALUES (?, ?, ?, ?)`,
+		monitor.Name, monitor.URL, monitor.Enabled, monitor.LibraryID)
+	if err != nil {
+		return err
+	}
+
+	monitor.ID = id
+	monitor.CreatedAt = time.Now().UTC()
+	return nil
+}
+
+func (db *DB) GetMonitors() ([]*Monitor, error) {
+	rows, err := db.query(`SELECT id, name, url, enabled, library_id, created_at FROM monitors ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var monitors []*Monitor
+	for rows.Next() {
+		m := &Monitor{}
+		if err := rows.Scan(&m.ID, &m.Name, &m.URL, &m.Enabled, &m.LibraryID, &m.CreatedAt); err != nil {
+			continue
+		}
+		monitors = append(monitors, m)
+	}
+
+	return monitors, nil
+}
+
+func (db *DB) GetMonitor(id int) (*Monitor, error) {
+	m := &Monitor{}
+	err := db.queryRow(`SELECT id, name, url, enabled, library_id, created_at FROM monitors WHERE id = ?`, id).
+		Scan(&m.ID, &m.Name, &m.URL, &m.Enabled, &m.LibraryID, &m.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (db *DB) SetMonitorEnabled(id int, enabled bool) error {
+	result, err := db.exec(`UPDATE monitors SET enabled = ? WHERE id = ?`, enabled, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// DeleteMonitor removes a monitor together with its airplay log.
+func (db *DB) DeleteMonitor(id int) error {
+	if _, err := db.exec(`DELETE FROM airplay WHERE monitor_id = ?`, id); err != nil {
+		return err
+	}
+	result, err := db.exec(`DELETE FROM monitors WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func (db *DB) AddAirplay(play *Airplay) error {
+	id, err := db.insert(`
+    INSERT INTO airplay (monitor_id, song_id, started_at, ended_at, confidence)
+    VALUES (?, ?, ?, ?, ?)
+    `, play.MonitorID, play.SongID, play.StartedAt.UTC(), play.EndedAt.UTC(), play.Confidence)
+	if err != nil {
+		return err
+	}
+
+	play.ID = id
+	return nil
+}
+
+// GetAirplays lists the plays of a monitor that started within [from, to),
+// newest first. A zero from or to leaves that side of the range open.
+func (db *DB) GetAirplays(monitorID int, from, to time.Time, limit, offset int) ([]*Airplay, error) {
+	if to.IsZero() {
+		to = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	rows, err := db.query(`
+    SELECT a.id, a.monitor_id, a.song_id, COALESCE(s.title, ''), COALESCE(s.artist, ''),
+           a.started_at, a.ended_at, a.confidence
+    FROM airplay a LEFT JOIN songs s ON s.id = a.song_id AND s.deleted_at IS NULL
+    WHERE a.monitor_id = ? AND a.started_at >= ? AND a.started_at < ?
+    ORDER BY a.started_at DESC
+    LIMIT ? OFFSET ?
+    `, monitorID, from.UTC(), to.UTC(), db.dialect.limit(limit), offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plays := []*Airplay{}
+	for rows.Next() {
+		p := &Airplay{}
+		err := rows.Scan(&p.ID, &p.MonitorID, &p.SongID, &p.Title, &p.Artist,
+			&p.StartedAt, &p.EndedAt, &p.Confidence)
+		if err != nil {
+			continue
+		}
+		plays = append(plays, p)
+	}
+
+	return plays, nil
+}
+
+// GetAirplayReport aggregates the plays of one monitor that started on the
+// UTC day containing day, most played songs first.
+func (db *DB) GetAirplayReport(monitorID int, day time.Time) ([]*AirplaySummary, error) {
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	plays, err := db.GetAirplays(monitorID, from, from.AddDate(0, 0, 1), -1, 0)
+	if err != nil {
+		return nil, err
+	}
+	return summariseAirplay(plays), nil
+}
+
+// summariseAirplay totals plays by song, most played first.
+func summariseAirplay(plays []*Airplay) []*AirplaySummary {
+	bySong := make(map[int]*AirplaySummary)
+	for _, p := range plays {
+		summary, ok := bySong[p.SongID]
+		if !ok {
+			summary = &AirplaySummary{SongID: p.SongID, Title: p.Title, Artist: p.Artist}
+			bySong[p.SongID] = summary
+		}
+		summary.Plays++
+		summary.Seconds += p.EndedAt.Sub(p.StartedAt).Seconds()
+	}
+
+	report := make([]*AirplaySummary, 0, len(bySong))
+	for _, summary := range bySong {
+		report = append(report, summary)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Plays != report[j].Plays {
+			return report[i].Plays > report[j].Plays
+		}
+		if report[i].Seconds != report[j].Seconds {
+			return report[i].Seconds > report[j].Seconds
+		}
+		return report[i].SongID < report[j].SongID
+	})
+
+	return report
+}
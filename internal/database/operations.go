@@ -3,29 +3,104 @@ package database
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// DB is the SQL implementation of Store, backed by SQLite or PostgreSQL.
 type DB struct {
-	conn *sql.DB
+	conn    *sql.DB
+	dialect dialect
+	// fullText is set when songs_fts is available for search.
+	fullText bool
+	// defaultLibrary is the ID of the DefaultLibrary.
+	defaultLibrary int
+
+	stmtMu sync.Mutex
+	stmts  map[string]*sql.Stmt
+
+	// writes is held shared by every write and exclusively by Restore, so
+	// that nothing is written while the database is being replaced. It is
+	// nil on the copy Restore works through.
+	writes *sync.RWMutex
+
+	// tx is set on the copy of a DB that inTx hands out, whose statements
+	// all run in that transaction; parent is the DB a copy was made from.
+	tx     *sql.Tx
+	parent *DB
 }
 
+// Initialize opens the SQLite database at dbPath with DefaultOptions.
 func Initialize(dbPath string) (*DB, error) {
-	conn, err := sql.Open("sqlite3", dbPath)
+	return InitializeWith(dbPath, DefaultOptions())
+}
+
+// InitializeWith opens the SQLite database at dbPath, checks that it can be
+// reached and is intact, and creates or upgrades its schema.
+func InitializeWith(dbPath string, opts Options) (*DB, error) {
+	dsn, err := sqliteDSN(dbPath, opts)
 	if err != nil {
 		return nil, err
 	}
+	conn, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	applyPool(conn, opts)
 
-	db := &DB{conn: conn}
-
-	if err := db.createTables(); err != nil {
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := integrityCheck(conn, opts.IntegrityCheck); err != nil {
+		conn.Close()
 		return nil, err
 	}
 
+	db := &DB{conn: conn, writes: new(sync.RWMutex)}
+	if err := db.migrate(); err != nil {
+		conn.Close()
+		return nil, err
+	}
 	return db, nil
 }
 
+// migrate creates the SQLite schema or brings an existing one up to date.
+func (db *DB) migrate() error {
+	if err := db.createTables(); err != nil {
+		return err
+	}
+
+	if err := db.addColumns(); err != nil {
+		return err
+	}
+
+	if err := db.createLibraryTables(); err != nil {
+		return err
+	}
+
+	if err := db.createCatalogTables(); err != nil {
+		return err
+	}
+
+	if err := db.createAuditTables(); err != nil {
+		return err
+	}
+
+	if err := db.linkSongs(); err != nil {
+		return err
+	}
+
+	if err := db.compactHashSegments(); err != nil {
+		return err
+	}
+
+	return db.createSearchIndex()
+}
+
 func (db *DB) createTables() error {
 	query := `
     CREATE TABLE IF NOT EXISTS songs (
@@ -35,116 +110,381 @@ func (db *DB) createTables() error {
         album TEXT,
         duration INTEGER,
         fingerprint TEXT NOT NULL,
-        hash_segments TEXT NOT NULL,
+        hash_segments BLOB NOT NULL,
         date_added DATETIME DEFAULT CURRENT_TIMESTAMP
     );
 
     CREATE INDEX IF NOT EXISTS idx_artist ON songs(artist);
     CREATE INDEX IF NOT EXISTS idx_title ON songs(title);
     CREATE INDEX IF NOT EXISTS idx_fingerprint ON songs(fingerprint);
+
+    CREATE TABLE IF NOT EXISTS monitors (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT NOT NULL,
+        url TEXT NOT NULL,
+        enabled BOOLEAN NOT NULL DEFAULT 1,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    );
+
+    CREATE TABLE IF NOT EXISTS airplay (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        monitor_id INTEGER NOT NULL,
+        song_id INTEGER NOT NULL,
+        started_at DATETIME NOT NULL,
+        ended_at DATETIME NOT NULL,
+        confidence REAL NOT NULL
+    );
+
+    CREATE INDEX IF NOT EXISTS idx_airplay_monitor ON airplay(monitor_id, started_at);
+
+    CREATE TABLE IF NOT EXISTS identifications (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        created_at DATETIME NOT NULL,
+        source TEXT NOT NULL,
+        duration REAL NOT NULL,
+        song_id INTEGER,
+        is_match BOOLEAN NOT NULL,
+        confidence REAL NOT NULL,
+        time_in_song REAL NOT NULL,
+        latency_ms INTEGER NOT NULL,
+        candidates TEXT NOT NULL
+    );
+
+    CREATE INDEX IF NOT EXISTS idx_identifications_created ON identifications(created_at);
+    CREATE INDEX IF NOT EXISTS idx_identifications_song ON identifications(song_id);
+
+    CREATE TABLE IF NOT EXISTS song_tags (
+        song_id INTEGER NOT NULL,
+        tag TEXT NOT NULL,
+        PRIMARY KEY (song_id, tag)
+    );
+
+    CREATE INDEX IF NOT EXISTS idx_song_tags_tag ON song_tags(tag);
+
+    CREATE TABLE IF NOT EXISTS playlists (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    );
+
+    CREATE TABLE IF NOT EXISTS playlist_songs (
+        playlist_id INTEGER NOT NULL,
+        position INTEGER NOT NULL,
+        song_id INTEGER NOT NULL,
+        PRIMARY KEY (playlist_id, position)
+    );
+
+    CREATE INDEX IF NOT EXISTS idx_playlist_songs_song ON playlist_songs(song_id);
     `
 
-	_, err := db.conn.Exec(query)
+	_, err := db.exec(query)
 	return err
 }
 
-func (db *DB) AddSong(song *Song) error {
-	hashSegmentsJSON, err := json.Marshal(song.HashSegments)
+// columnUpgrades lists columns added to existing tables after their first
+// release. addColumns creates any that an older database is missing.
+var columnUpgrades = []struct {
+	table, column, definition string
+}{
+	{"identifications", "confirmed_song_id", "INTEGER"},
+	{"identifications", "confirmed_at", "DATETIME"},
+	{"songs", "chroma", "BLOB"},
+	{"identifications", "covers", "TEXT"},
+	{"songs", "bpm", "REAL"},
+	{"songs", "musical_key", "TEXT"},
+	{"identifications", "query_hashes", "BLOB"},
+	{"songs", "artist_id", "INTEGER"},
+	{"songs", "album_id", "INTEGER"},
+	{"songs", "library_id", "INTEGER"},
+	{"identifications", "library_id", "INTEGER"},
+	{"monitors", "library_id", "INTEGER"},
+	{"songs", "deleted_at", "DATETIME"},
+	{"songs", "hash_offset", "INTEGER"},
+	{"songs", "fingerprint_version", "INTEGER"},
+	{"playlists", "library_id", "INTEGER"},
+}
+
+func (db *DB) addColumns() error {
+	for _, up := range columnUpgrades {
+		exists, err := db.hasColumn(up.table, up.column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		_, err = db.exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", up.table, up.column, up.definition))
+		if err != nil {
+			return fmt.Errorf("adding %s.%s: %v", up.table, up.column, err)
+		}
+	}
+	return nil
+}
+
+func (db *DB) hasColumn(table, column string) (bool, error) {
+	rows, err := db.query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// compactHashSegments rewrites rows still holding the legacy JSON array of
+// hex strings into the packed binary encoding.
+func (db *DB) compactHashSegments() error {
+	rows, err := db.query(`SELECT id, hash_segments FROM songs WHERE typeof(hash_segments) = 'text'`)
 	if err != nil {
 		return err
 	}
 
+	legacy := make(map[int]HashSegments)
+	for rows.Next() {
+		var id int
+		var hashSegmentsJSON string
+		if err := rows.Scan(&id, &hashSegmentsJSON); err != nil {
+			rows.Close()
+			return err
+		}
+		var segments HashSegments
+		if err := json.Unmarshal([]byte(hashSegmentsJSON), &segments); err != nil {
+			rows.Close()
+			return fmt.Errorf("song %d: %v", id, err)
+		}
+		legacy[id] = segments
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, segments := range legacy {
+		_, err := db.exec(`UPDATE songs SET hash_segments = ? WHERE id = ?`,
+			encodeHashSegments(segments), id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddSong stores a new song in its library, crediting it to the catalog's
+// artist and album of its names.
+func (db *DB) AddSong(song *Song) error {
+	return db.inTx(func(tx *DB) error { return tx.addSong(song) })
+}
+
+func (db *DB) addSong(song *Song) error {
 	query := `
-    INSERT INTO songs (title, artist, album, duration, fingerprint, hash_segments)
-    VALUES (?, ?, ?, ?, ?, ?)
+    INSERT INTO songs (title, artist, album, duration, fingerprint, hash_segments, chroma, bpm, musical_key,
+        artist_id, album_id, library_id, hash_offset, fingerprint_version)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
     `
 
-	result, err := db.conn.Exec(query, song.Title, song.Artist, song.Album,
-		song.Duration, song.Fingerprint, string(hashSegmentsJSON))
-	if err != nil {
+	if err := db.linkCatalog(song); err != nil {
 		return err
 	}
-
-	id, err := result.LastInsertId()
+	song.LibraryID = db.libraryOf(song.LibraryID)
+	bpm, key := analysisValues(song.BPM, song.Key)
+	id, err := db.insert(query, song.Title, song.Artist, song.Album,
+		song.Duration, song.Fingerprint, encodeHashSegments(song.HashSegments), encodeChroma(song.Chroma),
+		bpm, key, song.ArtistID, nullID(song.AlbumID), song.LibraryID, song.HashOffset, song.FingerprintVersion)
 	if err != nil {
 		return err
 	}
 
-	song.ID = int(id)
+	song.ID = id
 	return nil
 }
 
+// songColumns selects what scanSongs reads.
+const songColumns = `
+    SELECT id, title, artist, album, duration, fingerprint, hash_segments, chroma,
+        COALESCE(bpm, 0), COALESCE(musical_key, ''), date_added, COALESCE(artist_id, 0), COALESCE(album_id, 0),
+        library_id, deleted_at, COALESCE(hash_offset, 0), COALESCE(fingerprint_version, 0)
+    FROM songs`
+
+// liveSongs keeps the songs that are not deleted.
+const liveSongs = `songs.deleted_at IS NULL`
+
 func (db *DB) GetAllSongs() ([]*Song, error) {
-	query := `
-    SELECT id, title, artist, album, duration, fingerprint, hash_segments, date_added
-    FROM songs ORDER BY artist, title
-    `
+	rows, err := db.query(songColumns + ` WHERE ` + liveSongs + ` ORDER BY artist, title`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSongs(rows), nil
+}
 
-	rows, err := db.conn.Query(query)
+func (db *DB) GetSong(id int) (*Song, error) {
+	stmt, err := db.prepared(songColumns + ` WHERE id = ? AND ` + liveSongs)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.Query(id)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var songs []*Song
-	for rows.Next() {
-		song := &Song{}
-		var hashSegmentsJSON string
-		var dateAdded string
+	songs := scanSongs(rows)
+	if len(songs) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return songs[0], nil
+}
 
-		err := rows.Scan(&song.ID, &song.Title, &song.Artist, &song.Album,
-			&song.Duration, &song.Fingerprint, &hashSegmentsJSON, &dateAdded)
-		if err != nil {
-			continue
-		}
+// UpdateSong rewrites the descriptive metadata of a live song, relinking
+// it to the catalog; fingerprint data is left untouched.
+func (db *DB) UpdateSong(song *Song) error {
+	return db.inTx(func(tx *DB) error { return tx.updateSong(song) })
+}
 
-		json.Unmarshal([]byte(hashSegmentsJSON), &song.HashSegments)
-		songs = append(songs, song)
+func (db *DB) updateSong(song *Song) error {
+	if err := db.linkCatalog(song); err != nil {
+		return err
+	}
+	result, err := db.exec(`UPDATE songs SET title = ?, artist = ?, album = ?, artist_id = ?, album_id = ? WHERE id = ? AND `+liveSongs,
+		song.Title, song.Artist, song.Album, song.ArtistID, nullID(song.AlbumID), song.ID)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(result); err != nil {
+		return err
+	}
+	return db.pruneCatalog()
+}
+
+// SetSongAnalysis stores the estimated tempo and key of an existing song.
+func (db *DB) SetSongAnalysis(id int, bpm float64, key string) error {
+	bpmValue, keyValue := analysisValues(bpm, key)
+	result, err := db.exec(`UPDATE songs SET bpm = ?, musical_key = ? WHERE id = ?`, bpmValue, keyValue, id)
+	if err != nil {
+		return err
 	}
+	return requireRow(result)
+}
 
-	return songs, nil
+// analysisValues stores a failed estimate as NULL so that it sorts with
+// the songs never analysed.
+func analysisValues(bpm float64, key string) (sql.NullFloat64, sql.NullString) {
+	return sql.NullFloat64{Float64: bpm, Valid: bpm > 0}, sql.NullString{String: key, Valid: key != ""}
 }
 
-func (db *DB) SearchSongs(query string) ([]*Song, error) {
-	searchQuery := `
-    SELECT id, title, artist, album, duration, fingerprint, hash_segments, date_added
-    FROM songs 
-    WHERE title LIKE ? OR artist LIKE ?
-    ORDER BY artist, title
-    `
+// DeleteSong marks a live song deleted. Its row, tags and playlist entries
+// are kept for RestoreSong, but it is left out of everything else.
+func (db *DB) DeleteSong(id int) error {
+	return db.inTx(func(tx *DB) error { return tx.deleteSong(id) })
+}
 
-	searchTerm := "%" + query + "%"
-	rows, err := db.conn.Query(searchQuery, searchTerm, searchTerm)
+func (db *DB) deleteSong(id int) error {
+	result, err := db.exec(`UPDATE songs SET deleted_at = ? WHERE id = ? AND `+liveSongs,
+		time.Now().UTC(), id)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	if err := requireRow(result); err != nil {
+		return err
+	}
+	return db.pruneCatalog()
+}
+
+// RestoreSong brings a deleted song back, crediting it to the catalog
+// again as its artist and album may have been pruned meanwhile.
+func (db *DB) RestoreSong(id int) error {
+	return db.inTx(func(tx *DB) error { return tx.restoreSong(id) })
+}
+
+func (db *DB) restoreSong(id int) error {
+	result, err := db.exec(`UPDATE songs SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(result); err != nil {
+		return err
+	}
+	song, err := db.GetSong(id)
+	if err != nil {
+		return err
+	}
+	return db.updateSong(song)
+}
 
+// requireRow reports sql.ErrNoRows when a statement affected nothing.
+func requireRow(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func scanSongs(rows *sql.Rows) []*Song {
 	var songs []*Song
 	for rows.Next() {
 		song := &Song{}
-		var hashSegmentsJSON string
-		var dateAdded string
+		var hashSegments, chroma []byte
+		var dateAdded, deletedAt sql.NullTime
 
 		err := rows.Scan(&song.ID, &song.Title, &song.Artist, &song.Album,
-			&song.Duration, &song.Fingerprint, &hashSegmentsJSON, &dateAdded)
+			&song.Duration, &song.Fingerprint, &hashSegments, &chroma, &song.BPM, &song.Key, &dateAdded,
+			&song.ArtistID, &song.AlbumID, &song.LibraryID, &deletedAt, &song.HashOffset, &song.FingerprintVersion)
 		if err != nil {
 			continue
 		}
 
-		json.Unmarshal([]byte(hashSegmentsJSON), &song.HashSegments)
+		song.HashSegments, err = decodeHashSegments(hashSegments)
+		if err != nil {
+			continue
+		}
+		song.Chroma, err = decodeChroma(chroma)
+		if err != nil {
+			continue
+		}
+		song.DateAdded = dateAdded.Time
+		if deletedAt.Valid {
+			song.DeletedAt = &deletedAt.Time
+		}
 		songs = append(songs, song)
 	}
 
-	return songs, nil
+	return songs
 }
 
 func (db *DB) GetSongCount() (int, error) {
+	stmt, err := db.prepared("SELECT COUNT(*) FROM songs WHERE " + liveSongs)
+	if err != nil {
+		return 0, err
+	}
 	var count int
-	err := db.conn.QueryRow("SELECT COUNT(*) FROM songs").Scan(&count)
+	err = stmt.QueryRow().Scan(&count)
 	return count, err
 }
 
 func (db *DB) Close() error {
+	db.stmtMu.Lock()
+	for _, stmt := range db.stmts {
+		stmt.Close()
+	}
+	db.stmts = nil
+	db.stmtMu.Unlock()
+
 	return db.conn.Close()
 }
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options configures the connection to the database. The pool limits
+// apply to both backends, the rest to SQLite only.
+type Options struct {
+	// JournalMode is SQLite's journal_mode. In WAL mode matching and
+	// listings keep reading while a song or identification is written.
+	JournalMode string
+	// BusyTimeout is how long a statement waits for a lock held by
+	// another connection before failing with "database is locked".
+	BusyTimeout time.Duration
+	// Synchronous is SQLite's synchronous level. NORMAL is safe under WAL,
+	// where a power loss can only drop the last commits.
+	Synchronous string
+	// ForeignKeys makes SQLite enforce the REFERENCES clauses of the
+	// schema, as PostgreSQL always does.
+	ForeignKeys bool
+	// IntegrityCheck is run when the database is opened: "quick" for
+	// SQLite's quick_check, "full" for integrity_check, which also
+	// verifies every index against its table but reads much longer, or
+	// "off".
+	IntegrityCheck string
+
+	// MaxOpenConns and MaxIdleConns limit the connection pool, 0 leaving
+	// the database/sql default.
+	MaxOpenConns int
+	MaxIdleConns int
+	// ConnMaxLifetime closes connections after this long, 0 for never.
+	ConnMaxLifetime time.Duration
+}
+
+func DefaultOptions() Options {
+	return Options{
+		JournalMode:    "WAL",
+		BusyTimeout:    5 * time.Second,
+		Synchronous:    "NORMAL",
+		ForeignKeys:    true,
+		IntegrityCheck: "quick",
+		MaxOpenConns:   8,
+		MaxIdleConns:   8,
+	}
+}
+
+// sqliteDSN adds the options to the connection string of the SQLite file
+// at path. Parameters path already carries, such as _journal_mode=DELETE
+// in a sqlite:// DATABASE_URL, take precedence.
+func sqliteDSN(path string, opts Options) (string, error) {
+	file, query, _ := strings.Cut(path, "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", fmt.Errorf("invalid database parameters: %w", err)
+	}
+
+	set := func(key, value string) {
+		if value != "" && !params.Has(key) {
+			params.Set(key, value)
+		}
+	}
+	set("_journal_mode", opts.JournalMode)
+	if opts.BusyTimeout > 0 {
+		set("_busy_timeout", strconv.FormatInt(opts.BusyTimeout.Milliseconds(), 10))
+	}
+	set("_synchronous", opts.Synchronous)
+	if opts.ForeignKeys {
+		set("_foreign_keys", "1")
+	}
+
+	if len(params) == 0 {
+		return file, nil
+	}
+	return file + "?" + params.Encode(), nil
+}
+
+// applyPool sets the connection pool limits of opts on conn.
+func applyPool(conn *sql.DB, opts Options) {
+	if opts.MaxOpenConns > 0 {
+		conn.SetMaxOpenConns(opts.MaxOpenConns)
+	}
+	if opts.MaxIdleConns > 0 {
+		conn.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	if opts.ConnMaxLifetime > 0 {
+		conn.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	}
+}
+
+// integrityCheck runs SQLite's quick_check or integrity_check on conn,
+// reporting the first problem found.
+func integrityCheck(conn *sql.DB, mode string) error {
+	var pragma string
+	switch mode {
+	case "", "off":
+		return nil
+	case "quick":
+		pragma = "PRAGMA quick_check(1)"
+	case "full":
+		pragma = "PRAGMA integrity_check(1)"
+	default:
+		return fmt.Errorf("unknown integrity check %q", mode)
+	}
+
+	var result string
+	if err := conn.QueryRow(pragma).Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+	return nil
+}
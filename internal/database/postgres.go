@@ -0,0 +1,142 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	_ "github.com/lib/pq"
+)
+
+// OpenPostgres connects to the PostgreSQL database at url, creating the
+// schema if needed. Several servers can share one, each keeping its own
+// fingerprint index over the songs in it. Only the pool limits of opts
+// apply.
+func OpenPostgres(url string, opts Options) (*DB, error) {
+	conn, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	applyPool(conn, opts)
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	db := &DB{conn: conn, dialect: postgresDialect, writes: new(sync.RWMutex)}
+	if err := db.createPostgresTables(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// createPostgresTables mirrors the SQLite schema, upgraded columns
+// included.
+func (db *DB) createPostgresTables() error {
+	query := `
+    CREATE TABLE IF NOT EXISTS songs (
+        id BIGSERIAL PRIMARY KEY,
+        title TEXT NOT NULL,
+        artist TEXT NOT NULL,
+        album TEXT,
+        duration INTEGER,
+        fingerprint TEXT NOT NULL,
+        hash_segments BYTEA NOT NULL,
+        date_added TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+    );
+
+    CREATE INDEX IF NOT EXISTS idx_artist ON songs(artist);
+    CREATE INDEX IF NOT EXISTS idx_title ON songs(title);
+    CREATE INDEX IF NOT EXISTS idx_fingerprint ON songs(fingerprint);
+
+    CREATE TABLE IF NOT EXISTS monitors (
+        id BIGSERIAL PRIMARY KEY,
+        name TEXT NOT NULL,
+        url TEXT NOT NULL,
+        enabled BOOLEAN NOT NULL DEFAULT TRUE,
+        created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+    );
+
+    CREATE TABLE IF NOT EXISTS airplay (
+        id BIGSERIAL PRIMARY KEY,
+        monitor_id BIGINT NOT NULL,
+        song_id BIGINT NOT NULL,
+        started_at TIMESTAMPTZ NOT NULL,
+        ended_at TIMESTAMPTZ NOT NULL,
+        confidence DOUBLE PRECISION NOT NULL
+    );
+
+    CREATE INDEX IF NOT EXISTS idx_airplay_monitor ON airplay(monitor_id, started_at);
+
+    CREATE TABLE IF NOT EXISTS identifications (
+        id BIGSERIAL PRIMARY KEY,
+        created_at TIMESTAMPTZ NOT NULL,
+        source TEXT NOT NULL,
+        duration DOUBLE PRECISION NOT NULL,
+        song_id BIGINT,
+        is_match BOOLEAN NOT NULL,
+        confidence DOUBLE PRECISION NOT NULL,
+        time_in_song DOUBLE PRECISION NOT NULL,
+        latency_ms BIGINT NOT NULL,
+        candidates TEXT NOT NULL
+    );
+
+    CREATE INDEX IF NOT EXISTS idx_identifications_created ON identifications(created_at);
+    CREATE INDEX IF NOT EXISTS idx_identifications_song ON identifications(song_id);
+
+    CREATE TABLE IF NOT EXISTS song_tags (
+        song_id BIGINT NOT NULL,
+        tag TEXT NOT NULL,
+        PRIMARY KEY (song_id, tag)
+    );
+
+    CREATE INDEX IF NOT EXISTS idx_song_tags_tag ON song_tags(tag);
+
+    CREATE TABLE IF NOT EXISTS playlists (
+        id BIGSERIAL PRIMARY KEY,
+        name TEXT NOT NULL,
+        created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+    );
+
+    CREATE TABLE IF NOT EXISTS playlist_songs (
+        playlist_id BIGINT NOT NULL,
+        position INTEGER NOT NULL,
+        song_id BIGINT NOT NULL,
+        PRIMARY KEY (playlist_id, position)
+    );
+
+    CREATE INDEX IF NOT EXISTS idx_playlist_songs_song ON playlist_songs(song_id);
+    `
+	if _, err := db.exec(query); err != nil {
+		return err
+	}
+
+	for _, up := range columnUpgrades {
+		_, err := db.exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s",
+			up.table, up.column, postgresTypes[up.definition]))
+		if err != nil {
+			return fmt.Errorf("adding %s.%s: %v", up.table, up.column, err)
+		}
+	}
+
+	if err := db.createLibraryTables(); err != nil {
+		return err
+	}
+	if err := db.createCatalogTables(); err != nil {
+		return err
+	}
+	if err := db.createAuditTables(); err != nil {
+		return err
+	}
+	return db.linkSongs()
+}
+
+// postgresTypes translates the SQLite column types of columnUpgrades.
+var postgresTypes = map[string]string{
+	"INTEGER":  "BIGINT",
+	"REAL":     "DOUBLE PRECISION",
+	"TEXT":     "TEXT",
+	"BLOB":     "BYTEA",
+	"DATETIME": "TIMESTAMPTZ",
+}
@@ -0,0 +1,188 @@
+package database
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Song search matches every word of a query against the start of a word in
+// the title, artist or album, ignoring case and accents, so "beyo" finds
+// "Beyoncé". When nothing matches it retries allowing a typo or two per
+// word. The SQLite store answers the first pass from a full-text index when
+// the driver is built with FTS5 (-tags sqlite_fts5); everywhere else both
+// passes run over the library in memory.
+
+// Weights of a word match in each field: a title match outranks an artist
+// match, which outranks an album match.
+const (
+	titleWeight  = 3.0
+	artistWeight = 2.0
+	albumWeight  = 1.0
+)
+
+// foldAccents strips combining marks after canonical decomposition.
+var foldAccents = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// searchTokens splits text into the lower-cased, accent-free words search
+// compares. Anything but a letter or digit separates words.
+func searchTokens(text string) []string {
+	folded, _, err := transform.String(foldAccents, text)
+	if err != nil {
+		folded = text
+	}
+	return strings.FieldsFunc(strings.ToLower(folded), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchEntry is the searchable text of one song.
+type searchEntry struct {
+	id                   int
+	title, artist, album string
+}
+
+type searchField struct {
+	words  []string
+	weight float64
+}
+
+// rankSearch returns the IDs of the entries matching the query tokens, best
+// first, retrying with typo tolerance when no entry matches exactly.
+func rankSearch(tokens []string, entries []searchEntry) []int {
+	if len(tokens) == 0 {
+		return nil
+	}
+	ids := rankEntries(tokens, entries, prefixScore)
+	if len(ids) == 0 {
+		ids = rankEntries(tokens, entries, fuzzyScore)
+	}
+	return ids
+}
+
+func rankEntries(tokens []string, entries []searchEntry, score func(token, word string) float64) []int {
+	type hit struct {
+		entry *searchEntry
+		score float64
+	}
+	var hits []hit
+
+	for i := range entries {
+		e := &entries[i]
+		fields := []searchField{
+			{searchTokens(e.title), titleWeight},
+			{searchTokens(e.artist), artistWeight},
+			{searchTokens(e.album), albumWeight},
+		}
+
+		total := 0.0
+		for _, token := range tokens {
+			best := 0.0
+			for _, f := range fields {
+				for _, word := range f.words {
+					best = max(best, f.weight*score(token, word))
+				}
+			}
+			if best == 0 {
+				total = 0
+				break
+			}
+			total += best
+		}
+		if total > 0 {
+			hits = append(hits, hit{e, total})
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if a.entry.artist != b.entry.artist {
+			return a.entry.artist < b.entry.artist
+		}
+		if a.entry.title != b.entry.title {
+			return a.entry.title < b.entry.title
+		}
+		return a.entry.id < b.entry.id
+	})
+
+	ids := make([]int, len(hits))
+	for i, h := range hits {
+		ids[i] = h.entry.id
+	}
+	return ids
+}
+
+// prefixScore is 1 for a whole word match, a little less for a word that
+// only starts with token, and 0 otherwise.
+func prefixScore(token, word string) float64 {
+	switch {
+	case token == word:
+		return 1
+	case strings.HasPrefix(word, token):
+		return 0.8
+	}
+	return 0
+}
+
+// fuzzyScore also accepts words, or beginnings of words, within a typo of
+// token for tokens of four letters or more and within two from eight,
+// scoring them below exact matches and closer ones higher.
+func fuzzyScore(token, word string) float64 {
+	if s := prefixScore(token, word); s > 0 {
+		return s
+	}
+
+	t := []rune(token)
+	allowed := 0
+	switch {
+	case len(t) >= 8:
+		allowed = 2
+	case len(t) >= 4:
+		allowed = 1
+	}
+
+	w := []rune(word)
+	d := editDistance(t, w)
+	if len(w) > len(t) {
+		d = min(d, editDistance(t, w[:len(t)]))
+	}
+	if d > allowed {
+		return 0
+	}
+	return 0.6 * (1 - float64(d)/float64(len(t)+1))
+}
+
+// editDistance is the optimal string alignment distance between a and b:
+// the insertions, deletions, substitutions and swaps of adjacent letters
+// needed to turn one into the other.
+func editDistance(a, b []rune) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,338 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SongSorts maps the sort names accepted by ListSongs to their columns.
+// Songs missing the value sort last, and every order falls back to artist,
+// title and ID to stay stable. Songs are numbered in the order they are
+// added, so the date added sorts by ID, which unlike the stored timestamp
+// never ties. A NULL album or duration, left by older rows, sorts as the
+// empty album or zero duration it is read back as, so that cursors, which
+// only see the value read back, can resume after it.
+var SongSorts = map[string]string{
+	"title":      "title",
+	"artist":     "artist",
+	"album":      "COALESCE(album, '')",
+	"duration":   "COALESCE(duration, 0)",
+	"bpm":        "bpm",
+	"key":        "musical_key",
+	"date_added": "id",
+}
+
+// ErrInvalidCursor reports a SongFilter.After that was not made by
+// ListSongPage for the same sort.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListSongPage returns a page of up to filter.Limit songs, or all of them
+// when it is 0, with the cursor that continues after it.
+func ListSongPage(store SongStore, filter SongFilter) (*SongPage, error) {
+	limit := filter.Limit
+	if limit > 0 {
+		// One more than asked for tells whether there is a next page.
+		filter.Limit = limit + 1
+	}
+	songs, err := store.ListSongSummaries(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &SongPage{Songs: songs}
+	if limit > 0 && len(songs) > limit {
+		page.Songs = songs[:limit]
+		page.Next = newSongCursor(filter, songs[limit-1]).encode()
+	}
+	if page.Songs == nil {
+		page.Songs = []*SongSummary{}
+	}
+	return page, nil
+}
+
+// songCursor holds the sort values of the last song of a page. Number or
+// Text is the value of the sort column, unless Null says it is missing.
+type songCursor struct {
+	Sort   string  `json:"s,omitempty"`
+	Desc   bool    `json:"d,omitempty"`
+	Null   bool    `json:"n,omitempty"`
+	Number float64 `json:"f,omitempty"`
+	Text   string  `json:"x,omitempty"`
+	Artist string  `json:"a"`
+	Title  string  `json:"t"`
+	ID     int     `json:"i"`
+}
+
+func newSongCursor(filter SongFilter, last *SongSummary) *songCursor {
+	c := &songCursor{Sort: filter.Sort, Desc: filter.Desc, Artist: last.Artist, Title: last.Title, ID: last.ID}
+	switch filter.Sort {
+	case "title":
+		c.Text = last.Title
+	case "artist":
+		c.Text = last.Artist
+	case "album":
+		c.Text = last.Album
+	case "duration":
+		c.Number = float64(last.Duration)
+	case "bpm":
+		c.Number, c.Null = last.BPM, last.BPM <= 0
+	case "key":
+		c.Text, c.Null = last.Key, last.Key == ""
+	}
+	return c
+}
+
+func (c *songCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeSongCursor reads filter.After, returning nil when it is empty.
+func decodeSongCursor(filter SongFilter) (*songCursor, error) {
+	if filter.After == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(filter.After)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &songCursor{}
+	if err := json.Unmarshal(data, c); err != nil || c.Sort != filter.Sort || c.Desc != filter.Desc {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// value is the sort column value of the cursor as a query argument.
+func (c *songCursor) value() interface{} {
+	switch c.Sort {
+	case "duration", "bpm":
+		return c.Number
+	case "date_added":
+		return c.ID
+	}
+	return c.Text
+}
+
+// song is a stand-in for the last song of the page, carrying just the
+// values it was sorted by.
+func (c *songCursor) song() *Song {
+	song := &Song{ID: c.ID, Title: c.Title, Artist: c.Artist}
+	switch c.Sort {
+	case "title":
+		song.Title = c.Text
+	case "artist":
+		song.Artist = c.Text
+	case "album":
+		song.Album = c.Text
+	case "duration":
+		song.Duration = int(c.Number)
+	case "bpm":
+		song.BPM = c.Number
+	case "key":
+		song.Key = c.Text
+	}
+	return song
+}
+
+// sortKey is one term of an ORDER BY and the cursor's value for it.
+type sortKey struct {
+	expr  string
+	value interface{}
+	desc  bool
+}
+
+// keysetCondition selects the rows that sort after the values in keys.
+func keysetCondition(keys []sortKey) (string, []interface{}) {
+	k := keys[0]
+	op := " > ?"
+	if k.desc {
+		op = " < ?"
+	}
+	if len(keys) == 1 {
+		return k.expr + op, []interface{}{k.value}
+	}
+	rest, args := keysetCondition(keys[1:])
+	return "(" + k.expr + op + " OR (" + k.expr + " = ? AND " + rest + "))",
+		append([]interface{}{k.value, k.value}, args...)
+}
+
+// ListSongs returns the songs matching filter in the order it asks for.
+func (db *DB) ListSongs(filter SongFilter) ([]*Song, error) {
+	rows, err := db.listSongs(songColumns, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSongs(rows), nil
+}
+
+// songSummaryColumns selects what scanSongSummaries reads, counting the
+// hash segments without loading them.
+const songSummaryColumns = `
+    SELECT id, title, artist, COALESCE(album, ''), COALESCE(duration, 0), COALESCE(bpm, 0),
+        COALESCE(musical_key, ''), date_added, COALESCE(artist_id, 0), COALESCE(album_id, 0),
+        library_id, LENGTH(hash_segments) / 8, deleted_at
+    FROM songs`
+
+// ListSongSummaries lists songs as ListSongs does, without their
+// fingerprint data.
+func (db *DB) ListSongSummaries(filter SongFilter) ([]*SongSummary, error) {
+	rows, err := db.listSongs(songSummaryColumns, filter)
+	if err != nil {
+		return nil, err
+	}
+	songs, err := scanSongSummaries(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return songs, db.tagSummaries(songs)
+}
+
+func scanSongSummaries(rows *sql.Rows) ([]*SongSummary, error) {
+	songs := []*SongSummary{}
+	for rows.Next() {
+		s := &SongSummary{}
+		var dateAdded, deletedAt sql.NullTime
+		err := rows.Scan(&s.ID, &s.Title, &s.Artist, &s.Album, &s.Duration, &s.BPM,
+			&s.Key, &dateAdded, &s.ArtistID, &s.AlbumID, &s.LibraryID, &s.Segments, &deletedAt)
+		if err != nil {
+			return nil, err
+		}
+		s.DateAdded = dateAdded.Time
+		if deletedAt.Valid {
+			s.DeletedAt = &deletedAt.Time
+		}
+		songs = append(songs, s)
+	}
+	return songs, rows.Err()
+}
+
+func (db *DB) listSongs(columns string, filter SongFilter) (*sql.Rows, error) {
+	where := []string{liveSongs}
+	if filter.Deleted {
+		where[0] = `songs.deleted_at IS NOT NULL`
+	}
+	var args []interface{}
+
+	if filter.MinBPM > 0 {
+		where = append(where, "bpm >= ?")
+		args = append(args, filter.MinBPM)
+	}
+	if filter.MaxBPM > 0 {
+		where = append(where, "bpm <= ?")
+		args = append(args, filter.MaxBPM)
+	}
+	if filter.Key != "" {
+		where = append(where, "LOWER(musical_key) = LOWER(?)")
+		args = append(args, filter.Key)
+	}
+	if filter.Artist != "" {
+		where = append(where, `LOWER(artist) LIKE LOWER(?) ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(filter.Artist)+"%")
+	}
+	if filter.Album != "" {
+		where = append(where, `LOWER(album) LIKE LOWER(?) ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(filter.Album)+"%")
+	}
+	if filter.ArtistID != 0 {
+		where = append(where, "artist_id = ?")
+		args = append(args, filter.ArtistID)
+	}
+	if filter.AlbumID != 0 {
+		where = append(where, "album_id = ?")
+		args = append(args, filter.AlbumID)
+	}
+	if filter.LibraryID != 0 {
+		where = append(where, "library_id = ?")
+		args = append(args, filter.LibraryID)
+	}
+	if filter.Tag != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM song_tags WHERE song_tags.song_id = songs.id AND song_tags.tag = ?)")
+		args = append(args, normalizeTag(filter.Tag))
+	}
+	if !filter.AddedFrom.IsZero() {
+		where = append(where, "date_added >= ?")
+		args = append(args, db.dialect.timestamp(filter.AddedFrom))
+	}
+	if !filter.AddedTo.IsZero() {
+		where = append(where, "date_added < ?")
+		args = append(args, db.dialect.timestamp(filter.AddedTo))
+	}
+
+	column := ""
+	if filter.Sort != "" {
+		var ok bool
+		if column, ok = SongSorts[filter.Sort]; !ok {
+			return nil, fmt.Errorf("unknown sort %q", filter.Sort)
+		}
+	}
+
+	cursor, err := decodeSongCursor(filter)
+	if err != nil {
+		return nil, err
+	}
+	if cursor != nil {
+		var keys []sortKey
+		if column != "" {
+			keys = append(keys, sortKey{"(" + column + " IS NULL)", cursor.Null, false})
+			if !cursor.Null {
+				keys = append(keys, sortKey{column, cursor.value(), filter.Desc})
+			}
+		}
+		keys = append(keys,
+			sortKey{"artist", cursor.Artist, false},
+			sortKey{"title", cursor.Title, false},
+			sortKey{"id", cursor.ID, false})
+		condition, keyArgs := keysetCondition(keys)
+		where = append(where, condition)
+		args = append(args, keyArgs...)
+	}
+
+	clause := " WHERE " + strings.Join(where, " AND ")
+
+	order := "artist, title, id"
+	if column != "" {
+		direction := " ASC"
+		if filter.Desc {
+			direction = " DESC"
+		}
+		order = column + " IS NULL, " + column + direction + ", " + order
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	return db.query(columns+clause+` ORDER BY `+order+` LIMIT ?`, append(args, db.dialect.limit(limit))...)
+}
+
+// escapeLike quotes the LIKE wildcards in s with backslashes.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// summarizeSong drops the fingerprint data of a song.
+func summarizeSong(song *Song) *SongSummary {
+	return &SongSummary{
+		ID:        song.ID,
+		Title:     song.Title,
+		Artist:    song.Artist,
+		Album:     song.Album,
+		Duration:  song.Duration,
+		BPM:       song.BPM,
+		Key:       song.Key,
+		DateAdded: song.DateAdded,
+		ArtistID:  song.ArtistID,
+		AlbumID:   song.AlbumID,
+		LibraryID: song.LibraryID,
+		Segments:  len(song.HashSegments),
+		DeletedAt: song.DeletedAt,
+	}
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"strings"
+	"time"
+)
+
+// SongStore keeps the song library together with each song's fingerprint,
+// hash segments, chroma and analysis. Deleting a song only marks it
+// deleted: every other method but RestoreSong and a SongFilter asking for
+// Deleted songs treats it as missing.
+type SongStore interface {
+	AddSong(song *Song) error
+	GetAllSongs() ([]*Song, error)
+	ListSongs(filter SongFilter) ([]*Song, error)
+	ListSongSummaries(filter SongFilter) ([]*SongSummary, error)
+	GetSong(id int) (*Song, error)
+	UpdateSong(song *Song) error
+	SetSongChroma(id int, seq ChromaSequence) error
+	SetSongFingerprint(id int, fingerprint string, segments HashSegments, offset, version int) error
+	SetSongAnalysis(id int, bpm float64, key string) error
+	DeleteSong(id int) error
+	RestoreSong(id int) error
+	SearchSongs(query string, library, limit, offset int) ([]*Song, int, error)
+	GetSongCount() (int, error)
+}
+
+// CatalogStore keeps the artists and albums songs are credited to. Adding
+// or updating a song links it to them, creating them as needed, and an
+// artist or album goes once its last song does. The catalog is shared by
+// every library, but an artist or album is only found in, and counts the
+// songs of, the libraries its songs are in.
+type CatalogStore interface {
+	GetArtists(name string, library, limit, offset int) ([]*Artist, int, error)
+	GetArtist(id, library int) (*Artist, error)
+	GetArtistAlbums(id, library int) ([]*Album, error)
+	GetAlbum(id, library int) (*Album, error)
+	MergeArtists(id, into, library int) error
+}
+
+// CollectionStore keeps the tags on songs and the playlists gathering
+// them. Tags are stored trimmed and lower-cased, and a deleted song is
+// left off every tag and playlist until it is restored. Setting the tags or
+// entries of a missing song or playlist reports sql.ErrNoRows. A playlist
+// belongs to one library and only holds songs of it; adding a song of
+// another library reports ErrOtherLibrary.
+type CollectionStore interface {
+	GetTags(library int) ([]*TagCount, error)
+	GetSongTags(songID int) ([]string, error)
+	SetSongTags(songID int, tags []string) error
+	AddPlaylist(playlist *Playlist) error
+	GetPlaylists(library int) ([]*Playlist, error)
+	GetPlaylist(id int) (*Playlist, error)
+	RenamePlaylist(id int, name string) error
+	DeletePlaylist(id int) error
+	GetPlaylistSongs(id int) ([]*SongSummary, error)
+	SetPlaylistSongs(id int, songIDs []int) error
+	AddPlaylistSong(id, songID int) error
+}
+
+// LibraryStore keeps the libraries that songs, identifications and monitors
+// are kept apart in. A library of 0 given to a filter, search or statistic
+// means every library, and given to a row being stored means the default
+// library.
+type LibraryStore interface {
+	AddLibrary(library *Library) error
+	GetLibraries() ([]*Library, error)
+	GetLibrary(id int) (*Library, error)
+	GetLibraryByName(name string) (*Library, error)
+	DeleteLibrary(id int) error
+}
+
+// HistoryStore keeps the log of identification attempts and their review.
+type HistoryStore interface {
+	AddIdentification(ident *Identification) error
+	GetIdentification(id int) (*Identification, error)
+	GetIdentificationQuery(id int) (HashSegments, error)
+	GetIdentifications(filter HistoryFilter) ([]*Identification, int, error)
+	ConfirmIdentification(id, songID int) error
+	GetAccuracyStats(library int) (*AccuracyStats, error)
+}
+
+// MonitorStore keeps the stream monitors, the background jobs of the
+// server, and the airplay they log.
+type MonitorStore interface {
+	AddMonitor(monitor *Monitor) error
+	GetMonitors() ([]*Monitor, error)
+	GetMonitor(id int) (*Monitor, error)
+	SetMonitorEnabled(id int, enabled bool) error
+	DeleteMonitor(id int) error
+	AddAirplay(play *Airplay) error
+	GetAirplays(monitorID int, from, to time.Time, limit, offset int) ([]*Airplay, error)
+	GetAirplayReport(monitorID int, day time.Time) ([]*AirplaySummary, error)
+}
+
+// Store is everything the server persists. Lookups of a missing row report
+// sql.ErrNoRows whichever backend is behind it.
+type Store interface {
+	SongStore
+	CatalogStore
+	CollectionStore
+	LibraryStore
+	HistoryStore
+	MonitorStore
+	AuditStore
+	Close() error
+}
+
+var _ Store = (*DB)(nil)
+
+// Open connects to the database named by url, which selects the backend by
+// its scheme. An empty url opens the SQLite file at path.
+func Open(url, path string, opts Options) (Store, error) {
+	if strings.HasPrefix(url, "postgres://") || strings.HasPrefix(url, "postgresql://") {
+		return OpenPostgres(url, opts)
+	}
+	if url != "" {
+		path = strings.TrimPrefix(url, "sqlite://")
+	}
+	return InitializeWith(path, opts)
+}
@@ -0,0 +1,1429 @@
+// Package storetest checks that a database.Store behaves like the SQLite
+// store: the same IDs, ordering, filtering, search and missing-row errors.
+// A backend's tests run it with a function opening an empty store:
+//
+//	func TestMemoryStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) database.Store {
+//			return database.NewMemoryStore()
+//		})
+//	}
+//
+// Titles and artists are lower case wherever their order is checked, so it
+// holds for backends that collate text by locale rather than by byte.
+package storetest
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"Shazam/internal/database"
+)
+
+// Run runs the whole suite, opening a fresh store for every test.
+func Run(t *testing.T, open func(t *testing.T) database.Store) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s database.Store)
+	}{
+		{"SongRoundTrip", testSongRoundTrip},
+		{"SongIDs", testSongIDs},
+		{"MissingSong", testMissingSong},
+		{"SongOrder", testSongOrder},
+		{"ListSongs", testListSongs},
+		{"SongPages", testSongPages},
+		{"SongFilters", testSongFilters},
+		{"Catalog", testCatalog},
+		{"MergeArtists", testMergeArtists},
+		{"Tags", testTags},
+		{"Playlists", testPlaylists},
+		{"SearchSongs", testSearchSongs},
+		{"Libraries", testLibraries},
+		{"LibraryScopes", testLibraryScopes},
+		{"UpdateSong", testUpdateSong},
+		{"RestoreSong", testRestoreSong},
+		{"History", testHistory},
+		{"Accuracy", testAccuracy},
+		{"Monitors", testMonitors},
+		{"Airplay", testAirplay},
+		{"Audit", testAudit},
+		{"RevertSong", testRevertSong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := open(t)
+			defer s.Close()
+			tt.run(t, s)
+		})
+	}
+}
+
+func addSong(t *testing.T, s database.Store, title, artist string, bpm float64, key string) *database.Song {
+	t.Helper()
+	song := &database.Song{
+		Title:              title,
+		Artist:             artist,
+		Album:              "album of " + title,
+		Duration:           len(title) * 10,
+		Fingerprint:        "fp-" + title,
+		HashSegments:       database.HashSegments{0x89abcdef01234567, database.SilentHash, 0xfedcba9876543210},
+		Chroma:             database.ChromaSequence{{255, 0, 12}, {1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+		BPM:                bpm,
+		Key:                key,
+		HashOffset:         len(title),
+		FingerprintVersion: 1,
+	}
+	if err := s.AddSong(song); err != nil {
+		t.Fatalf("AddSong(%q): %v", title, err)
+	}
+	return song
+}
+
+// titles lists the titles of songs in order.
+func titles(songs []*database.Song) []string {
+	out := []string{}
+	for _, song := range songs {
+		out = append(out, song.Title)
+	}
+	return out
+}
+
+func wantTitles(t *testing.T, what string, songs []*database.Song, want ...string) {
+	t.Helper()
+	if got := titles(songs); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %q, want %q", what, got, want)
+	}
+}
+
+func wantNoRows(t *testing.T, what string, err error) {
+	t.Helper()
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("%s: err = %v, want sql.ErrNoRows", what, err)
+	}
+}
+
+func testSongRoundTrip(t *testing.T, s database.Store) {
+	added := addSong(t, s, "hello", "adele", 79.5, "F minor")
+	before := time.Now().UTC().Add(-2 * time.Second)
+
+	got, err := s.GetSong(added.ID)
+	if err != nil {
+		t.Fatalf("GetSong: %v", err)
+	}
+	if got.DateAdded.Before(before) || got.DateAdded.After(time.Now().Add(time.Second)) {
+		t.Errorf("DateAdded = %v, want about now", got.DateAdded)
+	}
+	got.DateAdded = time.Time{}
+	if !reflect.DeepEqual(got, added) {
+		t.Errorf("GetSong = %+v, want %+v", got, added)
+	}
+
+	if err := s.SetSongChroma(added.ID, database.ChromaSequence{{9}}); err != nil {
+		t.Fatalf("SetSongChroma: %v", err)
+	}
+	if err := s.SetSongAnalysis(added.ID, 0, ""); err != nil {
+		t.Fatalf("SetSongAnalysis: %v", err)
+	}
+	if err := s.SetSongFingerprint(added.ID, "fp-new", database.HashSegments{7, 8}, 3, 2); err != nil {
+		t.Fatalf("SetSongFingerprint: %v", err)
+	}
+	got, _ = s.GetSong(added.ID)
+	if len(got.Chroma) != 1 || got.Chroma[0][0] != 9 || got.BPM != 0 || got.Key != "" {
+		t.Errorf("after updates chroma = %v, bpm = %v, key = %q", got.Chroma, got.BPM, got.Key)
+	}
+	if got.Fingerprint != "fp-new" || !reflect.DeepEqual(got.HashSegments, database.HashSegments{7, 8}) ||
+		got.HashOffset != 3 || got.FingerprintVersion != 2 {
+		t.Errorf("after SetSongFingerprint fingerprint = %q, hashes = %v, offset = %d, version = %d",
+			got.Fingerprint, got.HashSegments, got.HashOffset, got.FingerprintVersion)
+	}
+
+	empty := &database.Song{Title: "empty", Artist: "nobody"}
+	if err := s.AddSong(empty); err != nil {
+		t.Fatalf("AddSong: %v", err)
+	}
+	got, _ = s.GetSong(empty.ID)
+	if got.HashSegments == nil || len(got.HashSegments) != 0 || got.Chroma == nil || len(got.Chroma) != 0 {
+		t.Errorf("empty song reads back hashes %#v, chroma %#v, want empty", got.HashSegments, got.Chroma)
+	}
+}
+
+func testSongIDs(t *testing.T, s database.Store) {
+	a := addSong(t, s, "a", "x", 0, "")
+	b := addSong(t, s, "b", "x", 0, "")
+	if a.ID <= 0 || b.ID <= a.ID {
+		t.Fatalf("IDs %d, %d, want positive and increasing", a.ID, b.ID)
+	}
+
+	if err := s.DeleteSong(b.ID); err != nil {
+		t.Fatalf("DeleteSong: %v", err)
+	}
+	c := addSong(t, s, "c", "x", 0, "")
+	if c.ID <= b.ID {
+		t.Errorf("ID %d reused after deleting %d", c.ID, b.ID)
+	}
+
+	count, err := s.GetSongCount()
+	if err != nil || count != 2 {
+		t.Errorf("GetSongCount = %d, %v, want 2", count, err)
+	}
+}
+
+func testMissingSong(t *testing.T, s database.Store) {
+	_, err := s.GetSong(42)
+	wantNoRows(t, "GetSong", err)
+	wantNoRows(t, "UpdateSong", s.UpdateSong(&database.Song{ID: 42, Title: "t", Artist: "a"}))
+	wantNoRows(t, "SetSongChroma", s.SetSongChroma(42, nil))
+	wantNoRows(t, "SetSongAnalysis", s.SetSongAnalysis(42, 120, "C major"))
+	wantNoRows(t, "SetSongFingerprint", s.SetSongFingerprint(42, "fp", nil, 0, 1))
+	wantNoRows(t, "DeleteSong", s.DeleteSong(42))
+	wantNoRows(t, "RestoreSong", s.RestoreSong(42))
+
+	songs, err := s.GetAllSongs()
+	if err != nil || len(songs) != 0 {
+		t.Errorf("GetAllSongs on empty store = %d songs, %v", len(songs), err)
+	}
+}
+
+func testSongOrder(t *testing.T, s database.Store) {
+	addSong(t, s, "zebra", "beta", 0, "")
+	addSong(t, s, "apple", "gamma", 0, "")
+	addSong(t, s, "mango", "alpha", 0, "")
+	addSong(t, s, "kiwi", "beta", 0, "")
+
+	songs, err := s.GetAllSongs()
+	if err != nil {
+		t.Fatalf("GetAllSongs: %v", err)
+	}
+	wantTitles(t, "GetAllSongs", songs, "mango", "kiwi", "zebra", "apple")
+}
+
+func testListSongs(t *testing.T, s database.Store) {
+	addSong(t, s, "slow", "a", 70, "A minor")
+	addSong(t, s, "fast", "b", 170, "C major")
+	addSong(t, s, "unknown", "c", 0, "")
+	addSong(t, s, "mid", "d", 120, "A minor")
+
+	cases := []struct {
+		filter database.SongFilter
+		want   []string
+	}{
+		{database.SongFilter{}, []string{"slow", "fast", "unknown", "mid"}},
+		{database.SongFilter{MinBPM: 100}, []string{"fast", "mid"}},
+		{database.SongFilter{MaxBPM: 150}, []string{"slow", "mid"}},
+		{database.SongFilter{MinBPM: 60, MaxBPM: 130}, []string{"slow", "mid"}},
+		{database.SongFilter{Key: "a MINOR"}, []string{"slow", "mid"}},
+		{database.SongFilter{Sort: "bpm"}, []string{"slow", "mid", "fast", "unknown"}},
+		{database.SongFilter{Sort: "bpm", Desc: true}, []string{"fast", "mid", "slow", "unknown"}},
+		{database.SongFilter{Sort: "key"}, []string{"slow", "mid", "fast", "unknown"}},
+		{database.SongFilter{Sort: "title", Desc: true}, []string{"unknown", "slow", "mid", "fast"}},
+		{database.SongFilter{Sort: "duration"}, []string{"mid", "slow", "fast", "unknown"}},
+	}
+	for _, c := range cases {
+		songs, err := s.ListSongs(c.filter)
+		if err != nil {
+			t.Errorf("ListSongs(%+v): %v", c.filter, err)
+			continue
+		}
+		wantTitles(t, "ListSongs", songs, c.want...)
+	}
+
+	if _, err := s.ListSongs(database.SongFilter{Sort: "loudness"}); err == nil {
+		t.Error("ListSongs with an unknown sort succeeded")
+	}
+}
+
+func testSongPages(t *testing.T, s database.Store) {
+	// Ties in every sort, missing values and exact duplicates exercise each
+	// key of the cursor.
+	addSong(t, s, "one", "b", 120, "C major")
+	addSong(t, s, "two", "a", 0, "")
+	addSong(t, s, "three", "b", 120, "C major")
+	addSong(t, s, "four", "c", 90, "")
+	addSong(t, s, "one", "b", 0, "A minor")
+	addSong(t, s, "five", "a", 90, "A minor")
+	addSong(t, s, "one", "b", 120, "C major")
+
+	sorts := []string{""}
+	for name := range database.SongSorts {
+		sorts = append(sorts, name)
+	}
+	sort.Strings(sorts)
+
+	for _, name := range sorts {
+		for _, desc := range []bool{false, true} {
+			filter := database.SongFilter{Sort: name, Desc: desc}
+			all, err := s.ListSongSummaries(filter)
+			if err != nil {
+				t.Fatalf("ListSongSummaries(%+v): %v", filter, err)
+			}
+
+			var paged []*database.SongSummary
+			for pages := 0; pages < 10; pages++ {
+				filter.Limit = 2
+				page, err := database.ListSongPage(s, filter)
+				if err != nil {
+					t.Fatalf("ListSongPage(%+v): %v", filter, err)
+				}
+				paged = append(paged, page.Songs...)
+				if page.Next == "" {
+					break
+				}
+				filter.After = page.Next
+			}
+
+			if len(all) != 7 || !reflect.DeepEqual(summaryIDs(paged), summaryIDs(all)) {
+				t.Errorf("sort %q desc %v: pages list %v, want %v", name, desc, summaryIDs(paged), summaryIDs(all))
+			}
+		}
+	}
+
+	page, err := database.ListSongPage(s, database.SongFilter{Limit: 7})
+	if err != nil || len(page.Songs) != 7 || page.Next != "" {
+		t.Errorf("exact last page = %d songs, next %q, %v", len(page.Songs), page.Next, err)
+	}
+	if page.Songs[0].Segments != 3 || page.Songs[0].DateAdded.IsZero() {
+		t.Errorf("summary = %+v", page.Songs[0])
+	}
+
+	page, _ = database.ListSongPage(s, database.SongFilter{Sort: "title", Limit: 2})
+	_, err = database.ListSongPage(s, database.SongFilter{Sort: "bpm", After: page.Next, Limit: 2})
+	if !errors.Is(err, database.ErrInvalidCursor) {
+		t.Errorf("cursor reused for another sort: err = %v", err)
+	}
+	_, err = database.ListSongPage(s, database.SongFilter{After: "not a cursor"})
+	if !errors.Is(err, database.ErrInvalidCursor) {
+		t.Errorf("garbage cursor: err = %v", err)
+	}
+}
+
+func summaryIDs(songs []*database.SongSummary) []int {
+	ids := []int{}
+	for _, song := range songs {
+		ids = append(ids, song.ID)
+	}
+	return ids
+}
+
+func testSongFilters(t *testing.T, s database.Store) {
+	for _, song := range []*database.Song{
+		{Title: "a", Artist: "the beatles", Album: "abbey road"},
+		{Title: "b", Artist: "beatles tribute", Album: "100% hits"},
+		{Title: "c", Artist: "stones", Album: "100 hits_live"},
+	} {
+		if err := s.AddSong(song); err != nil {
+			t.Fatalf("AddSong: %v", err)
+		}
+	}
+
+	hour := time.Hour
+	cases := []struct {
+		filter database.SongFilter
+		want   []string
+	}{
+		{database.SongFilter{Artist: "BEATLES"}, []string{"b", "a"}},
+		{database.SongFilter{Album: "hits"}, []string{"b", "c"}},
+		{database.SongFilter{Album: "0%"}, []string{"b"}},
+		{database.SongFilter{Album: "s_l"}, []string{"c"}},
+		{database.SongFilter{Artist: "beatles", Album: "road"}, []string{"a"}},
+		{database.SongFilter{AddedFrom: time.Now().Add(-hour)}, []string{"b", "c", "a"}},
+		{database.SongFilter{AddedTo: time.Now().Add(-hour)}, []string{}},
+		{database.SongFilter{AddedFrom: time.Now().Add(hour)}, []string{}},
+		{database.SongFilter{AddedTo: time.Now().Add(hour)}, []string{"b", "c", "a"}},
+	}
+	for _, c := range cases {
+		songs, err := s.ListSongs(c.filter)
+		if err != nil {
+			t.Errorf("ListSongs(%+v): %v", c.filter, err)
+			continue
+		}
+		wantTitles(t, "ListSongs", songs, c.want...)
+	}
+}
+
+// credit adds a song credited to artist and album.
+func credit(t *testing.T, s database.Store, title, artist, album string) *database.Song {
+	t.Helper()
+	song := &database.Song{Title: title, Artist: artist, Album: album, Fingerprint: "fp-" + title}
+	if err := s.AddSong(song); err != nil {
+		t.Fatalf("AddSong(%q): %v", title, err)
+	}
+	return song
+}
+
+func artistNames(artists []*database.Artist) []string {
+	names := []string{}
+	for _, a := range artists {
+		names = append(names, a.Name)
+	}
+	return names
+}
+
+func testCatalog(t *testing.T, s database.Store) {
+	first := credit(t, s, "castle", "Ed Sheeran", "Divide")
+	second := credit(t, s, "perfect", " ed  sheeran ", "divide!")
+	credit(t, s, "hold up", "Beyoncé", "Lemonade")
+	loose := credit(t, s, "halo", "BEYONCE", "")
+
+	if second.ArtistID != first.ArtistID || second.Artist != "Ed Sheeran" ||
+		second.AlbumID != first.AlbumID || second.Album != "Divide" {
+		t.Errorf("second song credited to %d %q, album %d %q; want the first song's %d %q, %d %q",
+			second.ArtistID, second.Artist, second.AlbumID, second.Album,
+			first.ArtistID, first.Artist, first.AlbumID, first.Album)
+	}
+	if loose.AlbumID != 0 {
+		t.Errorf("song without album has album %d", loose.AlbumID)
+	}
+	got, err := s.GetSong(second.ID)
+	if err != nil || got.Artist != "Ed Sheeran" || got.ArtistID != first.ArtistID || got.AlbumID != first.AlbumID {
+		t.Errorf("GetSong = %+v, %v", got, err)
+	}
+
+	artists, total, err := s.GetArtists("", 0, 0, 0)
+	if err != nil || total != 2 || !reflect.DeepEqual(artistNames(artists), []string{"Beyoncé", "Ed Sheeran"}) {
+		t.Fatalf("GetArtists = %q, %d, %v", artistNames(artists), total, err)
+	}
+	if a := artists[1]; a.SongCount != 2 || a.AlbumCount != 1 {
+		t.Errorf("Ed Sheeran has %d songs and %d albums, want 2 and 1", a.SongCount, a.AlbumCount)
+	}
+	if a := artists[0]; a.SongCount != 2 || a.AlbumCount != 1 {
+		t.Errorf("Beyoncé has %d songs and %d albums, want 2 and 1", a.SongCount, a.AlbumCount)
+	}
+	artists, total, _ = s.GetArtists("beyonce", 0, 0, 0)
+	if total != 1 || !reflect.DeepEqual(artistNames(artists), []string{"Beyoncé"}) {
+		t.Errorf(`GetArtists("beyonce") = %q, %d`, artistNames(artists), total)
+	}
+	artists, total, _ = s.GetArtists("", 0, 1, 1)
+	if total != 2 || !reflect.DeepEqual(artistNames(artists), []string{"Ed Sheeran"}) {
+		t.Errorf("second page of artists = %q, %d", artistNames(artists), total)
+	}
+
+	songs, err := s.ListSongs(database.SongFilter{ArtistID: first.ArtistID})
+	if err != nil {
+		t.Fatalf("ListSongs: %v", err)
+	}
+	wantTitles(t, "songs of Ed Sheeran", songs, "castle", "perfect")
+	songs, _ = s.ListSongs(database.SongFilter{AlbumID: first.AlbumID})
+	wantTitles(t, "songs of Divide", songs, "castle", "perfect")
+
+	albums, err := s.GetArtistAlbums(first.ArtistID, 0)
+	if err != nil || len(albums) != 1 {
+		t.Fatalf("GetArtistAlbums = %d albums, %v", len(albums), err)
+	}
+	album, err := s.GetAlbum(first.AlbumID, 0)
+	if err != nil || !reflect.DeepEqual(album, albums[0]) {
+		t.Fatalf("GetAlbum = %+v, %v; listed as %+v", album, err, albums[0])
+	}
+	if album.Title != "Divide" || album.Artist != "Ed Sheeran" || album.ArtistID != first.ArtistID || album.SongCount != 2 {
+		t.Errorf("album = %+v", album)
+	}
+
+	// Moving the last song off an album or artist removes it.
+	first.Album = ""
+	second.Album = "Plus"
+	for _, song := range []*database.Song{first, second} {
+		if err := s.UpdateSong(song); err != nil {
+			t.Fatalf("UpdateSong: %v", err)
+		}
+	}
+	_, err = s.GetAlbum(album.ID, 0)
+	wantNoRows(t, "GetAlbum of an emptied album", err)
+	if second.AlbumID == 0 || second.AlbumID == album.ID || second.ArtistID != first.ArtistID {
+		t.Errorf("updated song credited to artist %d, album %d", second.ArtistID, second.AlbumID)
+	}
+
+	beyonce := loose.ArtistID
+	songs, _ = s.ListSongs(database.SongFilter{ArtistID: beyonce})
+	for _, song := range songs {
+		if err := s.DeleteSong(song.ID); err != nil {
+			t.Fatalf("DeleteSong: %v", err)
+		}
+	}
+	_, err = s.GetArtist(beyonce, 0)
+	wantNoRows(t, "GetArtist of an artist without songs", err)
+	if albums, _ := s.GetArtistAlbums(beyonce, 0); len(albums) != 0 {
+		t.Errorf("deleted artist still has %d albums", len(albums))
+	}
+	_, err = s.GetArtist(0, 0)
+	wantNoRows(t, "GetArtist(0)", err)
+}
+
+func testMergeArtists(t *testing.T, s database.Store) {
+	ed := credit(t, s, "castle", "ed sheeran", "divide")
+	credit(t, s, "plus one", "ed sheeran", "plus")
+	typo := credit(t, s, "perfect", "ed sheeren", "Divide")
+	credit(t, s, "lego house", "ed sheeren", "lego")
+	galway := credit(t, s, "galway girl", "e. sheeran", "")
+	other := credit(t, s, "hello", "adele", "25")
+
+	if err := s.MergeArtists(typo.ArtistID, ed.ArtistID, 0); err != nil {
+		t.Fatalf("MergeArtists: %v", err)
+	}
+	if err := s.MergeArtists(typo.ArtistID+100, ed.ArtistID, 0); err == nil {
+		t.Error("MergeArtists of a missing artist succeeded")
+	}
+	songs, _ := s.ListSongs(database.SongFilter{ArtistID: ed.ArtistID})
+	wantTitles(t, "songs after the merge", songs, "castle", "lego house", "perfect", "plus one")
+	for _, song := range songs {
+		if song.Artist != "ed sheeran" {
+			t.Errorf("merged song %q credited to %q", song.Title, song.Artist)
+		}
+	}
+	_, err := s.GetArtist(typo.ArtistID, 0)
+	wantNoRows(t, "GetArtist of the merged artist", err)
+
+	// The albums both had become one; the other moves over.
+	albums, _ := s.GetArtistAlbums(ed.ArtistID, 0)
+	got := []string{}
+	for _, album := range albums {
+		got = append(got, fmt.Sprintf("%s:%d", album.Title, album.SongCount))
+	}
+	if want := []string{"divide:2", "lego:1", "plus:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("albums after the merge = %q, want %q", got, want)
+	}
+	merged, _ := s.GetSong(typo.ID)
+	if merged.AlbumID != ed.AlbumID || merged.Album != "divide" {
+		t.Errorf("merged song on album %d %q, want %d", merged.AlbumID, merged.Album, ed.AlbumID)
+	}
+
+	// Aliases travel with later merges and catch new songs.
+	if err := s.MergeArtists(galway.ArtistID, ed.ArtistID, 0); err != nil {
+		t.Fatalf("MergeArtists: %v", err)
+	}
+	artist, err := s.GetArtist(ed.ArtistID, 0)
+	if err != nil || !reflect.DeepEqual(artist.Aliases, []string{"e. sheeran", "ed sheeren"}) {
+		t.Fatalf("aliases = %q, %v", artist.Aliases, err)
+	}
+	if artist.SongCount != 5 || artist.AlbumCount != 3 {
+		t.Errorf("merged artist has %d songs and %d albums", artist.SongCount, artist.AlbumCount)
+	}
+	late := credit(t, s, "shivers", "Ed Sheeren", "")
+	if late.ArtistID != ed.ArtistID || late.Artist != "ed sheeran" {
+		t.Errorf("song under an alias credited to %d %q", late.ArtistID, late.Artist)
+	}
+
+	if err := s.MergeArtists(ed.ArtistID, ed.ArtistID, 0); err != nil {
+		t.Errorf("merging an artist into itself: %v", err)
+	}
+	artists, total, _ := s.GetArtists("", 0, 0, 0)
+	if total != 2 || !reflect.DeepEqual(artistNames(artists), []string{other.Artist, "ed sheeran"}) {
+		t.Errorf("artists after merging = %q", artistNames(artists))
+	}
+}
+
+func testTags(t *testing.T, s database.Store) {
+	a := addSong(t, s, "a", "x", 0, "")
+	b := addSong(t, s, "b", "x", 0, "")
+	addSong(t, s, "c", "x", 0, "")
+
+	if err := s.SetSongTags(a.ID, []string{" Party ", "chill", "party", ""}); err != nil {
+		t.Fatalf("SetSongTags: %v", err)
+	}
+	if err := s.SetSongTags(b.ID, []string{"party  time", "Party"}); err != nil {
+		t.Fatalf("SetSongTags: %v", err)
+	}
+	err := s.SetSongTags(a.ID+100, []string{"party"})
+	wantNoRows(t, "SetSongTags of a missing song", err)
+
+	tags, err := s.GetSongTags(a.ID)
+	if err != nil || !reflect.DeepEqual(tags, []string{"chill", "party"}) {
+		t.Errorf("GetSongTags = %q, %v", tags, err)
+	}
+	counts, err := s.GetTags(0)
+	if err != nil {
+		t.Fatalf("GetTags: %v", err)
+	}
+	got := []string{}
+	for _, c := range counts {
+		got = append(got, fmt.Sprintf("%s:%d", c.Tag, c.Songs))
+	}
+	if want := []string{"chill:1", "party:2", "party time:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetTags = %q, want %q", got, want)
+	}
+
+	songs, err := s.ListSongs(database.SongFilter{Tag: "PARTY"})
+	if err != nil {
+		t.Fatalf("ListSongs: %v", err)
+	}
+	wantTitles(t, "songs tagged party", songs, "a", "b")
+	summaries, _ := s.ListSongSummaries(database.SongFilter{})
+	if len(summaries) != 3 || !reflect.DeepEqual(summaries[0].Tags, []string{"chill", "party"}) || summaries[2].Tags != nil {
+		t.Errorf("summaries carry tags %v", summaries)
+	}
+
+	if err := s.SetSongTags(a.ID, nil); err != nil {
+		t.Fatalf("SetSongTags: %v", err)
+	}
+	if err := s.DeleteSong(b.ID); err != nil {
+		t.Fatalf("DeleteSong: %v", err)
+	}
+	if counts, _ := s.GetTags(0); len(counts) != 0 {
+		t.Errorf("tags left after untagging and deleting = %d", len(counts))
+	}
+}
+
+func testPlaylists(t *testing.T, s database.Store) {
+	a := addSong(t, s, "a", "x", 0, "")
+	b := addSong(t, s, "b", "x", 0, "")
+	c := addSong(t, s, "c", "x", 0, "")
+
+	set := &database.Playlist{Name: "setlist"}
+	if err := s.AddPlaylist(set); err != nil {
+		t.Fatalf("AddPlaylist: %v", err)
+	}
+	other := &database.Playlist{Name: "other"}
+	if err := s.AddPlaylist(other); err != nil || other.ID == set.ID {
+		t.Fatalf("AddPlaylist = %d, %v", other.ID, err)
+	}
+
+	for _, id := range []int{c.ID, a.ID, c.ID} {
+		if err := s.AddPlaylistSong(set.ID, id); err != nil {
+			t.Fatalf("AddPlaylistSong: %v", err)
+		}
+	}
+	wantPlaylist := func(what string, id int, want ...string) {
+		t.Helper()
+		songs, err := s.GetPlaylistSongs(id)
+		got, want := []string{}, append([]string{}, want...)
+		for _, song := range songs {
+			got = append(got, song.Title)
+		}
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %q, %v; want %q", what, got, err, want)
+		}
+	}
+	wantPlaylist("appended entries", set.ID, "c", "a", "c")
+
+	err := s.AddPlaylistSong(set.ID, c.ID+100)
+	wantNoRows(t, "AddPlaylistSong of a missing song", err)
+	err = s.AddPlaylistSong(set.ID+100, c.ID)
+	wantNoRows(t, "AddPlaylistSong to a missing playlist", err)
+	err = s.SetPlaylistSongs(set.ID, []int{a.ID, c.ID + 100})
+	wantNoRows(t, "SetPlaylistSongs with a missing song", err)
+	wantPlaylist("entries after a failed reorder", set.ID, "c", "a", "c")
+
+	if err := s.SetPlaylistSongs(set.ID, []int{b.ID, c.ID, a.ID}); err != nil {
+		t.Fatalf("SetPlaylistSongs: %v", err)
+	}
+	wantPlaylist("reordered entries", set.ID, "b", "c", "a")
+	if err := s.AddPlaylistSong(set.ID, b.ID); err != nil {
+		t.Fatalf("AddPlaylistSong: %v", err)
+	}
+	if err := s.AddPlaylistSong(other.ID, c.ID); err != nil {
+		t.Fatalf("AddPlaylistSong: %v", err)
+	}
+
+	if err := s.DeleteSong(b.ID); err != nil {
+		t.Fatalf("DeleteSong: %v", err)
+	}
+	wantPlaylist("entries after deleting a song", set.ID, "c", "a")
+	if err := s.AddPlaylistSong(set.ID, a.ID); err != nil {
+		t.Fatalf("AddPlaylistSong: %v", err)
+	}
+	wantPlaylist("entries appended after a deletion", set.ID, "c", "a", "a")
+
+	if err := s.RenamePlaylist(set.ID, "encore"); err != nil {
+		t.Fatalf("RenamePlaylist: %v", err)
+	}
+	wantNoRows(t, "RenamePlaylist of a missing playlist", s.RenamePlaylist(set.ID+100, "x"))
+	got, err := s.GetPlaylist(set.ID)
+	if err != nil || got.Name != "encore" || got.SongCount != 3 || got.CreatedAt.IsZero() {
+		t.Errorf("GetPlaylist = %+v, %v", got, err)
+	}
+	playlists, err := s.GetPlaylists(0)
+	if err != nil || len(playlists) != 2 || playlists[0].ID != set.ID || playlists[1].SongCount != 1 {
+		t.Errorf("GetPlaylists = %+v, %v", playlists, err)
+	}
+
+	if err := s.DeletePlaylist(set.ID); err != nil {
+		t.Fatalf("DeletePlaylist: %v", err)
+	}
+	_, err = s.GetPlaylist(set.ID)
+	wantNoRows(t, "GetPlaylist of a deleted playlist", err)
+	wantNoRows(t, "DeletePlaylist of a deleted playlist", s.DeletePlaylist(set.ID))
+	wantPlaylist("entries of a deleted playlist", set.ID)
+	wantPlaylist("entries of another playlist", other.ID, "c")
+}
+
+func testLibraries(t *testing.T, s database.Store) {
+	libraries, err := s.GetLibraries()
+	if err != nil || len(libraries) != 1 || libraries[0].Name != database.DefaultLibrary {
+		t.Fatalf("GetLibraries on empty store = %+v, %v, want the default library", libraries, err)
+	}
+	home := libraries[0].ID
+
+	club := &database.Library{Name: " club "}
+	if err := s.AddLibrary(club); err != nil {
+		t.Fatalf("AddLibrary: %v", err)
+	}
+	if club.ID <= home || club.Name != "club" || club.CreatedAt.IsZero() {
+		t.Errorf("AddLibrary set %+v", club)
+	}
+	if err := s.AddLibrary(&database.Library{Name: "club"}); !errors.Is(err, database.ErrLibraryExists) {
+		t.Errorf("AddLibrary with a taken name: err = %v, want ErrLibraryExists", err)
+	}
+
+	// Songs, identifications and monitors without a library go to the
+	// default one.
+	hello := addSong(t, s, "hello", "adele", 0, "")
+	if hello.LibraryID != home {
+		t.Errorf("song added without a library is in %d, want %d", hello.LibraryID, home)
+	}
+	remix := &database.Song{Title: "hello remix", Artist: "adele", Fingerprint: "fp", LibraryID: club.ID}
+	if err := s.AddSong(remix); err != nil {
+		t.Fatalf("AddSong: %v", err)
+	}
+	if got, _ := s.GetSong(remix.ID); got == nil || got.LibraryID != club.ID {
+		t.Errorf("GetSong = %+v, want library %d", got, club.ID)
+	}
+
+	songs, _ := s.ListSongs(database.SongFilter{LibraryID: club.ID})
+	wantTitles(t, "ListSongs of the club", songs, "hello remix")
+	summaries, _ := s.ListSongSummaries(database.SongFilter{LibraryID: home})
+	if len(summaries) != 1 || summaries[0].ID != hello.ID || summaries[0].LibraryID != home {
+		t.Errorf("ListSongSummaries of the default library = %+v", summaries)
+	}
+	songs, total, err := s.SearchSongs("hello", club.ID, 0, 0)
+	if err != nil || total != 1 {
+		t.Fatalf("SearchSongs in the club = %d songs, %v", total, err)
+	}
+	wantTitles(t, "SearchSongs in the club", songs, "hello remix")
+	if _, total, _ := s.SearchSongs("hello", 0, 0, 0); total != 2 {
+		t.Errorf("SearchSongs in every library found %d, want 2", total)
+	}
+
+	addIdentification(t, s, &database.Identification{Source: "upload", SongID: hello.ID, IsMatch: true})
+	played := addIdentification(t, s, &database.Identification{LibraryID: club.ID, Source: "upload", SongID: remix.ID, IsMatch: true})
+	if err := s.ConfirmIdentification(played.ID, remix.ID); err != nil {
+		t.Fatalf("ConfirmIdentification: %v", err)
+	}
+	idents, total, _ := s.GetIdentifications(database.HistoryFilter{LibraryID: club.ID})
+	if total != 1 || idents[0].ID != played.ID || idents[0].LibraryID != club.ID {
+		t.Errorf("GetIdentifications of the club = %+v of %d", idents, total)
+	}
+	if stats, _ := s.GetAccuracyStats(home); stats.Reviewed != 0 {
+		t.Errorf("GetAccuracyStats of the default library = %+v, want nothing reviewed", stats)
+	}
+	if stats, _ := s.GetAccuracyStats(club.ID); stats.Reviewed != 1 || stats.Correct != 1 {
+		t.Errorf("GetAccuracyStats of the club = %+v, want one right answer", stats)
+	}
+
+	got, err := s.GetLibraryByName("club")
+	want := &database.Library{ID: club.ID, Name: "club", SongCount: 1, Identifications: 1}
+	if err != nil || got.ID != want.ID || got.Name != want.Name || got.SongCount != want.SongCount ||
+		got.Identifications != want.Identifications {
+		t.Errorf("GetLibraryByName = %+v, %v, want %+v", got, err, want)
+	}
+	_, err = s.GetLibraryByName("nowhere")
+	wantNoRows(t, "GetLibraryByName", err)
+	_, err = s.GetLibrary(99)
+	wantNoRows(t, "GetLibrary", err)
+
+	// Only an empty library other than the default one can go, taking its
+	// history with it.
+	radio := &database.Monitor{Name: "radio", URL: "http://radio.example/stream", LibraryID: club.ID}
+	if err := s.AddMonitor(radio); err != nil {
+		t.Fatalf("AddMonitor: %v", err)
+	}
+	for what, id := range map[string]int{"default": home, "club": club.ID} {
+		if err := s.DeleteLibrary(id); !errors.Is(err, database.ErrLibraryInUse) {
+			t.Errorf("DeleteLibrary(%s): err = %v, want ErrLibraryInUse", what, err)
+		}
+	}
+	if err := s.DeleteMonitor(radio.ID); err != nil {
+		t.Fatalf("DeleteMonitor: %v", err)
+	}
+	if err := s.DeleteSong(remix.ID); err != nil {
+		t.Fatalf("DeleteSong: %v", err)
+	}
+	edit := &database.AuditEntry{Actor: "ann", Action: database.AuditDelete, Entity: "song", EntityID: remix.ID, LibraryID: club.ID}
+	if err := s.AddAuditEntry(edit); err != nil {
+		t.Fatalf("AddAuditEntry: %v", err)
+	}
+	if err := s.DeleteLibrary(club.ID); err != nil {
+		t.Fatalf("DeleteLibrary: %v", err)
+	}
+	if _, total, _ := s.GetAuditEntries(database.AuditFilter{}); total != 0 {
+		t.Errorf("%d audit entries left after deleting the club, want none", total)
+	}
+	if songs, _ := s.ListSongs(database.SongFilter{Deleted: true}); len(songs) != 0 {
+		t.Errorf("deleted songs of the club left: %q", titles(songs))
+	}
+	wantNoRows(t, "DeleteLibrary twice", s.DeleteLibrary(club.ID))
+	if _, total, _ := s.GetIdentifications(database.HistoryFilter{}); total != 1 {
+		t.Errorf("%d identifications left, want the default library's one", total)
+	}
+	if libraries, _ := s.GetLibraries(); len(libraries) != 1 || libraries[0].ID != home {
+		t.Errorf("GetLibraries after deleting the club = %+v", libraries)
+	}
+}
+
+// libraryCredit adds a song to library credited to artist and album.
+func libraryCredit(t *testing.T, s database.Store, library int, title, artist, album string) *database.Song {
+	t.Helper()
+	song := &database.Song{Title: title, Artist: artist, Album: album, Fingerprint: "fp-" + title, LibraryID: library}
+	if err := s.AddSong(song); err != nil {
+		t.Fatalf("AddSong(%q): %v", title, err)
+	}
+	return song
+}
+
+func albumCounts(albums []*database.Album) []string {
+	out := []string{}
+	for _, album := range albums {
+		out = append(out, fmt.Sprintf("%s:%d", album.Title, album.SongCount))
+	}
+	return out
+}
+
+func testLibraryScopes(t *testing.T, s database.Store) {
+	club := &database.Library{Name: "club"}
+	if err := s.AddLibrary(club); err != nil {
+		t.Fatalf("AddLibrary: %v", err)
+	}
+	castle := credit(t, s, "castle", "ed sheeran", "divide")
+	typo := credit(t, s, "perfect", "ed sheeren", "divide")
+	credit(t, s, "lego house", "ed sheeren", "lego")
+	live := libraryCredit(t, s, club.ID, "perfect live", "ed sheeren", "divide")
+	libraryCredit(t, s, club.ID, "lego live", "ed sheeren", "lego")
+	hello := libraryCredit(t, s, club.ID, "hello", "adele", "25")
+	home := castle.LibraryID
+
+	// The catalog only shows what has songs in the library asked for.
+	artists, total, err := s.GetArtists("", club.ID, 0, 0)
+	if err != nil || total != 2 || !reflect.DeepEqual(artistNames(artists), []string{"adele", "ed sheeren"}) {
+		t.Errorf("GetArtists of the club = %q of %d, %v", artistNames(artists), total, err)
+	}
+	_, err = s.GetArtist(hello.ArtistID, home)
+	wantNoRows(t, "GetArtist from another library", err)
+	_, err = s.GetAlbum(hello.AlbumID, home)
+	wantNoRows(t, "GetAlbum from another library", err)
+	if artist, err := s.GetArtist(typo.ArtistID, club.ID); err != nil || artist.SongCount != 2 || artist.AlbumCount != 2 {
+		t.Errorf("GetArtist in the club = %+v, %v, want 2 songs on 2 albums", artist, err)
+	}
+	if album, err := s.GetAlbum(typo.AlbumID, 0); err != nil || album.SongCount != 2 {
+		t.Errorf("GetAlbum in every library = %+v, %v, want 2 songs", album, err)
+	}
+
+	// Merging in one library leaves the artist and albums of the others.
+	if err := s.MergeArtists(typo.ArtistID, castle.ArtistID, club.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("MergeArtists into an artist without songs in the club: err = %v, want sql.ErrNoRows", err)
+	}
+	if err := s.MergeArtists(typo.ArtistID, castle.ArtistID, home); err != nil {
+		t.Fatalf("MergeArtists: %v", err)
+	}
+	albums, _ := s.GetArtistAlbums(castle.ArtistID, home)
+	if got, want := albumCounts(albums), []string{"divide:2", "lego:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("albums merged at home = %q, want %q", got, want)
+	}
+	albums, _ = s.GetArtistAlbums(typo.ArtistID, club.ID)
+	if got, want := albumCounts(albums), []string{"divide:1", "lego:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("albums left in the club = %q, want %q", got, want)
+	}
+	if got, _ := s.GetSong(live.ID); got.ArtistID != typo.ArtistID || got.Artist != "ed sheeren" || got.AlbumID != live.AlbumID {
+		t.Errorf("club song after the merge = %+v", got)
+	}
+	if artist, _ := s.GetArtist(castle.ArtistID, 0); len(artist.Aliases) != 0 {
+		t.Errorf("artist still in the club became the alias %q", artist.Aliases)
+	}
+
+	// Tags and playlists are those of the library.
+	if err := s.SetSongTags(castle.ID, []string{"party"}); err != nil {
+		t.Fatalf("SetSongTags: %v", err)
+	}
+	if err := s.SetSongTags(hello.ID, []string{"party"}); err != nil {
+		t.Fatalf("SetSongTags: %v", err)
+	}
+	if tags, _ := s.GetTags(club.ID); len(tags) != 1 || tags[0].Songs != 1 {
+		t.Errorf("GetTags of the club = %+v, want party on one song", tags)
+	}
+	if tags, _ := s.GetTags(0); len(tags) != 1 || tags[0].Songs != 2 {
+		t.Errorf("GetTags of every library = %+v, want party on two songs", tags)
+	}
+
+	set := &database.Playlist{Name: "set", LibraryID: club.ID}
+	mix := &database.Playlist{Name: "mix"}
+	for _, playlist := range []*database.Playlist{set, mix} {
+		if err := s.AddPlaylist(playlist); err != nil {
+			t.Fatalf("AddPlaylist: %v", err)
+		}
+	}
+	if mix.LibraryID != home {
+		t.Errorf("playlist added without a library is in %d, want %d", mix.LibraryID, home)
+	}
+	if playlists, _ := s.GetPlaylists(club.ID); len(playlists) != 1 || playlists[0].ID != set.ID || playlists[0].LibraryID != club.ID {
+		t.Errorf("GetPlaylists of the club = %+v", playlists)
+	}
+	if err := s.AddPlaylistSong(set.ID, castle.ID); !errors.Is(err, database.ErrOtherLibrary) {
+		t.Errorf("AddPlaylistSong from another library: err = %v, want ErrOtherLibrary", err)
+	}
+	if err := s.SetPlaylistSongs(set.ID, []int{hello.ID, castle.ID}); !errors.Is(err, database.ErrOtherLibrary) {
+		t.Errorf("SetPlaylistSongs from another library: err = %v, want ErrOtherLibrary", err)
+	}
+	if err := s.AddPlaylistSong(set.ID, hello.ID); err != nil {
+		t.Fatalf("AddPlaylistSong: %v", err)
+	}
+	if songs, _ := s.GetPlaylistSongs(set.ID); len(songs) != 1 || songs[0].ID != hello.ID {
+		t.Errorf("GetPlaylistSongs = %+v, want hello", songs)
+	}
+}
+
+func testSearchSongs(t *testing.T, s database.Store) {
+	for _, song := range []*database.Song{
+		{Title: "yellow submarine", Artist: "the beatles", Album: "revolver"},
+		{Title: "mr blue sky", Artist: "electric light orchestra", Album: "out of the blue"},
+		{Title: "café del mar", Artist: "energy 52", Album: "ibiza"},
+		{Title: "blue monday", Artist: "new order", Album: "power, corruption & lies"},
+		{Title: "sky fits heaven", Artist: "madonna", Album: "ray of light"},
+	} {
+		if err := s.AddSong(song); err != nil {
+			t.Fatalf("AddSong: %v", err)
+		}
+	}
+
+	search := func(query string, limit, offset int) ([]*database.Song, int) {
+		t.Helper()
+		songs, total, err := s.SearchSongs(query, 0, limit, offset)
+		if err != nil {
+			t.Fatalf("SearchSongs(%q): %v", query, err)
+		}
+		return songs, total
+	}
+
+	// Results are compared as sets where backends may rank them differently.
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"BLUE", []string{"blue monday", "mr blue sky"}},
+		{"subm", []string{"yellow submarine"}},
+		{"cafe", []string{"café del mar"}},
+		{"CAFÉ DEL", []string{"café del mar"}},
+		{"revolver", []string{"yellow submarine"}},
+		{"beatles yellow", []string{"yellow submarine"}},
+		{"submarnie", []string{"yellow submarine"}},
+		{"blue mondya", []string{"blue monday"}},
+		{"zzzz", []string{}},
+		{"!!!", []string{}},
+	}
+	for _, c := range cases {
+		songs, total := search(c.query, 0, 0)
+		got := titles(songs)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, c.want) || total != len(c.want) {
+			t.Errorf("SearchSongs(%q) = %q of %d, want %q", c.query, got, total, c.want)
+		}
+	}
+
+	// An artist match outranks an album match.
+	songs, _ := search("light", 0, 0)
+	wantTitles(t, "SearchSongs(light)", songs, "mr blue sky", "sky fits heaven")
+
+	all, _ := search("blue", 0, 0)
+	songs, total := search("blue", 1, 1)
+	if total != 2 || len(songs) != 1 || songs[0].ID != all[1].ID {
+		t.Errorf("second page of SearchSongs(blue) = %q of %d, want %q", titles(songs), total, titles(all[1:]))
+	}
+}
+
+func testUpdateSong(t *testing.T, s database.Store) {
+	song := addSong(t, s, "draft", "someone", 90, "D major")
+	update := &database.Song{ID: song.ID, Title: "final", Artist: "someone else", Album: "", Fingerprint: "ignored"}
+	if err := s.UpdateSong(update); err != nil {
+		t.Fatalf("UpdateSong: %v", err)
+	}
+
+	got, err := s.GetSong(song.ID)
+	if err != nil {
+		t.Fatalf("GetSong: %v", err)
+	}
+	if got.Title != "final" || got.Artist != "someone else" || got.Album != "" {
+		t.Errorf("metadata = %q, %q, %q after update", got.Title, got.Artist, got.Album)
+	}
+	if got.Fingerprint != song.Fingerprint || !reflect.DeepEqual(got.HashSegments, song.HashSegments) || got.BPM != 90 {
+		t.Error("UpdateSong changed fingerprint data")
+	}
+
+	wantNoRows(t, "UpdateSong of a missing song", s.UpdateSong(&database.Song{ID: song.ID + 100, Title: "t", Artist: "nobody", Album: "nothing"}))
+	if artists, _, _ := s.GetArtists("nobody", 0, 0, 0); len(artists) != 0 {
+		t.Errorf("a failed UpdateSong left artist %q in the catalog", artists[0].Name)
+	}
+}
+
+func testRestoreSong(t *testing.T, s database.Store) {
+	song := credit(t, s, "castle", "ed sheeran", "divide")
+	other := addSong(t, s, "other", "x", 0, "")
+	if err := s.SetSongTags(song.ID, []string{"party"}); err != nil {
+		t.Fatalf("SetSongTags: %v", err)
+	}
+	set := &database.Playlist{Name: "setlist"}
+	if err := s.AddPlaylist(set); err != nil {
+		t.Fatalf("AddPlaylist: %v", err)
+	}
+	if err := s.SetPlaylistSongs(set.ID, []int{song.ID, other.ID}); err != nil {
+		t.Fatalf("SetPlaylistSongs: %v", err)
+	}
+	ident := addIdentification(t, s, &database.Identification{Source: "upload", SongID: song.ID, IsMatch: true})
+
+	wantNoRows(t, "RestoreSong of a live song", s.RestoreSong(song.ID))
+	if err := s.DeleteSong(song.ID); err != nil {
+		t.Fatalf("DeleteSong: %v", err)
+	}
+	wantNoRows(t, "DeleteSong twice", s.DeleteSong(song.ID))
+	_, err := s.GetSong(song.ID)
+	wantNoRows(t, "GetSong of a deleted song", err)
+	wantNoRows(t, "UpdateSong of a deleted song", s.UpdateSong(&database.Song{ID: song.ID, Title: "t", Artist: "a"}))
+	wantNoRows(t, "SetSongTags of a deleted song", s.SetSongTags(song.ID, nil))
+
+	live, _ := s.ListSongs(database.SongFilter{})
+	wantTitles(t, "live songs", live, "other")
+	deleted, err := s.ListSongSummaries(database.SongFilter{Deleted: true})
+	if err != nil || len(deleted) != 1 || deleted[0].ID != song.ID || deleted[0].DeletedAt == nil ||
+		!reflect.DeepEqual(deleted[0].Tags, []string{"party"}) {
+		t.Errorf("deleted songs = %+v, %v", deleted, err)
+	}
+	if count, _ := s.GetSongCount(); count != 1 {
+		t.Errorf("GetSongCount with a deleted song = %d, want 1", count)
+	}
+	if songs, total, _ := s.SearchSongs("castle", 0, 10, 0); total != 0 || len(songs) != 0 {
+		t.Errorf("SearchSongs found the deleted song: %d", total)
+	}
+	if tags, _ := s.GetTags(0); len(tags) != 0 {
+		t.Errorf("GetTags counts the deleted song: %+v", tags)
+	}
+	if entries, _ := s.GetPlaylistSongs(set.ID); len(entries) != 1 || entries[0].ID != other.ID {
+		t.Errorf("playlist entries with a deleted song = %d", len(entries))
+	}
+	if got, _ := s.GetPlaylist(set.ID); got.SongCount != 1 {
+		t.Errorf("playlist count with a deleted song = %d, want 1", got.SongCount)
+	}
+	if got, _ := s.GetIdentification(ident.ID); got.Title != "" {
+		t.Errorf("identification of a deleted song titled %q", got.Title)
+	}
+	_, err = s.GetArtist(song.ArtistID, 0)
+	wantNoRows(t, "GetArtist of a deleted song's artist", err)
+
+	// Restoring brings back its tags, playlist entries and catalog entries.
+	if err := s.RestoreSong(song.ID); err != nil {
+		t.Fatalf("RestoreSong: %v", err)
+	}
+	got, err := s.GetSong(song.ID)
+	if err != nil || got.DeletedAt != nil || got.Artist != "ed sheeran" || got.ArtistID == 0 || got.AlbumID == 0 {
+		t.Fatalf("restored song = %+v, %v", got, err)
+	}
+	if tags, _ := s.GetSongTags(song.ID); !reflect.DeepEqual(tags, []string{"party"}) {
+		t.Errorf("restored tags = %q", tags)
+	}
+	if entries, _ := s.GetPlaylistSongs(set.ID); len(entries) != 2 || entries[0].ID != song.ID {
+		t.Errorf("playlist entries after restoring = %d", len(entries))
+	}
+	if artist, err := s.GetArtist(got.ArtistID, 0); err != nil || artist.SongCount != 1 {
+		t.Errorf("restored artist = %+v, %v", artist, err)
+	}
+	if deleted, _ := s.ListSongs(database.SongFilter{Deleted: true}); len(deleted) != 0 {
+		t.Errorf("%d songs still deleted after restoring", len(deleted))
+	}
+}
+
+func addIdentification(t *testing.T, s database.Store, ident *database.Identification) *database.Identification {
+	t.Helper()
+	if err := s.AddIdentification(ident); err != nil {
+		t.Fatalf("AddIdentification: %v", err)
+	}
+	return ident
+}
+
+func testHistory(t *testing.T, s database.Store) {
+	song := addSong(t, s, "tune", "band", 0, "")
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	first := addIdentification(t, s, &database.Identification{
+		CreatedAt: base, Source: "upload", Duration: 10, SongID: song.ID, IsMatch: true,
+		Confidence: 0.9, TimeInSong: 12.5, LatencyMillis: 40,
+		Candidates: []database.IdentifiedCandidate{{SongID: song.ID, Title: "tune", Artist: "band", Confidence: 0.9, MatchOffset: 7,
+			TempoFactor: 1.04, PitchFactor: 0.98}},
+		Query: database.HashSegments{1, 2, 3},
+	})
+	second := addIdentification(t, s, &database.Identification{
+		CreatedAt: base.Add(time.Minute), Source: "microphone", Duration: 5, Confidence: 0.1,
+		Candidates: []database.IdentifiedCandidate{},
+		Covers:     []database.PossibleCover{{SongID: song.ID, Similarity: 0.7, Transpose: 2, Tempo: 1.1}},
+	})
+	// Same time as second: ties list the later attempt first.
+	third := addIdentification(t, s, &database.Identification{
+		CreatedAt: base.Add(time.Minute), Source: "upload", Duration: 8, SongID: song.ID, Confidence: 0.4,
+	})
+	if first.ID <= 0 || second.ID <= first.ID || third.ID <= second.ID {
+		t.Fatalf("IDs %d, %d, %d, want positive and increasing", first.ID, second.ID, third.ID)
+	}
+
+	got, err := s.GetIdentification(first.ID)
+	if err != nil {
+		t.Fatalf("GetIdentification: %v", err)
+	}
+	if !got.CreatedAt.Equal(base) || got.Title != "tune" || got.Artist != "band" || got.Query != nil ||
+		!reflect.DeepEqual(got.Candidates, first.Candidates) || got.Covers != nil || got.ConfirmedAt != nil {
+		t.Errorf("GetIdentification = %+v", got)
+	}
+	got, _ = s.GetIdentification(second.ID)
+	if got.SongID != 0 || got.Title != "" || !reflect.DeepEqual(got.Covers, second.Covers) {
+		t.Errorf("unmatched identification = %+v", got)
+	}
+
+	qry, err := s.GetIdentificationQuery(first.ID)
+	if err != nil || !reflect.DeepEqual(qry, database.HashSegments{1, 2, 3}) {
+		t.Errorf("GetIdentificationQuery = %v, %v", qry, err)
+	}
+	qry, err = s.GetIdentificationQuery(second.ID)
+	if err != nil || len(qry) != 0 {
+		t.Errorf("GetIdentificationQuery without query = %v, %v", qry, err)
+	}
+	_, err = s.GetIdentification(99)
+	wantNoRows(t, "GetIdentification", err)
+	_, err = s.GetIdentificationQuery(99)
+	wantNoRows(t, "GetIdentificationQuery", err)
+
+	matched := true
+	cases := []struct {
+		filter database.HistoryFilter
+		want   []int
+		total  int
+	}{
+		{database.HistoryFilter{}, []int{third.ID, second.ID, first.ID}, 3},
+		{database.HistoryFilter{Source: "upload"}, []int{third.ID, first.ID}, 2},
+		{database.HistoryFilter{SongID: song.ID}, []int{third.ID, first.ID}, 2},
+		{database.HistoryFilter{Matched: &matched}, []int{first.ID}, 1},
+		{database.HistoryFilter{MinConfidence: 0.4}, []int{third.ID, first.ID}, 2},
+		{database.HistoryFilter{From: base.Add(time.Minute)}, []int{third.ID, second.ID}, 2},
+		{database.HistoryFilter{To: base.Add(time.Minute)}, []int{first.ID}, 1},
+		{database.HistoryFilter{Limit: 1, Offset: 1}, []int{second.ID}, 3},
+		{database.HistoryFilter{Offset: 2}, []int{first.ID}, 3},
+	}
+	for _, c := range cases {
+		idents, total, err := s.GetIdentifications(c.filter)
+		if err != nil {
+			t.Errorf("GetIdentifications(%+v): %v", c.filter, err)
+			continue
+		}
+		ids := []int{}
+		for _, ident := range idents {
+			ids = append(ids, ident.ID)
+		}
+		if !reflect.DeepEqual(ids, c.want) || total != c.total {
+			t.Errorf("GetIdentifications(%+v) = %v of %d, want %v of %d", c.filter, ids, total, c.want, c.total)
+		}
+	}
+
+	// Identifications outlive their song, losing its title.
+	if err := s.DeleteSong(song.ID); err != nil {
+		t.Fatalf("DeleteSong: %v", err)
+	}
+	got, _ = s.GetIdentification(first.ID)
+	if got.SongID != song.ID || got.Title != "" {
+		t.Errorf("after deleting the song = %+v", got)
+	}
+}
+
+func testAccuracy(t *testing.T, s database.Store) {
+	stats, err := s.GetAccuracyStats(0)
+	if err != nil || *stats != (database.AccuracyStats{}) {
+		t.Fatalf("GetAccuracyStats on empty store = %+v, %v", stats, err)
+	}
+
+	// Right, corrected, rejected, a miss rightly rejected, and unreviewed.
+	reviews := []struct {
+		songID    int
+		isMatch   bool
+		confirmed int
+	}{{1, true, 1}, {1, true, 2}, {1, true, 0}, {1, false, 0}, {2, true, -1}}
+	for _, r := range reviews {
+		ident := addIdentification(t, s, &database.Identification{Source: "upload", SongID: r.songID, IsMatch: r.isMatch})
+		if r.confirmed < 0 {
+			continue
+		}
+		if err := s.ConfirmIdentification(ident.ID, r.confirmed); err != nil {
+			t.Fatalf("ConfirmIdentification: %v", err)
+		}
+	}
+	wantNoRows(t, "ConfirmIdentification", s.ConfirmIdentification(99, 1))
+
+	stats, err = s.GetAccuracyStats(0)
+	want := database.AccuracyStats{Reviewed: 4, Correct: 2, Corrected: 1, Rejected: 1, Accuracy: 0.5}
+	if err != nil || *stats != want {
+		t.Errorf("GetAccuracyStats = %+v, %v, want %+v", stats, err, want)
+	}
+
+	idents, _, _ := s.GetIdentifications(database.HistoryFilter{})
+	for _, ident := range idents {
+		if (ident.ConfirmedAt != nil) != (ident.SongID == 1) {
+			t.Errorf("identification %d confirmed at %v", ident.ID, ident.ConfirmedAt)
+		}
+	}
+}
+
+func testMonitors(t *testing.T, s database.Store) {
+	radio := &database.Monitor{Name: "radio", URL: "http://radio.example/stream", Enabled: true}
+	other := &database.Monitor{Name: "other", URL: "http://other.example/stream"}
+	for _, m := range []*database.Monitor{radio, other} {
+		if err := s.AddMonitor(m); err != nil {
+			t.Fatalf("AddMonitor: %v", err)
+		}
+		if m.ID <= 0 || m.CreatedAt.IsZero() {
+			t.Errorf("AddMonitor set ID %d, CreatedAt %v", m.ID, m.CreatedAt)
+		}
+	}
+
+	if err := s.SetMonitorEnabled(radio.ID, false); err != nil {
+		t.Fatalf("SetMonitorEnabled: %v", err)
+	}
+	got, err := s.GetMonitor(radio.ID)
+	if err != nil || got.Name != "radio" || got.URL != radio.URL || got.Enabled {
+		t.Errorf("GetMonitor = %+v, %v", got, err)
+	}
+
+	monitors, err := s.GetMonitors()
+	if err != nil || len(monitors) != 2 || monitors[0].ID != radio.ID || monitors[1].ID != other.ID {
+		t.Errorf("GetMonitors = %v, %v", monitors, err)
+	}
+
+	if err := s.DeleteMonitor(radio.ID); err != nil {
+		t.Fatalf("DeleteMonitor: %v", err)
+	}
+	_, err = s.GetMonitor(radio.ID)
+	wantNoRows(t, "GetMonitor", err)
+	wantNoRows(t, "SetMonitorEnabled", s.SetMonitorEnabled(radio.ID, true))
+	wantNoRows(t, "DeleteMonitor", s.DeleteMonitor(radio.ID))
+}
+
+func testAirplay(t *testing.T, s database.Store) {
+	hit := addSong(t, s, "hit", "star", 0, "")
+	filler := addSong(t, s, "filler", "nobody", 0, "")
+	radio := &database.Monitor{Name: "radio", URL: "http://radio.example/stream"}
+	if err := s.AddMonitor(radio); err != nil {
+		t.Fatalf("AddMonitor: %v", err)
+	}
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	play := func(songID int, at time.Duration, seconds int) *database.Airplay {
+		p := &database.Airplay{
+			MonitorID: radio.ID, SongID: songID, Confidence: 0.8,
+			StartedAt: day.Add(at), EndedAt: day.Add(at + time.Duration(seconds)*time.Second),
+		}
+		if err := s.AddAirplay(p); err != nil {
+			t.Fatalf("AddAirplay: %v", err)
+		}
+		return p
+	}
+	morning := play(hit.ID, 8*time.Hour, 180)
+	noon := play(filler.ID, 12*time.Hour, 200)
+	evening := play(hit.ID, 20*time.Hour, 170)
+	nextDay := play(hit.ID, 26*time.Hour, 180)
+
+	ids := func(plays []*database.Airplay) []int {
+		out := []int{}
+		for _, p := range plays {
+			out = append(out, p.ID)
+		}
+		return out
+	}
+
+	plays, err := s.GetAirplays(radio.ID, time.Time{}, time.Time{}, -1, 0)
+	if err != nil {
+		t.Fatalf("GetAirplays: %v", err)
+	}
+	if want := []int{nextDay.ID, evening.ID, noon.ID, morning.ID}; !reflect.DeepEqual(ids(plays), want) {
+		t.Errorf("GetAirplays = %v, want %v", ids(plays), want)
+	}
+	if plays[0].Title != "hit" || plays[0].Artist != "star" || !plays[0].StartedAt.Equal(nextDay.StartedAt) {
+		t.Errorf("GetAirplays first play = %+v", plays[0])
+	}
+
+	plays, _ = s.GetAirplays(radio.ID, day.Add(12*time.Hour), day.Add(24*time.Hour), 1, 1)
+	if want := []int{noon.ID}; !reflect.DeepEqual(ids(plays), want) {
+		t.Errorf("GetAirplays paged = %v, want %v", ids(plays), want)
+	}
+
+	report, err := s.GetAirplayReport(radio.ID, day.Add(15*time.Hour))
+	if err != nil {
+		t.Fatalf("GetAirplayReport: %v", err)
+	}
+	want := []*database.AirplaySummary{
+		{SongID: hit.ID, Title: "hit", Artist: "star", Plays: 2, Seconds: 350},
+		{SongID: filler.ID, Title: "filler", Artist: "nobody", Plays: 1, Seconds: 200},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("GetAirplayReport = %+v, want %+v", report, want)
+	}
+
+	if err := s.DeleteMonitor(radio.ID); err != nil {
+		t.Fatalf("DeleteMonitor: %v", err)
+	}
+	plays, err = s.GetAirplays(radio.ID, time.Time{}, time.Time{}, -1, 0)
+	if err != nil || len(plays) != 0 {
+		t.Errorf("GetAirplays after deleting the monitor = %v, %v", ids(plays), err)
+	}
+}
+
+func testAudit(t *testing.T, s database.Store) {
+	entries, total, err := s.GetAuditEntries(database.AuditFilter{})
+	if err != nil || total != 0 || len(entries) != 0 {
+		t.Fatalf("GetAuditEntries on empty store = %d of %d, %v", len(entries), total, err)
+	}
+
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	add := func(entry *database.AuditEntry) *database.AuditEntry {
+		t.Helper()
+		if err := s.AddAuditEntry(entry); err != nil {
+			t.Fatalf("AddAuditEntry: %v", err)
+		}
+		return entry
+	}
+	created := add(&database.AuditEntry{CreatedAt: base, Actor: "ann", Action: database.AuditCreate,
+		Entity: "song", EntityID: 7, After: []byte(`{"title":"a"}`)})
+	updated := add(&database.AuditEntry{CreatedAt: base.Add(time.Minute), Actor: "bob", Action: database.AuditUpdate,
+		Entity: "song", EntityID: 7, Before: []byte(`{"title":"a"}`), After: []byte(`{"title":"b"}`)})
+	other := add(&database.AuditEntry{CreatedAt: base.Add(2 * time.Minute), Actor: "ann", Action: database.AuditDelete,
+		Entity: "playlist", EntityID: 7, Before: []byte(`{"name":"p"}`)})
+	if created.ID <= 0 || updated.ID <= created.ID || other.ID <= updated.ID {
+		t.Fatalf("IDs %d, %d, %d, want positive and increasing", created.ID, updated.ID, other.ID)
+	}
+
+	undo := add(&database.AuditEntry{CreatedAt: base.Add(3 * time.Minute), Actor: "ann", Action: database.AuditUndo,
+		Entity: "song", EntityID: 7, Before: updated.After, After: updated.Before, UndoOf: updated.ID})
+	wantNoRows(t, "undoing an entry twice", s.AddAuditEntry(&database.AuditEntry{Actor: "ann",
+		Action: database.AuditUndo, Entity: "song", EntityID: 7, UndoOf: updated.ID}))
+	wantNoRows(t, "undoing a missing entry", s.AddAuditEntry(&database.AuditEntry{Actor: "ann",
+		Action: database.AuditUndo, Entity: "song", EntityID: 7, UndoOf: 999}))
+
+	entries, _, _ = s.GetAuditEntries(database.AuditFilter{EntityID: 7, Entity: "song", Limit: 1, Offset: 1})
+	if len(entries) != 1 {
+		t.Fatalf("paged entries = %d", len(entries))
+	}
+	got := entries[0]
+	if got.ID != updated.ID || got.Actor != "bob" || !got.CreatedAt.Equal(updated.CreatedAt) ||
+		string(got.Before) != `{"title":"a"}` || string(got.After) != `{"title":"b"}` || got.UndoneAt == nil {
+		t.Errorf("undone entry = %+v", got)
+	}
+
+	notUndone, undone := false, true
+	cases := []struct {
+		filter database.AuditFilter
+		want   []int
+		total  int
+	}{
+		{database.AuditFilter{}, []int{undo.ID, other.ID, updated.ID, created.ID}, 4},
+		{database.AuditFilter{Actor: "ann"}, []int{undo.ID, other.ID, created.ID}, 3},
+		{database.AuditFilter{Action: database.AuditCreate}, []int{created.ID}, 1},
+		{database.AuditFilter{Entity: "song", EntityID: 7}, []int{undo.ID, updated.ID, created.ID}, 3},
+		{database.AuditFilter{Undone: &undone}, []int{updated.ID}, 1},
+		{database.AuditFilter{Entity: "song", Undone: &notUndone}, []int{undo.ID, created.ID}, 2},
+		{database.AuditFilter{From: base.Add(time.Minute), To: base.Add(3 * time.Minute)}, []int{other.ID, updated.ID}, 2},
+		{database.AuditFilter{Limit: 2}, []int{undo.ID, other.ID}, 4},
+	}
+	for _, c := range cases {
+		entries, total, err := s.GetAuditEntries(c.filter)
+		if err != nil {
+			t.Errorf("GetAuditEntries(%+v): %v", c.filter, err)
+			continue
+		}
+		ids := []int{}
+		for _, e := range entries {
+			ids = append(ids, e.ID)
+		}
+		if !reflect.DeepEqual(ids, c.want) || total != c.total {
+			t.Errorf("GetAuditEntries(%+v) = %v of %d, want %v of %d", c.filter, ids, total, c.want, c.total)
+		}
+	}
+
+	entries, _, _ = s.GetAuditEntries(database.AuditFilter{Action: database.AuditUndo})
+	if len(entries) != 1 || entries[0].UndoOf != updated.ID || entries[0].UndoneAt != nil || entries[0].Before == nil {
+		t.Errorf("undo entry = %+v", entries)
+	}
+	entries, _, _ = s.GetAuditEntries(database.AuditFilter{Entity: "playlist"})
+	if len(entries) != 1 || entries[0].After != nil {
+		t.Errorf("deletion entry = %+v", entries)
+	}
+
+	// Entries stored without a library go to the default one.
+	club := &database.Library{Name: "club"}
+	if err := s.AddLibrary(club); err != nil {
+		t.Fatalf("AddLibrary: %v", err)
+	}
+	moved := add(&database.AuditEntry{Actor: "ann", Action: database.AuditCreate, Entity: "song", EntityID: 8, LibraryID: club.ID})
+	entries, total, _ = s.GetAuditEntries(database.AuditFilter{LibraryID: club.ID})
+	if total != 1 || entries[0].ID != moved.ID || entries[0].LibraryID != club.ID {
+		t.Errorf("entries of the club = %+v of %d", entries, total)
+	}
+	if _, total, _ := s.GetAuditEntries(database.AuditFilter{LibraryID: created.LibraryID}); total != 4 {
+		t.Errorf("default library %d has %d entries, want 4", created.LibraryID, total)
+	}
+}
+
+func testRevertSong(t *testing.T, s database.Store) {
+	club := &database.Library{Name: "club"}
+	if err := s.AddLibrary(club); err != nil {
+		t.Fatalf("AddLibrary: %v", err)
+	}
+	song := addSong(t, s, "a", "x", 0, "")
+	entry := func(action string) *database.AuditEntry {
+		t.Helper()
+		e := &database.AuditEntry{Actor: "ann", Action: action, Entity: "song", EntityID: song.ID}
+		if err := s.AddAuditEntry(e); err != nil {
+			t.Fatalf("AddAuditEntry: %v", err)
+		}
+		return e
+	}
+	undo := func(of *database.AuditEntry) *database.AuditEntry {
+		return &database.AuditEntry{Actor: "bob", Action: database.AuditUndo, Entity: "song", EntityID: song.ID, UndoOf: of.ID}
+	}
+	created := entry(database.AuditCreate)
+
+	if err := s.UpdateSong(&database.Song{ID: song.ID, Title: "b", Artist: "y", Album: "z"}); err != nil {
+		t.Fatalf("UpdateSong: %v", err)
+	}
+	updated := entry(database.AuditUpdate)
+	previous := &database.Song{ID: song.ID, Title: "a", Artist: "x", Album: song.Album, LibraryID: club.ID}
+	if err := s.RevertSong(undo(updated), database.AuditUpdate, previous, []string{"Old"}); err != nil {
+		t.Fatalf("RevertSong of an update: %v", err)
+	}
+	got, _ := s.GetSong(song.ID)
+	if got.Title != "a" || got.Artist != "x" || got.LibraryID != club.ID {
+		t.Errorf("song after undoing its update = %q by %q in %d", got.Title, got.Artist, got.LibraryID)
+	}
+	if tags, _ := s.GetSongTags(song.ID); !reflect.DeepEqual(tags, []string{"old"}) {
+		t.Errorf("tags after undoing the update = %q", tags)
+	}
+
+	// Undoing it again fails as a whole, leaving the song alone.
+	again := &database.Song{ID: song.ID, Title: "again", Artist: "x"}
+	wantNoRows(t, "RevertSong twice", s.RevertSong(undo(updated), database.AuditUpdate, again, nil))
+	if got, _ := s.GetSong(song.ID); got.Title != "a" {
+		t.Errorf("failed undo renamed the song to %q", got.Title)
+	}
+	if tags, _ := s.GetSongTags(song.ID); len(tags) != 1 {
+		t.Errorf("failed undo left tags %q", tags)
+	}
+	if _, total, _ := s.GetAuditEntries(database.AuditFilter{Action: database.AuditUndo}); total != 1 {
+		t.Errorf("%d undo entries after a failed undo, want 1", total)
+	}
+
+	if err := s.RevertSong(undo(created), database.AuditCreate, &database.Song{ID: song.ID}, nil); err != nil {
+		t.Fatalf("RevertSong of a creation: %v", err)
+	}
+	_, err := s.GetSong(song.ID)
+	wantNoRows(t, "GetSong after undoing its creation", err)
+	deleted := entry(database.AuditDelete)
+	if err := s.RevertSong(undo(deleted), database.AuditDelete, &database.Song{ID: song.ID}, nil); err != nil {
+		t.Fatalf("RevertSong of a deletion: %v", err)
+	}
+	if _, err := s.GetSong(song.ID); err != nil {
+		t.Errorf("GetSong after undoing its deletion: %v", err)
+	}
+	undone := true
+	if _, total, _ := s.GetAuditEntries(database.AuditFilter{Undone: &undone}); total != 3 {
+		t.Errorf("%d entries undone, want 3", total)
+	}
+}
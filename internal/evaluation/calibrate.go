@@ -0,0 +1,139 @@
+package evaluation
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"Shazam/internal/matching"
+)
+
+// calibrationSteps and calibrationRate drive the gradient descent that fits
+// the decision model.
+const (
+	calibrationSteps = 5000
+	calibrationRate  = 0.5
+)
+
+// Calibrate fits the logistic decision model to the queries of a report run
+// with Details, then picks the lowest threshold whose accepted answers
+// reach targetPrecision. A query is a positive example when its top result
+// names the expected song. Other fields are copied from base.
+func Calibrate(report *Report, base matching.DecisionConfig, targetPrecision float64) (matching.DecisionConfig, error) {
+	var samples []*Query
+	for _, r := range report.Conditions {
+		for _, q := range r.Details {
+			if q.Predicted != 0 {
+				samples = append(samples, q)
+			}
+		}
+	}
+	if len(samples) == 0 {
+		return base, fmt.Errorf("the report has no query details to calibrate from")
+	}
+
+	positives := 0
+	for _, q := range samples {
+		if q.correct() {
+			positives++
+		}
+	}
+	if positives == 0 || positives == len(samples) {
+		return base, fmt.Errorf("calibration needs both correct and wrong answers, got %d of %d correct",
+			positives, len(samples))
+	}
+
+	// Features are standardised while fitting so one learning rate suits
+	// them all, then the weights are mapped back to raw units.
+	x := make([][3]float64, len(samples))
+	for i, q := range samples {
+		x[i] = [3]float64{q.ZScore, q.Margin, math.Log(math.Max(q.Seconds, 0.1))}
+	}
+	var mean, std [3]float64
+	for j := 0; j < 3; j++ {
+		for _, row := range x {
+			mean[j] += row[j]
+		}
+		mean[j] /= float64(len(x))
+		for _, row := range x {
+			std[j] += (row[j] - mean[j]) * (row[j] - mean[j])
+		}
+		std[j] = math.Sqrt(std[j] / float64(len(x)))
+		if std[j] == 0 {
+			std[j] = 1
+		}
+	}
+
+	var w [3]float64
+	var b float64
+	for step := 0; step < calibrationSteps; step++ {
+		var gw [3]float64
+		var gb float64
+		for i, row := range x {
+			z := b
+			for j := 0; j < 3; j++ {
+				z += w[j] * (row[j] - mean[j]) / std[j]
+			}
+			p := 1 / (1 + math.Exp(-z))
+			y := 0.0
+			if samples[i].correct() {
+				y = 1
+			}
+			for j := 0; j < 3; j++ {
+				gw[j] += (p - y) * (row[j] - mean[j]) / std[j]
+			}
+			gb += p - y
+		}
+		n := float64(len(x))
+		for j := 0; j < 3; j++ {
+			w[j] -= calibrationRate * gw[j] / n
+		}
+		b -= calibrationRate * gb / n
+	}
+
+	cfg := base
+	cfg.ZWeight = w[0] / std[0]
+	cfg.MarginWeight = w[1] / std[1]
+	cfg.LengthWeight = w[2] / std[2]
+	cfg.Bias = b
+	for j := 0; j < 3; j++ {
+		cfg.Bias -= w[j] * mean[j] / std[j]
+	}
+
+	cfg.Threshold = pickThreshold(samples, cfg, targetPrecision)
+	return cfg, nil
+}
+
+// pickThreshold returns the lowest probability threshold at which the
+// answers accepted by cfg are at least targetPrecision correct.
+func pickThreshold(samples []*Query, cfg matching.DecisionConfig, targetPrecision float64) float64 {
+	type scored struct {
+		p       float64
+		correct bool
+	}
+	var all []scored
+	for _, q := range samples {
+		if q.Score < cfg.MinScore {
+			continue
+		}
+		p := cfg.Probability(matching.Features{ZScore: q.ZScore, Margin: q.Margin, Seconds: q.Seconds})
+		all = append(all, scored{p, q.correct()})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].p > all[j].p })
+
+	threshold, correct := 1.0, 0
+	for i, s := range all {
+		if s.correct {
+			correct++
+		}
+		last := i == len(all)-1 || all[i+1].p < s.p
+		if last && float64(correct)/float64(i+1) >= targetPrecision {
+			threshold = s.p
+		}
+	}
+	return threshold
+}
+
+func (q *Query) correct() bool {
+	return q.Expected != 0 && q.Predicted == q.Expected
+}
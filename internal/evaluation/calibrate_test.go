@@ -0,0 +1,108 @@
+package evaluation
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"Shazam/internal/matching"
+)
+
+// answer is a query whose top result had z-score z and a raw score of
+// 0.5, naming the expected song when correct.
+func answer(z float64, correct bool) *Query {
+	q := &Query{Expected: 1, Predicted: 2, ZScore: z, Score: 0.5, Seconds: 10}
+	if correct {
+		q.Predicted = 1
+	}
+	return q
+}
+
+func TestPickThreshold(t *testing.T) {
+	// The probability grows with the z-score alone.
+	cfg := matching.DecisionConfig{ZWeight: 1}
+	p := func(z float64) float64 { return cfg.Probability(matching.Features{ZScore: z, Seconds: 10}) }
+	low := answer(-1, true)
+	low.Score = 0.01
+
+	for _, test := range []struct {
+		name     string
+		samples  []*Query
+		minScore float64
+		target   float64
+		want     float64
+	}{
+		{"all correct", []*Query{answer(3, true), answer(1, true), answer(-2, true)}, 0, 1, p(-2)},
+		{"stops above the first wrong answer", []*Query{answer(3, true), answer(2, true), answer(1, false), answer(0, true)}, 0, 1, p(2)},
+		{"lower precision reaches further", []*Query{answer(3, true), answer(2, true), answer(1, false), answer(0, true), answer(-1, false)}, 0, 0.75, p(0)},
+		{"ties are accepted together", []*Query{answer(2, true), answer(1, true), answer(1, false)}, 0, 1, p(2)},
+		{"best answer wrong", []*Query{answer(1, false), answer(0, true)}, 0, 1, 1},
+		{"low scores never accepted", []*Query{answer(2, true), answer(1, false), low}, 0.25, 0.6, p(2)},
+	} {
+		cfg := cfg
+		cfg.MinScore = test.minScore
+		if got := pickThreshold(test.samples, cfg, test.target); got != test.want {
+			t.Errorf("%s: threshold = %.4f, want %.4f", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	// Answers are right when the z-score, blurred by noise, clears 4.
+	rng := rand.New(rand.NewSource(1))
+	var details []*Query
+	for i := 0; i < 400; i++ {
+		z := rng.Float64() * 8
+		details = append(details, answer(z, z+rng.NormFloat64() > 4))
+	}
+	// Queries without an answer are left out of the fit.
+	details = append(details, &Query{Expected: 1})
+	report := &Report{Conditions: []*Result{{Details: details}}}
+
+	base := matching.DefaultDecisionConfig()
+	cfg, err := Calibrate(report, base, 0.95)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ZWeight <= 0 {
+		t.Errorf("z weight = %.3f, want positive", cfg.ZWeight)
+	}
+	if cfg.MinScore != base.MinScore {
+		t.Errorf("min score = %.3f, want %.3f from the base", cfg.MinScore, base.MinScore)
+	}
+	// Where the answers turn from mostly wrong to mostly right the model
+	// should be unsure.
+	if mid := cfg.Probability(matching.Features{ZScore: 4, Seconds: 10}); math.Abs(mid-0.5) > 0.15 {
+		t.Errorf("probability at the boundary = %.2f, want about 0.5", mid)
+	}
+
+	accepted, correct := 0, 0
+	for _, q := range details[:400] {
+		if cfg.Probability(matching.Features{ZScore: q.ZScore, Margin: q.Margin, Seconds: q.Seconds}) >= cfg.Threshold {
+			accepted++
+			if q.correct() {
+				correct++
+			}
+		}
+	}
+	if accepted == 0 || float64(correct)/float64(accepted) < 0.95 {
+		t.Errorf("threshold %.3f accepts %d answers, %d correct, want 95%% precision", cfg.Threshold, accepted, correct)
+	}
+}
+
+func TestCalibrateNeedsBothOutcomes(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		details []*Query
+	}{
+		{"no details", nil},
+		{"no answers", []*Query{{Expected: 1}}},
+		{"all correct", []*Query{answer(1, true), answer(2, true)}},
+		{"all wrong", []*Query{answer(1, false), answer(2, false)}},
+	} {
+		report := &Report{Conditions: []*Result{{Details: test.details}}}
+		if _, err := Calibrate(report, matching.DefaultDecisionConfig(), 0.95); err == nil {
+			t.Errorf("%s: calibrated without error", test.name)
+		}
+	}
+}
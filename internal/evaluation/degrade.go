@@ -0,0 +1,288 @@
+package evaluation
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"Shazam/internal/audio"
+)
+
+// Condition is a chain of degradations applied to every excerpt, written as
+// steps joined by "+", each step being name[:key=value,...]:
+//
+//	white:snr=10        white noise at 10 dB SNR
+//	pink:snr=10         pink noise at 10 dB SNR
+//	gain:db=-12         gain change, clipped to [-1, 1]
+//	lowpass:hz=3000     second order low-pass filter
+//	mp3:kbps=64         band limiting and coding noise of a low bitrate encoder
+//	offset:ms=11        excerpt starts this much later than the hop grid
+//	speed:factor=1.02   playback speed change, shifting pitch as well
+//
+// "clean" is the empty chain.
+type Condition struct {
+	Name  string
+	steps []step
+}
+
+type step func(samples []float64, rng *rand.Rand) []float64
+
+// DefaultConditions covers each degradation at a moderate strength.
+var DefaultConditions = []string{
+	"clean",
+	"white:snr=10",
+	"pink:snr=10",
+	"gain:db=-20",
+	"lowpass:hz=3000",
+	"mp3:kbps=64",
+	"offset:ms=11",
+	"speed:factor=1.02",
+	"pink:snr=5+lowpass:hz=4000",
+}
+
+// Apply degrades a copy of samples.
+func (c Condition) Apply(samples []float64, rng *rand.Rand) []float64 {
+	out := append([]float64(nil), samples...)
+	for _, s := range c.steps {
+		out = s(out, rng)
+	}
+	return out
+}
+
+// ParseCondition parses a condition spec as described on Condition.
+func ParseCondition(spec string) (Condition, error) {
+	spec = strings.TrimSpace(spec)
+	cond := Condition{Name: spec}
+	if spec == "" || spec == "clean" {
+		cond.Name = "clean"
+		return cond, nil
+	}
+
+	for _, part := range strings.Split(spec, "+") {
+		name, params, err := parseStep(part)
+		if err != nil {
+			return cond, err
+		}
+
+		var s step
+		switch name {
+		case "white":
+			s, err = noiseStep(params, whiteNoise)
+		case "pink":
+			s, err = noiseStep(params, pinkNoise)
+		case "gain":
+			s, err = gainStep(params)
+		case "lowpass":
+			s, err = lowPassStep(params)
+		case "mp3":
+			s, err = mp3Step(params)
+		case "offset":
+			s, err = offsetStep(params)
+		case "speed":
+			s, err = speedStep(params)
+		default:
+			err = fmt.Errorf("unknown degradation %q", name)
+		}
+		if err != nil {
+			return cond, err
+		}
+		cond.steps = append(cond.steps, s)
+	}
+
+	return cond, nil
+}
+
+func parseStep(part string) (string, map[string]float64, error) {
+	name, args, _ := strings.Cut(strings.TrimSpace(part), ":")
+	params := make(map[string]float64)
+	if args == "" {
+		return name, params, nil
+	}
+
+	for _, arg := range strings.Split(args, ",") {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return "", nil, fmt.Errorf("%s: expected key=value, got %q", name, arg)
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: invalid %s %q", name, key, value)
+		}
+		params[key] = v
+	}
+	return name, params, nil
+}
+
+func param(params map[string]float64, key string, def float64) float64 {
+	if v, ok := params[key]; ok {
+		return v
+	}
+	return def
+}
+
+func noiseStep(params map[string]float64, noise func(n int, rng *rand.Rand) []float64) (step, error) {
+	snr := param(params, "snr", 10)
+	return func(samples []float64, rng *rand.Rand) []float64 {
+		addNoise(samples, noise(len(samples), rng), snr)
+		return samples
+	}, nil
+}
+
+// addNoise mixes noise into samples scaled to the given signal-to-noise
+// ratio in dB.
+func addNoise(samples, noise []float64, snr float64) {
+	signal, level := rmsOf(samples), rmsOf(noise)
+	if signal == 0 || level == 0 {
+		return
+	}
+	scale := signal / level / math.Pow(10, snr/20)
+	for i := range samples {
+		samples[i] += noise[i] * scale
+	}
+}
+
+func whiteNoise(n int, rng *rand.Rand) []float64 {
+	noise := make([]float64, n)
+	for i := range noise {
+		noise[i] = rng.NormFloat64()
+	}
+	return noise
+}
+
+// pinkNoise filters white noise to a 1/f spectrum using Paul Kellet's
+// economy filter.
+func pinkNoise(n int, rng *rand.Rand) []float64 {
+	noise := make([]float64, n)
+	var b0, b1, b2 float64
+	for i := range noise {
+		white := rng.NormFloat64()
+		b0 = 0.99765*b0 + white*0.0990460
+		b1 = 0.96300*b1 + white*0.2965164
+		b2 = 0.57000*b2 + white*1.0526913
+		noise[i] = b0 + b1 + b2 + white*0.1848
+	}
+	return noise
+}
+
+func gainStep(params map[string]float64) (step, error) {
+	gain := math.Pow(10, param(params, "db", 0)/20)
+	return func(samples []float64, rng *rand.Rand) []float64 {
+		for i, s := range samples {
+			samples[i] = math.Max(-1, math.Min(1, s*gain))
+		}
+		return samples
+	}, nil
+}
+
+func lowPassStep(params map[string]float64) (step, error) {
+	hz := param(params, "hz", 3000)
+	if hz <= 0 || hz >= audio.SampleRate/2 {
+		return nil, fmt.Errorf("lowpass: hz must be between 0 and %d", audio.SampleRate/2)
+	}
+	return func(samples []float64, rng *rand.Rand) []float64 {
+		lowPass(samples, hz)
+		return samples
+	}, nil
+}
+
+// lowPass applies a Butterworth biquad in place.
+func lowPass(samples []float64, hz float64) {
+	w := 2 * math.Pi * hz / audio.SampleRate
+	alpha := math.Sin(w) / math.Sqrt2 // Q = 1/√2
+	cos := math.Cos(w)
+
+	a0 := 1 + alpha
+	b0 := (1 - cos) / 2 / a0
+	b1 := (1 - cos) / a0
+	b2 := b0
+	a1 := -2 * cos / a0
+	a2 := (1 - alpha) / a0
+
+	var x1, x2, y1, y2 float64
+	for i, x := range samples {
+		y := b0*x + b1*x1 + b2*x2 - a1*y1 - a2*y2
+		x2, x1 = x1, x
+		y2, y1 = y1, y
+		samples[i] = y
+	}
+}
+
+// mp3Step approximates a low bitrate encoder: coding noise that shrinks as
+// the bitrate grows, and a steep low-pass at the cutoff encoders typically
+// pick for that bitrate.
+func mp3Step(params map[string]float64) (step, error) {
+	kbps := param(params, "kbps", 64)
+	if kbps <= 0 {
+		return nil, fmt.Errorf("mp3: kbps must be positive")
+	}
+
+	cutoff := 10500.0
+	switch {
+	case kbps <= 32:
+		cutoff = 5500
+	case kbps <= 48:
+		cutoff = 7000
+	case kbps <= 64:
+		cutoff = 8000
+	case kbps <= 96:
+		cutoff = 9500
+	}
+	snr := 20 + kbps/16
+
+	return func(samples []float64, rng *rand.Rand) []float64 {
+		addNoise(samples, whiteNoise(len(samples), rng), snr)
+		lowPass(samples, cutoff)
+		lowPass(samples, cutoff)
+		return samples
+	}, nil
+}
+
+func offsetStep(params map[string]float64) (step, error) {
+	ms := param(params, "ms", 0)
+	if ms < 0 {
+		return nil, fmt.Errorf("offset: ms must not be negative")
+	}
+	skip := int(math.Round(ms * audio.SampleRate / 1000))
+	return func(samples []float64, rng *rand.Rand) []float64 {
+		if skip >= len(samples) {
+			return samples[:0]
+		}
+		return samples[skip:]
+	}, nil
+}
+
+// speedStep resamples by linear interpolation, so the excerpt plays
+// factor times faster and correspondingly higher.
+func speedStep(params map[string]float64) (step, error) {
+	factor := param(params, "factor", 1)
+	if factor <= 0 {
+		return nil, fmt.Errorf("speed: factor must be positive")
+	}
+	return func(samples []float64, rng *rand.Rand) []float64 {
+		if len(samples) < 2 {
+			return samples
+		}
+		n := int(float64(len(samples)-1) / factor)
+		out := make([]float64, n)
+		for i := range out {
+			pos := float64(i) * factor
+			j := int(pos)
+			frac := pos - float64(j)
+			out[i] = samples[j]*(1-frac) + samples[j+1]*frac
+		}
+		return out
+	}, nil
+}
+
+func rmsOf(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, s := range samples {
+		sum += s * s
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
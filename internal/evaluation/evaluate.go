@@ -0,0 +1,300 @@
+// Package evaluation measures identification accuracy by cutting excerpts
+// from the library's source audio, degrading them and identifying the
+// result against the index.
+package evaluation
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"Shazam/internal/audio"
+	"Shazam/internal/database"
+	"Shazam/internal/matching"
+)
+
+// Source is a library song together with the audio it was fingerprinted
+// from.
+type Source struct {
+	Song *database.Song
+	Path string
+}
+
+// Options controls an evaluation run.
+type Options struct {
+	// Queries is the number of excerpts cut; every excerpt is identified
+	// once per condition.
+	Queries int
+	// Seconds is the length of each excerpt.
+	Seconds float64
+	// Holdout is the fraction of sources removed from the index. Their
+	// excerpts are negatives that should come back as no match.
+	Holdout float64
+	// TopN is how many ranked candidates are checked for top-N accuracy.
+	TopN int
+	Seed int64
+	// Details keeps the outcome of every query in the report.
+	Details bool
+	// Shift, when set, identifies with shift-tolerant matching.
+	Shift *matching.ShiftOptions
+}
+
+func DefaultOptions() Options {
+	return Options{Queries: 100, Seconds: 10, Holdout: 0.2, TopN: 5, Seed: 1}
+}
+
+// Report is the outcome of an evaluation run.
+type Report struct {
+	Seed       int64     `json:"seed"`
+	Queries    int       `json:"queries"`
+	Seconds    float64   `json:"seconds"`
+	TopN       int       `json:"top_n"`
+	Songs      int       `json:"songs"`
+	HeldOut    []int     `json:"held_out"`
+	Conditions []*Result `json:"conditions"`
+}
+
+// Result holds the metrics for one condition. Positives are excerpts of
+// indexed songs, negatives excerpts of held-out songs. A true positive is
+// an accepted match naming the right song; any other accepted match is a
+// false positive.
+type Result struct {
+	Condition      string  `json:"condition"`
+	Positives      int     `json:"positives"`
+	Negatives      int     `json:"negatives"`
+	TruePositives  int     `json:"true_positives"`
+	FalsePositives int     `json:"false_positives"`
+	Precision      float64 `json:"precision"`
+	Recall         float64 `json:"recall"`
+	FalseAlarmRate float64 `json:"false_alarm_rate"`
+	Top1           float64 `json:"top1"`
+	TopN           float64 `json:"top_n"`
+	MeanMillis     float64 `json:"latency_mean_ms"`
+	P50Millis      float64 `json:"latency_p50_ms"`
+	P95Millis      float64 `json:"latency_p95_ms"`
+	Failures       int     `json:"failures"`
+
+	Details []*Query `json:"details,omitempty"`
+
+	top1, topN int
+	latencies  []float64
+}
+
+// Query is the outcome of identifying one degraded excerpt. Expected is 0
+// for negatives; Rank is the 1-based position of the expected song among
+// the candidates, or 0 when it was not ranked.
+type Query struct {
+	SongID     int     `json:"song_id"`
+	Start      float64 `json:"start"`
+	Expected   int     `json:"expected"`
+	Predicted  int     `json:"predicted"`
+	IsMatch    bool    `json:"is_match"`
+	Confidence float64 `json:"confidence"`
+	Score      float64 `json:"score"`
+	Margin     float64 `json:"margin"`
+	ZScore     float64 `json:"z_score"`
+	Seconds    float64 `json:"seconds"`
+	Rank       int     `json:"rank"`
+	Millis     float64 `json:"latency_ms"`
+}
+
+type excerpt struct {
+	number int
+	source int
+	start  float64
+}
+
+// Run evaluates conditions against idx. Held-out sources are removed from
+// idx for the duration of the run and added back afterwards. load decodes
+// a source file into mono samples at audio.SampleRate.
+func Run(idx *matching.Index, sources []Source, conditions []Condition, opts Options,
+	load func(path string) ([]float64, error)) (*Report, error) {
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no songs with source audio to evaluate")
+	}
+	if opts.TopN <= 0 {
+		opts.TopN = 1
+	}
+
+	rng := rand.New(rand.NewSource(opts.Seed))
+	report := &Report{
+		Seed:    opts.Seed,
+		Queries: opts.Queries,
+		Seconds: opts.Seconds,
+		TopN:    opts.TopN,
+		Songs:   len(sources),
+		HeldOut: []int{},
+	}
+
+	heldOut := make(map[int]bool)
+	for _, i := range rng.Perm(len(sources))[:int(opts.Holdout*float64(len(sources)))] {
+		song := sources[i].Song
+		heldOut[i] = true
+		report.HeldOut = append(report.HeldOut, song.ID)
+		idx.Remove(song.ID)
+		defer idx.Add(song)
+	}
+	sort.Ints(report.HeldOut)
+
+	// Excerpts are planned up front and grouped by source so each file is
+	// decoded once, whatever the order.
+	plan := make([]excerpt, opts.Queries)
+	for i := range plan {
+		plan[i] = excerpt{number: i, source: rng.Intn(len(sources)), start: rng.Float64()}
+	}
+	sort.SliceStable(plan, func(i, j int) bool { return plan[i].source < plan[j].source })
+
+	for _, c := range conditions {
+		report.Conditions = append(report.Conditions, &Result{Condition: c.Name})
+	}
+
+	length := int(opts.Seconds * audio.SampleRate)
+	var samples []float64
+	loaded := -1
+
+	for _, e := range plan {
+		src := sources[e.source]
+		if e.source != loaded {
+			var err error
+			if samples, err = load(src.Path); err != nil {
+				return nil, fmt.Errorf("song %d: %v", src.Song.ID, err)
+			}
+			loaded = e.source
+		}
+
+		// Starts sit on the hop grid so "clean" is the best case and the
+		// offset degradation measures misalignment alone.
+		start := 0
+		if len(samples) > length {
+			start = int(e.start*float64(len(samples)-length)) / audio.HopSize * audio.HopSize
+		}
+		cut := samples[start:min(start+length, len(samples))]
+
+		expected := src.Song.ID
+		if heldOut[e.source] {
+			expected = 0
+		}
+
+		for ci, c := range conditions {
+			q := &Query{
+				SongID:   src.Song.ID,
+				Start:    float64(start) / audio.SampleRate,
+				Expected: expected,
+			}
+			degraded := c.Apply(cut, rand.New(rand.NewSource(opts.Seed^int64(e.number*len(conditions)+ci+1))))
+			report.Conditions[ci].add(q, identify(idx, degraded, q, opts))
+		}
+	}
+
+	for _, r := range report.Conditions {
+		r.finish()
+		if !opts.Details {
+			r.Details = nil
+		}
+	}
+	return report, nil
+}
+
+// identify fingerprints and matches one excerpt, filling in q. It reports
+// false when the excerpt could not be fingerprinted.
+func identify(idx *matching.Index, samples []float64, q *Query, opts Options) bool {
+	start := time.Now()
+	fp, err := audio.GenerateFingerprint(samples)
+	if err != nil {
+		return false
+	}
+	var top []*database.MatchResult
+	if opts.Shift != nil {
+		top, err = matching.GetTopMatchesShifted(idx, samples, opts.TopN, *opts.Shift)
+	} else {
+		top, err = matching.GetTopMatches(idx, fp, opts.TopN)
+	}
+	if err != nil {
+		return false
+	}
+	q.Millis = float64(time.Since(start).Microseconds()) / 1000
+
+	// Seconds is the active audio the decision was made on, not the
+	// length of the excerpt.
+	q.Seconds = matching.QuerySeconds(fp.HashSegments)
+	for i, r := range top {
+		if i == 0 {
+			q.Predicted = r.Song.ID
+			q.IsMatch = r.IsMatch
+			q.Confidence = r.Confidence
+			q.Score = r.Score
+			q.Margin = r.Margin
+			q.ZScore = r.ZScore
+		}
+		if r.Song.ID == q.Expected {
+			q.Rank = i + 1
+		}
+	}
+	return true
+}
+
+func (r *Result) add(q *Query, ok bool) {
+	r.Details = append(r.Details, q)
+	if q.Expected != 0 {
+		r.Positives++
+	} else {
+		r.Negatives++
+	}
+	if !ok {
+		r.Failures++
+		return
+	}
+
+	r.latencies = append(r.latencies, q.Millis)
+	if q.IsMatch {
+		if q.Predicted == q.Expected {
+			r.TruePositives++
+		} else {
+			r.FalsePositives++
+		}
+	}
+	if q.Rank == 1 {
+		r.top1++
+	}
+	if q.Rank > 0 {
+		r.topN++
+	}
+}
+
+func (r *Result) finish() {
+	if accepted := r.TruePositives + r.FalsePositives; accepted > 0 {
+		r.Precision = float64(r.TruePositives) / float64(accepted)
+	}
+	if r.Positives > 0 {
+		r.Recall = float64(r.TruePositives) / float64(r.Positives)
+		r.Top1 = float64(r.top1) / float64(r.Positives)
+		r.TopN = float64(r.topN) / float64(r.Positives)
+	}
+	if r.Negatives > 0 {
+		alarms := 0
+		for _, q := range r.Details {
+			if q.Expected == 0 && q.IsMatch {
+				alarms++
+			}
+		}
+		r.FalseAlarmRate = float64(alarms) / float64(r.Negatives)
+	}
+
+	if len(r.latencies) > 0 {
+		sort.Float64s(r.latencies)
+		sum := 0.0
+		for _, l := range r.latencies {
+			sum += l
+		}
+		r.MeanMillis = sum / float64(len(r.latencies))
+		r.P50Millis = percentile(r.latencies, 0.50)
+		r.P95Millis = percentile(r.latencies, 0.95)
+	}
+}
+
+// percentile reads the p-th quantile from sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	return sorted[int(p*float64(len(sorted)-1)+0.5)]
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"Shazam/internal/audio"
+	"Shazam/internal/database"
+)
+
+// IndexStats reports the size and memory footprint of the in-memory index
+// of the request's library.
+func (h *Handler) IndexStats(w http.ResponseWriter, r *http.Request) {
+	_, idx, ok := h.libraryIndex(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(idx.Stats())
+}
+
+// RebuildIndex reloads the in-memory index of the request's library from
+// the database.
+func (h *Handler) RebuildIndex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_, idx, ok := h.libraryIndex(w, r)
+	if !ok {
+		return
+	}
+
+	if err := idx.Rebuild(); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to rebuild index: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(idx.Stats())
+}
+
+// BackfillAnalysis computes chroma, tempo and key for songs ingested before
+// they were stored, and fingerprints again songs of an older
+// audio.FingerprintVersion, using the source audio kept in the audio
+// directory. Songs without source audio are skipped. Only the request's
+// library is backfilled.
+func (h *Handler) BackfillAnalysis(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_, idx, ok := h.libraryIndex(w, r)
+	if !ok {
+		return
+	}
+
+	updated, missing, failed := 0, 0, 0
+	for _, song := range idx.Songs() {
+		current := song.FingerprintVersion >= audio.FingerprintVersion
+		if current && len(song.Chroma) > 0 && song.BPM > 0 && song.Key != "" {
+			continue
+		}
+		path := audio.SourceAudioPath(h.config.AudioDir, song.ID)
+		if _, err := os.Stat(path); err != nil {
+			missing++
+			continue
+		}
+
+		analysed, err := h.analyse(song, path)
+		if err != nil {
+			log.Printf("Failed to analyse song %d: %v", song.ID, err)
+			failed++
+			continue
+		}
+
+		updated++
+		idx.Update(analysed)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{
+		"updated": updated,
+		"missing": missing,
+		"failed":  failed,
+	})
+}
+
+// analyse stores the chroma, tempo and key of the audio at path for song,
+// along with its fingerprint if song's is of an older version, and returns
+// the song with them filled in.
+func (h *Handler) analyse(song *database.Song, path string) (*database.Song, error) {
+	samples, err := audio.LoadSamples(path)
+	if err != nil {
+		return nil, err
+	}
+	fp, err := audio.GenerateFingerprint(samples)
+	if err != nil {
+		return nil, err
+	}
+
+	analysed := *song
+	analysed.Chroma = fp.Chroma
+	analysed.BPM = audio.EstimateBPM(samples)
+	analysed.Key = audio.EstimateKey(fp.Chroma)
+
+	if song.FingerprintVersion < audio.FingerprintVersion {
+		analysed.Fingerprint = fp.Fingerprint
+		analysed.HashSegments = fp.HashSegments
+		analysed.HashOffset = fp.Offset
+		analysed.FingerprintVersion = audio.FingerprintVersion
+		err := h.db.SetSongFingerprint(song.ID, fp.Fingerprint, fp.HashSegments, fp.Offset, audio.FingerprintVersion)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := h.db.SetSongChroma(song.ID, analysed.Chroma); err != nil {
+		return nil, err
+	}
+	if err := h.db.SetSongAnalysis(song.ID, analysed.BPM, analysed.Key); err != nil {
+		return nil, err
+	}
+	return &analysed, nil
+}
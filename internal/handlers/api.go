@@ -2,10 +2,13 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"Shazam/internal/audio"
@@ -14,17 +17,25 @@ import (
 )
 
 // RecordAudio starts the background recording process and immediately responds.
+// The recording is identified in the request's library, and with
+// covers=true searched for covers when nothing matches exactly.
 func (h *Handler) RecordAudio(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	library, ok := h.requestLibrary(w, r)
+	if !ok {
+		return
+	}
+
+	covers, _ := strconv.ParseBool(r.URL.Query().Get("covers"))
 
 	w.Header().Set("Content-Type", "application/json")
 
 	// Kick off the recording pipeline asynchronously
 	go func() {
-		h.recordingProcess()
+		h.recordingProcess(library.ID, covers)
 	}()
 
 	response := map[string]interface{}{
@@ -35,14 +46,18 @@ func (h *Handler) RecordAudio(w http.ResponseWriter, r *http.Request) {
 }
 
 // recordingProcess performs an early 3s guess and then the full-duration recording and matching.
-func (h *Handler) recordingProcess() {
+func (h *Handler) recordingProcess(library int, covers bool) {
 	// ---- EARLY 3s GUESS ----
 	// Attempt a short capture, fingerprint, and best-match to push a quick song name to the UI.
 	previewFile := fmt.Sprintf("%s/preview_%d.mp4", h.config.TempDir, time.Now().UnixNano())
 	if err := audio.RecordScreenWithAudio(previewFile, 3); err == nil {
 		if fp, err := audio.ExtractAudioFingerprint(previewFile); err == nil {
-			if best, err := matching.FindBestMatch(h.db, fp); err == nil && best != nil && best.Song != nil && best.Song.Title != "" {
-				h.broadcastWebSocketMessage("early_guess", map[string]string{"name": best.Song.Title})
+			if best, ident, err := h.identify("preview", library, fp, nil, false); err == nil && best.IsMatch && best.Song != nil && best.Song.Title != "" {
+				h.broadcastWebSocketMessage("early_guess", map[string]interface{}{
+					"name":       best.Song.Title,
+					"history_id": ident.ID,
+					"candidates": ident.Candidates,
+				})
 			} else {
 				h.broadcastWebSocketMessage("early_guess", map[string]string{"name": "Unknown"})
 			}
@@ -79,7 +94,7 @@ func (h *Handler) recordingProcess() {
 		return
 	}
 
-	result, err := matching.FindBestMatch(h.db, fp)
+	result, ident, err := h.identify("recording", library, fp, nil, covers)
 	if err != nil {
 		h.broadcastStatus(database.RecordingStatus{
 			Status:  "error",
@@ -89,47 +104,208 @@ func (h *Handler) recordingProcess() {
 		return
 	}
 
-	h.broadcastResult(result)
+	h.broadcastResult(result, ident)
 	_ = os.Remove(videoFile)
 }
 
-// IdentifySong remains as a stub endpoint; the app uses /api/record + WebSocket for live flow.
+// IdentifySong identifies an uploaded audio clip (multipart field "file")
+// and records the attempt in the history. With shift=true the clip may be
+// sped up or transposed by up to max_tempo and max_pitch (fractions,
+// default 0.1) and the result reports the detected factors. With
+// explain=true the response also carries the score curves of the clip
+// along its candidates; shift-tolerant matches cannot be explained. With
+// covers=true a clip without an exact match is searched for covers.
+// playlist (an id) and tag limit the match to the songs of that playlist
+// or carrying that tag, or both. Only songs of the request's library are
+// considered.
 func (h *Handler) IdentifySong(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	library, ok := h.requestLibrary(w, r)
+	if !ok {
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json")
+	var err error
+	opts := matching.DefaultShiftOptions()
+	query := r.URL.Query()
+	if v := query.Get("max_tempo"); v != "" {
+		if opts.MaxTempo, err = strconv.ParseFloat(v, 64); err != nil || opts.MaxTempo < 0 || opts.MaxTempo > 0.5 {
+			http.Error(w, "Invalid max_tempo, expected 0 to 0.5", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("max_pitch"); v != "" {
+		if opts.MaxPitch, err = strconv.ParseFloat(v, 64); err != nil || opts.MaxPitch < 0 || opts.MaxPitch > 0.5 {
+			http.Error(w, "Invalid max_pitch, expected 0 to 0.5", http.StatusBadRequest)
+			return
+		}
+	}
+	songs, ok := h.identifyScope(w, r, library.ID)
+	if !ok {
+		return
+	}
+	opts.Songs = songs
+
+	path, _, err := h.saveUpload(r, "file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer os.Remove(path)
+
+	samples, err := audio.LoadSamples(path)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to process audio: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var result *database.MatchResult
+	var ident *database.Identification
+	if shift, _ := strconv.ParseBool(query.Get("shift")); shift {
+		result, ident, err = h.identifyShifted("upload", library.ID, samples, opts)
+	} else {
+		var fp *audio.AudioFingerprint
+		if fp, err = audio.GenerateFingerprint(samples); err == nil {
+			covers, _ := strconv.ParseBool(query.Get("covers"))
+			result, ident, err = h.identify("upload", library.ID, fp, songs, covers)
+		}
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Matching failed: %v", err), http.StatusInternalServerError)
+		return
+	}
 
 	response := map[string]interface{}{
-		"song": map[string]interface{}{
-			"title":      "Shape of You",
-			"artist":     "Ed Sheeran",
-			"confidence": 67.3,
-		},
+		"history_id": ident.ID,
+		"result":     withoutSegments(result),
+		"covers":     ident.Covers,
+	}
+	if explain, _ := strconv.ParseBool(query.Get("explain")); explain {
+		response["explanation"] = h.explain(ident)
 	}
+
+	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(response)
 }
 
-// GetSongs streams all songs as JSON.
+// Song listings are paged by cursor: each page carries the cursor of the
+// next in "next", passed back as "cursor".
+const (
+	songPageSize    = 50
+	maxSongPageSize = 500
+)
+
+// GetSongs lists the songs of the request's library without their
+// fingerprint data, one page at a time, filtered by min_bpm, max_bpm, key,
+// artist, album, tag, added_from and added_to and ordered by sort and
+// order when given. deleted=true lists the deleted songs instead.
 func (h *Handler) GetSongs(w http.ResponseWriter, r *http.Request) {
-	songs, err := h.db.GetAllSongs()
+	library, ok := h.requestLibrary(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseSongFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.LibraryID = library.ID
+	h.writeSongPage(w, r, filter)
+}
+
+// writeSongPage writes the page of songs matching filter that the limit
+// and cursor of the request ask for.
+func (h *Handler) writeSongPage(w http.ResponseWriter, r *http.Request, filter database.SongFilter) {
+	filter.Limit = min(intParam(r.URL.Query().Get("limit"), songPageSize), maxSongPageSize)
+	if filter.Limit == 0 {
+		filter.Limit = songPageSize
+	}
+
+	page, err := database.ListSongPage(h.db, filter)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to fetch songs", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(songs)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"limit": filter.Limit,
+		"next":  page.Next,
+		"items": page.Songs,
+	})
 }
 
-// AddSong ingests a song by artist/title/album using yt-dlp + fingerprinting pipeline.
+func parseSongFilter(r *http.Request) (database.SongFilter, error) {
+	query := r.URL.Query()
+	filter := database.SongFilter{
+		Key:    query.Get("key"),
+		Artist: query.Get("artist"),
+		Album:  query.Get("album"),
+		Tag:    query.Get("tag"),
+		Sort:   query.Get("sort"),
+		After:  query.Get("cursor"),
+	}
+
+	if v := query.Get("deleted"); v != "" {
+		deleted, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, errors.New("Invalid deleted")
+		}
+		filter.Deleted = deleted
+	}
+	if v := query.Get("min_bpm"); v != "" {
+		bpm, err := strconv.ParseFloat(v, 64)
+		if err != nil || bpm < 0 {
+			return filter, errors.New("Invalid min_bpm")
+		}
+		filter.MinBPM = bpm
+	}
+	if v := query.Get("max_bpm"); v != "" {
+		bpm, err := strconv.ParseFloat(v, 64)
+		if err != nil || bpm < 0 {
+			return filter, errors.New("Invalid max_bpm")
+		}
+		filter.MaxBPM = bpm
+	}
+	var err error
+	if filter.AddedFrom, err = parseTimeParam(query.Get("added_from")); err != nil {
+		return filter, errors.New("Invalid added_from")
+	}
+	if filter.AddedTo, err = parseTimeParam(query.Get("added_to")); err != nil {
+		return filter, errors.New("Invalid added_to")
+	}
+	if _, ok := database.SongSorts[filter.Sort]; filter.Sort != "" && !ok {
+		return filter, errors.New("Invalid sort")
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		filter.Desc = true
+	default:
+		return filter, errors.New("Invalid order")
+	}
+
+	return filter, nil
+}
+
+// AddSong ingests a song by artist/title/album using yt-dlp + fingerprinting
+// pipeline into the request's library.
 func (h *Handler) AddSong(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	library, ok := h.requestLibrary(w, r)
+	if !ok {
+		return
+	}
 
 	var req struct {
 		Artist string `json:"artist"`
@@ -147,10 +323,14 @@ func (h *Handler) AddSong(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := audio.AddSongToDatabase(h.db, req.Artist, req.Title, req.Album); err != nil {
+	song, err := audio.AddSongToDatabase(h.db, h.config.AudioDir, library.ID, req.Artist, req.Title, req.Album)
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to add song: %v", err), http.StatusInternalServerError)
 		return
 	}
+	h.libraries.Add(song)
+	h.audit(r, song.LibraryID, database.AuditCreate, auditSong, song.ID, nil,
+		&songState{Title: song.Title, Artist: song.Artist, Album: song.Album, LibraryID: song.LibraryID, Tags: []string{}})
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]string{
@@ -159,22 +339,143 @@ func (h *Handler) AddSong(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// SearchSongs performs a case-insensitive substring search on title/artist.
+// SongByID serves GET, PUT and DELETE on /api/songs/{id}, keeping the
+// in-memory index in step with every change and recording it in the audit
+// log. Deleted songs and songs of other libraries than the request's are
+// not found; a deletion can be undone at /api/songs/{id}/undo.
+func (h *Handler) SongByID(w http.ResponseWriter, r *http.Request) {
+	song, ok := h.lookupSong(w, r)
+	if !ok {
+		return
+	}
+	id := song.ID
+
+	var before *songState
+	if r.Method == http.MethodPut || r.Method == http.MethodDelete {
+		var err error
+		if before, err = h.songState(song); err != nil {
+			http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(song)
+
+	case http.MethodPut:
+		var req struct {
+			Artist string `json:"artist"`
+			Title  string `json:"title"`
+			Album  string `json:"album"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if req.Artist == "" || req.Title == "" {
+			http.Error(w, "Artist and title are required", http.StatusBadRequest)
+			return
+		}
+
+		song = &database.Song{ID: id, Title: req.Title, Artist: req.Artist, Album: req.Album}
+		if err := h.db.UpdateSong(song); err != nil {
+			writeLookupError(w, err, "Failed to update song")
+			return
+		}
+		updated, err := h.db.GetSong(id)
+		if err != nil {
+			writeLookupError(w, err, "Failed to fetch song")
+			return
+		}
+		h.libraries.Update(updated)
+		after := *before
+		after.Title, after.Artist, after.Album = updated.Title, updated.Artist, updated.Album
+		h.audit(r, updated.LibraryID, database.AuditUpdate, auditSong, id, before, &after)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(updated)
+
+	case http.MethodDelete:
+		if err := h.db.DeleteSong(id); err != nil {
+			writeLookupError(w, err, "Failed to delete song")
+			return
+		}
+		h.libraries.Remove(id)
+		h.audit(r, song.LibraryID, database.AuditDelete, auditSong, id, before, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"status":  "success",
+			"message": fmt.Sprintf("Deleted song %d", id),
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// lookupSong fetches the song named by the id path value, answering 404
+// when it is missing or belongs to another library than the request's.
+func (h *Handler) lookupSong(w http.ResponseWriter, r *http.Request) (*database.Song, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid song id", http.StatusBadRequest)
+		return nil, false
+	}
+	song, err := h.db.GetSong(id)
+	if err != nil {
+		writeLookupError(w, err, "Failed to fetch song")
+		return nil, false
+	}
+	if !h.inLibrary(w, r, song.LibraryID, "Song not found") {
+		return nil, false
+	}
+	return song, true
+}
+
+// writeLookupError maps a missing row to 404 and anything else to 500.
+func writeLookupError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Song not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, message, http.StatusInternalServerError)
+}
+
+// searchPageSize is the default number of search results per page.
+const searchPageSize = 20
+
+// SearchSongs searches titles, artists and albums by word prefix, ignoring
+// case and accents and tolerating typos, best match first, within the
+// request's library. limit and offset page through the results.
 func (h *Handler) SearchSongs(w http.ResponseWriter, r *http.Request) {
+	library, ok := h.requestLibrary(w, r)
+	if !ok {
+		return
+	}
 	query := r.URL.Query().Get("q")
 	if query == "" {
 		http.Error(w, "Query parameter 'q' is required", http.StatusBadRequest)
 		return
 	}
+	limit := intParam(r.URL.Query().Get("limit"), searchPageSize)
+	offset := intParam(r.URL.Query().Get("offset"), 0)
 
-	songs, err := h.db.SearchSongs(query)
+	songs, total, err := h.db.SearchSongs(query, library.ID, limit, offset)
 	if err != nil {
 		http.Error(w, "Search failed", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(songs)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+		"items":  songs,
+	})
 }
 
 // broadcastStatus forwards recording status to the frontend via WebSocket.
@@ -182,7 +483,34 @@ func (h *Handler) broadcastStatus(status database.RecordingStatus) {
 	h.broadcastWebSocketMessage("recording_status", status)
 }
 
-// broadcastResult forwards the final match result to the frontend via WebSocket.
-func (h *Handler) broadcastResult(result *database.MatchResult) {
-	h.broadcastWebSocketMessage("result", result)
+// resultPayload is the final WebSocket result: the best match, the ranked
+// alternatives and the history entry confirmations should refer to.
+type resultPayload struct {
+	*database.MatchResult
+	HistoryID  int                            `json:"history_id"`
+	Candidates []database.IdentifiedCandidate `json:"candidates"`
+	Covers     []database.PossibleCover       `json:"covers,omitempty"`
+}
+
+// broadcastResult forwards the final match result and its alternatives to
+// the frontend via WebSocket.
+func (h *Handler) broadcastResult(result *database.MatchResult, ident *database.Identification) {
+	h.broadcastWebSocketMessage("result", resultPayload{
+		MatchResult: withoutSegments(result),
+		HistoryID:   ident.ID,
+		Candidates:  ident.Candidates,
+		Covers:      ident.Covers,
+	})
+}
+
+// withoutSegments copies a result, dropping the matched song's hash
+// segments which no client uses.
+func withoutSegments(result *database.MatchResult) *database.MatchResult {
+	out := *result
+	if out.Song != nil {
+		song := *out.Song
+		song.HashSegments = nil
+		out.Song = &song
+	}
+	return &out
 }
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"Shazam/internal/database"
+)
+
+// actorHeader names who makes a change, as recorded in the audit log.
+// Changes without it are put down to the client's address.
+const actorHeader = "X-Actor"
+
+// auditPageSize is the default number of audit log entries per page.
+const auditPageSize = 50
+
+// Entities recorded in the audit log.
+const (
+	auditSong           = "song"
+	auditPlaylist       = "playlist"
+	auditLibrary        = "library"
+	auditMonitor        = "monitor"
+	auditArtist         = "artist"
+	auditIdentification = "identification"
+)
+
+// songState is what the audit log keeps of a song: the fields an edit can
+// change, which are the ones an undo puts back.
+type songState struct {
+	Title     string   `json:"title"`
+	Artist    string   `json:"artist"`
+	Album     string   `json:"album"`
+	LibraryID int      `json:"library_id"`
+	Tags      []string `json:"tags"`
+}
+
+func (h *Handler) songState(song *database.Song) (*songState, error) {
+	tags, err := h.db.GetSongTags(song.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &songState{Title: song.Title, Artist: song.Artist, Album: song.Album, LibraryID: song.LibraryID, Tags: tags}, nil
+}
+
+// audit records a change the request made in library in the audit log,
+// with the entity as JSON before and after it; nil leaves either empty.
+// The change is made already, so failing to record it is only logged.
+func (h *Handler) audit(r *http.Request, library int, action, entity string, id int, before, after interface{}) {
+	entry := &database.AuditEntry{Actor: actor(r), Action: action, Entity: entity, EntityID: id, LibraryID: library}
+	var err error
+	if before != nil {
+		entry.Before, err = json.Marshal(before)
+	}
+	if after != nil && err == nil {
+		entry.After, err = json.Marshal(after)
+	}
+	if err == nil {
+		err = h.db.AddAuditEntry(entry)
+	}
+	if err != nil {
+		log.Printf("Failed to record %s of %s %d in the audit log: %v", action, entity, id, err)
+	}
+}
+
+// actor is who the request acts for: the X-Actor header, or else the
+// client's address.
+func actor(r *http.Request) string {
+	if name := strings.TrimSpace(r.Header.Get(actorHeader)); name != "" {
+		return name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Audit lists the audit log of the request's library, newest first. It
+// supports limit/offset paging and filtering by actor, action, entity,
+// entity_id, undone and a from/to time range.
+func (h *Handler) Audit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	library, ok := h.requestLibrary(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.LibraryID = library.ID
+	filter.Limit = intParam(r.URL.Query().Get("limit"), auditPageSize)
+	filter.Offset = intParam(r.URL.Query().Get("offset"), 0)
+
+	items, total, err := h.db.GetAuditEntries(filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":  total,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+		"items":  items,
+	})
+}
+
+func parseAuditFilter(r *http.Request) (database.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := database.AuditFilter{
+		Actor:    query.Get("actor"),
+		Action:   query.Get("action"),
+		Entity:   query.Get("entity"),
+		EntityID: intParam(query.Get("entity_id"), 0),
+	}
+
+	if v := query.Get("undone"); v != "" {
+		undone, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, errors.New("Invalid undone")
+		}
+		filter.Undone = &undone
+	}
+
+	var err error
+	if filter.From, err = parseTimeParam(query.Get("from")); err != nil {
+		return filter, errors.New("Invalid from")
+	}
+	if filter.To, err = parseTimeParam(query.Get("to")); err != nil {
+		return filter, errors.New("Invalid to")
+	}
+
+	return filter, nil
+}
+
+// UndoSong reverts the most recent change to a song not undone yet: a
+// deletion is restored, an edit of its names or tags put back and an
+// addition deleted. Each call undoes one change, so repeating it walks
+// back through the song's history. The undo is recorded in the audit log
+// and the entry it reverted marked undone together with the change, so a
+// concurrent undo of the same entry fails as a whole.
+func (h *Handler) UndoSong(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid song id", http.StatusBadRequest)
+		return
+	}
+
+	undone := false
+	entries, _, err := h.db.GetAuditEntries(database.AuditFilter{Entity: auditSong, EntityID: id, Undone: &undone})
+	if err != nil {
+		http.Error(w, "Failed to fetch audit log", http.StatusInternalServerError)
+		return
+	}
+	var entry *database.AuditEntry
+	for _, e := range entries {
+		if e.Action != database.AuditUndo {
+			entry = e
+			break
+		}
+	}
+	if entry == nil {
+		http.Error(w, "Nothing to undo", http.StatusNotFound)
+		return
+	}
+
+	var before, after songState
+	if len(entry.Before) > 0 {
+		if err := json.Unmarshal(entry.Before, &before); err != nil {
+			http.Error(w, "Failed to read audit log", http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(entry.After) > 0 {
+		if err := json.Unmarshal(entry.After, &after); err != nil {
+			http.Error(w, "Failed to read audit log", http.StatusInternalServerError)
+			return
+		}
+	}
+	library := after.LibraryID
+	if entry.Action == database.AuditDelete {
+		library = before.LibraryID
+	}
+	if !h.inLibrary(w, r, library, "Song not found") {
+		return
+	}
+
+	switch entry.Action {
+	case database.AuditCreate, database.AuditDelete, database.AuditUpdate:
+	default:
+		http.Error(w, "Cannot undo "+entry.Action, http.StatusConflict)
+		return
+	}
+
+	// The undo swaps the states of the change it reverts.
+	undo := &database.AuditEntry{
+		Actor:     actor(r),
+		Action:    database.AuditUndo,
+		Entity:    auditSong,
+		EntityID:  id,
+		LibraryID: library,
+		Before:    entry.After,
+		After:     entry.Before,
+		UndoOf:    entry.ID,
+	}
+	previous := &database.Song{ID: id, Title: before.Title, Artist: before.Artist, Album: before.Album, LibraryID: before.LibraryID}
+	err = h.db.RevertSong(undo, entry.Action, previous, before.Tags)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Nothing to undo", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to undo audit entry %d: %v", entry.ID, err)
+		http.Error(w, "Failed to undo change", http.StatusInternalServerError)
+		return
+	}
+
+	// An undone update may have moved the song to another library.
+	h.libraries.Remove(id)
+	var song *database.Song
+	if entry.Action != database.AuditCreate {
+		if song, err = h.db.GetSong(id); err != nil {
+			writeLookupError(w, err, "Failed to fetch song")
+			return
+		}
+		h.libraries.Add(song)
+	}
+	entry.UndoneAt = &undo.CreatedAt
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"undone": entry,
+		"song":   song,
+	})
+}
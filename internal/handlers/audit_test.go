@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"Shazam/internal/database"
+)
+
+type auditPage struct {
+	Total int                    `json:"total"`
+	Items []*database.AuditEntry `json:"items"`
+}
+
+func TestSongEditsAuditedInTheirLibrary(t *testing.T) {
+	s := newTestServer(t)
+	club := s.library("club")
+	song := s.song(club.ID, "hello", "adele", "25")
+	url := fmt.Sprintf("/api/songs/%d?library=club", song.ID)
+
+	if code := s.do(http.MethodPut, url, `{"title":"hello (live)","artist":"adele","album":"25"}`, nil); code != http.StatusOK {
+		t.Fatalf("PUT = %d", code)
+	}
+	if code := s.do(http.MethodPut, fmt.Sprintf("/api/songs/%d/tags?library=club", song.ID), `{"tags":["Live"]}`, nil); code != http.StatusOK {
+		t.Fatalf("PUT tags = %d", code)
+	}
+
+	var page auditPage
+	s.do(http.MethodGet, "/api/audit?library=club", "", &page)
+	if page.Total != 2 || page.Items[0].LibraryID != club.ID || page.Items[1].LibraryID != club.ID {
+		t.Errorf("audit log of the club = %+v of %d, want both edits", page.Items, page.Total)
+	}
+	s.do(http.MethodGet, "/api/audit", "", &page)
+	if page.Total != 0 {
+		t.Errorf("audit log of the default library has %d entries, want none", page.Total)
+	}
+
+	if code := s.do(http.MethodPost, fmt.Sprintf("/api/songs/%d/undo", song.ID), "", nil); code != http.StatusNotFound {
+		t.Errorf("undo from the default library = %d, want 404", code)
+	}
+
+	// Undo walks back through the edits, recording each undo in the club.
+	for _, want := range []struct {
+		title string
+		tags  int
+	}{{"hello (live)", 0}, {"hello", 0}} {
+		var got struct {
+			Song *database.Song `json:"song"`
+		}
+		if code := s.do(http.MethodPost, fmt.Sprintf("/api/songs/%d/undo?library=club", song.ID), "", &got); code != http.StatusOK {
+			t.Fatalf("undo = %d", code)
+		}
+		tags, _ := s.db.GetSongTags(song.ID)
+		if got.Song.Title != want.title || got.Song.LibraryID != club.ID || len(tags) != want.tags {
+			t.Errorf("after undo song = %q in %d with tags %q, want %q", got.Song.Title, got.Song.LibraryID, tags, want.title)
+		}
+	}
+	if code := s.do(http.MethodPost, fmt.Sprintf("/api/songs/%d/undo?library=club", song.ID), "", nil); code != http.StatusNotFound {
+		t.Errorf("undo with nothing left = %d, want 404", code)
+	}
+	s.do(http.MethodGet, "/api/audit?library=club&action=undo", "", &page)
+	if page.Total != 2 {
+		t.Errorf("club has %d undo entries, want 2", page.Total)
+	}
+}
+
+func TestUndoDeleteRestoresSong(t *testing.T) {
+	s := newTestServer(t)
+	song := s.song(0, "hello", "adele", "25")
+	url := fmt.Sprintf("/api/songs/%d", song.ID)
+	if code := s.do(http.MethodPut, url+"/tags", `{"tags":["live"]}`, nil); code != http.StatusOK {
+		t.Fatalf("PUT tags = %d", code)
+	}
+
+	if code := s.do(http.MethodDelete, url, "", nil); code != http.StatusOK {
+		t.Fatalf("DELETE = %d", code)
+	}
+	if code := s.do(http.MethodGet, url, "", nil); code != http.StatusNotFound {
+		t.Errorf("GET of the deleted song = %d, want 404", code)
+	}
+
+	if code := s.do(http.MethodPost, url+"/undo", "", nil); code != http.StatusOK {
+		t.Fatalf("undo = %d", code)
+	}
+	var got database.Song
+	if code := s.do(http.MethodGet, url, "", &got); code != http.StatusOK || got.Title != "hello" {
+		t.Errorf("GET after undo = %d, %q", code, got.Title)
+	}
+	if tags, _ := s.db.GetSongTags(song.ID); len(tags) != 1 || tags[0] != "live" {
+		t.Errorf("tags after undo = %q, want the ones it was deleted with", tags)
+	}
+	idx, err := s.h.libraries.Index(song.LibraryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := idx.Song(song.ID); !ok {
+		t.Error("restored song is missing from the index")
+	}
+
+	var page auditPage
+	s.do(http.MethodGet, "/api/audit", "", &page)
+	if page.Total != 3 {
+		t.Errorf("audit log has %d entries, want the tags, delete and undo", page.Total)
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"Shazam/internal/backup"
+	"Shazam/internal/database"
+)
+
+// Backups lists the database backups, newest first (GET), or makes one
+// now (POST).
+func (h *Handler) Backups(w http.ResponseWriter, r *http.Request) {
+	if h.backups == nil {
+		http.Error(w, "Backups are not supported by this database", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		backups, err := h.backups.List()
+		if err != nil {
+			http.Error(w, "Failed to list backups", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(backups)
+
+	case http.MethodPost:
+		b, err := h.backups.Create(backup.Manual)
+		if err != nil {
+			writeBackupError(w, err, "Failed to back up database")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(b)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// RestoreBackup replaces the live database with a backup. Monitors are
+// stopped while it is swapped in and the fingerprint index of every
+// library in the backup loaded after. The database as it was is kept as a
+// pre-restore backup, named in the response.
+func (h *Handler) RestoreBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.backups == nil {
+		http.Error(w, "Backups are not supported by this database", http.StatusNotImplemented)
+		return
+	}
+
+	name := r.PathValue("name")
+	h.monitors.StopAll()
+	previous, err := h.backups.Restore(name)
+	if err == nil {
+		err = h.reloadLibraries()
+	}
+	if startErr := h.monitors.StartAll(); startErr != nil {
+		log.Printf("Failed to restart monitors after restore: %v", startErr)
+	}
+	if err != nil {
+		writeBackupError(w, err, "Failed to restore backup")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"restored":    name,
+		"pre_restore": previous,
+	})
+}
+
+// reloadLibraries loads the index of every library afresh.
+func (h *Handler) reloadLibraries() error {
+	libraries, err := h.db.GetLibraries()
+	if err != nil {
+		return err
+	}
+	ids := make([]int, len(libraries))
+	for i, library := range libraries {
+		ids[i] = library.ID
+	}
+	return h.libraries.Reload(ids)
+}
+
+func writeBackupError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, backup.ErrNotFound):
+		http.Error(w, "Backup not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrInvalidBackup):
+		http.Error(w, "Backup is not a valid song database", http.StatusBadRequest)
+	case errors.Is(err, database.ErrBackupUnsupported):
+		http.Error(w, "Backups are not supported by this database", http.StatusNotImplemented)
+	default:
+		log.Printf("%s: %v", message, err)
+		http.Error(w, message, http.StatusInternalServerError)
+	}
+}
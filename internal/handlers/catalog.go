@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"Shazam/internal/database"
+)
+
+// artistPageSize is the default number of artists per page.
+const artistPageSize = 50
+
+// artistView is an artist together with its albums.
+type artistView struct {
+	*database.Artist
+	Albums []*database.Album `json:"albums"`
+}
+
+// albumView is an album together with its songs.
+type albumView struct {
+	*database.Album
+	Songs []*database.SongSummary `json:"songs"`
+}
+
+// Artists lists the artists of the request's library whose name contains
+// q, ignoring case, accents and punctuation, in name order. limit and
+// offset page through them.
+func (h *Handler) Artists(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	library, ok := h.requestLibrary(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	limit := intParam(query.Get("limit"), artistPageSize)
+	offset := intParam(query.Get("offset"), 0)
+
+	artists, total, err := h.db.GetArtists(query.Get("q"), library.ID, limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to fetch artists", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+		"items":  artists,
+	})
+}
+
+// ArtistByID shows an artist with its aliases and its albums in the
+// request's library.
+func (h *Handler) ArtistByID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	view, _, ok := h.lookupArtist(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(view)
+}
+
+// ArtistSongs lists the songs of an artist in the request's library a page
+// at a time, taking the same filters, sorting and cursor as GetSongs.
+func (h *Handler) ArtistSongs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	view, library, ok := h.lookupArtist(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseSongFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.ArtistID = view.ID
+	filter.LibraryID = library.ID
+	h.writeSongPage(w, r, filter)
+}
+
+// MergeArtist merges the artist into the one whose id is "into" in the
+// JSON body, within the request's library. Its songs and albums there move
+// over and, unless it still has songs in other libraries, its names become
+// aliases, so songs added under them later are credited to the same artist.
+func (h *Handler) MergeArtist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	source, library, ok := h.lookupArtist(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Into int `json:"into"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Into == source.ID {
+		http.Error(w, "Cannot merge an artist into itself", http.StatusBadRequest)
+		return
+	}
+
+	before, err := h.artistView(req.Into, library.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Artist not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch artist", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.db.MergeArtists(source.ID, req.Into, library.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Artist not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to merge artists", http.StatusInternalServerError)
+		return
+	}
+
+	// The index answers matches with its own copy of each song.
+	songs, err := h.db.ListSongs(database.SongFilter{ArtistID: req.Into, LibraryID: library.ID})
+	if err != nil {
+		log.Printf("Failed to refresh songs of artist %d: %v", req.Into, err)
+	}
+	for _, song := range songs {
+		h.libraries.Update(song)
+	}
+
+	target, err := h.artistView(req.Into, library.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch artist", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, library.ID, database.AuditDelete, auditArtist, source.ID, source, nil)
+	h.audit(r, library.ID, database.AuditUpdate, auditArtist, target.ID, before, target)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(target)
+}
+
+// AlbumByID shows an album with its songs in the request's library.
+func (h *Handler) AlbumByID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	view, ok := h.lookupAlbum(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(view)
+}
+
+// artistView reads an artist as seen from library, reporting
+// sql.ErrNoRows when it has no songs there.
+func (h *Handler) artistView(id, library int) (*artistView, error) {
+	artist, err := h.db.GetArtist(id, library)
+	if err != nil {
+		return nil, err
+	}
+	albums, err := h.db.GetArtistAlbums(id, library)
+	if err != nil {
+		return nil, err
+	}
+	return &artistView{Artist: artist, Albums: albums}, nil
+}
+
+// lookupArtist resolves the artist of the path in the request's library,
+// answering 404 for artists without songs there.
+func (h *Handler) lookupArtist(w http.ResponseWriter, r *http.Request) (*artistView, *database.Library, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid artist id", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	library, ok := h.requestLibrary(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+
+	view, err := h.artistView(id, library.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Artist not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch artist", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return view, library, true
+}
+
+func (h *Handler) lookupAlbum(w http.ResponseWriter, r *http.Request) (*albumView, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid album id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	library, ok := h.requestLibrary(w, r)
+	if !ok {
+		return nil, false
+	}
+	album, err := h.db.GetAlbum(id, library.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Album not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch album", http.StatusInternalServerError)
+		return nil, false
+	}
+	songs, err := h.db.ListSongSummaries(database.SongFilter{AlbumID: id, LibraryID: library.ID, Sort: "date_added"})
+	if err != nil {
+		http.Error(w, "Failed to fetch album", http.StatusInternalServerError)
+		return nil, false
+	}
+	return &albumView{Album: album, Songs: songs}, true
+}
+
+// ArtistsPage renders the artists in name order with simple paging.
+func (h *Handler) ArtistsPage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	page := intParam(r.URL.Query().Get("page"), 1)
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * artistPageSize
+
+	library, ok := h.requestLibrary(w, r)
+	if !ok {
+		return
+	}
+	artists, total, err := h.db.GetArtists(query, library.ID, artistPageSize, offset)
+	if err != nil {
+		http.Error(w, "Failed to fetch artists", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Title    string
+		Artists  []*database.Artist
+		Total    int
+		Query    string
+		Page     int
+		PrevPage int
+		NextPage int
+	}{
+		Title:   "Artists",
+		Artists: artists,
+		Total:   total,
+		Query:   query,
+		Page:    page,
+	}
+	if page > 1 {
+		data.PrevPage = page - 1
+	}
+	if offset+len(artists) < total {
+		data.NextPage = page + 1
+	}
+
+	h.templates["artists.html"].Execute(w, data)
+}
+
+// ArtistPage renders an artist with its albums and the songs of the
+// request's library.
+func (h *Handler) ArtistPage(w http.ResponseWriter, r *http.Request) {
+	view, library, ok := h.lookupArtist(w, r)
+	if !ok {
+		return
+	}
+	songs, err := h.db.ListSongSummaries(database.SongFilter{ArtistID: view.ID, LibraryID: library.ID, Sort: "title"})
+	if err != nil {
+		http.Error(w, "Failed to fetch songs", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Title  string
+		Artist *artistView
+		Songs  []*database.SongSummary
+	}{
+		Title:  view.Name,
+		Artist: view,
+		Songs:  songs,
+	}
+
+	h.templates["artist.html"].Execute(w, data)
+}
+
+// AlbumPage renders an album with its songs.
+func (h *Handler) AlbumPage(w http.ResponseWriter, r *http.Request) {
+	view, ok := h.lookupAlbum(w, r)
+	if !ok {
+		return
+	}
+
+	data := struct {
+		Title string
+		Album *albumView
+	}{
+		Title: fmt.Sprintf("%s - %s", view.Artist, view.Title),
+		Album: view,
+	}
+
+	h.templates["album.html"].Execute(w, data)
+}
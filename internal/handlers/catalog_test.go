@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"Shazam/internal/database"
+)
+
+type artistPage struct {
+	Total int                `json:"total"`
+	Items []*database.Artist `json:"items"`
+}
+
+func TestArtistsAndMergesStayInTheirLibrary(t *testing.T) {
+	s := newTestServer(t)
+	club := s.library("club")
+	s.song(0, "hello", "Adele", "25")
+	hello := s.song(club.ID, "hello (live)", "Adele", "25")
+	typo := s.song(club.ID, "skyfall", "adel", "")
+
+	var page artistPage
+	s.do(http.MethodGet, "/api/artists", "", &page)
+	if page.Total != 1 || page.Items[0].ID != hello.ArtistID {
+		t.Errorf("default library artists = %+v, want Adele", page.Items)
+	}
+	s.do(http.MethodGet, "/api/artists?library=club", "", &page)
+	if page.Total != 2 {
+		t.Errorf("club artists = %+v, want Adele and adel", page.Items)
+	}
+	if code := s.do(http.MethodGet, fmt.Sprintf("/api/artists/%d", typo.ArtistID), "", nil); code != http.StatusNotFound {
+		t.Errorf("artist of the club from the default library = %d, want 404", code)
+	}
+	if code := s.do(http.MethodGet, fmt.Sprintf("/api/albums/%d?library=club", hello.AlbumID), "", nil); code != http.StatusOK {
+		t.Errorf("album of the club = %d", code)
+	}
+
+	merge := fmt.Sprintf("/api/artists/%d/merge", typo.ArtistID)
+	into := fmt.Sprintf(`{"into":%d}`, hello.ArtistID)
+	for _, test := range []struct {
+		name string
+		url  string
+		body string
+		code int
+	}{
+		{"from the default library", merge, into, http.StatusNotFound},
+		{"into itself", merge + "?library=club", fmt.Sprintf(`{"into":%d}`, typo.ArtistID), http.StatusBadRequest},
+		{"into an unknown artist", merge + "?library=club", `{"into":999}`, http.StatusNotFound},
+	} {
+		if code := s.do(http.MethodPost, test.url, test.body, nil); code != test.code {
+			t.Errorf("merging %s = %d, want %d", test.name, code, test.code)
+		}
+	}
+
+	var merged artistView
+	if code := s.do(http.MethodPost, merge+"?library=club", into, &merged); code != http.StatusOK {
+		t.Fatalf("merge = %d", code)
+	}
+	if merged.ID != hello.ArtistID || merged.SongCount != 2 {
+		t.Errorf("merged artist = %+v, want Adele with both club songs", merged.Artist)
+	}
+	var song database.Song
+	s.do(http.MethodGet, fmt.Sprintf("/api/songs/%d?library=club", typo.ID), "", &song)
+	if song.Artist != "Adele" || song.ArtistID != hello.ArtistID {
+		t.Errorf("merged song is by %q (%d), want Adele (%d)", song.Artist, song.ArtistID, hello.ArtistID)
+	}
+	s.do(http.MethodGet, "/api/artists?library=club", "", &page)
+	if page.Total != 1 {
+		t.Errorf("club artists after the merge = %+v, want Adele alone", page.Items)
+	}
+
+	var log auditPage
+	s.do(http.MethodGet, "/api/audit?library=club", "", &log)
+	if log.Total != 2 {
+		t.Errorf("club audit log has %d entries, want the merge's two", log.Total)
+	}
+}
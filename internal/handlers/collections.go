@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"Shazam/internal/database"
+	"Shazam/internal/matching"
+)
+
+// playlistView is a playlist together with its entries.
+type playlistView struct {
+	*database.Playlist
+	Songs []*database.SongSummary `json:"songs"`
+}
+
+// Tags lists every tag in use in the request's library with the number of
+// its songs carrying it.
+func (h *Handler) Tags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	library, ok := h.requestLibrary(w, r)
+	if !ok {
+		return
+	}
+	tags, err := h.db.GetTags(library.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(tags)
+}
+
+// SongTags shows (GET) or replaces (PUT, {"tags": [...]}) the tags of a
+// song. Tags are trimmed and lower-cased, and replacing them is recorded in
+// the audit log as an update of the song.
+func (h *Handler) SongTags(w http.ResponseWriter, r *http.Request) {
+	song, ok := h.lookupSong(w, r)
+	if !ok {
+		return
+	}
+	id := song.ID
+
+	switch r.Method {
+	case http.MethodGet:
+
+	case http.MethodPut:
+		var req struct {
+			Tags []string `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		before, err := h.songState(song)
+		if err != nil {
+			http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+			return
+		}
+		if err := h.db.SetSongTags(id, req.Tags); err != nil {
+			writeLookupError(w, err, "Failed to update tags")
+			return
+		}
+		if after, err := h.songState(song); err == nil {
+			h.audit(r, song.LibraryID, database.AuditUpdate, auditSong, id, before, after)
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tags, err := h.db.GetSongTags(id)
+	if err != nil {
+		http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string][]string{"tags": tags})
+}
+
+// Playlists lists the playlists of the request's library (GET) or creates
+// one there (POST, {"name"}).
+func (h *Handler) Playlists(w http.ResponseWriter, r *http.Request) {
+	library, ok := h.requestLibrary(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		playlists, err := h.db.GetPlaylists(library.ID)
+		if err != nil {
+			http.Error(w, "Failed to fetch playlists", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(playlists)
+
+	case http.MethodPost:
+		name, ok := decodePlaylistName(w, r)
+		if !ok {
+			return
+		}
+		playlist := &database.Playlist{Name: name, LibraryID: library.ID}
+		if err := h.db.AddPlaylist(playlist); err != nil {
+			http.Error(w, "Failed to add playlist", http.StatusInternalServerError)
+			return
+		}
+
+		view := playlistView{Playlist: playlist, Songs: []*database.SongSummary{}}
+		h.audit(r, playlist.LibraryID, database.AuditCreate, auditPlaylist, playlist.ID, nil, view)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(view)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// PlaylistByID shows (GET), renames (PUT, {"name"}) or deletes (DELETE) a
+// playlist.
+func (h *Handler) PlaylistByID(w http.ResponseWriter, r *http.Request) {
+	view, ok := h.lookupPlaylist(w, r)
+	if !ok {
+		return
+	}
+	playlist := *view.Playlist
+	before := playlistView{Playlist: &playlist, Songs: view.Songs}
+
+	switch r.Method {
+	case http.MethodGet:
+
+	case http.MethodPut:
+		name, ok := decodePlaylistName(w, r)
+		if !ok {
+			return
+		}
+		if err := h.db.RenamePlaylist(view.ID, name); err != nil {
+			writePlaylistError(w, err, "Failed to rename playlist")
+			return
+		}
+		view.Name = name
+		h.audit(r, view.LibraryID, database.AuditUpdate, auditPlaylist, view.ID, before, view)
+
+	case http.MethodDelete:
+		if err := h.db.DeletePlaylist(view.ID); err != nil {
+			writePlaylistError(w, err, "Failed to delete playlist")
+			return
+		}
+		h.audit(r, view.LibraryID, database.AuditDelete, auditPlaylist, view.ID, before, nil)
+		w.WriteHeader(http.StatusNoContent)
+		return
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(view)
+}
+
+// PlaylistSongs lists the entries of a playlist (GET), appends a song
+// (POST, {"song_id"}) or replaces them all in a new order (PUT,
+// {"song_ids": [...]}), which also serves to remove entries.
+func (h *Handler) PlaylistSongs(w http.ResponseWriter, r *http.Request) {
+	view, ok := h.lookupPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(view.Songs)
+		return
+
+	case http.MethodPost:
+		var req struct {
+			SongID int `json:"song_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		err = h.db.AddPlaylistSong(view.ID, req.SongID)
+
+	case http.MethodPut:
+		var req struct {
+			SongIDs []int `json:"song_ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		err = h.db.SetPlaylistSongs(view.ID, req.SongIDs)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		writePlaylistError(w, err, "Failed to update playlist")
+		return
+	}
+	before := view
+	if view, ok = h.lookupPlaylist(w, r); !ok {
+		return
+	}
+	h.audit(r, view.LibraryID, database.AuditUpdate, auditPlaylist, view.ID, before, view)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(view)
+}
+
+func decodePlaylistName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return "", false
+	}
+	if req.Name = strings.TrimSpace(req.Name); req.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return "", false
+	}
+	return req.Name, true
+}
+
+func (h *Handler) lookupPlaylist(w http.ResponseWriter, r *http.Request) (*playlistView, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid playlist id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	playlist, err := h.db.GetPlaylist(id)
+	if err != nil {
+		writePlaylistError(w, err, "Failed to fetch playlist")
+		return nil, false
+	}
+	if !h.inLibrary(w, r, playlist.LibraryID, "Playlist not found") {
+		return nil, false
+	}
+	songs, err := h.db.GetPlaylistSongs(id)
+	if err != nil {
+		http.Error(w, "Failed to fetch playlist", http.StatusInternalServerError)
+		return nil, false
+	}
+	return &playlistView{Playlist: playlist, Songs: songs}, true
+}
+
+// writePlaylistError maps a missing playlist to 404, a missing song or one
+// of another library to 400 and anything else to 500. The store names the
+// song when it is the one missing.
+func writePlaylistError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, database.ErrOtherLibrary):
+		http.Error(w, "Song is in another library", http.StatusBadRequest)
+	case errors.Is(err, sql.ErrNoRows) && strings.HasPrefix(err.Error(), "song "):
+		http.Error(w, "Unknown song", http.StatusBadRequest)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Playlist not found", http.StatusNotFound)
+	default:
+		http.Error(w, message, http.StatusInternalServerError)
+	}
+}
+
+// identifyScope reads the playlist and tag parameters that restrict an
+// identification to part of library, such as one event's setlist. With
+// both, only songs of the playlist carrying the tag are considered; with
+// neither, every song is. Playlists of other libraries are not found.
+func (h *Handler) identifyScope(w http.ResponseWriter, r *http.Request, library int) (matching.SongSet, bool) {
+	query := r.URL.Query()
+	var songs matching.SongSet
+
+	if v := query.Get("playlist"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid playlist", http.StatusBadRequest)
+			return nil, false
+		}
+		playlist, err := h.db.GetPlaylist(id)
+		if err != nil {
+			writePlaylistError(w, err, "Failed to fetch playlist")
+			return nil, false
+		}
+		if playlist.LibraryID != library {
+			http.Error(w, "Playlist not found", http.StatusNotFound)
+			return nil, false
+		}
+		entries, err := h.db.GetPlaylistSongs(id)
+		if err != nil {
+			http.Error(w, "Failed to fetch playlist", http.StatusInternalServerError)
+			return nil, false
+		}
+		songs = matching.SongSet{}
+		for _, song := range entries {
+			songs[song.ID] = true
+		}
+	}
+
+	if tag := query.Get("tag"); tag != "" {
+		tagged, err := h.db.ListSongSummaries(database.SongFilter{Tag: tag, LibraryID: library})
+		if err != nil {
+			http.Error(w, "Failed to fetch tagged songs", http.StatusInternalServerError)
+			return nil, false
+		}
+		inTag := matching.SongSet{}
+		for _, song := range tagged {
+			if songs.Allows(song.ID) {
+				inTag[song.ID] = true
+			}
+		}
+		songs = inTag
+	}
+
+	return songs, true
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"Shazam/internal/database"
+)
+
+func TestPlaylistsStayInTheirLibrary(t *testing.T) {
+	s := newTestServer(t)
+	club := s.library("club")
+	home := s.song(0, "hello", "adele", "25")
+	song := s.song(club.ID, "rolling", "adele", "21")
+
+	var created playlistView
+	if code := s.do(http.MethodPost, "/api/playlists?library=club", `{"name":" friday "}`, &created); code != http.StatusCreated {
+		t.Fatalf("POST = %d", code)
+	}
+	if created.Name != "friday" || created.LibraryID != club.ID {
+		t.Errorf("created %+v, want friday in the club", created.Playlist)
+	}
+
+	var playlists []*database.Playlist
+	s.do(http.MethodGet, "/api/playlists", "", &playlists)
+	if len(playlists) != 0 {
+		t.Errorf("default library lists %d playlists, want none", len(playlists))
+	}
+	s.do(http.MethodGet, "/api/playlists?library=club", "", &playlists)
+	if len(playlists) != 1 || playlists[0].ID != created.ID {
+		t.Errorf("club lists %+v, want friday", playlists)
+	}
+
+	url := fmt.Sprintf("/api/playlists/%d", created.ID)
+	if code := s.do(http.MethodGet, url, "", nil); code != http.StatusNotFound {
+		t.Errorf("GET from the default library = %d, want 404", code)
+	}
+
+	for _, test := range []struct {
+		name string
+		body string
+		code int
+	}{
+		{"song of another library", fmt.Sprintf(`{"song_id":%d}`, home.ID), http.StatusBadRequest},
+		{"unknown song", `{"song_id":999}`, http.StatusBadRequest},
+		{"song of the club", fmt.Sprintf(`{"song_id":%d}`, song.ID), http.StatusOK},
+		{"same song again", fmt.Sprintf(`{"song_id":%d}`, song.ID), http.StatusOK},
+	} {
+		if code := s.do(http.MethodPost, url+"/songs?library=club", test.body, nil); code != test.code {
+			t.Errorf("adding the %s = %d, want %d", test.name, code, test.code)
+		}
+	}
+
+	var view playlistView
+	s.do(http.MethodGet, url+"?library=club", "", &view)
+	if len(view.Songs) != 2 || view.Songs[0].ID != song.ID || view.Songs[1].ID != song.ID {
+		t.Errorf("entries = %+v, want the club song twice", view.Songs)
+	}
+	if code := s.do(http.MethodPut, url+"/songs?library=club", `{"song_ids":[]}`, &view); code != http.StatusOK || len(view.Songs) != 0 {
+		t.Errorf("clearing = %d with %d entries left", code, len(view.Songs))
+	}
+
+	if code := s.do(http.MethodDelete, url, "", nil); code != http.StatusNotFound {
+		t.Errorf("DELETE from the default library = %d, want 404", code)
+	}
+	if code := s.do(http.MethodDelete, url+"?library=club", "", nil); code != http.StatusNoContent {
+		t.Errorf("DELETE = %d", code)
+	}
+}
+
+func TestSongTagsAndTagCounts(t *testing.T) {
+	s := newTestServer(t)
+	club := s.library("club")
+	song := s.song(club.ID, "hello", "adele", "25")
+	s.song(club.ID, "skyfall", "adele", "")
+	url := fmt.Sprintf("/api/songs/%d/tags", song.ID)
+
+	if code := s.do(http.MethodPut, url, `{"tags":["live"]}`, nil); code != http.StatusNotFound {
+		t.Errorf("PUT from the default library = %d, want 404", code)
+	}
+
+	var got struct {
+		Tags []string `json:"tags"`
+	}
+	if code := s.do(http.MethodPut, url+"?library=club", `{"tags":[" Live ","acoustic"]}`, &got); code != http.StatusOK {
+		t.Fatalf("PUT = %d", code)
+	}
+	if want := []string{"acoustic", "live"}; !reflect.DeepEqual(got.Tags, want) {
+		t.Errorf("tags = %q, want %q", got.Tags, want)
+	}
+
+	var counts []database.TagCount
+	s.do(http.MethodGet, "/api/tags?library=club", "", &counts)
+	if want := []database.TagCount{{Tag: "acoustic", Songs: 1}, {Tag: "live", Songs: 1}}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("club tags = %+v, want %+v", counts, want)
+	}
+	s.do(http.MethodGet, "/api/tags", "", &counts)
+	if len(counts) != 0 {
+		t.Errorf("default library tags = %+v, want none", counts)
+	}
+}
@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"Shazam/internal/audio"
+	"Shazam/internal/database"
+	"Shazam/internal/matching"
+)
+
+// historyCandidates is how many ranked songs are kept with each attempt.
+const historyCandidates = 5
+
+// historyPageSize is the number of attempts shown per history page.
+const historyPageSize = 50
+
+// identify matches a fingerprint against the songs of a library in songs,
+// or all of them when nil, and records the attempt in the library's
+// identification history. The best result is returned even if recording
+// fails. With covers set, a clip without an exact match is also compared
+// with the harmony of every song, which is much slower.
+func (h *Handler) identify(source string, library int, fp *audio.AudioFingerprint, songs matching.SongSet, covers bool) (*database.MatchResult, *database.Identification, error) {
+	start := time.Now()
+	idx, err := h.libraries.Index(library)
+	if err != nil {
+		return nil, nil, err
+	}
+	top, err := matching.GetTopMatchesIn(idx, fp, historyCandidates, songs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Without an exact match the clip may still be a cover or live
+	// version of a library song.
+	var possible []database.PossibleCover
+	if covers && (len(top) == 0 || !top[0].IsMatch) {
+		opts := matching.DefaultCoverOptions()
+		opts.Songs = songs
+		possible = matching.FindCovers(idx, fp.Chroma, opts)
+	}
+
+	best, ident := h.recordIdentification(source, library, fp.HashSegments, matching.QuerySeconds(fp.HashSegments), top, possible, time.Since(start))
+	return best, ident, nil
+}
+
+// identifyShifted is identify for clips that may be sped up, slowed down
+// or transposed. The query is not kept, as its hashes depend on the shift
+// each song was matched at, but its duration is that of the unshifted
+// clip as in identify.
+func (h *Handler) identifyShifted(source string, library int, samples []float64, opts matching.ShiftOptions) (*database.MatchResult, *database.Identification, error) {
+	start := time.Now()
+	idx, err := h.libraries.Index(library)
+	if err != nil {
+		return nil, nil, err
+	}
+	fp, err := audio.GenerateFingerprint(samples)
+	if err != nil {
+		return nil, nil, err
+	}
+	top, err := matching.GetTopMatchesShifted(idx, samples, historyCandidates, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	best, ident := h.recordIdentification(source, library, nil, matching.QuerySeconds(fp.HashSegments), top, nil, time.Since(start))
+	return best, ident, nil
+}
+
+func (h *Handler) recordIdentification(source string, library int, query database.HashSegments, duration float64,
+	top []*database.MatchResult, covers []database.PossibleCover, latency time.Duration) (*database.MatchResult, *database.Identification) {
+	best := &database.MatchResult{IsMatch: false, Confidence: 0.0}
+	if len(top) > 0 {
+		best = top[0]
+	}
+
+	ident := &database.Identification{
+		Source:        source,
+		Duration:      duration,
+		IsMatch:       best.IsMatch,
+		Confidence:    best.Confidence,
+		TimeInSong:    best.TimeInSong,
+		LatencyMillis: latency.Milliseconds(),
+		Candidates:    make([]database.IdentifiedCandidate, 0, len(top)),
+		Covers:        covers,
+		Query:         query,
+		LibraryID:     library,
+	}
+	if best.Song != nil {
+		ident.SongID = best.Song.ID
+		ident.Title = best.Song.Title
+		ident.Artist = best.Song.Artist
+	}
+	for _, r := range top {
+		ident.Candidates = append(ident.Candidates, database.IdentifiedCandidate{
+			SongID:      r.Song.ID,
+			Title:       r.Song.Title,
+			Artist:      r.Song.Artist,
+			Album:       r.Song.Album,
+			Confidence:  r.Confidence,
+			MatchOffset: r.MatchOffset,
+			TimeInSong:  r.TimeInSong,
+			TempoFactor: r.TempoFactor,
+			PitchFactor: r.PitchFactor,
+		})
+	}
+
+	if err := h.db.AddIdentification(ident); err != nil {
+		log.Printf("Failed to record identification: %v", err)
+	}
+
+	return best, ident
+}
+
+// History lists identification attempts, newest first. It supports
+// limit/offset paging and filtering by source, song_id, matched,
+// min_confidence and a from/to time range. Only attempts made in the
+// request's library are listed.
+func (h *Handler) History(w http.ResponseWriter, r *http.Request) {
+	library, ok := h.requestLibrary(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseHistoryFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.LibraryID = library.ID
+	filter.Limit = intParam(r.URL.Query().Get("limit"), historyPageSize)
+	filter.Offset = intParam(r.URL.Query().Get("offset"), 0)
+
+	items, total, err := h.db.GetIdentifications(filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":  total,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+		"items":  items,
+	})
+}
+
+// HistoryByID returns a single identification attempt.
+func (h *Handler) HistoryByID(w http.ResponseWriter, r *http.Request) {
+	ident, ok := h.lookupIdentification(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(ident)
+}
+
+// HistoryExplain returns the score curves of an identification's query
+// along its candidates, as the explain option of /api/identify does.
+func (h *Handler) HistoryExplain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ident, ok := h.lookupIdentification(w, r)
+	if !ok {
+		return
+	}
+	var err error
+	if ident.Query, err = h.db.GetIdentificationQuery(ident.ID); err != nil {
+		http.Error(w, "Failed to fetch identification", http.StatusInternalServerError)
+		return
+	}
+
+	explanation := h.explain(ident)
+	if explanation == nil {
+		http.Error(w, "No query kept for this identification", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(explanation)
+}
+
+// lookupIdentification fetches the identification named by the id path
+// value, answering 404 when it is missing or was made in another library
+// than the request's.
+func (h *Handler) lookupIdentification(w http.ResponseWriter, r *http.Request) (*database.Identification, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid history id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	ident, err := h.db.GetIdentification(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Identification not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch identification", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !h.inLibrary(w, r, ident.LibraryID, "Identification not found") {
+		return nil, false
+	}
+	return ident, true
+}
+
+// explain lays the query of ident along each of its candidates at the
+// offset it was reported at, in the library it was made in. It returns nil
+// when the query was not kept.
+func (h *Handler) explain(ident *database.Identification) *matching.Explanation {
+	if len(ident.Query) == 0 {
+		return nil
+	}
+	idx, err := h.libraries.Index(ident.LibraryID)
+	if err != nil {
+		log.Printf("Failed to load index of library %d: %v", ident.LibraryID, err)
+		return nil
+	}
+	targets := make([]matching.ExplainTarget, len(ident.Candidates))
+	for i, c := range ident.Candidates {
+		targets[i] = matching.ExplainTarget{SongID: c.SongID, Offset: c.MatchOffset}
+	}
+	return matching.Explain(idx, ident.Query, targets, matching.DefaultExplainOptions())
+}
+
+// ConfirmHistory records which song was really playing for an
+// identification. The body is {"song_id": N}; 0 or null means none of the
+// candidates was right. The song must be in the identification's library.
+func (h *Handler) ConfirmHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ident, ok := h.lookupIdentification(w, r)
+	if !ok {
+		return
+	}
+	idx, ok := h.loadIndex(w, ident.LibraryID)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		SongID int `json:"song_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.SongID != 0 {
+		if _, ok := idx.Song(req.SongID); !ok {
+			http.Error(w, "Song not found", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := h.db.ConfirmIdentification(ident.ID, req.SongID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Identification not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to record confirmation", http.StatusInternalServerError)
+		return
+	}
+	if confirmed, err := h.db.GetIdentification(ident.ID); err == nil {
+		h.audit(r, ident.LibraryID, database.AuditUpdate, auditIdentification, ident.ID, ident, confirmed)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Thanks for the feedback",
+	})
+}
+
+// Accuracy reports how often confirmed identifications of the request's
+// library were right.
+func (h *Handler) Accuracy(w http.ResponseWriter, r *http.Request) {
+	library, ok := h.requestLibrary(w, r)
+	if !ok {
+		return
+	}
+	stats, err := h.db.GetAccuracyStats(library.ID)
+	if err != nil {
+		http.Error(w, "Failed to compute accuracy", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(stats)
+}
+
+// HistoryPage renders the identification history with simple paging.
+func (h *Handler) HistoryPage(w http.ResponseWriter, r *http.Request) {
+	library, ok := h.requestLibrary(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseHistoryFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.LibraryID = library.ID
+
+	page := intParam(r.URL.Query().Get("page"), 1)
+	if page < 1 {
+		page = 1
+	}
+	filter.Limit = historyPageSize
+	filter.Offset = (page - 1) * historyPageSize
+
+	items, total, err := h.db.GetIdentifications(filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch history", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Title    string
+		Items    []*database.Identification
+		Total    int
+		Page     int
+		PrevPage int
+		NextPage int
+		Source   string
+	}{
+		Title:  "Identification History",
+		Items:  items,
+		Total:  total,
+		Page:   page,
+		Source: filter.Source,
+	}
+	if page > 1 {
+		data.PrevPage = page - 1
+	}
+	if filter.Offset+len(items) < total {
+		data.NextPage = page + 1
+	}
+
+	h.templates["history.html"].Execute(w, data)
+}
+
+func parseHistoryFilter(r *http.Request) (database.HistoryFilter, error) {
+	query := r.URL.Query()
+	filter := database.HistoryFilter{
+		Source: query.Get("source"),
+		SongID: intParam(query.Get("song_id"), 0),
+	}
+
+	if v := query.Get("matched"); v != "" {
+		matched, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, errors.New("Invalid matched")
+		}
+		filter.Matched = &matched
+	}
+	if v := query.Get("min_confidence"); v != "" {
+		conf, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return filter, errors.New("Invalid min_confidence")
+		}
+		filter.MinConfidence = conf
+	}
+
+	var err error
+	if filter.From, err = parseTimeParam(query.Get("from")); err != nil {
+		return filter, errors.New("Invalid from")
+	}
+	if filter.To, err = parseTimeParam(query.Get("to")); err != nil {
+		return filter, errors.New("Invalid to")
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"Shazam/internal/audio"
+	"Shazam/internal/database"
+	"Shazam/internal/matching"
+)
+
+// chords synthesises seconds of audio changing between random three-tone
+// chords five times a second.
+func chords(seconds float64, seed int64) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	samples := make([]float64, int(seconds*audio.SampleRate))
+	var tones [3]float64
+	for i := range samples {
+		if i%(audio.SampleRate/5) == 0 {
+			for j := range tones {
+				tones[j] = 200 + rng.Float64()*3000
+			}
+		}
+		t := float64(i) / audio.SampleRate
+		for _, f := range tones {
+			samples[i] += 0.2 * math.Sin(2*math.Pi*f*t)
+		}
+		samples[i] += 0.01 * rng.NormFloat64()
+	}
+	return samples
+}
+
+// TestIdentifyShiftedRecordsShift identifies an excerpt played 10% faster and checks
+// that its history keeps the shift and measures the clip as identify does.
+func TestIdentifyShiftedRecordsShift(t *testing.T) {
+	s := newTestServer(t)
+	recording := chords(30, 1)
+	fp, err := audio.GenerateFingerprint(recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	song := &database.Song{Title: "tune", Artist: "band", Fingerprint: fp.Fingerprint,
+		HashSegments: fp.HashSegments, HashOffset: fp.Offset}
+	if err := s.db.AddSong(song); err != nil {
+		t.Fatal(err)
+	}
+
+	part := recording[10*audio.SampleRate : 20*audio.SampleRate]
+	clip := make([]float64, int(float64(len(part))/1.1))
+	for i := range clip {
+		clip[i] = part[int(float64(i)*1.1)]
+	}
+	best, ident, err := s.h.identifyShifted("upload", song.LibraryID, clip, matching.ShiftOptions{MaxTempo: 0.1, Step: 0.02})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.db.GetIdentification(ident.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Candidates) == 0 || got.Candidates[0].SongID != song.ID {
+		t.Fatalf("candidates = %+v, want %q first", got.Candidates, song.Title)
+	}
+	if c := got.Candidates[0]; c.TempoFactor == 1 || c.TempoFactor != best.TempoFactor || c.PitchFactor != best.PitchFactor {
+		t.Errorf("candidate shift = %.2f, %.2f, want the %.2f, %.2f matched at", c.TempoFactor, c.PitchFactor, best.TempoFactor, best.PitchFactor)
+	}
+	unshifted, err := audio.GenerateFingerprint(clip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := matching.QuerySeconds(unshifted.HashSegments); got.Duration != want {
+		t.Errorf("duration = %.2f, want %.2f as identify measures it", got.Duration, want)
+	}
+}
+
+// TestIdentifySearchesCoversWhenAsked identifies a clip whose harmony, but
+// not whose fingerprint, is that of a song, with and without covers.
+func TestIdentifySearchesCoversWhenAsked(t *testing.T) {
+	s := newTestServer(t)
+	fp, err := audio.GenerateFingerprint(chords(20, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(3))
+	hashes := make(database.HashSegments, len(fp.HashSegments))
+	for i := range hashes {
+		hashes[i] = rng.Uint64() | 1
+	}
+	song := &database.Song{Title: "original", Artist: "band", Fingerprint: "other",
+		HashSegments: hashes, Chroma: fp.Chroma}
+	if err := s.db.AddSong(song); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, covers := range []bool{false, true} {
+		best, ident, err := s.h.identify("upload", song.LibraryID, fp, nil, covers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if best.IsMatch {
+			t.Fatalf("covers=%v: the clip matched %q exactly", covers, best.Song.Title)
+		}
+		if found := len(ident.Covers) > 0 && ident.Covers[0].SongID == song.ID; found != covers {
+			t.Errorf("covers=%v: covers = %+v", covers, ident.Covers)
+		}
+	}
+}
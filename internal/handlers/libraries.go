@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"Shazam/internal/database"
+	"Shazam/internal/matching"
+)
+
+// libraryHeader names the library a request works in when the library
+// query parameter does not.
+const libraryHeader = "X-Library"
+
+// libraryView is a library with the statistics of its index and of the
+// identifications reviewed in it.
+type libraryView struct {
+	*database.Library
+	Index    matching.IndexStats     `json:"index"`
+	Accuracy *database.AccuracyStats `json:"accuracy"`
+}
+
+// requestLibrary resolves the library a request works in, named by the
+// library query parameter or the X-Library header, and the default library
+// when neither is given. Songs are listed, searched, added and identified
+// in it, and songs, identifications and monitors of other libraries are not
+// found through it.
+func (h *Handler) requestLibrary(w http.ResponseWriter, r *http.Request) (*database.Library, bool) {
+	name := r.URL.Query().Get("library")
+	if name == "" {
+		name = r.Header.Get(libraryHeader)
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		name = database.DefaultLibrary
+	}
+
+	library, err := h.db.GetLibraryByName(name)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Library not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch library", http.StatusInternalServerError)
+		return nil, false
+	}
+	return library, true
+}
+
+// libraryIndex resolves the library of a request together with its index.
+func (h *Handler) libraryIndex(w http.ResponseWriter, r *http.Request) (*database.Library, *matching.Index, bool) {
+	library, ok := h.requestLibrary(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	idx, ok := h.loadIndex(w, library.ID)
+	if !ok {
+		return nil, nil, false
+	}
+	return library, idx, true
+}
+
+// loadIndex returns the index of a library, loading it if needed.
+func (h *Handler) loadIndex(w http.ResponseWriter, library int) (*matching.Index, bool) {
+	idx, err := h.libraries.Index(library)
+	if err != nil {
+		http.Error(w, "Failed to load fingerprint index", http.StatusInternalServerError)
+		return nil, false
+	}
+	return idx, true
+}
+
+// inLibrary reports whether a row of library is visible to the request,
+// answering notFound with a 404 when it is not.
+func (h *Handler) inLibrary(w http.ResponseWriter, r *http.Request, library int, notFound string) bool {
+	current, ok := h.requestLibrary(w, r)
+	if !ok {
+		return false
+	}
+	if library != current.ID {
+		http.Error(w, notFound, http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// Libraries lists the libraries with their statistics (GET) or creates one
+// (POST, {"name"}).
+func (h *Handler) Libraries(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		libraries, err := h.db.GetLibraries()
+		if err != nil {
+			http.Error(w, "Failed to fetch libraries", http.StatusInternalServerError)
+			return
+		}
+		views := make([]*libraryView, len(libraries))
+		for i, library := range libraries {
+			if views[i], err = h.libraryView(library); err != nil {
+				http.Error(w, "Failed to fetch libraries", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(views)
+
+	case http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.Name) == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+
+		library := &database.Library{Name: req.Name}
+		err := h.db.AddLibrary(library)
+		if errors.Is(err, database.ErrLibraryExists) {
+			http.Error(w, "Library already exists", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to add library", http.StatusInternalServerError)
+			return
+		}
+		// Libraries themselves are logged in the default library, which
+		// outlives them all.
+		h.audit(r, 0, database.AuditCreate, auditLibrary, library.ID, nil, library)
+		view, err := h.libraryView(library)
+		if err != nil {
+			http.Error(w, "Failed to fetch library", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(view)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// LibraryByID shows a library with its statistics (GET) or deletes it
+// (DELETE). Only empty libraries other than the default one can be
+// deleted, and their identification history goes with them.
+func (h *Handler) LibraryByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid library id", http.StatusBadRequest)
+		return
+	}
+	library, err := h.db.GetLibrary(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Library not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch library", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		view, err := h.libraryView(library)
+		if err != nil {
+			http.Error(w, "Failed to fetch library", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(view)
+
+	case http.MethodDelete:
+		err := h.db.DeleteLibrary(id)
+		if errors.Is(err, database.ErrLibraryInUse) {
+			http.Error(w, "Library still has songs or monitors, or is the default", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to delete library", http.StatusInternalServerError)
+			return
+		}
+		h.libraries.Drop(id)
+		h.audit(r, 0, database.AuditDelete, auditLibrary, id, library, nil)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) libraryView(library *database.Library) (*libraryView, error) {
+	idx, err := h.libraries.Index(library.ID)
+	if err != nil {
+		return nil, err
+	}
+	accuracy, err := h.db.GetAccuracyStats(library.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &libraryView{Library: library, Index: idx.Stats(), Accuracy: accuracy}, nil
+}
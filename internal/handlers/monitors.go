@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"Shazam/internal/database"
+	"Shazam/internal/monitor"
+)
+
+type monitorView struct {
+	*database.Monitor
+	Status monitor.Status `json:"status"`
+}
+
+// Monitors lists the stream monitors of the request's library (GET) or
+// creates one in it (POST). A monitor identifies its stream against the
+// songs of its library.
+func (h *Handler) Monitors(w http.ResponseWriter, r *http.Request) {
+	library, ok := h.requestLibrary(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		monitors, err := h.db.GetMonitors()
+		if err != nil {
+			http.Error(w, "Failed to fetch monitors", http.StatusInternalServerError)
+			return
+		}
+
+		views := []monitorView{}
+		for _, m := range monitors {
+			if m.LibraryID == library.ID {
+				views = append(views, monitorView{Monitor: m, Status: h.monitors.Status(m.ID)})
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(views)
+
+	case http.MethodPost:
+		var req struct {
+			Name    string `json:"name"`
+			URL     string `json:"url"`
+			Enabled *bool  `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := monitor.ValidateURL(req.URL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Name == "" {
+			req.Name = req.URL
+		}
+
+		m := &database.Monitor{Name: req.Name, URL: req.URL, Enabled: req.Enabled == nil || *req.Enabled, LibraryID: library.ID}
+		if err := h.db.AddMonitor(m); err != nil {
+			http.Error(w, "Failed to add monitor", http.StatusInternalServerError)
+			return
+		}
+		if m.Enabled {
+			h.monitors.Start(m)
+		}
+		h.audit(r, m.LibraryID, database.AuditCreate, auditMonitor, m.ID, nil, m)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(monitorView{Monitor: m, Status: h.monitors.Status(m.ID)})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// MonitorByID shows (GET), enables or disables (PUT) or deletes (DELETE) a
+// monitor.
+func (h *Handler) MonitorByID(w http.ResponseWriter, r *http.Request) {
+	m, ok := h.lookupMonitor(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		// The monitor is written out below.
+
+	case http.MethodPut:
+		var req struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := h.db.SetMonitorEnabled(m.ID, req.Enabled); err != nil {
+			http.Error(w, "Failed to update monitor", http.StatusInternalServerError)
+			return
+		}
+		before := *m
+		m.Enabled = req.Enabled
+		h.audit(r, m.LibraryID, database.AuditUpdate, auditMonitor, m.ID, &before, m)
+		if m.Enabled {
+			h.monitors.Start(m)
+		} else {
+			h.monitors.Stop(m.ID)
+		}
+
+	case http.MethodDelete:
+		h.monitors.Stop(m.ID)
+		if err := h.db.DeleteMonitor(m.ID); err != nil {
+			http.Error(w, "Failed to delete monitor", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, m.LibraryID, database.AuditDelete, auditMonitor, m.ID, m, nil)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"status":  "success",
+			"message": fmt.Sprintf("Deleted monitor %d", m.ID),
+		})
+		return
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(monitorView{Monitor: m, Status: h.monitors.Status(m.ID)})
+}
+
+// MonitorPlays lists logged airplay for a monitor, newest first. Optional
+// from/to bound the start time and limit/offset page through the log.
+func (h *Handler) MonitorPlays(w http.ResponseWriter, r *http.Request) {
+	m, ok := h.lookupMonitor(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := parseTimeParam(query.Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from", http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(query.Get("to"))
+	if err != nil {
+		http.Error(w, "Invalid to", http.StatusBadRequest)
+		return
+	}
+	limit := intParam(query.Get("limit"), 100)
+	offset := intParam(query.Get("offset"), 0)
+
+	plays, err := h.db.GetAirplays(m.ID, from, to, limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to fetch plays", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(plays)
+}
+
+// MonitorReport summarises a monitor's airplay for one UTC day given as
+// date=YYYY-MM-DD, defaulting to today.
+func (h *Handler) MonitorReport(w http.ResponseWriter, r *http.Request) {
+	m, ok := h.lookupMonitor(w, r)
+	if !ok {
+		return
+	}
+
+	day := time.Now().UTC()
+	if v := r.URL.Query().Get("date"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			http.Error(w, "Invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		day = parsed
+	}
+
+	songs, err := h.db.GetAirplayReport(m.ID, day)
+	if err != nil {
+		http.Error(w, "Failed to build report", http.StatusInternalServerError)
+		return
+	}
+
+	plays, seconds := 0, 0.0
+	for _, s := range songs {
+		plays += s.Plays
+		seconds += s.Seconds
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"monitor_id": m.ID,
+		"date":       day.Format("2006-01-02"),
+		"plays":      plays,
+		"seconds":    seconds,
+		"songs":      songs,
+	})
+}
+
+func (h *Handler) lookupMonitor(w http.ResponseWriter, r *http.Request) (*database.Monitor, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid monitor id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	m, err := h.db.GetMonitor(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Monitor not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch monitor", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !h.inLibrary(w, r, m.LibraryID, "Monitor not found") {
+		return nil, false
+	}
+	return m, true
+}
+
+// parseTimeParam accepts RFC 3339 timestamps or plain YYYY-MM-DD dates.
+// An empty value yields the zero time.
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
+
+// intParam parses a non-negative integer query value, falling back to def.
+func intParam(v string, def int) int {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"Shazam/internal/audio"
+	"Shazam/internal/matching"
+)
+
+// maxUploadMemory is how much of a multipart upload is buffered in memory
+// before the rest spills to disk.
+const maxUploadMemory = 32 << 20
+
+// Tracklist accepts an uploaded mix or long recording and returns the
+// songs it contains with their start and end times, as JSON or, with
+// format=cue, as a CUE sheet. Only songs of the request's library are
+// looked for.
+func (h *Handler) Tracklist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_, idx, ok := h.libraryIndex(w, r)
+	if !ok {
+		return
+	}
+
+	opts := matching.DefaultSegmentOptions()
+	query := r.URL.Query()
+	for name, target := range map[string]*float64{
+		"window": &opts.WindowSeconds,
+		"hop":    &opts.HopSeconds,
+		"min":    &opts.MinSeconds,
+	} {
+		if v := query.Get(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f <= 0 {
+				http.Error(w, fmt.Sprintf("Invalid %s", name), http.StatusBadRequest)
+				return
+			}
+			*target = f
+		}
+	}
+
+	path, name, err := h.saveUpload(r, "file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer os.Remove(path)
+
+	fp, err := audio.ProcessLongAudioFile(path)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to process audio: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	segments := matching.FindTracklist(idx, fp, opts)
+
+	if query.Get("format") == "cue" {
+		w.Header().Set("Content-Type", "application/x-cue")
+		_ = matching.WriteCUE(w, name, name, segments)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"file":     name,
+		"duration": float64(fp.Offset+len(fp.HashSegments)) * audio.HopSize / audio.SampleRate,
+		"tracks":   segments,
+	})
+}
+
+// saveUpload copies the multipart file in field to the temp directory and
+// returns its path along with the client-supplied file name.
+func (h *Handler) saveUpload(r *http.Request, field string) (string, string, error) {
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		return "", "", fmt.Errorf("invalid upload: %v", err)
+	}
+
+	file, header, err := r.FormFile(field)
+	if err != nil {
+		return "", "", fmt.Errorf("form field '%s' is required", field)
+	}
+	defer file.Close()
+
+	name := filepath.Base(header.Filename)
+	path := filepath.Join(h.config.TempDir,
+		fmt.Sprintf("upload_%d%s", time.Now().UnixNano(), filepath.Ext(name)))
+
+	out, err := os.Create(path)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := io.Copy(out, file); err != nil {
+		out.Close()
+		os.Remove(path)
+		return "", "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(path)
+		return "", "", err
+	}
+
+	return path, name, nil
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"Shazam/internal/audio"
+	"Shazam/internal/database"
+	"Shazam/internal/matching"
+	"Shazam/internal/visual"
+)
+
+// SongSpectrogram renders the spectrogram of a song's source audio as a
+// PNG. The optional from and seconds parameters crop it to part of the
+// song.
+func (h *Handler) SongSpectrogram(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	song, ok := h.lookupSong(w, r)
+	if !ok {
+		return
+	}
+	id := song.ID
+
+	var err error
+	var from, seconds float64
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = strconv.ParseFloat(v, 64); err != nil || from < 0 {
+			http.Error(w, "Invalid from", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("seconds"); v != "" {
+		if seconds, err = strconv.ParseFloat(v, 64); err != nil || seconds < 0 {
+			http.Error(w, "Invalid seconds", http.StatusBadRequest)
+			return
+		}
+	}
+
+	path := audio.SourceAudioPath(h.config.AudioDir, id)
+	if _, err := os.Stat(path); err != nil {
+		http.Error(w, "No source audio kept for this song", http.StatusNotFound)
+		return
+	}
+	samples, err := audio.LoadSamples(path)
+	if err != nil {
+		http.Error(w, "Failed to load source audio", http.StatusInternalServerError)
+		return
+	}
+
+	start := min(int(from*audio.SampleRate), len(samples))
+	end := len(samples)
+	if seconds > 0 {
+		end = min(start+int(seconds*audio.SampleRate), end)
+	}
+
+	var buf bytes.Buffer
+	frames := audio.Spectrogram(samples[start:end])
+	if err := visual.WriteSpectrogram(&buf, frames, visual.DefaultSpectrogramOptions()); err != nil {
+		http.Error(w, "Failed to render spectrogram", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	_, _ = buf.WriteTo(w)
+}
+
+// HistoryAlignment renders as SVG how the query of an identification lines
+// up along a song: its score at every offset and which segments match at
+// the offset it was matched at. The song defaults to the best candidate and
+// can be chosen with song_id.
+func (h *Handler) HistoryAlignment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ident, ok := h.lookupIdentification(w, r)
+	if !ok {
+		return
+	}
+	idx, ok := h.loadIndex(w, ident.LibraryID)
+	if !ok {
+		return
+	}
+
+	songID := intParam(r.URL.Query().Get("song_id"), 0)
+	if songID == 0 && len(ident.Candidates) > 0 {
+		songID = ident.Candidates[0].SongID
+	}
+	song, ok := idx.Song(songID)
+	if !ok {
+		http.Error(w, "Song not found", http.StatusNotFound)
+		return
+	}
+
+	qry, err := h.db.GetIdentificationQuery(ident.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch identification", http.StatusInternalServerError)
+		return
+	}
+	if len(qry) == 0 {
+		http.Error(w, "No query kept for this identification", http.StatusNotFound)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := visual.WriteAlignment(&buf, alignmentOf(idx, ident, song, qry)); err != nil {
+		http.Error(w, "Failed to render alignment", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	_, _ = buf.WriteTo(w)
+}
+
+// alignmentOf lays the query along song. The highlighted offset is the one
+// the identification reported for the song, or the best scoring one if
+// the song was not among its candidates.
+func alignmentOf(idx *matching.Index, ident *database.Identification, song *database.Song, qry database.HashSegments) visual.Alignment {
+	target := matching.ExplainTarget{SongID: song.ID, Offset: -1}
+	for _, c := range ident.Candidates {
+		if c.SongID == song.ID {
+			target.Offset = c.MatchOffset
+			break
+		}
+	}
+
+	exp := matching.Explain(idx, qry, []matching.ExplainTarget{target}, matching.ExplainOptions{})
+	alignment := visual.Alignment{
+		Title:            fmt.Sprintf("Query vs %s - %s", song.Artist, song.Title),
+		SecondsPerOffset: float64(audio.HopSize) / audio.SampleRate,
+		StartSeconds:     float64(song.HashOffset*audio.HopSize) / audio.SampleRate,
+		Threshold:        exp.MinScore,
+		MaxDistance:      exp.MaxNibbleMismatches,
+	}
+	if exp.Best != nil {
+		alignment.Scores = exp.Best.Curve
+		alignment.Best = exp.Best.Offset
+		alignment.Distances = exp.Best.Distances
+	}
+	return alignment
+}
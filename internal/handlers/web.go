@@ -1,24 +1,37 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"html/template"
 	"net/http"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"Shazam/config"
+	"Shazam/internal/backup"
 	"Shazam/internal/database"
+	"Shazam/internal/matching"
+	"Shazam/internal/monitor"
 )
 
 type Handler struct {
-	db        *database.DB
+	db        database.Store
+	libraries *matching.Libraries
+	monitors  *monitor.Service
+	backups   *backup.Service
 	config    *config.Config
 	templates map[string]*template.Template
 	hub       *Hub
 }
 
-func New(db *database.DB, cfg *config.Config) *Handler {
+func New(db database.Store, libraries *matching.Libraries, monitors *monitor.Service, backups *backup.Service, cfg *config.Config) *Handler {
 	h := &Handler{
 		db:        db,
+		libraries: libraries,
+		monitors:  monitors,
+		backups:   backups,
 		config:    cfg,
 		templates: make(map[string]*template.Template),
 	}
@@ -31,16 +44,27 @@ func New(db *database.DB, cfg *config.Config) *Handler {
 }
 
 func (h *Handler) loadTemplates() {
-	templateFiles := []string{"index.html", "database.html", "results.html"}
+	templateFiles := []string{"index.html", "database.html", "results.html", "history.html",
+		"artists.html", "artist.html", "album.html"}
 
 	for _, file := range templateFiles {
-		tmpl := template.Must(template.ParseFiles(
+		tmpl := template.Must(template.New(file).Funcs(templateFuncs).ParseFiles(
 			filepath.Join("web/templates", file),
 		))
 		h.templates[file] = tmpl
 	}
 }
 
+var templateFuncs = template.FuncMap{
+	"percent": func(f float64) string {
+		return fmt.Sprintf("%.0f%%", f*100)
+	},
+	"seconds": func(f float64) string {
+		return fmt.Sprintf("%d:%02d", int(f)/60, int(f)%60)
+	},
+	"join": strings.Join,
+}
+
 func (h *Handler) HomePage(w http.ResponseWriter, r *http.Request) {
 	count, _ := h.db.GetSongCount()
 
@@ -56,23 +80,86 @@ func (h *Handler) HomePage(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) DatabasePage(w http.ResponseWriter, r *http.Request) {
-	songs, err := h.db.GetAllSongs()
+	library, idx, ok := h.libraryIndex(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseSongFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.LibraryID = library.ID
+	filter.Limit = songPageSize
+
+	page, err := database.ListSongPage(h.db, filter)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to fetch songs", http.StatusInternalServerError)
 		return
 	}
+	libraries, err := h.db.GetLibraries()
+	if err != nil {
+		http.Error(w, "Failed to fetch libraries", http.StatusInternalServerError)
+		return
+	}
+	tags, err := h.db.GetTags(library.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+		return
+	}
+	playlists, err := h.db.GetPlaylists(library.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch playlists", http.StatusInternalServerError)
+		return
+	}
 
 	data := struct {
-		Songs []database.Song
-		Title string
+		Songs     []*database.SongSummary
+		Title     string
+		Total     int
+		Filter    database.SongFilter
+		Keys      []string
+		Tags      []*database.TagCount
+		Playlists []*database.Playlist
+		Library   *database.Library
+		Libraries []*database.Library
+		// FirstPage and NextPage link to the first and next page of the
+		// same listing, when there are such pages.
+		FirstPage string
+		NextPage  string
 	}{
-		Songs: make([]database.Song, len(songs)),
-		Title: "Song Database",
+		Songs:     page.Songs,
+		Title:     "Song Database",
+		Total:     library.SongCount,
+		Filter:    filter,
+		Tags:      tags,
+		Playlists: playlists,
+		Library:   library,
+		Libraries: libraries,
+	}
+
+	query := r.URL.Query()
+	if filter.After != "" {
+		query.Del("cursor")
+		data.FirstPage = "/database?" + query.Encode()
+	}
+	if page.Next != "" {
+		query.Set("cursor", page.Next)
+		data.NextPage = "/database?" + query.Encode()
 	}
 
-	for i, song := range songs {
-		data.Songs[i] = *song
+	seen := make(map[string]bool)
+	for _, song := range idx.Songs() {
+		if song.Key != "" && !seen[song.Key] {
+			seen[song.Key] = true
+			data.Keys = append(data.Keys, song.Key)
+		}
 	}
+	sort.Strings(data.Keys)
 
 	h.templates["database.html"].Execute(w, data)
 }
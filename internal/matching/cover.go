@@ -0,0 +1,213 @@
+package matching
+
+import (
+	"math"
+	"sort"
+
+	"Shazam/internal/audio"
+	"Shazam/internal/database"
+)
+
+// CoverOptions tunes the chroma search for covers and live versions.
+type CoverOptions struct {
+	// MinSimilarity is the mean correlation of aligned chroma profiles a
+	// song needs to be reported, between -1 and 1.
+	MinSimilarity float64
+	// MaxResults caps the number of songs returned.
+	MaxResults int
+	// Tempos are the relative tempos of the query tried, as covers are
+	// rarely played at the original speed.
+	Tempos []float64
+	// Keys is how many of the best transpositions by overall pitch-class
+	// profile are aligned in detail. The profile of a short excerpt is a
+	// poor guide to the key, so fewer than 12 trades recall for speed.
+	Keys int
+	// Songs, when set, restricts the search to those songs.
+	Songs SongSet
+}
+
+func DefaultCoverOptions() CoverOptions {
+	return CoverOptions{
+		MinSimilarity: 0.65,
+		MaxResults:    3,
+		Tempos:        []float64{0.85, 0.92, 1, 1.08, 1.15},
+		Keys:          12,
+	}
+}
+
+// minCoverProfiles is the shortest query, in chroma profiles, worth
+// comparing; shorter queries match too much by chance.
+const minCoverProfiles = 8
+
+// silentProfile is a normalised profile without harmony, such as that of a
+// block of inactive frames.
+var silentProfile [12]float64
+
+// FindCovers returns songs whose chord progression follows the query's in
+// any key and at any of the tried tempos, most similar first. It is much
+// looser than the fingerprint and meant as a fallback when there is no
+// exact match.
+func FindCovers(idx *Index, qry database.ChromaSequence, opts CoverOptions) []database.PossibleCover {
+	if len(qry) < minCoverProfiles {
+		return nil
+	}
+	query := normaliseChroma(qry)
+	queryProfile := chromaProfile(qry)
+
+	var covers []database.PossibleCover
+	for _, song := range idx.Songs() {
+		if len(song.Chroma) < minCoverProfiles || !opts.Songs.Allows(song.ID) {
+			continue
+		}
+		ref := normaliseChroma(song.Chroma)
+
+		best := database.PossibleCover{Similarity: math.Inf(-1)}
+		for _, shift := range bestTranspositions(chromaProfile(song.Chroma), queryProfile, opts.Keys) {
+			for _, tempo := range opts.Tempos {
+				sim, offset := alignChroma(ref, query, shift, tempo)
+				if sim > best.Similarity {
+					best = database.PossibleCover{
+						Similarity: sim,
+						Transpose:  shift,
+						Tempo:      tempo,
+						TimeInSong: float64(offset*audio.ChromaBlock) * timePerSegment,
+					}
+				}
+			}
+		}
+		if best.Similarity < opts.MinSimilarity {
+			continue
+		}
+
+		best.SongID = song.ID
+		best.Title = song.Title
+		best.Artist = song.Artist
+		best.Album = song.Album
+		covers = append(covers, best)
+	}
+
+	sort.Slice(covers, func(i, j int) bool { return covers[i].Similarity > covers[j].Similarity })
+	if opts.MaxResults > 0 && len(covers) > opts.MaxResults {
+		covers = covers[:opts.MaxResults]
+	}
+	return covers
+}
+
+// normaliseChroma centres each profile on its mean and scales it to unit
+// length, so that aligned profiles can be compared with a dot product
+// that is near zero for unrelated harmony.
+func normaliseChroma(seq database.ChromaSequence) [][12]float64 {
+	out := make([][12]float64, len(seq))
+	for i, c := range seq {
+		mean := 0.0
+		for _, v := range c {
+			mean += float64(v)
+		}
+		mean /= 12
+
+		norm := 0.0
+		for k, v := range c {
+			out[i][k] = float64(v) - mean
+			norm += out[i][k] * out[i][k]
+		}
+		if norm > 0 {
+			norm = math.Sqrt(norm)
+			for k := range out[i] {
+				out[i][k] /= norm
+			}
+		}
+	}
+	return out
+}
+
+// chromaProfile sums a sequence into its overall pitch-class distribution.
+func chromaProfile(seq database.ChromaSequence) [12]float64 {
+	var profile [12]float64
+	for _, c := range seq {
+		for k, v := range c {
+			profile[k] += float64(v)
+		}
+	}
+	return profile
+}
+
+// bestTranspositions ranks the 12 semitone shifts of the query by how well
+// its overall profile lines up with the song's and returns the first n.
+// A shift of s means query pitch class k+s corresponds to song class k.
+func bestTranspositions(song, query [12]float64, n int) []int {
+	type scored struct {
+		shift int
+		score float64
+	}
+	all := make([]scored, 12)
+	for s := 0; s < 12; s++ {
+		all[s].shift = s
+		for k := 0; k < 12; k++ {
+			all[s].score += song[k] * query[(k+s)%12]
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].score > all[j].score })
+
+	if n < 1 {
+		n = 1
+	}
+	if n > 12 {
+		n = 12
+	}
+	shifts := make([]int, n)
+	for i := range shifts {
+		shifts[i] = signedSemitones(all[i].shift)
+	}
+	return shifts
+}
+
+// signedSemitones maps a shift of 0..11 to the nearer of up or down.
+func signedSemitones(s int) int {
+	if s > 6 {
+		return s - 12
+	}
+	return s
+}
+
+// alignChroma slides the query, transposed and played back at tempo, over
+// the reference and returns the best mean correlation and its offset in
+// profiles. Profiles of inactive audio, which are all zeros, are left out
+// of the mean.
+func alignChroma(ref, qry [][12]float64, shift int, tempo float64) (float64, int) {
+	// Profile j of the reference lines up with profile j/tempo of the
+	// query once the query is slowed back down.
+	n := int(float64(len(qry)) * tempo)
+	if n > len(ref) {
+		n = len(ref)
+	}
+	if n < minCoverProfiles {
+		return math.Inf(-1), 0
+	}
+	index := make([]int, n)
+	for j := range index {
+		index[j] = min(int(float64(j)/tempo), len(qry)-1)
+	}
+
+	rot := ((shift % 12) + 12) % 12
+	best, bestOffset := math.Inf(-1), 0
+	for offset := 0; offset+n <= len(ref); offset++ {
+		sum, count := 0.0, 0
+		for j, qi := range index {
+			r, q := &ref[offset+j], &qry[qi]
+			if *r == silentProfile || *q == silentProfile {
+				continue
+			}
+			for k := 0; k < 12; k++ {
+				sum += r[k] * q[(k+rot)%12]
+			}
+			count++
+		}
+		if count < minCoverProfiles {
+			continue
+		}
+		if sim := sum / float64(count); sim > best {
+			best, bestOffset = sim, offset
+		}
+	}
+	return best, bestOffset
+}
@@ -0,0 +1,151 @@
+package matching
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+
+	"Shazam/internal/database"
+)
+
+// DecisionConfig turns raw alignment scores into a probability that the
+// top song is really playing, and decides when to answer "no match".
+//
+// Each result is described by three features: how far its score stands
+// above the query's background score distribution (a z-score), its margin
+// over the best competing song, and the log of the query length in
+// seconds. They are combined by a logistic model whose weights can be
+// fitted to evaluation data with the evaluate command.
+type DecisionConfig struct {
+	// MinScore is the raw fraction of matching segments below which a
+	// result is never accepted, whatever the model says.
+	MinScore float64 `json:"min_score"`
+	// Threshold is the probability a result needs to be accepted.
+	Threshold float64 `json:"threshold"`
+
+	Bias         float64 `json:"bias"`
+	ZWeight      float64 `json:"z_weight"`
+	MarginWeight float64 `json:"margin_weight"`
+	LengthWeight float64 `json:"length_weight"`
+
+	// BackgroundMean and BackgroundStd stand in for the background
+	// distribution when the query is too long to sample it from the
+	// reference.
+	BackgroundMean float64 `json:"background_mean"`
+	BackgroundStd  float64 `json:"background_std"`
+}
+
+func DefaultDecisionConfig() DecisionConfig {
+	return DecisionConfig{
+		MinScore:       matchThreshold,
+		Threshold:      0.5,
+		Bias:           -6,
+		ZWeight:        0.6,
+		MarginWeight:   12,
+		LengthWeight:   0.8,
+		BackgroundMean: 0.08,
+		BackgroundStd:  0.04,
+	}
+}
+
+// LoadDecisionConfig reads a calibration file written by the evaluate
+// command. Fields missing from the file keep their defaults.
+func LoadDecisionConfig(path string) (DecisionConfig, error) {
+	cfg := DefaultDecisionConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	err = json.Unmarshal(data, &cfg)
+	return cfg, err
+}
+
+// Features are the inputs of the decision model for one result.
+type Features struct {
+	ZScore  float64 `json:"z_score"`
+	Margin  float64 `json:"margin"`
+	Seconds float64 `json:"seconds"`
+}
+
+// Probability applies the logistic model.
+func (c DecisionConfig) Probability(f Features) float64 {
+	x := c.Bias + c.ZWeight*f.ZScore + c.MarginWeight*f.Margin +
+		c.LengthWeight*math.Log(math.Max(f.Seconds, 0.1))
+	return 1 / (1 + math.Exp(-x))
+}
+
+// backgroundOffsets is how many alignments away from the match are scored
+// to estimate the background distribution.
+const backgroundOffsets = 16
+
+// minBackgroundStd keeps near-constant backgrounds from producing huge
+// z-scores.
+const minBackgroundStd = 0.01
+
+// decide replaces the raw scores of ranked results with calibrated
+// probabilities and sets IsMatch. Results must be ordered best first and
+// keep their ranking.
+func (c DecisionConfig) decide(qry database.HashSegments, results []*database.MatchResult) {
+	if len(results) == 0 {
+		return
+	}
+
+	mean, std := c.background(qry, results[0])
+	seconds := QuerySeconds(qry)
+
+	for i, r := range results {
+		rival := mean
+		if i == 0 && len(results) > 1 {
+			rival = results[1].Score
+		} else if i > 0 {
+			rival = results[0].Score
+		}
+
+		r.ZScore = (r.Score - mean) / std
+		r.Margin = r.Score - rival
+		r.Confidence = c.Probability(Features{ZScore: r.ZScore, Margin: r.Margin, Seconds: seconds})
+		r.IsMatch = i == 0 && r.Score >= c.MinScore && r.Confidence >= c.Threshold
+	}
+}
+
+// background estimates how well the query scores against audio it does not
+// come from, by aligning it with the best song at offsets well away from
+// the match.
+func (c DecisionConfig) background(qry database.HashSegments, best *database.MatchResult) (float64, float64) {
+	if best.Song == nil {
+		return c.BackgroundMean, c.BackgroundStd
+	}
+
+	ref := best.Song.HashSegments
+	last := maxSlideOffset(ref, qry)
+	var scores []float64
+	for k := 0; k < backgroundOffsets && last > 0; k++ {
+		offset := k * last / backgroundOffsets
+		if abs(offset-best.MatchOffset) < len(qry) {
+			continue
+		}
+		scores = append(scores, scoreAt(ref, qry, offset))
+	}
+	if len(scores) < backgroundOffsets/4 {
+		return c.BackgroundMean, c.BackgroundStd
+	}
+
+	mean := 0.0
+	for _, s := range scores {
+		mean += s
+	}
+	mean /= float64(len(scores))
+	variance := 0.0
+	for _, s := range scores {
+		variance += (s - mean) * (s - mean)
+	}
+	std := math.Sqrt(variance / float64(len(scores)))
+	return mean, math.Max(std, minBackgroundStd)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -1,93 +1,200 @@
 package matching
 
 import (
-	"fmt"
 	"sort"
-	"strconv"
 
 	"Shazam/internal/audio"
 	"Shazam/internal/database"
 )
 
-func FindBestMatch(db *database.DB, queryFingerprint *audio.AudioFingerprint) (*database.MatchResult, error) {
-	fmt.Println("🔍 Searching database for best match...")
+// timePerSegment is the duration covered by one hop between hash segments.
+const timePerSegment = 512.0 / 22050.0
 
-	songs, err := db.GetAllSongs()
-	if err != nil {
-		return nil, err
+// songSeconds is the time in song of its segment at offset, counting the
+// inactive frames trimmed before its first segment.
+func songSeconds(song *database.Song, offset int) float64 {
+	return float64(song.HashOffset+offset) * timePerSegment
+}
+
+// QuerySeconds is how much audio a query holds as far as matching is
+// concerned: only active segments count, silence adds length but no
+// support.
+func QuerySeconds(query database.HashSegments) float64 {
+	return float64(query.Active()) * timePerSegment
+}
+
+// fromQueryStart moves the times of results back by the inactive frames
+// trimmed from the start of the query, so that they give where in the song
+// the query's audio begins rather than its first segment.
+func fromQueryStart(results []*database.MatchResult, query *audio.AudioFingerprint) {
+	for _, r := range results {
+		r.TimeInSong = max(r.TimeInSong-float64(query.Offset)*timePerSegment, 0)
 	}
+}
 
-	if len(songs) == 0 {
+// FindBestMatch identifies the query against the index, using the LSH
+// tables when they are enabled and a full scan otherwise.
+func FindBestMatch(idx *Index, queryFingerprint *audio.AudioFingerprint) (*database.MatchResult, error) {
+	candidates, ok := idx.Candidates(queryFingerprint.HashSegments, nil)
+	if !ok {
+		return FindBestMatchExhaustive(idx, queryFingerprint)
+	}
+
+	results := verifyCandidates(idx, queryFingerprint.HashSegments, candidates, nil)
+	idx.Decision().decide(queryFingerprint.HashSegments, results)
+	fromQueryStart(results, queryFingerprint)
+	if len(results) == 0 {
 		return &database.MatchResult{IsMatch: false, Confidence: 0.0}, nil
 	}
+	return results[0], nil
+}
 
-	fmt.Printf("📚 Comparing against %d songs in database...\n", len(songs))
+// FindBestMatchExhaustive slides the query over every song in the index.
+func FindBestMatchExhaustive(idx *Index, queryFingerprint *audio.AudioFingerprint) (*database.MatchResult, error) {
+	songs := idx.Songs()
 
-	bestMatch := &database.MatchResult{
-		IsMatch:    false,
-		Confidence: 0.0,
-		Song:       nil,
+	if len(songs) == 0 {
+		return &database.MatchResult{IsMatch: false, Confidence: 0.0}, nil
 	}
 
-	for i, song := range songs {
+	var results []*database.MatchResult
+	for _, song := range songs {
 		refFingerprint := audio.ConvertSongToFingerprint(song)
 
 		result := SlideHamming(refFingerprint, queryFingerprint)
 
-		fmt.Printf("  [%d/%d] %s - %s: %.1f%%\n", i+1, len(songs),
-			song.Artist, song.Title, result.Confidence*100)
+		result.Song = song
+		result.TimeInSong = songSeconds(song, result.MatchOffset)
+		results = append(results, result)
+	}
 
-		if result.Confidence > bestMatch.Confidence {
-			bestMatch.Confidence = result.Confidence
-			bestMatch.MatchOffset = result.MatchOffset
-			bestMatch.IsMatch = result.IsMatch
-			bestMatch.Song = song
+	sortByScore(results)
+	idx.Decision().decide(queryFingerprint.HashSegments, results)
+	fromQueryStart(results, queryFingerprint)
+	return results[0], nil
+}
 
-			timePerSegment := 512.0 / 22050.0
-			bestMatch.TimeInSong = float64(result.MatchOffset) * timePerSegment
-		}
+// SongSet restricts a search to the songs with these IDs, such as the
+// setlist of an event being identified. A nil set allows every song.
+type SongSet map[int]bool
+
+// Allows reports whether the set admits the song.
+func (s SongSet) Allows(id int) bool {
+	return s == nil || s[id]
+}
+
+func GetTopMatches(idx *Index, queryFingerprint *audio.AudioFingerprint, topN int) ([]*database.MatchResult, error) {
+	return GetTopMatchesIn(idx, queryFingerprint, topN, nil)
+}
+
+// GetTopMatchesIn is GetTopMatches considering only the songs in songs.
+// Confidences are calibrated against those songs alone, so a match stands
+// out more than it would in the whole library.
+func GetTopMatchesIn(idx *Index, queryFingerprint *audio.AudioFingerprint, topN int, songs SongSet) ([]*database.MatchResult, error) {
+	results := score(idx, queryFingerprint.HashSegments, songs)
+	idx.Decision().decide(queryFingerprint.HashSegments, results)
+	fromQueryStart(results, queryFingerprint)
+
+	if topN > len(results) {
+		topN = len(results)
+	}
+
+	return results[:topN], nil
+}
+
+// Identify returns the best match for raw segments without logging each
+// comparison. It suits callers that identify continuously, such as the
+// segmenter and stream monitors.
+func Identify(idx *Index, qry database.HashSegments) *database.MatchResult {
+	results := rank(idx, qry)
+	if len(results) == 0 {
+		return &database.MatchResult{IsMatch: false, Confidence: 0.0}
 	}
+	return results[0]
+}
 
-	return bestMatch, nil
+// rank scores the query against the index and returns one result per
+// song considered, best first, with calibrated confidences.
+func rank(idx *Index, qry database.HashSegments) []*database.MatchResult {
+	results := score(idx, qry, nil)
+	idx.Decision().decide(qry, results)
+	return results
 }
 
-func GetTopMatches(db *database.DB, queryFingerprint *audio.AudioFingerprint, topN int) ([]*database.MatchResult, error) {
-	songs, err := db.GetAllSongs()
-	if err != nil {
-		return nil, err
+// exhaustiveSongs is the largest song set scored by sliding the query over
+// every song in it rather than through the LSH tables, which can miss a
+// song the set was chosen for.
+const exhaustiveSongs = 64
+
+// score returns the raw best alignment of the query with each song in
+// songs, best first. With LSH enabled only songs proposed by the tables
+// are considered, unless songs is small enough to score them all.
+func score(idx *Index, qry database.HashSegments, songs SongSet) []*database.MatchResult {
+	if songs == nil || len(songs) > exhaustiveSongs {
+		if candidates, ok := idx.Candidates(qry, songs); ok {
+			return verifyCandidates(idx, qry, candidates, songs)
+		}
 	}
 
+	query := &audio.AudioFingerprint{HashSegments: qry}
 	var results []*database.MatchResult
-
-	for _, song := range songs {
-		refFingerprint := &audio.AudioFingerprint{
-			TrackID:      strconv.Itoa(song.ID),
-			TrackName:    song.Title,
-			Artist:       song.Artist,
-			Fingerprint:  song.Fingerprint,
-			HashSegments: song.HashSegments,
+	for _, song := range idx.Songs() {
+		if !songs.Allows(song.ID) {
+			continue
 		}
+		result := SlideHamming(audio.ConvertSongToFingerprint(song), query)
+		result.Song = song
+		result.TimeInSong = songSeconds(song, result.MatchOffset)
+		results = append(results, result)
+	}
 
-		result := SlideHamming(refFingerprint, queryFingerprint)
+	sortByScore(results)
+	return results
+}
+
+// verifyCandidates scores each proposed alignment of a song in songs
+// exactly and keeps the best one per song, ordered by score.
+func verifyCandidates(idx *Index, qry database.HashSegments, candidates []Candidate, songs SongSet) []*database.MatchResult {
+	bySong := make(map[int]*database.MatchResult)
 
-		matchResult := &database.MatchResult{
-			IsMatch:     result.IsMatch,
-			Confidence:  result.Confidence,
-			MatchOffset: result.MatchOffset,
+	for _, c := range candidates {
+		if !songs.Allows(c.SongID) {
+			continue
+		}
+		song, ok := idx.Song(c.SongID)
+		if !ok || c.Offset > maxSlideOffset(song.HashSegments, qry) {
+			continue
+		}
+
+		score := scoreAt(song.HashSegments, qry, c.Offset)
+		if best, seen := bySong[c.SongID]; seen && best.Score >= score {
+			continue
+		}
+		bySong[c.SongID] = &database.MatchResult{
+			IsMatch:     score >= matchThreshold,
+			Confidence:  score,
+			Score:       score,
+			MatchOffset: c.Offset,
 			Song:        song,
-			TimeInSong:  float64(result.MatchOffset) * (512.0 / 22050.0),
+			TimeInSong:  songSeconds(song, c.Offset),
 		}
+	}
 
-		results = append(results, matchResult)
+	results := make([]*database.MatchResult, 0, len(bySong))
+	for _, r := range bySong {
+		results = append(results, r)
 	}
+	sortByScore(results)
+	return results
+}
 
+// sortByScore orders results by raw score, best first, breaking ties by
+// song ID so rankings are stable.
+func sortByScore(results []*database.MatchResult) {
 	sort.Slice(results, func(i, j int) bool {
-		return results[i].Confidence > results[j].Confidence
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Song.ID < results[j].Song.ID
 	})
-
-	if topN > len(results) {
-		topN = len(results)
-	}
-
-	return results[:topN], nil
 }
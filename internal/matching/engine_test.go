@@ -0,0 +1,164 @@
+package matching
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"Shazam/internal/audio"
+	"Shazam/internal/database"
+)
+
+// testSongs adds n songs of random, never silent hashes, segments long, to a
+// memory store.
+func testSongs(tb testing.TB, rng *rand.Rand, n, segments int) *database.MemoryStore {
+	tb.Helper()
+	db := database.NewMemoryStore()
+	for i := 0; i < n; i++ {
+		hashes := make(database.HashSegments, segments)
+		for j := range hashes {
+			hashes[j] = rng.Uint64() | 1
+		}
+		song := &database.Song{Title: "song", Artist: "artist", Fingerprint: "fp", HashSegments: hashes}
+		if err := db.AddSong(song); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return db
+}
+
+func testIndex(tb testing.TB, db database.SongStore, lsh LSHConfig) *Index {
+	tb.Helper()
+	idx, err := NewIndex(db, lsh)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return idx
+}
+
+// excerpt cuts segments hashes of song from start, with noise nibbles of
+// each moved to a neighbouring level as playback through speakers would.
+func excerpt(rng *rand.Rand, song *database.Song, start, segments, noise int) *audio.AudioFingerprint {
+	out := make(database.HashSegments, segments)
+	for i, h := range song.HashSegments[start : start+segments] {
+		for j := 0; j < noise; j++ {
+			shift := uint(4 * rng.Intn(16))
+			v := min(max(int(h>>shift&0xf)+rng.Intn(5)-2, 0), 15)
+			h = h&^(0xf<<shift) | uint64(v)<<shift
+		}
+		out[i] = h | 1
+	}
+	return &audio.AudioFingerprint{HashSegments: out}
+}
+
+func TestFindBestMatch(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	db := testSongs(t, rng, 40, 1300)
+	for _, test := range []struct {
+		name string
+		lsh  LSHConfig
+	}{
+		{"lsh", DefaultLSHConfig()},
+		{"exhaustive", LSHConfig{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			idx := testIndex(t, db, test.lsh)
+			song := idx.Songs()[7]
+			query := excerpt(rng, song, 300, 215, 3)
+			// Ten inactive frames were trimmed from the start of the query.
+			query.Offset = 10
+
+			best, err := FindBestMatch(idx, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if best.Song == nil || best.Song.ID != song.ID || !best.IsMatch || best.MatchOffset != 300 {
+				t.Fatalf("best = %+v, want a match with song %d at 300", best, song.ID)
+			}
+			if want := 290 * timePerSegment; math.Abs(best.TimeInSong-want) > 1e-9 {
+				t.Errorf("time in song = %.3f, want %.3f from the start of the query", best.TimeInSong, want)
+			}
+
+			unknown := testSongs(t, rng, 1, 215)
+			stranger, _ := unknown.GetSong(1)
+			best, err = FindBestMatch(idx, excerpt(rng, stranger, 0, 215, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if best.IsMatch {
+				t.Errorf("a song outside the library matched %d with %.2f", best.Song.ID, best.Confidence)
+			}
+		})
+	}
+}
+
+func TestGetTopMatchesIn(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	idx := testIndex(t, testSongs(t, rng, 80, 1300), DefaultLSHConfig())
+	songs := idx.Songs()
+	song := songs[3]
+	query := excerpt(rng, song, 500, 215, 3)
+
+	// Sets larger than exhaustiveSongs go through the LSH tables.
+	large := SongSet{}
+	for _, s := range songs[:70] {
+		large[s.ID] = true
+	}
+	for _, test := range []struct {
+		name  string
+		songs SongSet
+		first bool
+	}{
+		{"all", nil, true},
+		{"small set", SongSet{song.ID: true, songs[10].ID: true}, true},
+		{"large set", large, true},
+		{"without it", SongSet{songs[10].ID: true, songs[11].ID: true}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			top, err := GetTopMatchesIn(idx, query, 5, test.songs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range top {
+				if !test.songs.Allows(r.Song.ID) {
+					t.Errorf("song %d outside the set was ranked", r.Song.ID)
+				}
+			}
+			found := len(top) > 0 && top[0].Song.ID == song.ID && top[0].IsMatch
+			if found != test.first {
+				t.Errorf("matched first = %v, want %v", found, test.first)
+			}
+		})
+	}
+}
+
+// BenchmarkFindBestMatch identifies 5 second excerpts among 200 songs of
+// a minute, through the LSH tables and with a full scan.
+func BenchmarkFindBestMatch(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	db := testSongs(b, rng, 200, 2600)
+	for _, bench := range []struct {
+		name string
+		lsh  LSHConfig
+	}{
+		{"lsh", DefaultLSHConfig()},
+		{"exhaustive", LSHConfig{}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			idx := testIndex(b, db, bench.lsh)
+			songs := idx.Songs()
+			queries := make([]*audio.AudioFingerprint, 64)
+			for i := range queries {
+				song := songs[rng.Intn(len(songs))]
+				queries[i] = excerpt(rng, song, rng.Intn(2600-215), 215, 3)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := FindBestMatch(idx, queries[i%len(queries)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
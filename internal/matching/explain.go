@@ -0,0 +1,137 @@
+package matching
+
+import "Shazam/internal/database"
+
+// Explanation shows how a query scored against the songs it was ranked
+// against, for diagnosing why an identification went the way it did.
+type Explanation struct {
+	QuerySegments int `json:"query_segments"`
+	// ActiveSegments excludes the silent segments no score counts.
+	ActiveSegments int `json:"active_segments"`
+	// MaxNibbleMismatches and MinScore are the thresholds the scores were
+	// judged by.
+	MaxNibbleMismatches int                `json:"max_nibble_mismatches"`
+	MinScore            float64            `json:"min_score"`
+	Best                *SongExplanation   `json:"best,omitempty"`
+	RunnersUp           []*SongExplanation `json:"runners_up"`
+}
+
+// SongExplanation is the score of a query at every offset along one song.
+type SongExplanation struct {
+	SongID     int     `json:"song_id"`
+	Title      string  `json:"title"`
+	Artist     string  `json:"artist"`
+	Offset     int     `json:"offset"`
+	TimeInSong float64 `json:"time_in_song"`
+	Score      float64 `json:"score"`
+	// Matched is the number of query segments within MaxNibbleMismatches
+	// of the song at Offset.
+	Matched int `json:"matched"`
+	// Curve is the score at every CurveStep-th offset, each point the peak
+	// of the offsets it covers so that narrow spikes survive.
+	Curve     []float64 `json:"curve"`
+	CurveStep int       `json:"curve_step"`
+	// Distances is the nibble distance of every query segment at Offset,
+	// -1 where it was not compared. It is only filled in for the best song.
+	Distances []int `json:"distances,omitempty"`
+}
+
+// ExplainOptions limits the size of an explanation.
+type ExplainOptions struct {
+	// RunnersUp is how many songs after the best are explained.
+	RunnersUp int
+	// CurvePoints caps the length of each curve.
+	CurvePoints int
+}
+
+func DefaultExplainOptions() ExplainOptions {
+	return ExplainOptions{RunnersUp: 4, CurvePoints: 1000}
+}
+
+// ExplainTarget is a song a query was ranked against and the offset it
+// was reported at. A negative Offset picks the best scoring one.
+type ExplainTarget struct {
+	SongID int
+	Offset int
+}
+
+// Explain recomputes the full score curve of qry along each target, best
+// first, as SlideHamming would before keeping only its peak. Targets no
+// longer in the index are skipped.
+func Explain(idx *Index, qry database.HashSegments, targets []ExplainTarget, opts ExplainOptions) *Explanation {
+	exp := &Explanation{
+		QuerySegments:       len(qry),
+		ActiveSegments:      qry.Active(),
+		MaxNibbleMismatches: MaxNibbleMismatches,
+		MinScore:            idx.Decision().MinScore,
+		RunnersUp:           []*SongExplanation{},
+	}
+
+	for _, t := range targets {
+		if exp.Best != nil && len(exp.RunnersUp) >= opts.RunnersUp {
+			break
+		}
+		song, ok := idx.Song(t.SongID)
+		if !ok {
+			continue
+		}
+
+		se := explainSong(song, qry, t.Offset, opts.CurvePoints)
+		if exp.Best == nil {
+			exp.Best = se
+			continue
+		}
+		se.Distances = nil
+		exp.RunnersUp = append(exp.RunnersUp, se)
+	}
+	return exp
+}
+
+func explainSong(song *database.Song, qry database.HashSegments, offset, points int) *SongExplanation {
+	scores := OffsetScores(song.HashSegments, qry)
+	if offset < 0 || offset >= len(scores) {
+		offset = 0
+		for o, s := range scores {
+			if s > scores[offset] {
+				offset = o
+			}
+		}
+	}
+
+	se := &SongExplanation{
+		SongID:     song.ID,
+		Title:      song.Title,
+		Artist:     song.Artist,
+		Offset:     offset,
+		TimeInSong: songSeconds(song, offset),
+		Distances:  SegmentDistances(song.HashSegments, qry, offset),
+	}
+	if offset < len(scores) {
+		se.Score = scores[offset]
+	}
+	for _, d := range se.Distances {
+		if d >= 0 && d <= MaxNibbleMismatches {
+			se.Matched++
+		}
+	}
+	se.Curve, se.CurveStep = poolPeaks(scores, points)
+	return se
+}
+
+// poolPeaks shortens scores to at most points values by keeping the peak
+// of every step consecutive ones.
+func poolPeaks(scores []float64, points int) ([]float64, int) {
+	step := 1
+	if points > 0 && len(scores) > points {
+		step = (len(scores) + points - 1) / points
+	}
+	curve := make([]float64, 0, (len(scores)+step-1)/step)
+	for i := 0; i < len(scores); i += step {
+		peak := scores[i]
+		for _, s := range scores[i:min(i+step, len(scores))] {
+			peak = max(peak, s)
+		}
+		curve = append(curve, peak)
+	}
+	return curve, step
+}
@@ -1,25 +1,32 @@
 package matching
 
 import (
-	"math"
+	"math/bits"
 
 	"Shazam/internal/audio"
 	"Shazam/internal/database"
 )
 
-func HammingHex(a, b string) int {
-	if len(a) != len(b) {
-		return math.MaxInt32
-	}
-	d := 0
-	for i := 0; i < len(a); i++ {
-		if a[i] != b[i] {
-			d++
-		}
-	}
-	return d
+// nibbleLowBits has the lowest bit of every nibble set.
+const nibbleLowBits = 0x1111111111111111
+
+// HammingNibbles counts the nibbles that differ between two packed hashes.
+// Each differing nibble is folded down onto its lowest bit before counting.
+func HammingNibbles(a, b uint64) int {
+	x := a ^ b
+	x |= x >> 1
+	x |= x >> 2
+	return bits.OnesCount64(x & nibbleLowBits)
 }
 
+// MaxNibbleMismatches is the most nibbles two segments may differ in and
+// still count as matching.
+const MaxNibbleMismatches = 8
+
+// matchThreshold is the fraction of matching query segments needed to call
+// an alignment a match. It is the default floor of DecisionConfig.MinScore.
+const matchThreshold = 0.25
+
 func SlideHamming(reference, query *audio.AudioFingerprint) *database.MatchResult {
 	ref := reference.HashSegments
 	qry := query.HashSegments
@@ -27,26 +34,14 @@ func SlideHamming(reference, query *audio.AudioFingerprint) *database.MatchResul
 		return &database.MatchResult{IsMatch: false, Confidence: 0}
 	}
 
-	const maxNibbleMismatches = 8
-
 	best := &database.MatchResult{IsMatch: false, Confidence: 0, MatchOffset: -1}
-	maxOffset := len(ref) - len(qry)
-	if maxOffset < 0 {
-		maxOffset = 0
-	}
-
-	for offset := 0; offset <= maxOffset; offset++ {
-		matches := 0
-		for i := 0; i < len(qry) && offset+i < len(ref); i++ {
-			if HammingHex(ref[offset+i], qry[i]) <= maxNibbleMismatches {
-				matches++
-			}
-		}
-		conf := float64(matches) / float64(len(qry))
+	for offset := 0; offset <= maxSlideOffset(ref, qry); offset++ {
+		conf := scoreAt(ref, qry, offset)
 		if conf > best.Confidence {
 			best = &database.MatchResult{
-				IsMatch:     conf >= 0.25,
+				IsMatch:     conf >= matchThreshold,
 				Confidence:  conf,
+				Score:       conf,
 				MatchOffset: offset,
 			}
 		}
@@ -54,3 +49,65 @@ func SlideHamming(reference, query *audio.AudioFingerprint) *database.MatchResul
 
 	return best
 }
+
+// maxSlideOffset is the last reference offset SlideHamming tries.
+func maxSlideOffset(ref, qry database.HashSegments) int {
+	if len(ref) < len(qry) {
+		return 0
+	}
+	return len(ref) - len(qry)
+}
+
+// scoreAt returns the fraction of active query segments matching the
+// reference when the query is aligned at offset. Silent segments on either
+// side never match, and silent query segments are not counted at all.
+func scoreAt(ref, qry database.HashSegments, offset int) float64 {
+	matches, active := 0, 0
+	for i, q := range qry {
+		if q == database.SilentHash {
+			continue
+		}
+		active++
+		if offset+i < len(ref) && segmentsMatch(ref[offset+i], q) {
+			matches++
+		}
+	}
+	if active == 0 {
+		return 0
+	}
+	return float64(matches) / float64(active)
+}
+
+// segmentsMatch reports whether a reference and an active query segment
+// are close enough to count as matching.
+func segmentsMatch(ref, qry uint64) bool {
+	return ref != database.SilentHash && HammingNibbles(ref, qry) <= MaxNibbleMismatches
+}
+
+// OffsetScores returns the score SlideHamming computes at every reference
+// offset, for plotting how a query lines up along a song.
+func OffsetScores(ref, qry database.HashSegments) []float64 {
+	if len(qry) == 0 || len(ref) == 0 {
+		return nil
+	}
+	scores := make([]float64, maxSlideOffset(ref, qry)+1)
+	for offset := range scores {
+		scores[offset] = scoreAt(ref, qry, offset)
+	}
+	return scores
+}
+
+// SegmentDistances returns the nibble distance of each query segment to
+// the reference segment it is aligned with at offset, or -1 where they are
+// not compared: past the end of the reference or where either is silent.
+func SegmentDistances(ref, qry database.HashSegments, offset int) []int {
+	distances := make([]int, len(qry))
+	for i := range qry {
+		if offset+i < 0 || offset+i >= len(ref) || qry[i] == database.SilentHash || ref[offset+i] == database.SilentHash {
+			distances[i] = -1
+			continue
+		}
+		distances[i] = HammingNibbles(ref[offset+i], qry[i])
+	}
+	return distances
+}
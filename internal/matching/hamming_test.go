@@ -0,0 +1,50 @@
+package matching
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestHammingNibbles(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		a, b uint64
+		want int
+	}{
+		{"equal", 0x0123456789abcdef, 0x0123456789abcdef, 0},
+		{"lowest bit", 0, 0x1, 1},
+		{"second bit", 0, 0x2, 1},
+		{"third bit", 0, 0x4, 1},
+		{"highest bit of a nibble", 0, 0x8, 1},
+		{"whole nibble", 0, 0xf, 1},
+		{"top nibble", 0, 0x8000000000000000, 1},
+		{"neighbouring nibbles", 0, 0x18, 2},
+		{"every nibble", 0, 0xffffffffffffffff, 16},
+		{"every nibble by one bit", 0x1111111111111111, 0x2222222222222222, 16},
+		{"alternate nibbles", 0x0f0f0f0f0f0f0f0f, 0, 8},
+	} {
+		if got := HammingNibbles(test.a, test.b); got != test.want {
+			t.Errorf("%s: HammingNibbles(%016x, %016x) = %d, want %d", test.name, test.a, test.b, got, test.want)
+		}
+	}
+}
+
+// TestHammingNibblesMatchesNibbleCount checks the folding against
+// comparing the nibbles one by one.
+func TestHammingNibblesMatchesNibbleCount(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		a, b := rng.Uint64(), rng.Uint64()
+		// Most pairs differ everywhere; copy some nibbles across.
+		b = b&rng.Uint64() | a&^rng.Uint64()
+		want := 0
+		for shift := 0; shift < 64; shift += 4 {
+			if a>>shift&0xf != b>>shift&0xf {
+				want++
+			}
+		}
+		if got := HammingNibbles(a, b); got != want {
+			t.Fatalf("HammingNibbles(%016x, %016x) = %d, want %d", a, b, got, want)
+		}
+	}
+}
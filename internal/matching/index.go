@@ -0,0 +1,224 @@
+package matching
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"Shazam/internal/database"
+)
+
+// Index keeps the whole song library in memory so identification never
+// waits on SQLite. It is loaded once at startup and then kept in sync by
+// the handlers that add, update or delete songs.
+type Index struct {
+	db database.SongStore
+	// library is the library whose songs are indexed, or 0 for every
+	// song in the database.
+	library   int
+	lshConfig LSHConfig
+	decision  DecisionConfig
+
+	mu       sync.RWMutex
+	lsh      *LSH
+	songs    map[int]*database.Song
+	ordered  []*database.Song
+	loadedAt time.Time
+	loadTime time.Duration
+}
+
+// IndexStats describes the current contents and footprint of an Index.
+type IndexStats struct {
+	Songs        int       `json:"songs"`
+	Segments     int       `json:"segments"`
+	SegmentBytes int       `json:"segment_bytes"`
+	ApproxBytes  int       `json:"approx_bytes"`
+	LSHTables    int       `json:"lsh_tables"`
+	LSHPostings  int       `json:"lsh_postings"`
+	LoadedAt     time.Time `json:"loaded_at"`
+	LoadMillis   int64     `json:"load_ms"`
+}
+
+// songOverhead approximates the fixed per-song cost of the Song struct,
+// its map entry and the ordered slice slot.
+const songOverhead = 256
+
+// postingOverhead approximates the cost of one LSH posting including its
+// share of bucket and map overhead.
+const postingOverhead = 12
+
+// NewIndex loads every song in the database and, unless lshConfig.Tables
+// is zero, builds the LSH tables over them.
+func NewIndex(db database.SongStore, lshConfig LSHConfig) (*Index, error) {
+	return NewLibraryIndex(db, 0, lshConfig)
+}
+
+// NewLibraryIndex is NewIndex over the songs of one library.
+func NewLibraryIndex(db database.SongStore, library int, lshConfig LSHConfig) (*Index, error) {
+	idx := &Index{db: db, library: library, lshConfig: lshConfig, decision: DefaultDecisionConfig()}
+	if err := idx.Rebuild(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Rebuild reloads every song from the database and atomically replaces the
+// in-memory contents.
+func (idx *Index) Rebuild() error {
+	start := time.Now()
+
+	songs, err := idx.db.ListSongs(database.SongFilter{LibraryID: idx.library})
+	if err != nil {
+		return fmt.Errorf("failed to load songs: %v", err)
+	}
+
+	bySong := make(map[int]*database.Song, len(songs))
+	var lsh *LSH
+	if idx.lshConfig.Tables > 0 {
+		lsh = NewLSH(idx.lshConfig)
+	}
+	for _, song := range songs {
+		bySong[song.ID] = song
+		if lsh != nil {
+			lsh.Insert(song)
+		}
+	}
+
+	idx.mu.Lock()
+	idx.songs = bySong
+	idx.lsh = lsh
+	idx.reorder()
+	idx.loadedAt = time.Now()
+	idx.loadTime = time.Since(start)
+	idx.mu.Unlock()
+
+	return nil
+}
+
+// Add inserts a newly stored song, replacing any entry with the same ID.
+func (idx *Index) Add(song *database.Song) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.lsh != nil {
+		if old, ok := idx.songs[song.ID]; ok {
+			idx.lsh.Remove(old)
+		}
+		idx.lsh.Insert(song)
+	}
+	idx.songs[song.ID] = song
+	idx.reorder()
+}
+
+// Update replaces an existing entry. Songs unknown to the index are added.
+func (idx *Index) Update(song *database.Song) {
+	idx.Add(song)
+}
+
+func (idx *Index) Remove(id int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	old, ok := idx.songs[id]
+	if !ok {
+		return
+	}
+	if idx.lsh != nil {
+		idx.lsh.Remove(old)
+	}
+	delete(idx.songs, id)
+	idx.reorder()
+}
+
+// SetDecision replaces the model that decides whether results match.
+func (idx *Index) SetDecision(cfg DecisionConfig) {
+	idx.mu.Lock()
+	idx.decision = cfg
+	idx.mu.Unlock()
+}
+
+func (idx *Index) Decision() DecisionConfig {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.decision
+}
+
+// Songs returns the indexed songs ordered by artist and title. The slice is
+// shared and must not be modified by the caller.
+func (idx *Index) Songs() []*database.Song {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.ordered
+}
+
+func (idx *Index) Song(id int) (*database.Song, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	song, ok := idx.songs[id]
+	return song, ok
+}
+
+// Candidates proposes alignments of the songs in songs for a query through
+// the LSH tables. The second return value is false when LSH is disabled.
+func (idx *Index) Candidates(qry database.HashSegments, songs SongSet) ([]Candidate, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if idx.lsh == nil {
+		return nil, false
+	}
+	return idx.lsh.Query(qry, songs), true
+}
+
+func (idx *Index) Stats() IndexStats {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	stats := IndexStats{
+		Songs:      len(idx.ordered),
+		LoadedAt:   idx.loadedAt,
+		LoadMillis: idx.loadTime.Milliseconds(),
+	}
+	for _, song := range idx.ordered {
+		stats.Segments += len(song.HashSegments)
+		stats.ApproxBytes += songOverhead + len(song.Title) + len(song.Artist) +
+			len(song.Album) + len(song.Fingerprint)
+	}
+	stats.SegmentBytes = stats.Segments * 8
+	stats.ApproxBytes += stats.SegmentBytes
+	if idx.lsh != nil {
+		stats.LSHTables = len(idx.lsh.tables)
+		stats.LSHPostings = idx.lsh.Postings()
+		stats.ApproxBytes += stats.LSHPostings * postingOverhead
+	}
+
+	return stats
+}
+
+// reorder rebuilds the ordered view. A fresh slice is allocated on every
+// change so readers holding the previous one are never affected.
+// Callers must hold the write lock.
+func (idx *Index) reorder() {
+	ordered := make([]*database.Song, 0, len(idx.songs))
+	for _, song := range idx.songs {
+		ordered = append(ordered, song)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		if a.Artist != b.Artist {
+			return a.Artist < b.Artist
+		}
+		if a.Title != b.Title {
+			return a.Title < b.Title
+		}
+		return a.ID < b.ID
+	})
+	idx.ordered = ordered
+}
+
+// Summary is a short human-readable description used in startup logs.
+func (s IndexStats) Summary() string {
+	return fmt.Sprintf("%d songs, %d segments, ~%.1f MB", s.Songs, s.Segments,
+		float64(s.ApproxBytes)/(1<<20))
+}
@@ -0,0 +1,146 @@
+package matching
+
+import (
+	"sync"
+
+	"Shazam/internal/database"
+)
+
+// Libraries keeps a separate Index for each song library, so that a query
+// against one library is never answered with a song of another and each
+// library's LSH tables hold only its own songs. All of them share one
+// decision model.
+type Libraries struct {
+	db        database.SongStore
+	lshConfig LSHConfig
+
+	mu       sync.Mutex
+	decision DecisionConfig
+	indexes  map[int]*Index
+}
+
+// NewLibraries loads the index of each of the given libraries. Others are
+// loaded when first asked for.
+func NewLibraries(db database.SongStore, lshConfig LSHConfig, libraries []int) (*Libraries, error) {
+	l := &Libraries{
+		db:        db,
+		lshConfig: lshConfig,
+		decision:  DefaultDecisionConfig(),
+		indexes:   make(map[int]*Index),
+	}
+	for _, library := range libraries {
+		if _, err := l.Index(library); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Index returns the index of a library, loading it on first use.
+func (l *Libraries) Index(library int) (*Index, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if idx, ok := l.indexes[library]; ok {
+		return idx, nil
+	}
+	idx, err := NewLibraryIndex(l.db, library, l.lshConfig)
+	if err != nil {
+		return nil, err
+	}
+	idx.SetDecision(l.decision)
+	l.indexes[library] = idx
+	return idx, nil
+}
+
+// loaded returns the indexes loaded so far.
+func (l *Libraries) loaded() map[int]*Index {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	indexes := make(map[int]*Index, len(l.indexes))
+	for library, idx := range l.indexes {
+		indexes[library] = idx
+	}
+	return indexes
+}
+
+// Song finds an indexed song whichever library it is in.
+func (l *Libraries) Song(id int) (*database.Song, bool) {
+	for _, idx := range l.loaded() {
+		if song, ok := idx.Song(id); ok {
+			return song, true
+		}
+	}
+	return nil, false
+}
+
+// Add inserts a newly stored song into the index of its library. A library
+// not loaded yet will read the song from the database when it is.
+func (l *Libraries) Add(song *database.Song) {
+	if idx, ok := l.loaded()[song.LibraryID]; ok {
+		idx.Add(song)
+	}
+}
+
+// Update replaces an existing entry in the index of the song's library.
+func (l *Libraries) Update(song *database.Song) {
+	l.Add(song)
+}
+
+// Remove drops a song from whichever index holds it.
+func (l *Libraries) Remove(id int) {
+	for _, idx := range l.loaded() {
+		idx.Remove(id)
+	}
+}
+
+// Drop forgets the index of a deleted library.
+func (l *Libraries) Drop(library int) {
+	l.mu.Lock()
+	delete(l.indexes, library)
+	l.mu.Unlock()
+}
+
+// Rebuild reloads every loaded index from the database.
+func (l *Libraries) Rebuild() error {
+	for _, idx := range l.loaded() {
+		if err := idx.Rebuild(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reload replaces every index with a fresh one for each of the given
+// libraries, as after the database was swapped for another whose
+// libraries may differ. Others are loaded when first asked for.
+func (l *Libraries) Reload(libraries []int) error {
+	indexes := make(map[int]*Index, len(libraries))
+	for _, library := range libraries {
+		idx, err := NewLibraryIndex(l.db, library, l.lshConfig)
+		if err != nil {
+			return err
+		}
+		indexes[library] = idx
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, idx := range indexes {
+		idx.SetDecision(l.decision)
+	}
+	l.indexes = indexes
+	return nil
+}
+
+// SetDecision replaces the decision model of every library.
+func (l *Libraries) SetDecision(cfg DecisionConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.decision = cfg
+	for _, idx := range l.indexes {
+		idx.SetDecision(cfg)
+	}
+}
@@ -0,0 +1,197 @@
+package matching
+
+import (
+	"math/rand"
+	"sort"
+
+	"Shazam/internal/database"
+)
+
+// LSHConfig tunes the locality-sensitive lookup layer. Each table keys
+// segments by a fixed random subset of their nibbles, so two segments that
+// differ in d of 16 nibbles share a bucket in one table with probability
+// C(16-d, k) / C(16, k). More tables raise recall, more nibbles per key
+// raise precision and shrink buckets.
+type LSHConfig struct {
+	// Tables is the number of projections. Zero disables LSH and makes the
+	// matcher fall back to a brute-force scan.
+	Tables int
+	// NibblesPerKey is the number of nibbles each projection samples (1-8).
+	NibblesPerKey int
+	// MaxBucket skips buckets holding more postings than this; they come
+	// from near-constant spectra and carry no information.
+	MaxBucket int
+	// Candidates is how many of the best-voted alignments are verified
+	// with an exact score.
+	Candidates int
+	// Seed fixes the projections so results are reproducible.
+	Seed int64
+}
+
+// DefaultLSHConfig returns settings that recover the brute-force best match
+// for typical recordings while scanning only a small part of the library.
+func DefaultLSHConfig() LSHConfig {
+	return LSHConfig{
+		Tables:        8,
+		NibblesPerKey: 7,
+		MaxBucket:     2000,
+		Candidates:    32,
+		Seed:          1,
+	}
+}
+
+// Candidate is a song alignment proposed by the LSH tables.
+type Candidate struct {
+	SongID int
+	Offset int
+	Votes  int
+}
+
+type posting struct {
+	song int32
+	pos  int32
+}
+
+// LSH maps nibble projections of reference segments to their positions.
+// It is not safe for concurrent use; Index guards it with its own lock.
+type LSH struct {
+	cfg         LSHConfig
+	projections [][]uint
+	tables      []map[uint32][]posting
+}
+
+func NewLSH(cfg LSHConfig) *LSH {
+	if cfg.NibblesPerKey < 1 {
+		cfg.NibblesPerKey = 1
+	}
+	if cfg.NibblesPerKey > 8 {
+		cfg.NibblesPerKey = 8
+	}
+
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	l := &LSH{
+		cfg:         cfg,
+		projections: make([][]uint, cfg.Tables),
+		tables:      make([]map[uint32][]posting, cfg.Tables),
+	}
+	for t := range l.projections {
+		perm := rng.Perm(16)[:cfg.NibblesPerKey]
+		sort.Ints(perm)
+		shifts := make([]uint, len(perm))
+		for i, nibble := range perm {
+			shifts[i] = uint(60 - 4*nibble)
+		}
+		l.projections[t] = shifts
+		l.tables[t] = make(map[uint32][]posting)
+	}
+	return l
+}
+
+func (l *LSH) key(t int, h uint64) uint32 {
+	var k uint32
+	for _, shift := range l.projections[t] {
+		k = k<<4 | uint32(h>>shift&0xf)
+	}
+	return k
+}
+
+func (l *LSH) Insert(song *database.Song) {
+	id := int32(song.ID)
+	for pos, h := range song.HashSegments {
+		if h == database.SilentHash {
+			continue
+		}
+		for t := range l.tables {
+			k := l.key(t, h)
+			l.tables[t][k] = append(l.tables[t][k], posting{song: id, pos: int32(pos)})
+		}
+	}
+}
+
+func (l *LSH) Remove(song *database.Song) {
+	id := int32(song.ID)
+	for _, h := range song.HashSegments {
+		if h == database.SilentHash {
+			continue
+		}
+		for t := range l.tables {
+			k := l.key(t, h)
+			bucket := l.tables[t][k]
+			kept := bucket[:0]
+			for _, p := range bucket {
+				if p.song != id {
+					kept = append(kept, p)
+				}
+			}
+			if len(kept) == 0 {
+				delete(l.tables[t], k)
+			} else {
+				l.tables[t][k] = kept
+			}
+		}
+	}
+}
+
+// Query votes for (song, offset) alignments of the songs in songs whose
+// segments collide with the query in any table and returns the best-voted
+// ones, most votes first. Other songs get no votes, so they never crowd
+// the allowed ones out of the Candidates kept.
+func (l *LSH) Query(qry database.HashSegments, songs SongSet) []Candidate {
+	type alignment struct {
+		song   int32
+		offset int32
+	}
+	votes := make(map[alignment]int)
+
+	for i, h := range qry {
+		if h == database.SilentHash {
+			continue
+		}
+		for t := range l.tables {
+			bucket := l.tables[t][l.key(t, h)]
+			if l.cfg.MaxBucket > 0 && len(bucket) > l.cfg.MaxBucket {
+				continue
+			}
+			for _, p := range bucket {
+				if !songs.Allows(int(p.song)) {
+					continue
+				}
+				offset := p.pos - int32(i)
+				if offset < 0 {
+					continue
+				}
+				votes[alignment{song: p.song, offset: offset}]++
+			}
+		}
+	}
+
+	candidates := make([]Candidate, 0, len(votes))
+	for a, v := range votes {
+		candidates = append(candidates, Candidate{SongID: int(a.song), Offset: int(a.offset), Votes: v})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Votes != candidates[j].Votes {
+			return candidates[i].Votes > candidates[j].Votes
+		}
+		if candidates[i].SongID != candidates[j].SongID {
+			return candidates[i].SongID < candidates[j].SongID
+		}
+		return candidates[i].Offset < candidates[j].Offset
+	})
+
+	if l.cfg.Candidates > 0 && len(candidates) > l.cfg.Candidates {
+		candidates = candidates[:l.cfg.Candidates]
+	}
+	return candidates
+}
+
+// Postings returns the total number of stored postings across all tables.
+func (l *LSH) Postings() int {
+	n := 0
+	for _, table := range l.tables {
+		for _, bucket := range table {
+			n += len(bucket)
+		}
+	}
+	return n
+}
@@ -0,0 +1,69 @@
+package matching
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// TestLSHRecall compares the songs found through the LSH tables with a
+// full scan of the same library, for excerpts heard with more and more
+// noise.
+func TestLSHRecall(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	db := testSongs(t, rng, 30, 1300)
+	lsh := testIndex(t, db, DefaultLSHConfig())
+	exhaustive := testIndex(t, db, LSHConfig{})
+	songs := lsh.Songs()
+
+	for _, test := range []struct {
+		noise     int
+		minRecall float64
+	}{
+		{0, 1},
+		{4, 0.95},
+		{8, 0.9},
+	} {
+		const queries = 20
+		proposed, agreed := 0, 0
+		for i := 0; i < queries; i++ {
+			song := songs[rng.Intn(len(songs))]
+			start := rng.Intn(1300 - 215)
+			query := excerpt(rng, song, start, 215, test.noise)
+
+			candidates, ok := lsh.Candidates(query.HashSegments, nil)
+			if !ok {
+				t.Fatal("LSH is disabled")
+			}
+			for _, c := range candidates {
+				if c.SongID == song.ID && c.Offset == start {
+					proposed++
+					break
+				}
+			}
+
+			fast, err := FindBestMatch(lsh, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			slow, err := FindBestMatch(exhaustive, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fast.Song != nil && slow.Song != nil && fast.Song.ID == slow.Song.ID && fast.MatchOffset == slow.MatchOffset {
+				agreed++
+			}
+		}
+
+		recall := float64(proposed) / queries
+		agreement := float64(agreed) / queries
+		t.Logf("noise %d: recall %.2f, agreement with a full scan %.2f", test.noise, recall, agreement)
+		if recall < test.minRecall {
+			t.Errorf("noise %d: the tables proposed the right alignment for %.0f%% of queries, want %.0f%%",
+				test.noise, 100*recall, 100*test.minRecall)
+		}
+		if agreement < test.minRecall {
+			t.Errorf("noise %d: LSH agreed with a full scan on %.0f%% of queries, want %.0f%%",
+				test.noise, 100*agreement, 100*test.minRecall)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package matching
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"Shazam/internal/audio"
+)
+
+// SegmentOptions controls how a long recording is split into tracks.
+type SegmentOptions struct {
+	// WindowSeconds is the length of each identified region.
+	WindowSeconds float64
+	// HopSeconds is the step between consecutive windows.
+	HopSeconds float64
+	// MaxDriftSeconds is how far the song position may wander between two
+	// windows that are still merged into one segment.
+	MaxDriftSeconds float64
+	// MinSeconds drops segments shorter than this after merging.
+	MinSeconds float64
+}
+
+func DefaultSegmentOptions() SegmentOptions {
+	return SegmentOptions{
+		WindowSeconds:   10,
+		HopSeconds:      5,
+		MaxDriftSeconds: 3,
+		MinSeconds:      10,
+	}
+}
+
+// TrackSegment is one contiguous region of a recording attributed to a song.
+type TrackSegment struct {
+	Start      float64 `json:"start"`
+	End        float64 `json:"end"`
+	SongID     int     `json:"song_id"`
+	Title      string  `json:"title"`
+	Artist     string  `json:"artist"`
+	Album      string  `json:"album,omitempty"`
+	Confidence float64 `json:"confidence"`
+	TimeInSong float64 `json:"time_in_song"`
+	Windows    int     `json:"windows"`
+}
+
+// FindTracklist slides a window across a long query, identifies every
+// window and merges neighbouring windows that agree on the song and its
+// playback position into segments. Segment times count from the start of
+// the recording, including the inactive frames trimmed before its first
+// hash.
+func FindTracklist(idx *Index, query *audio.AudioFingerprint, opts SegmentOptions) []*TrackSegment {
+	qry := query.HashSegments
+	lead := float64(query.Offset) * timePerSegment
+	window := int(math.Round(opts.WindowSeconds / timePerSegment))
+	hop := int(math.Round(opts.HopSeconds / timePerSegment))
+	if window < 1 || hop < 1 {
+		return nil
+	}
+	if window > len(qry) {
+		window = len(qry)
+	}
+
+	var segments []*TrackSegment
+	var current *TrackSegment
+
+	for start := 0; start+window <= len(qry); start += hop {
+		best := Identify(idx, qry[start:start+window])
+		if !best.IsMatch {
+			current = nil
+			continue
+		}
+		windowStart := lead + float64(start)*timePerSegment
+		windowEnd := lead + float64(start+window)*timePerSegment
+
+		if current != nil && current.SongID == best.Song.ID {
+			expected := current.TimeInSong + (windowStart - current.Start)
+			if math.Abs(best.TimeInSong-expected) <= opts.MaxDriftSeconds {
+				current.Confidence += (best.Confidence - current.Confidence) / float64(current.Windows+1)
+				current.End = windowEnd
+				current.Windows++
+				continue
+			}
+		}
+
+		current = &TrackSegment{
+			Start:      windowStart,
+			End:        windowEnd,
+			SongID:     best.Song.ID,
+			Title:      best.Song.Title,
+			Artist:     best.Song.Artist,
+			Album:      best.Song.Album,
+			Confidence: best.Confidence,
+			TimeInSong: best.TimeInSong,
+			Windows:    1,
+		}
+		segments = append(segments, current)
+	}
+
+	return settleSegments(segments, opts.MinSeconds)
+}
+
+// settleSegments drops short segments and splits the overlap left by
+// overlapping windows at its midpoint.
+func settleSegments(segments []*TrackSegment, minSeconds float64) []*TrackSegment {
+	kept := segments[:0]
+	for _, seg := range segments {
+		if seg.End-seg.Start >= minSeconds {
+			kept = append(kept, seg)
+		}
+	}
+
+	for i := 1; i < len(kept); i++ {
+		prev, next := kept[i-1], kept[i]
+		if prev.End > next.Start {
+			mid := (prev.End + next.Start) / 2
+			next.TimeInSong += mid - next.Start
+			prev.End, next.Start = mid, mid
+		}
+	}
+	return kept
+}
+
+// WriteCUE renders segments as a CUE sheet referencing audioFile.
+func WriteCUE(w io.Writer, title, audioFile string, segments []*TrackSegment) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "TITLE %s\n", cueQuote(title))
+	fmt.Fprintf(&b, "FILE %s %s\n", cueQuote(audioFile), cueFileType(audioFile))
+	for i, seg := range segments {
+		fmt.Fprintf(&b, "  TRACK %02d AUDIO\n", i+1)
+		fmt.Fprintf(&b, "    TITLE %s\n", cueQuote(seg.Title))
+		fmt.Fprintf(&b, "    PERFORMER %s\n", cueQuote(seg.Artist))
+		fmt.Fprintf(&b, "    INDEX 01 %s\n", cueTimestamp(seg.Start))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// cueTimestamp formats seconds as mm:ss:ff with 75 frames per second.
+func cueTimestamp(seconds float64) string {
+	frames := int(math.Round(seconds * 75))
+	return fmt.Sprintf("%02d:%02d:%02d", frames/(75*60), frames/75%60, frames%75)
+}
+
+func cueQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+}
+
+func cueFileType(name string) string {
+	switch {
+	case strings.HasSuffix(strings.ToLower(name), ".mp3"):
+		return "MP3"
+	case strings.HasSuffix(strings.ToLower(name), ".aif"), strings.HasSuffix(strings.ToLower(name), ".aiff"):
+		return "AIFF"
+	default:
+		return "WAVE"
+	}
+}
@@ -0,0 +1,87 @@
+package matching
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"Shazam/internal/audio"
+	"Shazam/internal/database"
+)
+
+// part is a stretch of a mix: segments of song from start, or of unknown
+// audio when song is nil. Parts after the first start well into their
+// song, as an alignment cannot begin before a song does.
+type part struct {
+	song          *database.Song
+	start, length int
+}
+
+func TestFindTracklist(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	idx := testIndex(t, testSongs(t, rng, 5, 1300), DefaultLSHConfig())
+	songs := idx.Songs()
+	a, b, c := songs[0], songs[1], songs[2]
+
+	for _, test := range []struct {
+		name  string
+		parts []part
+		// minSeconds overrides the default MinSeconds when set.
+		minSeconds float64
+		// want lists the parts found, by index.
+		want []int
+	}{
+		{"one song", []part{{a, 100, 1000}}, 0, []int{0}},
+		{"unknown audio", []part{{nil, 0, 1000}}, 0, nil},
+		{"two songs with a gap", []part{{a, 100, 900}, {nil, 0, 400}, {b, 200, 1000}}, 0, []int{0, 2}},
+		{"back to back", []part{{a, 0, 900}, {b, 300, 900}, {c, 300, 900}}, 0, []int{0, 1, 2}},
+		{"same song twice", []part{{a, 0, 800}, {a, 400, 800}}, 0, []int{0, 1}},
+		{"too short to keep", []part{{a, 0, 1000}, {b, 500, 300}, {c, 100, 1200}}, 18, []int{0, 2}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			const lead = 20
+			var mix database.HashSegments
+			starts := make([]float64, len(test.parts))
+			for i, p := range test.parts {
+				starts[i] = float64(lead+len(mix)) * timePerSegment
+				if p.song == nil {
+					unknown := testSongs(t, rng, 1, p.length)
+					p.song, _ = unknown.GetSong(1)
+				}
+				mix = append(mix, excerpt(rng, p.song, p.start, p.length, 2).HashSegments...)
+			}
+
+			opts := DefaultSegmentOptions()
+			if test.minSeconds > 0 {
+				opts.MinSeconds = test.minSeconds
+			}
+			got := FindTracklist(idx, &audio.AudioFingerprint{HashSegments: mix, Offset: lead}, opts)
+			if len(got) != len(test.want) {
+				for _, seg := range got {
+					t.Logf("song %d from %.1f to %.1f", seg.SongID, seg.Start, seg.End)
+				}
+				t.Fatalf("found %d segments, want %d", len(got), len(test.want))
+			}
+			for i, seg := range got {
+				p := test.parts[test.want[i]]
+				if seg.SongID != p.song.ID {
+					t.Errorf("segment %d is song %d, want %d", i, seg.SongID, p.song.ID)
+					continue
+				}
+				if i > 0 && seg.Start < got[i-1].End {
+					t.Errorf("segment %d starts at %.1f before the previous ends at %.1f", i, seg.Start, got[i-1].End)
+				}
+				// Windows fall every HopSeconds, so boundaries land within
+				// one hop of the true change.
+				start := starts[test.want[i]]
+				if math.Abs(seg.Start-start) > opts.HopSeconds {
+					t.Errorf("segment %d starts at %.1f, want about %.1f", i, seg.Start, start)
+				}
+				want := float64(p.start)*timePerSegment + seg.Start - start
+				if math.Abs(seg.TimeInSong-want) > opts.MaxDriftSeconds {
+					t.Errorf("segment %d is at %.1f in the song, want about %.1f", i, seg.TimeInSong, want)
+				}
+			}
+		})
+	}
+}
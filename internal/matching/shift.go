@@ -0,0 +1,179 @@
+package matching
+
+import (
+	"math"
+
+	"Shazam/internal/audio"
+	"Shazam/internal/database"
+)
+
+// ShiftOptions bounds the search of shift-tolerant matching. MaxTempo and
+// MaxPitch are the largest relative deviations tried in each direction and
+// Step is the resolution of the detected factors. A zero MaxPitch searches
+// tempo only. Songs, when set, restricts the search as in GetTopMatchesIn.
+type ShiftOptions struct {
+	MaxTempo float64
+	MaxPitch float64
+	Step     float64
+	Songs    SongSet
+}
+
+func DefaultShiftOptions() ShiftOptions {
+	return ShiftOptions{MaxTempo: 0.1, MaxPitch: 0.1, Step: 0.02}
+}
+
+// grid lists the shifts within the limits spaced step apart, unshifted
+// included.
+func (o ShiftOptions) grid(step float64) []audio.Shift {
+	factors := func(limit float64) []float64 {
+		out := []float64{1}
+		for d := step; step > 0 && d <= limit+1e-9; d += step {
+			out = append(out, round2(1+d), round2(1-d))
+		}
+		return out
+	}
+
+	var shifts []audio.Shift
+	for _, tempo := range factors(o.MaxTempo) {
+		for _, pitch := range factors(o.MaxPitch) {
+			shifts = append(shifts, audio.Shift{Tempo: tempo, Pitch: pitch})
+		}
+	}
+	return shifts
+}
+
+// neighbours lists the shifts one step away from s that lie within the
+// limits.
+func (o ShiftOptions) neighbours(s audio.Shift) []audio.Shift {
+	var out []audio.Shift
+	for _, dt := range []float64{-o.Step, 0, o.Step} {
+		for _, dp := range []float64{-o.Step, 0, o.Step} {
+			n := audio.Shift{Tempo: round2(s.Tempo + dt), Pitch: round2(s.Pitch + dp)}
+			if n != s && math.Abs(n.Tempo-1) <= o.MaxTempo+1e-9 && math.Abs(n.Pitch-1) <= o.MaxPitch+1e-9 {
+				out = append(out, n)
+			}
+		}
+	}
+	return out
+}
+
+func round2(x float64) float64 {
+	return math.Round(x*100) / 100
+}
+
+// GetTopMatchesShifted identifies samples that may have been sped up,
+// slowed down or transposed. The unshifted query is tried first and
+// returned when it already matches. Otherwise a grid twice as coarse as
+// opts.Step is searched, then refined around the best shift found; each
+// song keeps the shift it scores best with. Results carry the detected
+// TempoFactor and PitchFactor.
+func GetTopMatchesShifted(idx *Index, samples []float64, topN int, opts ShiftOptions) ([]*database.MatchResult, error) {
+	search := newShiftSearch(idx, opts.Songs)
+	unshifted := audio.Shift{Tempo: 1, Pitch: 1}
+	if err := search.try(samples, []audio.Shift{unshifted}); err != nil {
+		return nil, err
+	}
+
+	results := search.results()
+	if len(results) == 0 || !results[0].IsMatch {
+		if err := search.try(samples, opts.grid(2*opts.Step)); err != nil {
+			return nil, err
+		}
+		if best := search.best(); best != nil {
+			shift := audio.Shift{Tempo: best.TempoFactor, Pitch: best.PitchFactor}
+			if err := search.try(samples, opts.neighbours(shift)); err != nil {
+				return nil, err
+			}
+		}
+		results = search.results()
+	}
+
+	if topN > len(results) {
+		topN = len(results)
+	}
+	return results[:topN], nil
+}
+
+// shiftSearch accumulates the best scoring shift per song over the shifts
+// tried so far.
+type shiftSearch struct {
+	idx     *Index
+	songs   SongSet
+	tried   map[audio.Shift]bool
+	bySong  map[int]*database.MatchResult
+	queries map[int]*audio.AudioFingerprint
+}
+
+func newShiftSearch(idx *Index, songs SongSet) *shiftSearch {
+	return &shiftSearch{
+		idx:     idx,
+		songs:   songs,
+		tried:   make(map[audio.Shift]bool),
+		bySong:  make(map[int]*database.MatchResult),
+		queries: make(map[int]*audio.AudioFingerprint),
+	}
+}
+
+func (s *shiftSearch) try(samples []float64, shifts []audio.Shift) error {
+	var pending []audio.Shift
+	for _, shift := range shifts {
+		if !s.tried[shift] {
+			s.tried[shift] = true
+			pending = append(pending, shift)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	fingerprints, err := audio.GenerateShiftedFingerprints(samples, pending)
+	if err != nil {
+		return err
+	}
+	for i, fp := range fingerprints {
+		for _, r := range score(s.idx, fp.HashSegments, s.songs) {
+			if best, seen := s.bySong[r.Song.ID]; seen && best.Score >= r.Score {
+				continue
+			}
+			r.TempoFactor = pending[i].Tempo
+			r.PitchFactor = pending[i].Pitch
+			shiftedFromQueryStart(r, fp.Offset)
+			s.bySong[r.Song.ID] = r
+			s.queries[r.Song.ID] = fp
+		}
+	}
+	return nil
+}
+
+func (s *shiftSearch) best() *database.MatchResult {
+	var best *database.MatchResult
+	for _, r := range s.bySong {
+		if best == nil || r.Score > best.Score || r.Score == best.Score && r.Song.ID < best.Song.ID {
+			best = r
+		}
+	}
+	return best
+}
+
+// results ranks the songs and calibrates the ranking against the query of
+// the winning shift.
+func (s *shiftSearch) results() []*database.MatchResult {
+	results := make([]*database.MatchResult, 0, len(s.bySong))
+	for _, r := range s.bySong {
+		results = append(results, r)
+	}
+	sortByScore(results)
+	if len(results) > 0 {
+		s.idx.Decision().decide(s.queries[results[0].Song.ID].HashSegments, results)
+	}
+	return results
+}
+
+// shiftedFromQueryStart moves the time of a shifted result back by the
+// offset inactive frames trimmed from its query. Those frames were taken
+// HopSize/TempoFactor samples apart, so they span that much less of the
+// clip, which covers TempoFactor times as much of the song.
+func shiftedFromQueryStart(r *database.MatchResult, offset int) {
+	trimmed := float64(offset) * timePerSegment / r.TempoFactor
+	r.TimeInSong = max(r.TimeInSong-trimmed*r.TempoFactor, 0)
+}
@@ -0,0 +1,339 @@
+// Package monitor listens to audio streams continuously, identifies what
+// is playing and records each airplay to the database.
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"sync"
+	"time"
+
+	"Shazam/internal/audio"
+	"Shazam/internal/database"
+	"Shazam/internal/matching"
+)
+
+// Options tunes how plays are detected on a stream.
+type Options struct {
+	// WindowSeconds is the amount of audio identified at a time.
+	WindowSeconds float64
+	// HopSeconds is how often a new window is identified.
+	HopSeconds float64
+	// GapSeconds is how long a song may go unrecognised before its play is
+	// considered over.
+	GapSeconds float64
+	// MinPlaySeconds drops plays shorter than this, which are usually
+	// jingles or talk over a song's intro.
+	MinPlaySeconds float64
+	// ReconnectDelay is the pause before reconnecting to a dropped stream.
+	ReconnectDelay time.Duration
+}
+
+func DefaultOptions() Options {
+	return Options{
+		WindowSeconds:  10,
+		HopSeconds:     5,
+		GapSeconds:     15,
+		MinPlaySeconds: 20,
+		ReconnectDelay: 5 * time.Second,
+	}
+}
+
+// Status is the live state of one monitor.
+type Status struct {
+	MonitorID  int               `json:"monitor_id"`
+	Running    bool              `json:"running"`
+	Error      string            `json:"error,omitempty"`
+	ListenedAt *time.Time        `json:"listened_at,omitempty"`
+	NowPlaying *database.Airplay `json:"now_playing,omitempty"`
+}
+
+// Service runs one listener goroutine per started monitor, identifying
+// against the library of the monitor.
+type Service struct {
+	db        database.Store
+	libraries *matching.Libraries
+	opts      Options
+
+	mu      sync.Mutex
+	workers map[int]*worker
+}
+
+type worker struct {
+	monitor *database.Monitor
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	mu     sync.Mutex
+	status Status
+}
+
+func NewService(db database.Store, libraries *matching.Libraries, opts Options) *Service {
+	return &Service{
+		db:        db,
+		libraries: libraries,
+		opts:      opts,
+		workers:   make(map[int]*worker),
+	}
+}
+
+// StartAll starts every monitor that is enabled in the database.
+func (s *Service) StartAll() error {
+	monitors, err := s.db.GetMonitors()
+	if err != nil {
+		return err
+	}
+	for _, m := range monitors {
+		if m.Enabled {
+			s.Start(m)
+		}
+	}
+	return nil
+}
+
+// Start begins listening to a monitor, restarting it if already running.
+func (s *Service) Start(m *database.Monitor) {
+	s.Stop(m.ID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &worker{
+		monitor: m,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		status:  Status{MonitorID: m.ID, Running: true},
+	}
+
+	s.mu.Lock()
+	s.workers[m.ID] = w
+	s.mu.Unlock()
+
+	go func() {
+		defer close(w.done)
+		s.run(ctx, w)
+	}()
+}
+
+// Stop halts a monitor and waits for its current play to be recorded.
+func (s *Service) Stop(id int) {
+	s.mu.Lock()
+	w, ok := s.workers[id]
+	delete(s.workers, id)
+	s.mu.Unlock()
+
+	if ok {
+		w.cancel()
+		<-w.done
+	}
+}
+
+func (s *Service) StopAll() {
+	s.mu.Lock()
+	ids := make([]int, 0, len(s.workers))
+	for id := range s.workers {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+
+	for _, id := range ids {
+		s.Stop(id)
+	}
+}
+
+func (s *Service) Status(id int) Status {
+	s.mu.Lock()
+	w, ok := s.workers[id]
+	s.mu.Unlock()
+
+	if !ok {
+		return Status{MonitorID: id}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+func (w *worker) update(fn func(*Status)) {
+	w.mu.Lock()
+	fn(&w.status)
+	w.mu.Unlock()
+}
+
+// run keeps a stream connected until ctx is cancelled. Monitors saved
+// before their URLs were checked are refused rather than opened.
+func (s *Service) run(ctx context.Context, w *worker) {
+	if err := ValidateURL(w.monitor.URL); err != nil {
+		log.Printf("📻 Monitor %d: %v", w.monitor.ID, err)
+		w.update(func(st *Status) {
+			st.Running = false
+			st.Error = err.Error()
+		})
+		return
+	}
+	log.Printf("📻 Monitor %d (%s) listening to %s", w.monitor.ID, w.monitor.Name, w.monitor.URL)
+
+	for {
+		err := s.listen(ctx, w)
+		if ctx.Err() != nil {
+			break
+		}
+
+		msg := "stream ended"
+		if err != nil {
+			msg = err.Error()
+		}
+		log.Printf("📻 Monitor %d: %s, reconnecting in %s", w.monitor.ID, msg, s.opts.ReconnectDelay)
+		w.update(func(st *Status) { st.Error = msg })
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(s.opts.ReconnectDelay):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	w.update(func(st *Status) {
+		st.Running = false
+		st.NowPlaying = nil
+	})
+	log.Printf("📻 Monitor %d stopped", w.monitor.ID)
+}
+
+// listen consumes one connection to the stream. Play times are derived
+// from the decoded sample position, counting the silence skipped before
+// the first segment, so they track the broadcast clock even when decoding
+// runs faster than real time.
+func (s *Service) listen(ctx context.Context, w *worker) error {
+	index, err := s.libraries.Index(w.monitor.LibraryID)
+	if err != nil {
+		return err
+	}
+	sessionStart := time.Now().UTC()
+	segmentDuration := audio.HopSize * time.Second / audio.SampleRate
+	window := int(math.Round(s.opts.WindowSeconds * audio.SampleRate / audio.HopSize))
+	hop := int(math.Round(s.opts.HopSeconds * audio.SampleRate / audio.HopSize))
+
+	tracker := &playTracker{service: s, worker: w}
+	defer tracker.close()
+
+	var fp audio.StreamFingerprinter
+	var recent database.HashSegments
+	total, sinceCheck := 0, 0
+
+	err = audio.DecodeStream(ctx, w.monitor.URL, audio.SampleRate/4, func(samples []float64) error {
+		segments := fp.Push(samples)
+		recent = append(recent, segments...)
+		total += len(segments)
+		sinceCheck += len(segments)
+
+		if len(recent) < window || sinceCheck < hop {
+			return nil
+		}
+		recent = append(recent[:0], recent[len(recent)-window:]...)
+		sinceCheck = 0
+
+		end := sessionStart.Add(time.Duration(fp.Offset()+total) * segmentDuration)
+		start := end.Add(-time.Duration(window) * segmentDuration)
+		tracker.observe(matching.Identify(index, recent), start, end)
+
+		w.update(func(st *Status) {
+			st.Error = ""
+			st.ListenedAt = &end
+		})
+		return nil
+	})
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
+
+// playTracker turns the stream of per-window matches into airplay events.
+type playTracker struct {
+	service *Service
+	worker  *worker
+	current *database.Airplay
+	windows int
+}
+
+func (t *playTracker) observe(best *database.MatchResult, start, end time.Time) {
+	opts := t.service.opts
+
+	if best.IsMatch && best.Song != nil {
+		if t.current != nil && t.current.SongID == best.Song.ID {
+			t.windows++
+			t.current.EndedAt = end
+			t.current.Confidence += (best.Confidence - t.current.Confidence) / float64(t.windows)
+			t.publish()
+			return
+		}
+
+		t.close()
+		t.current = &database.Airplay{
+			MonitorID:  t.worker.monitor.ID,
+			SongID:     best.Song.ID,
+			Title:      best.Song.Title,
+			Artist:     best.Song.Artist,
+			StartedAt:  start.Truncate(time.Second),
+			EndedAt:    end,
+			Confidence: best.Confidence,
+		}
+		t.windows = 1
+		t.publish()
+		return
+	}
+
+	if t.current != nil && end.Sub(t.current.EndedAt).Seconds() > opts.GapSeconds {
+		t.close()
+	}
+}
+
+func (t *playTracker) publish() {
+	play := *t.current
+	t.worker.update(func(st *Status) { st.NowPlaying = &play })
+}
+
+// close records the current play if it lasted long enough.
+func (t *playTracker) close() {
+	play := t.current
+	t.current = nil
+	t.worker.update(func(st *Status) { st.NowPlaying = nil })
+	if play == nil {
+		return
+	}
+
+	play.EndedAt = play.EndedAt.Truncate(time.Second)
+	if play.EndedAt.Sub(play.StartedAt).Seconds() < t.service.opts.MinPlaySeconds {
+		return
+	}
+
+	if err := t.service.db.AddAirplay(play); err != nil {
+		log.Printf("📻 Monitor %d: failed to record play of %s - %s: %v",
+			play.MonitorID, play.Artist, play.Title, err)
+		return
+	}
+	log.Printf("📻 Monitor %d: %s - %s (%s, %.0f%%)", play.MonitorID, play.Artist, play.Title,
+		play.EndedAt.Sub(play.StartedAt), play.Confidence*100)
+}
+
+// ValidateURL checks that a monitor source is a network stream ffmpeg can
+// open. Local paths and file URLs are refused, as ffmpeg would read any
+// file on the host.
+func ValidateURL(input string) error {
+	if input == "" {
+		return fmt.Errorf("url is required")
+	}
+	u, err := url.Parse(input)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("url must be a stream address such as http://host/stream")
+	}
+	switch u.Scheme {
+	case "http", "https", "rtmp", "icecast":
+		return nil
+	}
+	return fmt.Errorf("unsupported stream scheme %q", u.Scheme)
+}
@@ -0,0 +1,214 @@
+package monitor_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"Shazam/config"
+	"Shazam/internal/audio"
+	"Shazam/internal/database"
+	"Shazam/internal/handlers"
+	"Shazam/internal/matching"
+	"Shazam/internal/monitor"
+)
+
+func TestValidateURL(t *testing.T) {
+	for _, input := range []string{
+		"http://radio.example/live",
+		"https://radio.example:8443/stream.mp3",
+		"rtmp://media.example/live/stream",
+		"icecast://source:pw@radio.example:8000/mount",
+	} {
+		if err := monitor.ValidateURL(input); err != nil {
+			t.Errorf("ValidateURL(%q) = %v, want nil", input, err)
+		}
+	}
+	for _, input := range []string{
+		"",
+		"/etc/passwd",
+		"data/songs.db",
+		"file:///etc/passwd",
+		"rtsp://camera.example/feed",
+		"concat:/etc/passwd|/etc/hosts",
+		"http:///no-host",
+	} {
+		if err := monitor.ValidateURL(input); err == nil {
+			t.Errorf("ValidateURL(%q) = nil, want an error", input)
+		}
+	}
+}
+
+// testClip is seconds of mono audio whose spectrum changes every fifth of
+// a second, so that every window of it fingerprints distinctly.
+func testClip(seconds float64) []float64 {
+	rng := rand.New(rand.NewSource(1))
+	samples := make([]float64, int(seconds*audio.SampleRate))
+	noteLength := audio.SampleRate / 5
+	var freqs [3]float64
+	for i := range samples {
+		if i%noteLength == 0 {
+			for j := range freqs {
+				freqs[j] = 200 + rng.Float64()*3000
+			}
+		}
+		t := float64(i) / audio.SampleRate
+		for _, f := range freqs {
+			samples[i] += 0.2 * math.Sin(2*math.Pi*f*t)
+		}
+		samples[i] += 0.01 * rng.NormFloat64()
+	}
+	return samples
+}
+
+// encodeWAV writes samples as 16-bit mono PCM.
+func encodeWAV(samples []float64) []byte {
+	var buf bytes.Buffer
+	size := uint32(2 * len(samples))
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, 36+size)
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, []interface{}{
+		uint32(16), uint16(1), uint16(1), uint32(audio.SampleRate), uint32(2 * audio.SampleRate), uint16(2), uint16(16),
+	})
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, size)
+	for _, s := range samples {
+		binary.Write(&buf, binary.LittleEndian, int16(math.Max(-1, math.Min(1, s))*math.MaxInt16))
+	}
+	return buf.Bytes()
+}
+
+// TestMonitorSession listens to a station that drops the connection
+// halfway through a song, plays it in full on reconnecting and then goes
+// off the air, and checks the plays logged and reported for it.
+func TestMonitorSession(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg is not installed")
+	}
+	// The handlers load their templates from the repository root.
+	t.Chdir("../..")
+
+	samples := testClip(30)
+	fp, err := audio.GenerateFingerprint(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := database.NewMemoryStore()
+	song := &database.Song{Title: "test clip", Artist: "monitor", Fingerprint: fp.Fingerprint, HashSegments: fp.HashSegments}
+	if err := db.AddSong(song); err != nil {
+		t.Fatal(err)
+	}
+	libraries, err := matching.NewLibraries(db, matching.DefaultLSHConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wav := encodeWAV(samples)
+	var connections atomic.Int32
+	station := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch connections.Add(1) {
+		case 1:
+			w.Header().Set("Content-Type", "audio/wav")
+			w.Header().Set("Content-Length", strconv.Itoa(len(wav)))
+			w.Write(wav[:len(wav)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		case 2:
+			w.Header().Set("Content-Type", "audio/wav")
+			w.Write(wav)
+		default:
+			http.Error(w, "Off the air", http.StatusServiceUnavailable)
+		}
+	}))
+	defer station.Close()
+
+	m := &database.Monitor{Name: "test station", URL: station.URL + "/live.wav", Enabled: true}
+	if err := db.AddMonitor(m); err != nil {
+		t.Fatal(err)
+	}
+	service := monitor.NewService(db, libraries, monitor.Options{
+		WindowSeconds:  5,
+		HopSeconds:     2.5,
+		GapSeconds:     10,
+		MinPlaySeconds: 8,
+		ReconnectDelay: 20 * time.Millisecond,
+	})
+	service.Start(m)
+	for deadline := time.Now().Add(time.Minute); connections.Load() < 3; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			service.Stop(m.ID)
+			t.Fatalf("station connected to %d times, want at least 3", connections.Load())
+		}
+	}
+	service.Stop(m.ID)
+	if status := service.Status(m.ID); status.Running {
+		t.Errorf("monitor still running after Stop: %+v", status)
+	}
+
+	h := handlers.New(db, libraries, service, nil, &config.Config{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/monitors/{id}/plays", h.MonitorPlays)
+	mux.HandleFunc("/api/monitors/{id}/report", h.MonitorReport)
+	get := func(path string, v interface{}) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", path, rec.Code, rec.Body)
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+
+	var plays []*database.Airplay
+	get(fmt.Sprintf("/api/monitors/%d/plays", m.ID), &plays)
+	if len(plays) != 2 {
+		t.Fatalf("got %d plays, want one before and one after the reconnect: %+v", len(plays), plays)
+	}
+	// Plays are listed newest first: the full play after reconnecting, then
+	// the half heard before the connection dropped.
+	full, dropped := plays[0], plays[1]
+	for _, play := range plays {
+		if play.SongID != song.ID || play.Title != song.Title || play.MonitorID != m.ID {
+			t.Errorf("play %+v, want song %d on monitor %d", play, song.ID, m.ID)
+		}
+	}
+	if seconds := full.EndedAt.Sub(full.StartedAt).Seconds(); seconds < 25 || seconds > 31 {
+		t.Errorf("full play lasted %.0fs, want about 30s", seconds)
+	}
+	if seconds := dropped.EndedAt.Sub(dropped.StartedAt).Seconds(); seconds < 10 || seconds > 16 {
+		t.Errorf("dropped play lasted %.0fs, want about 15s", seconds)
+	}
+	if full.StartedAt.Before(dropped.StartedAt) {
+		t.Errorf("play after reconnecting started at %s, before the dropped one at %s", full.StartedAt, dropped.StartedAt)
+	}
+
+	var report struct {
+		MonitorID int                        `json:"monitor_id"`
+		Date      string                     `json:"date"`
+		Plays     int                        `json:"plays"`
+		Seconds   float64                    `json:"seconds"`
+		Songs     []*database.AirplaySummary `json:"songs"`
+	}
+	date := full.StartedAt.UTC().Format("2006-01-02")
+	get(fmt.Sprintf("/api/monitors/%d/report?date=%s", m.ID, date), &report)
+	want := full.EndedAt.Sub(full.StartedAt).Seconds() + dropped.EndedAt.Sub(dropped.StartedAt).Seconds()
+	if report.MonitorID != m.ID || report.Date != date || report.Plays != 2 || report.Seconds != want {
+		t.Errorf("report %+v, want 2 plays of %.0fs on %s", report, want, date)
+	}
+	if len(report.Songs) != 1 || report.Songs[0].SongID != song.ID || report.Songs[0].Plays != 2 {
+		t.Errorf("report songs %+v, want song %d played twice", report.Songs, song.ID)
+	}
+}
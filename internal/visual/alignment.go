@@ -0,0 +1,200 @@
+package visual
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"math"
+)
+
+// Alignment describes how a query lines up along one reference song.
+type Alignment struct {
+	Title string
+	// Scores is the fraction of matching query segments at each reference
+	// offset, as matching.OffsetScores computes it.
+	Scores []float64
+	// SecondsPerOffset converts offsets to time in the song, and
+	// StartSeconds is the time in the song of offset 0, which follows any
+	// silence trimmed from its start.
+	SecondsPerOffset float64
+	StartSeconds     float64
+	// Threshold is the score below which an alignment is never a match.
+	Threshold float64
+	// Best is the offset the query was matched at.
+	Best int
+	// Distances is the nibble distance of every query segment at Best,
+	// -1 where it was not compared.
+	Distances []int
+	// MaxDistance is the largest distance that still counts as a match.
+	MaxDistance int
+}
+
+// Layout of the alignment chart in SVG user units.
+const (
+	chartWidth   = 900
+	chartLeft    = 50
+	chartRight   = 20
+	plotTop      = 40
+	plotHeight   = 200
+	stripTop     = plotTop + plotHeight + 50
+	stripHeight  = 36
+	chartHeight  = stripTop + stripHeight + 30
+	maxPlotWidth = chartWidth - chartLeft - chartRight
+)
+
+// WriteAlignment draws the score at every offset as a line chart, with the
+// threshold and best offset marked, above a strip showing which query
+// segments match at the best offset.
+func WriteAlignment(w io.Writer, a Alignment) error {
+	bw := bufio.NewWriter(w)
+	p := func(format string, args ...interface{}) {
+		fmt.Fprintf(bw, format, args...)
+	}
+
+	p(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" font-family="sans-serif" font-size="12">`+"\n",
+		chartWidth, chartHeight, chartWidth, chartHeight)
+	p(`<rect width="100%%" height="100%%" fill="#fff"/>` + "\n")
+	p(`<text x="%d" y="22" font-size="15" font-weight="bold">%s</text>`+"\n", chartLeft, html.EscapeString(a.Title))
+
+	writeScorePlot(p, a)
+	writeSegmentStrip(p, a)
+
+	p("</svg>\n")
+	return bw.Flush()
+}
+
+func writeScorePlot(p func(string, ...interface{}), a Alignment) {
+	y := func(score float64) float64 {
+		return plotTop + plotHeight*(1-math.Max(0, math.Min(1, score)))
+	}
+
+	p(`<rect x="%d" y="%d" width="%d" height="%d" fill="#f7f7fb" stroke="#ccc"/>`+"\n",
+		chartLeft, plotTop, maxPlotWidth, plotHeight)
+	for _, tick := range []float64{0, 0.25, 0.5, 0.75, 1} {
+		p(`<text x="%d" y="%.1f" text-anchor="end" fill="#666">%.0f%%</text>`+"\n",
+			chartLeft-6, y(tick)+4, tick*100)
+	}
+
+	n := len(a.Scores)
+	if n == 0 {
+		p(`<text x="%d" y="%d" text-anchor="middle" fill="#666">No alignment to show</text>`+"\n",
+			chartLeft+maxPlotWidth/2, plotTop+plotHeight/2)
+		return
+	}
+	x := func(offset int) float64 {
+		if n == 1 {
+			return chartLeft
+		}
+		return chartLeft + float64(offset)*maxPlotWidth/float64(n-1)
+	}
+
+	// Time ticks at a round interval of song time giving at most ten
+	// labels.
+	seconds := float64(n-1) * a.SecondsPerOffset
+	step := 1.0
+	for _, s := range []float64{1, 2, 5, 10, 15, 30, 60, 120, 300} {
+		step = s
+		if seconds/s <= 10 {
+			break
+		}
+	}
+	first := math.Ceil(a.StartSeconds/step)*step - a.StartSeconds
+	for t := first; a.SecondsPerOffset > 0 && t <= seconds; t += step {
+		tx := x(int(math.Round(t / a.SecondsPerOffset)))
+		p(`<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" stroke="#e2e2ea"/>`+"\n", tx, plotTop, tx, plotTop+plotHeight)
+		p(`<text x="%.1f" y="%d" text-anchor="middle" fill="#666">%s</text>`+"\n",
+			tx, plotTop+plotHeight+16, clock(a.StartSeconds+t))
+	}
+
+	// Pool offsets into at most one point per unit of width, keeping the
+	// peak so that a narrow match spike is never lost.
+	points := min(n, maxPlotWidth)
+	p(`<polyline fill="none" stroke="#667eea" stroke-width="1.2" points="`)
+	for i := 0; i < points; i++ {
+		lo, hi := span(i, points, n)
+		peak, at := a.Scores[lo], lo
+		for o := lo; o < hi; o++ {
+			if a.Scores[o] > peak {
+				peak, at = a.Scores[o], o
+			}
+		}
+		p("%.1f,%.1f ", x(at), y(peak))
+	}
+	p(`"/>` + "\n")
+
+	if a.Threshold > 0 {
+		p(`<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#F44336" stroke-dasharray="6 4"/>`+"\n",
+			chartLeft, y(a.Threshold), chartLeft+maxPlotWidth, y(a.Threshold))
+		p(`<text x="%d" y="%.1f" text-anchor="end" fill="#F44336">threshold</text>`+"\n",
+			chartLeft+maxPlotWidth-4, y(a.Threshold)-4)
+	}
+
+	if a.Best >= 0 && a.Best < n {
+		bx := x(a.Best)
+		p(`<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" stroke="#4CAF50" stroke-width="1.5"/>`+"\n",
+			bx, plotTop, bx, plotTop+plotHeight)
+		anchor := "start"
+		if bx > chartLeft+maxPlotWidth*0.7 {
+			anchor = "end"
+		}
+		p(`<text x="%.1f" y="%d" text-anchor="%s" fill="#2e7d32">best %s · %.0f%%</text>`+"\n",
+			bx+4, plotTop+14, anchor, clock(a.StartSeconds+float64(a.Best)*a.SecondsPerOffset), a.Scores[a.Best]*100)
+	}
+	p(`<text x="%d" y="%d" text-anchor="middle" fill="#333">time in song</text>`+"\n",
+		chartLeft+maxPlotWidth/2, plotTop+plotHeight+32)
+}
+
+func writeSegmentStrip(p func(string, ...interface{}), a Alignment) {
+	matched := 0
+	for _, d := range a.Distances {
+		if d >= 0 && d <= a.MaxDistance {
+			matched++
+		}
+	}
+	p(`<text x="%d" y="%d" fill="#333">Query segments at best offset: %d of %d match</text>`+"\n",
+		chartLeft, stripTop-8, matched, len(a.Distances))
+
+	n := len(a.Distances)
+	if n == 0 {
+		return
+	}
+	cells := min(n, maxPlotWidth)
+	width := float64(maxPlotWidth) / float64(cells)
+	for i := 0; i < cells; i++ {
+		// A pooled cell shows its worst segment.
+		lo, hi := span(i, cells, n)
+		worst := a.Distances[lo]
+		for _, d := range a.Distances[lo:hi] {
+			if d < 0 || worst >= 0 && d > worst {
+				worst = d
+			}
+		}
+		p(`<rect x="%.2f" y="%d" width="%.2f" height="%d" fill="%s"/>`+"\n",
+			chartLeft+float64(i)*width, stripTop, width+0.05, stripHeight, distanceColour(worst, a.MaxDistance))
+	}
+	p(`<rect x="%d" y="%d" width="%d" height="%d" fill="none" stroke="#ccc"/>`+"\n",
+		chartLeft, stripTop, maxPlotWidth, stripHeight)
+	p(`<text x="%d" y="%d" fill="#666">green: segment matches, darker is closer · red: differs · grey: silent or past end of song</text>`+"\n",
+		chartLeft, stripTop+stripHeight+18)
+}
+
+// distanceColour shades matching segments green, darker the closer they
+// are, and mismatches red.
+func distanceColour(d, maxDistance int) string {
+	switch {
+	case d < 0:
+		return "#bbb"
+	case d <= maxDistance:
+		t := float64(d) / float64(max(maxDistance, 1))
+		g := 120 + int(100*t)
+		return fmt.Sprintf("rgb(%d,%d,%d)", 40+int(120*t), g+30, 60+int(80*t))
+	default:
+		return "#F44336"
+	}
+}
+
+func clock(seconds float64) string {
+	s := int(math.Round(seconds))
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
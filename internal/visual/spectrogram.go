@@ -0,0 +1,128 @@
+// Package visual renders fingerprints and match alignments as images for
+// debugging identifications. Everything is drawn in pure Go.
+package visual
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+)
+
+// SpectrogramOptions sizes a spectrogram image.
+type SpectrogramOptions struct {
+	// MaxWidth caps the width in pixels; longer audio is pooled so that
+	// each column covers several frames.
+	MaxWidth int
+	// Height is the number of frequency rows, from 0 Hz at the bottom to
+	// the Nyquist frequency at the top.
+	Height int
+	// DynamicRange is how many dB below the loudest bin are drawn.
+	DynamicRange float64
+	// Bands draws a guide between each of this many equal-width bands, the
+	// bands the fingerprint hashes. Zero draws none.
+	Bands int
+}
+
+func DefaultSpectrogramOptions() SpectrogramOptions {
+	return SpectrogramOptions{
+		MaxWidth:     1600,
+		Height:       256,
+		DynamicRange: 80,
+		Bands:        16,
+	}
+}
+
+// WriteSpectrogram encodes frames, magnitude spectra in time order, as a
+// PNG. Each pixel shows the loudest bin it covers.
+func WriteSpectrogram(w io.Writer, frames [][]float64, opts SpectrogramOptions) error {
+	width := len(frames)
+	if opts.MaxWidth > 0 && width > opts.MaxWidth {
+		width = opts.MaxWidth
+	}
+	width = max(width, 1)
+	height := max(opts.Height, 1)
+	bins := 0
+	if len(frames) > 0 {
+		bins = len(frames[0])
+	}
+
+	levels := make([][]float64, width)
+	peak := math.Inf(-1)
+	for x := range levels {
+		levels[x] = make([]float64, height)
+		first, last := span(x, width, len(frames))
+		for y := range levels[x] {
+			lo, hi := span(y, height, bins)
+			level := 0.0
+			for _, frame := range frames[first:last] {
+				for _, m := range frame[lo:hi] {
+					level = math.Max(level, m)
+				}
+			}
+			db := math.Inf(-1)
+			if level > 0 {
+				db = 20 * math.Log10(level)
+			}
+			levels[x][y] = db
+			peak = math.Max(peak, db)
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x, column := range levels {
+		for y, db := range column {
+			t := 0.0
+			if !math.IsInf(peak, -1) && opts.DynamicRange > 0 {
+				t = 1 + (db-peak)/opts.DynamicRange
+			}
+			img.Set(x, height-1-y, heat(t))
+		}
+	}
+
+	if opts.Bands > 1 {
+		guide := color.RGBA{255, 255, 255, 255}
+		for b := 1; b < opts.Bands; b++ {
+			y := height - 1 - b*height/opts.Bands
+			for x := 0; x < width; x += 4 {
+				img.Set(x, y, guide)
+			}
+		}
+	}
+
+	return png.Encode(w, img)
+}
+
+// span returns the range of n items covered by cell i of cells, never
+// empty when n is not zero.
+func span(i, cells, n int) (int, int) {
+	lo := i * n / cells
+	hi := (i + 1) * n / cells
+	if hi <= lo && lo < n {
+		hi = lo + 1
+	}
+	return lo, hi
+}
+
+// heatStops is a black-purple-orange-yellow colour ramp.
+var heatStops = []color.RGBA{
+	{0, 0, 4, 255},
+	{87, 16, 110, 255},
+	{188, 55, 84, 255},
+	{249, 142, 9, 255},
+	{252, 255, 164, 255},
+}
+
+// heat maps t in [0, 1] onto heatStops, clamping values outside.
+func heat(t float64) color.RGBA {
+	t = math.Max(0, math.Min(1, t))
+	pos := t * float64(len(heatStops)-1)
+	i := min(int(pos), len(heatStops)-2)
+	f := pos - float64(i)
+	a, b := heatStops[i], heatStops[i+1]
+	mix := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + f*(float64(y)-float64(x))))
+	}
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 255}
+}
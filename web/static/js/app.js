@@ -2,6 +2,7 @@ class AudioRecognitionApp {
     constructor() {
         this.socket = null;
         this.recording = false;
+        this.historyId = null;
         this.init();
     }
 
@@ -18,6 +19,13 @@ class AudioRecognitionApp {
             });
         }
 
+        const confirmNone = document.getElementById('confirm-none');
+        if (confirmNone) {
+            confirmNone.addEventListener('click', () => {
+                this.confirmResult(0);
+            });
+        }
+
         const addSongForm = document.getElementById('add-song-form');
         if (addSongForm) {
             addSongForm.addEventListener('submit', (e) => {
@@ -25,6 +33,28 @@ class AudioRecognitionApp {
                 this.addSong();
             });
         }
+
+        document.querySelectorAll('.song-tags-form').forEach(form => {
+            form.addEventListener('submit', (e) => {
+                e.preventDefault();
+                this.saveSongTags(form);
+            });
+        });
+
+        document.querySelectorAll('.playlist-add-form').forEach(form => {
+            form.addEventListener('submit', (e) => {
+                e.preventDefault();
+                this.addToPlaylist(form);
+            });
+        });
+
+        const newPlaylistForm = document.getElementById('new-playlist-form');
+        if (newPlaylistForm) {
+            newPlaylistForm.addEventListener('submit', (e) => {
+                e.preventDefault();
+                this.createPlaylist(newPlaylistForm);
+            });
+        }
     }
 
     setButtonLoading(btn, isLoading, loadingText = 'Buffering...') {
@@ -93,7 +123,7 @@ class AudioRecognitionApp {
         this.showRecordingStatus();
         
         try {
-            const response = await fetch('/api/record', {
+            const response = await fetch('/api/record?covers=true', {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
@@ -178,10 +208,107 @@ class AudioRecognitionApp {
             //this.displaySuccessResult(result);
             this.displayNoMatchResult(result);
         }
+
+        this.renderAlternatives(result);
         
         this.recording = false;
     }
 
+    renderAlternatives(result) {
+        const wrap = document.getElementById('alternatives');
+        const list = document.getElementById('alternatives-list');
+        const noneBtn = document.getElementById('confirm-none');
+        if (!wrap || !list) return;
+
+        this.historyId = result.history_id;
+        const candidates = result.candidates || [];
+        list.innerHTML = '';
+        this.renderAlignment(candidates.length > 0 ? candidates[0].song_id : null);
+
+        if (!this.historyId || candidates.length === 0) {
+            wrap.style.display = 'none';
+            return;
+        }
+
+        candidates.forEach((candidate, i) => {
+            const row = document.createElement('div');
+            row.className = i === 0 && result.is_match ? 'alternative best' : 'alternative';
+
+            const info = document.createElement('div');
+            const name = document.createElement('strong');
+            name.textContent = `${candidate.title} - ${candidate.artist}`;
+            const meta = document.createElement('div');
+            meta.className = 'meta';
+            meta.textContent = `${Math.round(candidate.confidence * 100)}% · at ${this.formatTime(candidate.time_in_song)}`;
+            info.appendChild(name);
+            info.appendChild(meta);
+
+            const why = document.createElement('button');
+            why.className = 'btn btn-secondary';
+            why.title = 'Show how the recording lines up along this song';
+            why.innerHTML = '<i class="fas fa-chart-line"></i>';
+            why.addEventListener('click', () => this.renderAlignment(candidate.song_id, true));
+
+            const btn = document.createElement('button');
+            btn.className = 'btn btn-secondary';
+            btn.textContent = i === 0 && result.is_match ? "Yes, that's it" : 'This one';
+            btn.addEventListener('click', () => this.confirmResult(candidate.song_id));
+
+            row.appendChild(info);
+            row.appendChild(why);
+            row.appendChild(btn);
+            list.appendChild(row);
+        });
+
+        if (noneBtn) noneBtn.style.display = '';
+        wrap.style.display = 'block';
+    }
+
+    renderAlignment(songId, open = false) {
+        const wrap = document.getElementById('alignment');
+        const img = document.getElementById('alignment-image');
+        if (!wrap || !img) return;
+
+        if (!this.historyId || !songId) {
+            wrap.style.display = 'none';
+            return;
+        }
+
+        img.onerror = () => { wrap.style.display = 'none'; };
+        img.src = `/api/history/${this.historyId}/alignment.svg?song_id=${songId}`;
+        wrap.style.display = 'block';
+        if (open) wrap.open = true;
+    }
+
+    async confirmResult(songId) {
+        if (!this.historyId) return;
+
+        try {
+            const response = await fetch(`/api/history/${this.historyId}/confirm`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ song_id: songId })
+            });
+
+            if (!response.ok) {
+                throw new Error('Failed to record confirmation');
+            }
+
+            const list = document.getElementById('alternatives-list');
+            const noneBtn = document.getElementById('confirm-none');
+            if (list) list.innerHTML = '<p class="confirmed"><i class="fas fa-check"></i> Thanks for the feedback!</p>';
+            if (noneBtn) noneBtn.style.display = 'none';
+        } catch (error) {
+            console.error('Confirm error:', error);
+            this.showNotification('Failed to record your answer', 'error');
+        }
+    }
+
+    formatTime(seconds) {
+        const total = Math.floor(seconds || 0);
+        return `${Math.floor(total / 60)}:${String(total % 60).padStart(2, '0')}`;
+    }
+
     displaySuccessResult(result) {
         const elements = {
             icon: document.getElementById('result-icon'),
@@ -224,6 +351,18 @@ class AudioRecognitionApp {
         if (elements.songTitle) elements.songTitle.textContent = 'Unknown';
         if (elements.artist) elements.artist.textContent = 'This song is not in our database';
         if (elements.album) elements.album.textContent = '';
+
+        const covers = result.covers || [];
+        if (covers.length > 0) {
+            const cover = covers[0];
+            if (elements.title) elements.title.textContent = 'Possible Cover';
+            if (elements.artist) elements.artist.textContent = `Possibly a cover or live version of ${cover.title} - ${cover.artist}`;
+            if (elements.album) {
+                elements.album.textContent = covers.length > 1
+                    ? `Also similar: ${covers.slice(1).map(c => c.title).join(', ')}`
+                    : '';
+            }
+        }
         
         const confidence = Math.round(result.confidence * 100);
         if (elements.confidence) elements.confidence.textContent = confidence;
@@ -255,6 +394,14 @@ class AudioRecognitionApp {
         this.recording = false;
     }
 
+    // withLibrary carries the library the page was opened in over to an API
+    // call, so that songs are added to and looked up in that library.
+    withLibrary(url) {
+        const library = new URLSearchParams(window.location.search).get('library');
+        if (!library) return url;
+        return `${url}${url.includes('?') ? '&' : '?'}library=${encodeURIComponent(library)}`;
+    }
+
     async addSong() {
         const form = document.getElementById('add-song-form');
         if (!form) return;
@@ -273,7 +420,7 @@ class AudioRecognitionApp {
         formControls.forEach(el => el.disabled = true);
 
         try {
-            const response = await fetch('/api/songs/add', {
+            const response = await fetch(this.withLibrary('/api/songs/add'), {
                 method: 'POST',
                 headers: { 'Content-Type': 'application/json' },
                 body: JSON.stringify(songData)
@@ -299,12 +446,84 @@ class AudioRecognitionApp {
         }
     }
 
+    async saveSongTags(form) {
+        const tags = new FormData(form).get('tags')
+            .split(',')
+            .map(tag => tag.trim())
+            .filter(tag => tag !== '');
+
+        try {
+            const response = await fetch(this.withLibrary(`/api/songs/${form.dataset.songId}/tags`), {
+                method: 'PUT',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ tags })
+            });
+            if (!response.ok) {
+                throw new Error(await response.text());
+            }
+
+            this.showNotification('Tags saved', 'success');
+            setTimeout(() => window.location.reload(), 1000);
+        } catch (error) {
+            console.error('Save tags error:', error);
+            this.showNotification('Failed to save tags', 'error');
+        }
+    }
+
+    async addToPlaylist(form) {
+        const playlistId = new FormData(form).get('playlist');
+        if (!playlistId) return;
+
+        try {
+            const response = await fetch(`/api/playlists/${playlistId}/songs`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ song_id: Number(form.dataset.songId) })
+            });
+            if (!response.ok) {
+                throw new Error(await response.text());
+            }
+
+            const playlist = await response.json();
+            this.showNotification(`Added to ${playlist.name}`, 'success');
+            form.reset();
+        } catch (error) {
+            console.error('Add to playlist error:', error);
+            this.showNotification('Failed to add to playlist', 'error');
+        }
+    }
+
+    async createPlaylist(form) {
+        try {
+            const response = await fetch('/api/playlists', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ name: new FormData(form).get('name') })
+            });
+            if (!response.ok) {
+                throw new Error(await response.text());
+            }
+
+            this.showNotification('Playlist created', 'success');
+            setTimeout(() => window.location.reload(), 1000);
+        } catch (error) {
+            console.error('Create playlist error:', error);
+            this.showNotification('Failed to create playlist', 'error');
+        }
+    }
+
     resetApp() {
         const resultsEl = document.getElementById('results');
         const actionsEl = document.querySelector('.main-actions');
         const progressEl = document.getElementById('progress');
+        const alternativesEl = document.getElementById('alternatives');
         
         if (resultsEl) resultsEl.style.display = 'none';
+        const alignmentEl = document.getElementById('alignment');
+        
+        if (alternativesEl) alternativesEl.style.display = 'none';
+        if (alignmentEl) alignmentEl.style.display = 'none';
+        this.historyId = null;
         if (actionsEl) actionsEl.style.display = 'block';
         if (progressEl) progressEl.style.width = '0%';
     }